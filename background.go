@@ -0,0 +1,1036 @@
+package htmlbag
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+	"github.com/boxesandglue/svgreader"
+)
+
+// backgroundMaxTiles limits the number of tiles of one repeated background
+// layer, so a tiny background-size cannot produce millions of nodes.
+const backgroundMaxTiles = 2000
+
+// gradientStepLength is the width of one color band of a radial gradient,
+// gradientMaxSteps caps the number of bands.
+var gradientStepLength = bag.MustSP("1pt")
+
+const gradientMaxSteps = 128
+
+// boxBackground is the CSS background of a box (CSS Backgrounds 3 §2),
+// carried by settingBackground from Output() to HTMLBorder. The layers are
+// kept as raw CSS values and resolved when the size of the box is known;
+// the font sizes resolve em lengths and currentColor is color.
+type boxBackground struct {
+	layers       []backgroundLayer
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+	color        *color.Color
+}
+
+// backgroundLayer is one comma separated entry of background-image with
+// the matching entries of the other background properties.
+type backgroundLayer struct {
+	image    string // url(...), a gradient function or none
+	size     string
+	position string
+	repeat   string
+	clip     string // border-box, padding-box or content-box
+	origin   string // the box background-position refers to
+}
+
+// elementBackground returns the background layers of an element's styles,
+// if any. The number of layers is the number of background-image entries;
+// the lists of the other properties are repeated as needed (CSS
+// Backgrounds 3 §2.2). A background-clip without an image still clips the
+// background color.
+func elementBackground(styles *FormattingStyles) (*boxBackground, bool) {
+	images := splitTopLevel(styles.backgroundImage, ',')
+	if len(images) == 0 {
+		if styles.backgroundClip == "" {
+			return nil, false
+		}
+		images = []string{"none"}
+	}
+	pick := func(v string, i int, def string) string {
+		list := splitTopLevel(v, ',')
+		if len(list) == 0 {
+			return def
+		}
+		return list[i%len(list)]
+	}
+	bb := &boxBackground{
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+		color:        styles.color,
+	}
+	hasImage := false
+	for i, img := range images {
+		hasImage = hasImage || img != "none"
+		bb.layers = append(bb.layers, backgroundLayer{
+			image:    img,
+			size:     pick(styles.backgroundSize, i, "auto"),
+			position: pick(styles.backgroundPosition, i, "0% 0%"),
+			repeat:   pick(styles.backgroundRepeat, i, "repeat"),
+			clip:     pick(styles.backgroundClip, i, "border-box"),
+			origin:   pick(styles.backgroundOrigin, i, "padding-box"),
+		})
+	}
+	if !hasImage && bb.colorClip() == "border-box" {
+		return nil, false
+	}
+	return bb, true
+}
+
+// colorClip returns the box the background color is clipped to: the
+// background-clip of the bottom-most layer.
+func (bb *boxBackground) colorClip() string {
+	if bb == nil || len(bb.layers) == 0 {
+		return "border-box"
+	}
+	return bb.layers[len(bb.layers)-1].clip
+}
+
+// length resolves a length or percentage of ref.
+func (bb *boxBackground) length(s string, ref bag.ScaledPoint) bag.ScaledPoint {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0
+		}
+		return bag.MultiplyFloat(ref, f/100)
+	}
+	return ParseRelativeSize(s, bb.fontsize, bb.rootFontsize)
+}
+
+// bgRect is a rectangle in the coordinates of HTMLBorder's content list:
+// x grows to the right, y grows downward from the top of the content.
+type bgRect struct {
+	x0, y0, x1, y1 bag.ScaledPoint
+}
+
+func (r bgRect) wd() bag.ScaledPoint { return r.x1 - r.x0 }
+func (r bgRect) ht() bag.ScaledPoint { return r.y1 - r.y0 }
+
+// backgroundBox returns the border, padding or content box for the border
+// box x0…x3, y3…y0 of HTMLBorder (y grows upward).
+func backgroundBox(hv HTMLValues, box string, x0, y0, x3, y3 bag.ScaledPoint) bgRect {
+	r := bgRect{x0: x0, y0: -y0, x1: x3, y1: -y3}
+	if box == "padding-box" || box == "content-box" {
+		r.x0 += hv.BorderLeftWidth
+		r.y0 += hv.BorderTopWidth
+		r.x1 -= hv.BorderRightWidth
+		r.y1 -= hv.BorderBottomWidth
+	}
+	if box == "content-box" {
+		r.x0 += hv.PaddingLeft
+		r.y0 += hv.PaddingTop
+		r.x1 -= hv.PaddingRight
+		r.y1 -= hv.PaddingBottom
+	}
+	return r
+}
+
+// backgroundClipPath returns the outline of the background painting area
+// (background-clip) for the border box x0…x3, y3…y0. The border and the
+// padding box follow the border radii; the content box gets the inner radii
+// reduced by the padding (CSS Backgrounds 3 §5.3).
+func backgroundClipPath(hv HTMLValues, box string, x0, y0, x3, y3 bag.ScaledPoint) *pdfdraw.Object {
+	inner, outer := getBorderPaths(x0, y0, 0, 0, 0, 0, x3, y3, hv)
+	switch box {
+	case "padding-box":
+		return inner
+	case "content-box":
+		r := backgroundBox(hv, box, x0, y0, x3, y3)
+		radii := HTMLValues{
+			BorderTopLeftRadius:     bag.Max(0, hv.BorderTopLeftRadius-hv.BorderLeftWidth-hv.PaddingLeft),
+			BorderBottomLeftRadius:  bag.Max(0, hv.BorderBottomLeftRadius-hv.BorderLeftWidth-hv.PaddingLeft),
+			BorderTopRightRadius:    bag.Max(0, hv.BorderTopRightRadius-hv.BorderRightWidth-hv.PaddingRight),
+			BorderBottomRightRadius: bag.Max(0, hv.BorderBottomRightRadius-hv.BorderRightWidth-hv.PaddingRight),
+		}
+		return roundedRect(r.x0, -r.y0, r.x1, -r.y1, radii, 0)
+	}
+	return outer
+}
+
+// backgroundColorRule returns the hidden rule that paints the background
+// color of hv for the border box x0…x3, y3…y0. It is clipped to the outer
+// (border-box) path so the background extends through the padding and under
+// the border, matching CSS background-clip: border-box; another
+// background-clip selects the padding or the content box instead.
+func backgroundColorRule(hv HTMLValues, x0, y0, x3, y3 bag.ScaledPoint) *node.Rule {
+	r := node.NewRule()
+	r.Hide = true
+	clip := backgroundClipPath(hv, hv.background.colorClip(), x0, y0, x3, y3)
+	clip.Clip().Endpath()
+	clip.ColorNonstroking(*hv.BackgroundColor).Rect(x0, y3, x3-x0, y0-y3).Fill()
+	r.Pre = "q " + clip.String() + " Q"
+	r.Attributes = node.H{"origin": "html background color"}
+	return r
+}
+
+// backgroundNodes returns the nodes that paint the background images of hv
+// for the border box x0…x3, y3…y0, in painting order (the last layer
+// first). They go to the top of HTMLBorder's content list. Each layer is a
+// VList
+//
+//	StartStop "q" → Rule (clip path) → rows of tiles → StartStop "Q"
+//
+// followed by a kern that moves back up by the height of the layer, so the
+// content is not displaced. A layer that cannot be drawn (unknown image,
+// invalid gradient, empty box) is skipped with a warning.
+func (cb *CSSBuilder) backgroundNodes(hv HTMLValues, x0, y0, x3, y3 bag.ScaledPoint) []node.Node {
+	bb := hv.background
+	var nodes []node.Node
+	for i := len(bb.layers) - 1; i >= 0; i-- {
+		layer := bb.layers[i]
+		if layer.image == "" || layer.image == "none" {
+			continue
+		}
+		vl, err := cb.backgroundLayerVList(hv, layer, x0, y0, x3, y3)
+		if err != nil {
+			bag.Logger.Warn("background-image not drawn", "value", layer.image, "error", err)
+			continue
+		}
+		if vl == nil {
+			continue
+		}
+		back := node.NewKern()
+		back.Kern = -(vl.Height + vl.Depth)
+		back.Attributes = node.H{"origin": "html background image back"}
+		nodes = append(nodes, vl, back)
+	}
+	return nodes
+}
+
+// backgroundLayerVList builds the VList of one background layer, or nil if
+// there is nothing to paint.
+func (cb *CSSBuilder) backgroundLayerVList(hv HTMLValues, layer backgroundLayer, x0, y0, x3, y3 bag.ScaledPoint) (*node.VList, error) {
+	bb := hv.background
+	area := backgroundBox(hv, layer.origin, x0, y0, x3, y3)
+	paint := backgroundBox(hv, layer.clip, x0, y0, x3, y3)
+	if area.wd() <= 0 || area.ht() <= 0 || paint.wd() <= 0 || paint.ht() <= 0 {
+		return nil, nil
+	}
+
+	img, err := cb.backgroundImage(layer.image, bb)
+	if err != nil {
+		return nil, err
+	}
+	tw, th := backgroundTileSize(layer.size, area.wd(), area.ht(), img, bb)
+	if tw <= 0 || th <= 0 {
+		return nil, nil
+	}
+	repeatX, repeatY := backgroundRepeat(layer.repeat)
+	// round scales the tile so that a whole number of tiles fits the
+	// positioning area (CSS Backgrounds 3 §3.4).
+	if repeatX == "round" {
+		n := max(1, math.Round(float64(area.wd())/float64(tw)))
+		tw = bag.ScaledPoint(float64(area.wd()) / n)
+	}
+	if repeatY == "round" {
+		n := max(1, math.Round(float64(area.ht())/float64(th)))
+		th = bag.ScaledPoint(float64(area.ht()) / n)
+	}
+	px, py := backgroundPosition(layer.position, area.wd(), area.ht(), tw, th, bb)
+	xs := tilePositions(repeatX, area.x0, area.wd(), area.x0+px, paint.x0, paint.x1, tw)
+	ys := tilePositions(repeatY, area.y0, area.ht(), area.y0+py, paint.y0, paint.y1, th)
+	if len(xs) == 0 || len(ys) == 0 {
+		return nil, nil
+	}
+	if len(xs)*len(ys) > backgroundMaxTiles {
+		return nil, fmt.Errorf("more than %d tiles", backgroundMaxTiles)
+	}
+
+	save := node.NewStartStop()
+	save.Position = node.PDFOutputPage
+	save.ShipoutCallback = func(n node.Node) string {
+		return "q "
+	}
+	restore := node.NewStartStop()
+	restore.Position = node.PDFOutputPage
+	restore.ShipoutCallback = func(n node.Node) string {
+		return "Q "
+	}
+	clip := node.NewRule()
+	clip.Hide = true
+	clip.Pre = backgroundClipPath(hv, layer.clip, x0, y0, x3, y3).Clip().Endpath().String()
+	clip.Attributes = node.H{"origin": "html background clip"}
+
+	var head, tail node.Node
+	head = save
+	head = node.InsertAfter(head, save, clip)
+	tail = clip
+	var cur bag.ScaledPoint
+	for _, y := range ys {
+		if y != cur {
+			k := node.NewKern()
+			k.Kern = y - cur
+			head = node.InsertAfter(head, tail, k)
+			tail = k
+		}
+		var row, rowTail node.Node
+		var x bag.ScaledPoint
+		for _, tx := range xs {
+			if tx != x {
+				k := node.NewKern()
+				k.Kern = tx - x
+				row = node.InsertAfter(row, rowTail, k)
+				rowTail = k
+			}
+			t, err := img.tile(tw, th)
+			if err != nil {
+				return nil, err
+			}
+			row = node.InsertAfter(row, rowTail, t)
+			rowTail = t
+			x = tx + tw
+		}
+		hl := node.Hpack(row)
+		hl.Attributes = node.H{"origin": "html background row"}
+		head = node.InsertAfter(head, tail, hl)
+		tail = hl
+		cur = y + th
+	}
+	head = node.InsertAfter(head, tail, restore)
+	vl := node.Vpack(head)
+	vl.Attributes = node.H{"origin": "html background image"}
+	return vl, nil
+}
+
+// bgImage is a resolved background-image: something that can produce
+// tiles of a given size. Gradients have no intrinsic size.
+type bgImage struct {
+	intrinsicWd, intrinsicHt bag.ScaledPoint
+	tile                     func(wd, ht bag.ScaledPoint) (node.Node, error)
+}
+
+func (img bgImage) hasIntrinsicSize() bool {
+	return img.intrinsicWd > 0 && img.intrinsicHt > 0
+}
+
+// backgroundImage resolves a background-image entry.
+func (cb *CSSBuilder) backgroundImage(v string, bb *boxBackground) (bgImage, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "url(") {
+		return cb.backgroundURL(stripCSSURL(v))
+	}
+	g, err := parseGradient(v, bb, cb.frontend)
+	if err != nil {
+		return bgImage{}, err
+	}
+	if g.radial {
+		return bgImage{tile: func(wd, ht bag.ScaledPoint) (node.Node, error) {
+			// A hidden rule that only carries the drawing. Like the SVG
+			// tiles it paints downward from its reference point, so its
+			// space is Depth.
+			r := node.NewRule()
+			r.Hide = true
+			r.Width = wd
+			r.Depth = ht
+			r.Pre = radialGradientPre(g, wd, ht, bb)
+			r.Attributes = node.H{"origin": "html background gradient"}
+			return r, nil
+		}}, nil
+	}
+	return bgImage{tile: func(wd, ht bag.ScaledPoint) (node.Node, error) {
+		src, err := linearGradientSVG(g, wd, ht, bb)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := svgreader.Parse(strings.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		return cb.svgTile(doc, wd, ht), nil
+	}}, nil
+}
+
+// backgroundURL loads a url() image. Raster and PDF images become image
+// nodes, SVG files are rendered with svgreader.
+func (cb *CSSBuilder) backgroundURL(filename string) (bgImage, error) {
+	if filename == "" {
+		return bgImage{}, fmt.Errorf("empty url")
+	}
+	if resolved, err := cb.css.FindFile(filename); err == nil && resolved != "" {
+		filename = resolved
+	}
+	df := cb.frontend
+	if strings.ToLower(filepath.Ext(filename)) == ".svg" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return bgImage{}, err
+		}
+		defer f.Close()
+		doc, err := svgreader.Parse(f)
+		if err != nil {
+			return bgImage{}, err
+		}
+		return bgImage{
+			intrinsicWd: bag.ScaledPointFromFloat(doc.Width),
+			intrinsicHt: bag.ScaledPointFromFloat(doc.Height),
+			tile: func(wd, ht bag.ScaledPoint) (node.Node, error) {
+				return cb.svgTile(doc, wd, ht), nil
+			},
+		}, nil
+	}
+	imgfile, err := df.Doc.LoadImageFile(filename)
+	if err != nil {
+		return bgImage{}, err
+	}
+	probe := df.Doc.CreateImageNodeFromImagefile(imgfile, 1, "/MediaBox")
+	return bgImage{
+		intrinsicWd: probe.Width,
+		intrinsicHt: probe.Height,
+		tile: func(wd, ht bag.ScaledPoint) (node.Node, error) {
+			imgNode := df.Doc.CreateImageNodeFromImagefile(imgfile, 1, "/MediaBox")
+			imgNode.Width = wd
+			imgNode.Height = ht
+			return imgNode, nil
+		},
+	}, nil
+}
+
+// svgTile renders an SVG document as a tile. CreateSVGNodeFromDocument
+// paints downward from the rule's reference point, so the rule declares
+// its space as Depth (see newInlineSVGFormatter).
+func (cb *CSSBuilder) svgTile(doc *svgreader.Document, wd, ht bag.ScaledPoint) node.Node {
+	r := cb.frontend.Doc.CreateSVGNodeFromDocument(doc, wd, ht, frontend.NewSVGTextRenderer(cb.frontend))
+	r.Height = 0
+	r.Depth = ht
+	return r
+}
+
+// backgroundTileSize resolves background-size (CSS Backgrounds 3 §3.9) for
+// the positioning area aw × ah. Images without intrinsic size (gradients)
+// fill the area where the size is auto.
+func backgroundTileSize(size string, aw, ah bag.ScaledPoint, img bgImage, bb *boxBackground) (bag.ScaledPoint, bag.ScaledPoint) {
+	iw, ih := img.intrinsicWd, img.intrinsicHt
+	switch size = strings.TrimSpace(size); size {
+	case "cover", "contain":
+		if !img.hasIntrinsicSize() {
+			return aw, ah
+		}
+		sx, sy := float64(aw)/float64(iw), float64(ah)/float64(ih)
+		s := min(sx, sy)
+		if size == "cover" {
+			s = max(sx, sy)
+		}
+		return bag.MultiplyFloat(iw, s), bag.MultiplyFloat(ih, s)
+	}
+	f := strings.Fields(size)
+	if len(f) == 0 {
+		f = []string{"auto"}
+	}
+	if len(f) == 1 {
+		f = append(f, "auto")
+	}
+	var w, h bag.ScaledPoint = -1, -1
+	if f[0] != "auto" {
+		w = bb.length(f[0], aw)
+	}
+	if f[1] != "auto" {
+		h = bb.length(f[1], ah)
+	}
+	switch {
+	case w < 0 && h < 0:
+		if img.hasIntrinsicSize() {
+			return iw, ih
+		}
+		return aw, ah
+	case w < 0:
+		if img.hasIntrinsicSize() {
+			return bag.MultiplyFloat(iw, float64(h)/float64(ih)), h
+		}
+		return aw, h
+	case h < 0:
+		if img.hasIntrinsicSize() {
+			return w, bag.MultiplyFloat(ih, float64(w)/float64(iw))
+		}
+		return w, ah
+	}
+	return w, h
+}
+
+// backgroundRepeat splits background-repeat into the horizontal and the
+// vertical mode.
+func backgroundRepeat(v string) (string, string) {
+	f := strings.Fields(v)
+	switch {
+	case len(f) == 0:
+		return "repeat", "repeat"
+	case f[0] == "repeat-x":
+		return "repeat", "no-repeat"
+	case f[0] == "repeat-y":
+		return "no-repeat", "repeat"
+	case len(f) == 1:
+		return f[0], f[0]
+	}
+	return f[0], f[1]
+}
+
+// backgroundPosition resolves background-position (CSS Backgrounds 3 §3.6)
+// to the offset of the anchor tile tw × th inside the positioning area
+// aw × ah. Percentages align the same point of tile and area. The one and
+// two value syntax and the three/four value syntax with edge offsets
+// ("right 10pt bottom 5pt") are understood.
+func backgroundPosition(v string, aw, ah, tw, th bag.ScaledPoint, bb *boxBackground) (bag.ScaledPoint, bag.ScaledPoint) {
+	f := strings.Fields(v)
+	isKeyword := func(s string) bool {
+		switch s {
+		case "left", "right", "top", "bottom", "center":
+			return true
+		}
+		return false
+	}
+	// value resolves one component of the one/two value syntax.
+	value := func(s string, a, t bag.ScaledPoint) bag.ScaledPoint {
+		switch s {
+		case "left", "top":
+			return 0
+		case "center":
+			return (a - t) / 2
+		case "right", "bottom":
+			return a - t
+		}
+		return bb.length(s, a-t)
+	}
+	switch len(f) {
+	case 0:
+		return 0, 0
+	case 1:
+		if f[0] == "top" || f[0] == "bottom" {
+			return (aw - tw) / 2, value(f[0], ah, th)
+		}
+		return value(f[0], aw, tw), (ah - th) / 2
+	case 2:
+		if f[0] == "top" || f[0] == "bottom" || f[1] == "left" || f[1] == "right" {
+			f[0], f[1] = f[1], f[0]
+		}
+		return value(f[0], aw, tw), value(f[1], ah, th)
+	}
+	x, y := (aw-tw)/2, (ah-th)/2
+	for i := 0; i < len(f); i++ {
+		kw := f[i]
+		var off bag.ScaledPoint
+		hasOff := i+1 < len(f) && !isKeyword(f[i+1])
+		switch kw {
+		case "left", "right":
+			if hasOff {
+				off = bb.length(f[i+1], aw-tw)
+			}
+			x = off
+			if kw == "right" {
+				x = aw - tw - off
+			}
+		case "top", "bottom":
+			if hasOff {
+				off = bb.length(f[i+1], ah-th)
+			}
+			y = off
+			if kw == "bottom" {
+				y = ah - th - off
+			}
+		}
+		if hasOff {
+			i++
+		}
+	}
+	return x, y
+}
+
+// tilePositions returns the start coordinates of the tiles along one axis.
+// The positioning area starts at a0 and has the length a, the anchor tile
+// starts at start, tiles of the length t are painted where they touch the
+// painting area p0…p1.
+func tilePositions(mode string, a0, a, start, p0, p1, t bag.ScaledPoint) []bag.ScaledPoint {
+	step := t
+	switch mode {
+	case "no-repeat":
+		if start+t <= p0 || start >= p1 {
+			return nil
+		}
+		return []bag.ScaledPoint{start}
+	case "space":
+		// As many whole tiles as fit, the first and the last touching the
+		// edges of the area and the rest spread evenly in between. If
+		// fewer than two fit, the tile is placed like no-repeat.
+		n := int(a / t)
+		if n < 2 {
+			return tilePositions("no-repeat", a0, a, start, p0, p1, t)
+		}
+		step = t + (a-bag.ScaledPoint(n)*t)/bag.ScaledPoint(n-1)
+		start = a0
+	}
+	if start > p0 {
+		start -= bag.ScaledPoint(math.Ceil(float64(start-p0)/float64(step))) * step
+	}
+	var pos []bag.ScaledPoint
+	for x := start; x < p1 && len(pos) <= backgroundMaxTiles; x += step {
+		if x+t > p0 {
+			pos = append(pos, x)
+		}
+	}
+	return pos
+}
+
+// gradient is a parsed CSS linear-gradient() or radial-gradient() (CSS
+// Images 3 §3) or one of their repeating variants.
+type gradient struct {
+	radial    bool
+	repeating bool
+	// angle is the direction of a linear gradient in radians, clockwise
+	// from "to top". For "to <corner>" the angle depends on the box;
+	// cornerX/cornerY (-1 or 1) are then set instead.
+	angle            float64
+	cornerX, cornerY float64
+	// circle, size (the ending shape's size keywords or lengths) and at
+	// (the center position) of a radial gradient.
+	circle bool
+	size   []string
+	at     string
+	stops  []gradientStop
+}
+
+// gradientStop is a color stop; pos is "" for a stop without position.
+type gradientStop struct {
+	color *color.Color
+	pos   string
+}
+
+// parseGradient parses a gradient function. The color stops are resolved
+// to colors right away; alpha is mixed with white like for shadows, since
+// there is no transparency (transparent becomes white).
+func parseGradient(v string, bb *boxBackground, df *frontend.Document) (*gradient, error) {
+	open := strings.Index(v, "(")
+	if open < 0 || !strings.HasSuffix(v, ")") {
+		return nil, fmt.Errorf("unsupported image")
+	}
+	g := &gradient{angle: math.Pi}
+	switch fn := strings.ToLower(strings.TrimSpace(v[:open])); fn {
+	case "linear-gradient", "repeating-linear-gradient":
+		g.repeating = fn == "repeating-linear-gradient"
+	case "radial-gradient", "repeating-radial-gradient":
+		g.radial = true
+		g.repeating = fn == "repeating-radial-gradient"
+	default:
+		return nil, fmt.Errorf("unsupported image %q", fn)
+	}
+	parts := splitTopLevel(v[open+1:len(v)-1], ',')
+	if len(parts) > 0 {
+		first := parts[0]
+		f := strings.Fields(first)
+		switch {
+		case !g.radial && len(f) > 1 && f[0] == "to":
+			var sx, sy float64
+			for _, side := range f[1:] {
+				switch side {
+				case "left":
+					sx = -1
+				case "right":
+					sx = 1
+				case "top":
+					sy = -1
+				case "bottom":
+					sy = 1
+				}
+			}
+			if sx != 0 && sy != 0 {
+				g.cornerX, g.cornerY = sx, sy
+			} else {
+				g.angle = math.Atan2(sx, -sy)
+			}
+			parts = parts[1:]
+		case !g.radial:
+			if a, ok := parseAngle(first); ok {
+				g.angle = a
+				parts = parts[1:]
+			}
+		case isRadialConfig(f[0]):
+			shape, at, _ := strings.Cut(" "+first, " at ")
+			g.at = strings.TrimSpace(at)
+			explicitShape := false
+			for _, tok := range strings.Fields(shape) {
+				switch tok {
+				case "circle":
+					g.circle, explicitShape = true, true
+				case "ellipse":
+					explicitShape = true
+				default:
+					g.size = append(g.size, tok)
+				}
+			}
+			// A single length without a shape keyword is a circle.
+			if !explicitShape && len(g.size) == 1 && isShadowLength(g.size[0]) {
+				g.circle = true
+			}
+			parts = parts[1:]
+		}
+	}
+	for _, p := range parts {
+		toks := splitTopLevel(p, ' ')
+		if len(toks) == 0 {
+			continue
+		}
+		if len(toks) == 1 && isShadowLength(toks[0]) {
+			// An interpolation hint. Colors are always interpolated
+			// linearly, the hint is ignored.
+			continue
+		}
+		c := gradientColor(toks[0], bb, df)
+		if c == nil {
+			return nil, fmt.Errorf("invalid color %q", toks[0])
+		}
+		if len(toks) == 1 {
+			g.stops = append(g.stops, gradientStop{color: c})
+		}
+		// With two positions the color is repeated at both.
+		for _, pos := range toks[1:min(len(toks), 3)] {
+			g.stops = append(g.stops, gradientStop{color: c, pos: pos})
+		}
+	}
+	if len(g.stops) < 2 {
+		return nil, fmt.Errorf("a gradient needs at least two color stops")
+	}
+	return g, nil
+}
+
+// isRadialConfig reports whether tok starts the shape/size/position part of
+// radial-gradient() rather than the first color stop.
+func isRadialConfig(tok string) bool {
+	switch tok {
+	case "circle", "ellipse", "closest-side", "closest-corner", "farthest-side", "farthest-corner", "at":
+		return true
+	}
+	return isShadowLength(tok)
+}
+
+// parseAngle parses a CSS <angle> (deg, rad, grad, turn or 0) to radians.
+func parseAngle(s string) (float64, bool) {
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"deg", math.Pi / 180},
+		{"grad", math.Pi / 200},
+		{"rad", 1},
+		{"turn", 2 * math.Pi},
+	}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, false
+			}
+			return f * u.factor, true
+		}
+	}
+	if s == "0" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// gradientColor resolves the color of a stop.
+func gradientColor(v string, bb *boxBackground, df *frontend.Document) *color.Color {
+	if v == "transparent" {
+		return mixWithWhite(df.GetColor("black"), 0)
+	}
+	return shadowColor(v, bb.color, df)
+}
+
+// resolvedStop is a color stop at a position along the gradient line or
+// ray, in PDF points.
+type resolvedStop struct {
+	pos   float64
+	color *color.Color
+}
+
+// resolveStops positions the color stops on a gradient line of the length
+// l (CSS Images 3 §3.5.3): a missing first and last position become 0 and
+// l, a position before a previous one moves up to it and the stops without
+// position in between are spread evenly.
+func resolveStops(stops []gradientStop, l float64, bb *boxBackground) []resolvedStop {
+	rs := make([]resolvedStop, len(stops))
+	known := make([]bool, len(stops))
+	for i, s := range stops {
+		rs[i].color = s.color
+		if s.pos != "" {
+			rs[i].pos = bb.length(s.pos, bag.ScaledPointFromFloat(l)).ToPT()
+			known[i] = true
+		}
+	}
+	if !known[0] {
+		rs[0].pos, known[0] = 0, true
+	}
+	if last := len(rs) - 1; !known[last] {
+		rs[last].pos, known[last] = l, true
+	}
+	highest := rs[0].pos
+	for i := range rs {
+		if known[i] {
+			rs[i].pos = max(rs[i].pos, highest)
+			highest = rs[i].pos
+		}
+	}
+	for i := 1; i < len(rs); i++ {
+		if known[i] {
+			continue
+		}
+		j := i
+		for !known[j] {
+			j++
+		}
+		from, to := rs[i-1].pos, rs[j].pos
+		for k := i; k < j; k++ {
+			rs[k].pos = from + (to-from)*float64(k-i+1)/float64(j-i+1)
+			known[k] = true
+		}
+	}
+	return rs
+}
+
+// repeatStops repeats the stops of a repeating gradient so that they cover
+// 0…l. A zero period paints the last color.
+func repeatStops(rs []resolvedStop, l float64) []resolvedStop {
+	first, last := rs[0].pos, rs[len(rs)-1].pos
+	period := last - first
+	if period <= 0 {
+		return []resolvedStop{{pos: 0, color: rs[len(rs)-1].color}, {pos: l, color: rs[len(rs)-1].color}}
+	}
+	var out []resolvedStop
+	for k := math.Floor(-first / period); first+k*period < l; k++ {
+		for _, s := range rs {
+			out = append(out, resolvedStop{pos: s.pos + k*period, color: s.color})
+		}
+		if len(out) > 10*backgroundMaxTiles {
+			break
+		}
+	}
+	return out
+}
+
+// colorAt returns the color at position d of the resolved stops.
+func colorAt(rs []resolvedStop, d float64) *color.Color {
+	if d <= rs[0].pos {
+		return rs[0].color
+	}
+	for i := 1; i < len(rs); i++ {
+		if d <= rs[i].pos {
+			a, b := rs[i-1], rs[i]
+			if b.pos-a.pos <= 0 {
+				return b.color
+			}
+			return mixColors(a.color, b.color, (d-a.pos)/(b.pos-a.pos))
+		}
+	}
+	return rs[len(rs)-1].color
+}
+
+// mixColors interpolates between a and b. Colors of different color spaces
+// are mixed in RGB; colors that cannot be converted switch half way.
+func mixColors(a, b *color.Color, t float64) *color.Color {
+	lerp := func(x, y float64) float64 { return x + (y-x)*t }
+	if a.Space == b.Space {
+		c := *a
+		switch a.Space {
+		case color.ColorRGB:
+			c.R, c.G, c.B = lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B)
+			return &c
+		case color.ColorCMYK:
+			c.C, c.M, c.Y, c.K = lerp(a.C, b.C), lerp(a.M, b.M), lerp(a.Y, b.Y), lerp(a.K, b.K)
+			return &c
+		}
+	}
+	ar, ag, ab, aok := rgbOf(a)
+	br, bg, bb, bok := rgbOf(b)
+	if !aok || !bok {
+		if t < 0.5 {
+			return a
+		}
+		return b
+	}
+	return &color.Color{Space: color.ColorRGB, R: lerp(ar, br), G: lerp(ag, bg), B: lerp(ab, bb)}
+}
+
+// rgbOf returns the RGB components of an RGB or CMYK color.
+func rgbOf(c *color.Color) (r, g, b float64, ok bool) {
+	switch c.Space {
+	case color.ColorRGB:
+		return c.R, c.G, c.B, true
+	case color.ColorCMYK:
+		return (1 - c.C) * (1 - c.K), (1 - c.M) * (1 - c.K), (1 - c.Y) * (1 - c.K), true
+	}
+	return 0, 0, 0, false
+}
+
+// linearGradientSVG returns an SVG document of the size wd × ht that is
+// filled with the linear gradient g. boxesandglue turns SVG gradient fills
+// into PDF axial shadings. The gradient line runs through the center of
+// the box at the gradient angle and is as long as needed for the corners
+// to get the colors of the first and the last stop (CSS Images 3 §3.1.1).
+// The shading is in RGB, CMYK stop colors are converted.
+func linearGradientSVG(g *gradient, wd, ht bag.ScaledPoint, bb *boxBackground) (string, error) {
+	w, h := wd.ToPT(), ht.ToPT()
+	angle := g.angle
+	if g.cornerX != 0 {
+		// The line is perpendicular to the diagonal between the two
+		// other corners.
+		angle = math.Atan2(g.cornerX*h, -g.cornerY*w)
+	}
+	// y grows downward in SVG.
+	dx, dy := math.Sin(angle), -math.Cos(angle)
+	l := math.Abs(w*math.Sin(angle)) + math.Abs(h*math.Cos(angle))
+	rs := resolveStops(g.stops, l, bb)
+	if g.repeating {
+		rs = repeatStops(rs, l)
+	}
+
+	var sb strings.Builder
+	num := func(f float64) string {
+		return strconv.FormatFloat(math.Round(f*1000)/1000, 'f', -1, 64)
+	}
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s">`, num(w), num(h), num(w), num(h))
+	p0, p1 := rs[0].pos, rs[len(rs)-1].pos
+	if p1-p0 < 1e-6 {
+		// All stops at one position: the box has the last color.
+		hex, err := svgColor(rs[len(rs)-1].color)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, `<rect x="0" y="0" width="%s" height="%s" fill="%s"/></svg>`, num(w), num(h), hex)
+		return sb.String(), nil
+	}
+	cx, cy := w/2, h/2
+	fmt.Fprintf(&sb, `<defs><linearGradient id="g" gradientUnits="userSpaceOnUse" x1="%s" y1="%s" x2="%s" y2="%s">`,
+		num(cx+dx*(p0-l/2)), num(cy+dy*(p0-l/2)), num(cx+dx*(p1-l/2)), num(cy+dy*(p1-l/2)))
+	for _, s := range rs {
+		hex, err := svgColor(s.color)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, `<stop offset="%s" stop-color="%s"/>`, num((s.pos-p0)/(p1-p0)), hex)
+	}
+	fmt.Fprintf(&sb, `</linearGradient></defs><rect x="0" y="0" width="%s" height="%s" fill="url(#g)"/></svg>`, num(w), num(h))
+	return sb.String(), nil
+}
+
+// svgColor formats c as an SVG hex color.
+func svgColor(c *color.Color) (string, error) {
+	r, g, b, ok := rgbOf(c)
+	if !ok {
+		return "", fmt.Errorf("gradient colors must be RGB or CMYK")
+	}
+	ch := func(f float64) int { return int(math.Round(min(max(f, 0), 1) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", ch(r), ch(g), ch(b)), nil
+}
+
+// radialGradientPre returns the PDF instructions for a radial gradient
+// tile of the size wd × ht, drawn downward from the origin. The gradient is
+// drawn as concentric ellipses in bands of gradientStepLength (at most
+// gradientMaxSteps), from the farthest corner inward, clipped to the tile.
+// The ending shape follows CSS Images 3 §3.2.2; the gradient ray is the
+// horizontal radius.
+func radialGradientPre(g *gradient, wd, ht bag.ScaledPoint, bb *boxBackground) string {
+	cx, cy := backgroundPosition(orDefault(g.at, "center"), wd, ht, 0, 0, bb)
+	closestX, farthestX := min(cx, wd-cx), max(cx, wd-cx)
+	closestY, farthestY := min(cy, ht-cy), max(cy, ht-cy)
+	size := g.size
+	if len(size) == 0 {
+		size = []string{"farthest-corner"}
+	}
+	var rx, ry bag.ScaledPoint
+	switch size[0] {
+	case "closest-side", "farthest-side", "closest-corner", "farthest-corner":
+		sx, sy := closestX, closestY
+		if strings.HasPrefix(size[0], "farthest") {
+			sx, sy = farthestX, farthestY
+		}
+		corner := strings.HasSuffix(size[0], "corner")
+		switch {
+		case g.circle && corner:
+			rx = bag.ScaledPointFromFloat(math.Hypot(sx.ToPT(), sy.ToPT()))
+		case g.circle && size[0] == "closest-side":
+			rx = min(sx, sy)
+		case g.circle:
+			rx = max(sx, sy)
+		case corner:
+			// The ellipse through the corner with the aspect ratio of
+			// the side distances.
+			rx, ry = bag.MultiplyFloat(sx, math.Sqrt2), bag.MultiplyFloat(sy, math.Sqrt2)
+		default:
+			rx, ry = sx, sy
+		}
+		if g.circle {
+			ry = rx
+		}
+	default:
+		rx = bb.length(size[0], wd)
+		ry = rx
+		if !g.circle && len(size) > 1 {
+			ry = bb.length(size[1], ht)
+		}
+	}
+
+	clip := pdfdraw.New().Rect(0, -ht, wd, ht).Clip().Endpath().String()
+	l := rx.ToPT()
+	rs := resolveStops(g.stops, l, bb)
+	if rx <= 0 || ry <= 0 {
+		fill := pdfdraw.New().ColorNonstroking(*rs[len(rs)-1].color).Rect(0, -ht, wd, ht).Fill()
+		return "q " + clip + " " + fill.String() + " Q"
+	}
+	// The distance of the farthest tile corner, measured along the ray.
+	var dmax float64
+	for _, c := range [][2]bag.ScaledPoint{{0, 0}, {wd, 0}, {0, ht}, {wd, ht}} {
+		nx := (c[0] - cx).ToPT() / rx.ToPT()
+		ny := (c[1] - cy).ToPT() / ry.ToPT()
+		dmax = max(dmax, math.Hypot(nx, ny)*l)
+	}
+	if g.repeating {
+		rs = repeatStops(rs, dmax)
+	}
+	steps := int(math.Ceil(dmax / gradientStepLength.ToPT()))
+	steps = min(max(steps, 1), gradientMaxSteps)
+
+	var sb strings.Builder
+	sb.WriteString("q " + clip)
+	for k := steps; k >= 1; k-- {
+		d := dmax * float64(k) / float64(steps)
+		col := colorAt(rs, dmax*(float64(k)-0.5)/float64(steps))
+		erx := bag.ScaledPointFromFloat(d)
+		ery := bag.ScaledPointFromFloat(d * ry.ToPT() / rx.ToPT())
+		e := ellipsePath(pdfdraw.New().ColorNonstroking(*col), cx, -cy, erx, ery).Fill()
+		sb.WriteString(" " + e.String())
+	}
+	sb.WriteString(" Q")
+	return sb.String()
+}
+
+// ellipsePath appends the closed ellipse around cx, cy (y grows upward)
+// with the radii rx, ry to d.
+func ellipsePath(d *pdfdraw.Object, cx, cy, rx, ry bag.ScaledPoint) *pdfdraw.Object {
+	kx := bag.MultiplyFloat(rx, circleBezier)
+	ky := bag.MultiplyFloat(ry, circleBezier)
+	return d.Moveto(cx+rx, cy).
+		Curveto(cx+rx, cy+ky, cx+kx, cy+ry, cx, cy+ry).
+		Curveto(cx-kx, cy+ry, cx-rx, cy+ky, cx-rx, cy).
+		Curveto(cx-rx, cy-ky, cx-kx, cy-ry, cx, cy-ry).
+		Curveto(cx+kx, cy-ry, cx+rx, cy-ky, cx+rx, cy).
+		Close()
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
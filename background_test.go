@@ -0,0 +1,212 @@
+package htmlbag
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// pageVListOrigins counts the VLists with the given origin on a page.
+func pageVListOrigins(pg *document.Page, origin string) int {
+	var walk func(n node.Node) int
+	walk = func(n node.Node) int {
+		count := 0
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.HList:
+				count += walk(v.List)
+			case *node.VList:
+				if o, _ := v.Attributes["origin"].(string); o == origin {
+					count++
+				}
+				count += walk(v.List)
+			}
+		}
+		return count
+	}
+	count := 0
+	for _, obj := range pg.Objects {
+		if obj.Vlist != nil {
+			count += walk(obj.Vlist.List)
+		}
+	}
+	return count
+}
+
+func TestElementBackground(t *testing.T) {
+	styles := &FormattingStyles{
+		backgroundImage:  "url(a.png), linear-gradient( to right , red , blue )",
+		backgroundRepeat: "no-repeat",
+		backgroundClip:   "padding-box, content-box",
+	}
+	bb, ok := elementBackground(styles)
+	if !ok || len(bb.layers) != 2 {
+		t.Fatalf("elementBackground = %+v, %v, want two layers", bb, ok)
+	}
+	if got := bb.layers[1].repeat; got != "no-repeat" {
+		t.Errorf("second layer repeat = %q, want the list repeated", got)
+	}
+	if got := bb.layers[0].origin; got != "padding-box" {
+		t.Errorf("default origin = %q, want padding-box", got)
+	}
+	if got := bb.colorClip(); got != "content-box" {
+		t.Errorf("colorClip = %q, want the clip of the bottom layer", got)
+	}
+
+	if _, ok := elementBackground(&FormattingStyles{backgroundImage: "none"}); ok {
+		t.Error("background-image: none produced a background")
+	}
+	if bb, ok := elementBackground(&FormattingStyles{backgroundClip: "content-box"}); !ok || bb.colorClip() != "content-box" {
+		t.Error("background-clip without an image is lost")
+	}
+}
+
+func TestParseGradient(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	bb := &boxBackground{fontsize: bag.MustSP("10pt"), rootFontsize: bag.MustSP("10pt")}
+
+	g, err := parseGradient("linear-gradient( to right , red , rgba( 0 , 0 , 255 , 0.5 ) 80% )", bb, fe)
+	if err != nil {
+		t.Fatalf("linear-gradient: %v", err)
+	}
+	if g.radial || math.Abs(g.angle-math.Pi/2) > 1e-9 || len(g.stops) != 2 || g.stops[1].pos != "80%" {
+		t.Errorf("linear-gradient = %+v", g)
+	}
+	if g, err := parseGradient("linear-gradient( 45deg , red 0 50% , blue )", bb, fe); err != nil || len(g.stops) != 3 {
+		t.Errorf("a stop with two positions: %+v, %v", g, err)
+	}
+	if g, err := parseGradient("linear-gradient( to top left , red , blue )", bb, fe); err != nil || g.cornerX != -1 || g.cornerY != -1 {
+		t.Errorf("to top left: %+v, %v", g, err)
+	}
+
+	g, err = parseGradient("repeating-radial-gradient( circle at top left , red , blue 10pt )", bb, fe)
+	if err != nil {
+		t.Fatalf("repeating-radial-gradient: %v", err)
+	}
+	if !g.radial || !g.repeating || !g.circle || g.at != "top left" {
+		t.Errorf("repeating-radial-gradient = %+v", g)
+	}
+
+	for _, bad := range []string{"linear-gradient( red )", "conic-gradient( red , blue )", "element( #x )"} {
+		if _, err := parseGradient(bad, bb, fe); err == nil {
+			t.Errorf("parseGradient(%q) accepted", bad)
+		}
+	}
+}
+
+func TestResolveStops(t *testing.T) {
+	bb := &boxBackground{fontsize: bag.MustSP("10pt"), rootFontsize: bag.MustSP("10pt")}
+	rs := resolveStops([]gradientStop{{}, {}, {pos: "100pt"}, {pos: "20%"}, {}}, 200, bb)
+	want := []float64{0, 50, 100, 100, 200}
+	for i, w := range want {
+		if math.Abs(rs[i].pos-w) > 1e-3 {
+			t.Errorf("stop %d at %g, want %g", i, rs[i].pos, w)
+		}
+	}
+}
+
+func TestLinearGradientSVG(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	bb := &boxBackground{fontsize: bag.MustSP("10pt"), rootFontsize: bag.MustSP("10pt")}
+	g, err := parseGradient("linear-gradient( to right , red , blue )", bb, fe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := linearGradientSVG(g, bag.MustSP("100pt"), bag.MustSP("50pt"), bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`x1="0" y1="25" x2="100" y2="25"`, `stop-color="#ff0000"`, `stop-color="#0000ff"`} {
+		if !strings.Contains(src, want) {
+			t.Errorf("gradient SVG lacks %s: %s", want, src)
+		}
+	}
+}
+
+func TestBackgroundPositionAndTiles(t *testing.T) {
+	bb := &boxBackground{fontsize: bag.MustSP("10pt"), rootFontsize: bag.MustSP("10pt")}
+	aw, ah, tw, th := bag.MustSP("100pt"), bag.MustSP("50pt"), bag.MustSP("20pt"), bag.MustSP("10pt")
+	cases := []struct {
+		in   string
+		x, y bag.ScaledPoint
+	}{
+		{"center", bag.MustSP("40pt"), bag.MustSP("20pt")},
+		{"right bottom", bag.MustSP("80pt"), bag.MustSP("40pt")},
+		{"bottom 10pt right 5pt", bag.MustSP("75pt"), bag.MustSP("30pt")},
+		{"25% 10pt", bag.MustSP("20pt"), bag.MustSP("10pt")},
+	}
+	for _, tc := range cases {
+		if x, y := backgroundPosition(tc.in, aw, ah, tw, th, bb); x != tc.x || y != tc.y {
+			t.Errorf("backgroundPosition(%q) = %s, %s, want %s, %s", tc.in, x, y, tc.x, tc.y)
+		}
+	}
+
+	// Repeated tiles cover the painting area 0…100pt starting at 30pt.
+	xs := tilePositions("repeat", 0, aw, bag.MustSP("30pt"), 0, aw, tw)
+	if len(xs) != 6 || xs[0] != bag.MustSP("-10pt") {
+		t.Errorf("repeat tiles = %v, want 6 starting at -10pt", xs)
+	}
+	// space: four whole tiles, the outer ones touching the edges.
+	xs = tilePositions("space", 0, bag.MustSP("95pt"), 0, 0, bag.MustSP("95pt"), tw)
+	if len(xs) != 4 || xs[0] != 0 || xs[3] != bag.MustSP("75pt") {
+		t.Errorf("space tiles = %v", xs)
+	}
+}
+
+// TestGradientBackground: a gradient background is painted as a clipped
+// layer above the background color without moving the content.
+func TestGradientBackground(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+h2 { margin: 0; padding: 4pt; background-color: navy; }
+.grad { background-image: linear-gradient(to right, navy, white); }
+.radial { background-image: radial-gradient(circle, yellow, orange); background-clip: padding-box; }`
+	pages := renderHTMLPages(t, css, `<html><body><h2 class="grad">Umsatz</h2><div class="radial">Kreis</div><p>NACHHER</p></body></html>`)
+	if n := pageVListOrigins(pages[0], "html background image"); n != 2 {
+		t.Fatalf("got %d background image layers, want 2", n)
+	}
+	if n := len(borderRulePres(pages[0], "html background clip")); n != 2 {
+		t.Errorf("got %d background clip rules, want 2", n)
+	}
+	radial := borderRulePres(pages[0], "html background gradient")
+	if len(radial) != 1 || !strings.Contains(radial[0], " c ") {
+		t.Errorf("radial gradient is not drawn as ellipses: %q", radial)
+	}
+	shaded := lineTopY(pages[0], "NACHHER")
+	pages = renderHTMLPages(t, `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+h2 { margin: 0; padding: 4pt; background-color: navy; }`, `<html><body><h2>Umsatz</h2><div>Kreis</div><p>NACHHER</p></body></html>`)
+	if plain := lineTopY(pages[0], "NACHHER"); plain != shaded {
+		t.Errorf("background images moved the following flow: %s, want %s", shaded, plain)
+	}
+}
+
+// TestTableHeaderGradient: a gradient on a header row is painted by its
+// cells, which take over their background color.
+func TestTableHeaderGradient(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+tr.head { background-color: silver; background-image: linear-gradient(white, silver); }`
+	html := `<html><body><table>
+<tr class="head"><th>Artikel</th><th>Menge</th></tr>
+<tr><td>Schrauben</td><td>100</td></tr>
+</table></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if n := pageVListOrigins(pages[0], "html background image"); n != 2 {
+		t.Errorf("got %d cell background layers, want 2", n)
+	}
+	if n := len(borderRulePres(pages[0], "html background color")); n < 2 {
+		t.Errorf("got %d background color rules, want one per header cell", n)
+	}
+}
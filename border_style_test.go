@@ -0,0 +1,116 @@
+package htmlbag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// borderRulePres collects the Pre strings of all border rules with the
+// given origin on a page.
+func borderRulePres(pg *document.Page, origin string) []string {
+	var pres []string
+	var walk func(n node.Node)
+	walk = func(n node.Node) {
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.Rule:
+				if o, _ := v.Attributes["origin"].(string); o == origin {
+					pres = append(pres, v.Pre)
+				}
+			case *node.HList:
+				walk(v.List)
+			case *node.VList:
+				walk(v.List)
+			}
+		}
+	}
+	for _, obj := range pg.Objects {
+		if obj.Vlist != nil {
+			walk(obj.Vlist.List)
+		}
+	}
+	return pres
+}
+
+// TestBorderStyles: the non-solid border styles reach HTMLBorder. Dashed
+// and dotted borders are stroked with a dash pattern and round caps, double
+// borders are filled inside a clip of two bands.
+func TestBorderStyles(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+div { margin: 0 0 6pt 0; padding: 4pt; border-width: 3pt; border-color: red; }
+.dashed { border-style: dashed; }
+.dotted { border-style: dotted; border-radius: 6pt; }
+.double { border-style: double; }`
+	html := `<html><body>
+<div class="dashed">Coupon</div>
+<div class="dotted">Rounded</div>
+<div class="double">Total</div>
+</body></html>`
+	pages := renderHTMLPages(t, css, html)
+	pres := borderRulePres(pages[0], "html border + clipping")
+	if len(pres) != 3 {
+		t.Fatalf("got %d border rules, want 3", len(pres))
+	}
+	for i, pre := range pres[:2] {
+		if !strings.Contains(pre, " d ") || !strings.Contains(pre, "1 J") {
+			t.Errorf("border %d has no dash pattern with round caps: %q", i, pre)
+		}
+	}
+	if strings.Count(pres[2], "W n") < 2 {
+		t.Errorf("double border has no band clip: %q", pres[2])
+	}
+}
+
+// TestTableCellBorderStyle: a dashed cell border is drawn by htmlbag, not
+// by the frontend's solid cell borders.
+func TestTableCellBorderStyle(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+td { border-top: 1pt dashed black; padding: 2pt; }`
+	html := `<html><body><table><tr><td>Betrag</td><td>42,00</td></tr></table></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	pres := borderRulePres(pages[0], "table cell border")
+	if len(pres) != 2 {
+		t.Fatalf("got %d styled cell borders, want 2", len(pres))
+	}
+	if !strings.Contains(pres[0], " d ") {
+		t.Errorf("cell border is not dashed: %q", pres[0])
+	}
+}
+
+// TestTableHeaderBorderStylePages: the dashed borders of a header row
+// stay dashed on the pages the row is repeated on.
+func TestTableHeaderBorderStylePages(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+th { border: 1pt dashed black; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><thead><tr><th>KOPF</th><th>Betrag</th></tr></thead><tbody>`+sumRows(90)+`</tbody></table></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the table split", len(pages))
+	}
+	for p, pg := range pages {
+		pres := borderRulePres(pg, "table cell border")
+		if len(pres) != 2 {
+			t.Errorf("page %d: %d styled header borders, want 2", p+1, len(pres))
+			continue
+		}
+		if !strings.Contains(pres[0], " d ") {
+			t.Errorf("page %d: header border is not dashed: %q", p+1, pres[0])
+		}
+	}
+}
+
+func TestParseBorderStyle(t *testing.T) {
+	for _, v := range []string{"none", "hidden", "solid", "dashed", "dotted", "double", "groove", "ridge", "inset", "outset"} {
+		if _, ok := parseBorderStyle(v); !ok {
+			t.Errorf("parseBorderStyle(%q) not recognized", v)
+		}
+	}
+	if _, ok := parseBorderStyle("wavy"); ok {
+		t.Error("parseBorderStyle accepted an unknown style")
+	}
+	if sty, _ := parseBorderStyle("hidden"); isPrivateBorderStyle(sty) {
+		t.Error("hidden must behave like none")
+	}
+}
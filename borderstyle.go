@@ -0,0 +1,186 @@
+package htmlbag
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+)
+
+// The frontend only knows the border styles none and solid. The other CSS
+// border styles are htmlbag-private values of frontend.BorderStyle, far
+// above the frontend's own constants. Everywhere outside this file they
+// behave like solid (a border that takes up space and is drawn); only the
+// drawing code in borderRulePre tells them apart.
+const (
+	borderStyleDashed frontend.BorderStyle = 100 + iota
+	borderStyleDotted
+	borderStyleDouble
+	borderStyleGroove
+	borderStyleRidge
+	borderStyleInset
+	borderStyleOutset
+	// borderStyleHidden is none, except that it wins a border conflict of
+	// the collapsing table border model (see resolveEdge).
+	borderStyleHidden
+)
+
+// parseBorderStyle maps a CSS <line-style> keyword to a border style.
+// Reports false for unknown values.
+func parseBorderStyle(v string) (frontend.BorderStyle, bool) {
+	switch v {
+	case "none":
+		return frontend.BorderStyleNone, true
+	case "hidden":
+		return borderStyleHidden, true
+	case "solid":
+		return frontend.BorderStyleSolid, true
+	case "dashed":
+		return borderStyleDashed, true
+	case "dotted":
+		return borderStyleDotted, true
+	case "double":
+		return borderStyleDouble, true
+	case "groove":
+		return borderStyleGroove, true
+	case "ridge":
+		return borderStyleRidge, true
+	case "inset":
+		return borderStyleInset, true
+	case "outset":
+		return borderStyleOutset, true
+	}
+	return frontend.BorderStyleNone, false
+}
+
+// isPrivateBorderStyle reports whether sty needs htmlbag's own drawing
+// code, that is, anything but none and solid.
+func isPrivateBorderStyle(sty frontend.BorderStyle) bool {
+	return sty >= borderStyleDashed && sty <= borderStyleOutset
+}
+
+// borderSide is one side of a border: its style, color and width together
+// with the trapezoid (four corner points, counterclockwise) it covers.
+type borderSide struct {
+	style frontend.BorderStyle
+	color *color.Color
+	width bag.ScaledPoint
+	// topLeft is true for the top and left sides, which inset/outset and
+	// groove/ridge shade differently from the bottom and right sides.
+	topLeft bool
+	poly    [8]bag.ScaledPoint
+	// alpha is the alpha channel of color (1 = opaque).
+	alpha float64
+}
+
+// trapezoid appends the side's trapezoid as a closed path to d.
+func (bs borderSide) trapezoid(d *pdfdraw.Object) *pdfdraw.Object {
+	p := bs.poly
+	return d.Moveto(p[0], p[1]).Lineto(p[2], p[3]).Lineto(p[4], p[5]).Lineto(p[6], p[7]).Close()
+}
+
+// borderBand returns the border paths of the band between the fractions
+// from and to (0 = outer edge, 1 = inner edge) of every border width. The
+// corner radii shrink with the band like the inner radii of getBorderPaths.
+// As with getBorderPaths, filling outer followed by inner with the nonzero
+// rule covers just the band.
+func borderBand(x0, y0, x3, y3 bag.ScaledPoint, hv HTMLValues, from, to float64) (inner, outer *pdfdraw.Object) {
+	l := bag.MultiplyFloat(hv.BorderLeftWidth, from)
+	r := bag.MultiplyFloat(hv.BorderRightWidth, from)
+	t := bag.MultiplyFloat(hv.BorderTopWidth, from)
+	b := bag.MultiplyFloat(hv.BorderBottomWidth, from)
+	band := hv
+	band.BorderTopLeftRadius = bag.Max(0, hv.BorderTopLeftRadius-l)
+	band.BorderBottomLeftRadius = bag.Max(0, hv.BorderBottomLeftRadius-l)
+	band.BorderTopRightRadius = bag.Max(0, hv.BorderTopRightRadius-r)
+	band.BorderBottomRightRadius = bag.Max(0, hv.BorderBottomRightRadius-r)
+	band.BorderLeftWidth = bag.MultiplyFloat(hv.BorderLeftWidth, to-from)
+	band.BorderRightWidth = bag.MultiplyFloat(hv.BorderRightWidth, to-from)
+	band.BorderTopWidth = bag.MultiplyFloat(hv.BorderTopWidth, to-from)
+	band.BorderBottomWidth = bag.MultiplyFloat(hv.BorderBottomWidth, to-from)
+	return getBorderPaths(x0+l, y0-t, 0, 0, 0, 0, x3-r, y3+b, band)
+}
+
+// styledBorderSide returns the PDF instructions for a side with one of the
+// private border styles. It runs inside the clip of the whole border ring
+// set up by borderRulePre and saves/restores the graphics state itself.
+//
+//   - dashed and dotted stroke the center line of the ring (which follows
+//     the corner radii) with round caps, clipped to the side's trapezoid.
+//     Dots are as wide as the border, dashes twice as long plus their caps.
+//   - double fills the outer and the inner third of the side (CSS Backgrounds
+//     3 §4.3: the two lines and the gap add up to the border width).
+//   - groove/ridge fill the outer and the inner half with a darker and a
+//     lighter shade of the color, inset/outset the whole side with one of
+//     them, depending on whether the side is top/left or bottom/right.
+func styledBorderSide(bs borderSide, x0, y0, x3, y3 bag.ScaledPoint, hv HTMLValues) string {
+	fill := func(c *color.Color) string {
+		return bs.trapezoid(pdfdraw.New().ColorNonstroking(*c)).Fill().String()
+	}
+	band := func(from, to float64) string {
+		inner, outer := borderBand(x0, y0, x3, y3, hv, from, to)
+		return outer.String() + " " + inner.Clip().Endpath().String()
+	}
+	// dark is true for the shade a top/left side gets with inset and on the
+	// outer half with groove.
+	shade := func(dark bool) *color.Color {
+		return shadeBorderColor(bs.color, dark == bs.topLeft)
+	}
+
+	switch bs.style {
+	case borderStyleDashed, borderStyleDotted:
+		_, center := borderBand(x0, y0, x3, y3, hv, 0.5, 0.5)
+		dash := "[" + pdfLength(2*bs.width) + " " + pdfLength(4*bs.width) + "] 0 d"
+		if bs.style == borderStyleDotted {
+			dash = "[0 " + pdfLength(2*bs.width) + "] 0 d"
+		}
+		return "q " + bs.trapezoid(pdfdraw.New()).Clip().Endpath().String() + " " +
+			pdfLength(bs.width) + " w 1 J " + dash + " " + bs.color.PDFStringStroking() + " " +
+			center.Close().Stroke().String() + " Q"
+	case borderStyleDouble:
+		// One clip path with two bands: the nonzero rule leaves the gap
+		// between them out.
+		outerInner, outerOuter := borderBand(x0, y0, x3, y3, hv, 0, 1.0/3)
+		innerInner, innerOuter := borderBand(x0, y0, x3, y3, hv, 2.0/3, 1)
+		return "q " + outerOuter.String() + " " + outerInner.String() + " " +
+			innerOuter.String() + " " + innerInner.Clip().Endpath().String() + " " + fill(bs.color) + " Q"
+	case borderStyleGroove, borderStyleRidge:
+		dark := bs.style == borderStyleGroove
+		return "q " + band(0, 0.5) + " " + fill(shade(dark)) + " Q " +
+			"q " + band(0.5, 1) + " " + fill(shade(!dark)) + " Q"
+	case borderStyleInset, borderStyleOutset:
+		return "q " + fill(shade(bs.style == borderStyleInset)) + " Q"
+	}
+	return "q " + fill(bs.color) + " Q"
+}
+
+// shadeBorderColor returns the darker or lighter variant of c used for the
+// 3D border styles. CSS leaves the exact colors to the user agent; RGB and
+// CMYK colors are mixed half way with black or white, other color spaces
+// keep their color.
+func shadeBorderColor(c *color.Color, dark bool) *color.Color {
+	s := *c
+	switch s.Space {
+	case color.ColorRGB:
+		if dark {
+			s.R, s.G, s.B = s.R/2, s.G/2, s.B/2
+		} else {
+			s.R, s.G, s.B = s.R+(1-s.R)/2, s.G+(1-s.G)/2, s.B+(1-s.B)/2
+		}
+	case color.ColorCMYK:
+		if dark {
+			s.K += (1 - s.K) / 2
+		} else {
+			s.C, s.M, s.Y, s.K = s.C/2, s.M/2, s.Y/2, s.K/2
+		}
+	}
+	return &s
+}
+
+// pdfLength formats a length for a raw PDF operator (in PDF points).
+func pdfLength(sp bag.ScaledPoint) string {
+	return strconv.FormatFloat(math.Round(sp.ToPT()*1000)/1000, 'f', -1, 64)
+}
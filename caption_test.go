@@ -0,0 +1,105 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/document"
+)
+
+// captionRows returns n table rows with the cells ZEILEnn.
+func captionRows(n int) string {
+	var sb strings.Builder
+	for i := range n {
+		fmt.Fprintf(&sb, "<tr><td>ZEILE%02d</td><td>Wert</td></tr>", i)
+	}
+	return sb.String()
+}
+
+// TestCaptionSide: a caption sits above the table, with caption-side:
+// bottom below it.
+func TestCaptionSide(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.unten caption { caption-side: bottom; }`
+	pages := renderHTMLPages(t, css, `<html><body>
+<table><caption>OBERHALB</caption><tr><td>ERSTE</td></tr><tr><td>LETZTE</td></tr></table>
+<table class="unten"><caption>UNTERHALB</caption><tr><td>ANFANG</td></tr><tr><td>SCHLUSS</td></tr></table>
+</body></html>`)
+	_, oy, ok1 := textPos(pages[0], "OBERHALB")
+	_, ey, ok2 := textPos(pages[0], "ERSTE")
+	_, ly, ok3 := textPos(pages[0], "LETZTE")
+	_, ay, ok4 := textPos(pages[0], "ANFANG")
+	_, sy, ok5 := textPos(pages[0], "SCHLUSS")
+	_, uy, ok6 := textPos(pages[0], "UNTERHALB")
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+		t.Fatal("text not found")
+	}
+	if !(oy > ey && ey > ly && ly > ay) {
+		t.Errorf("top caption at %s, rows at %s and %s, next table at %s", oy, ey, ly, ay)
+	}
+	if !(ay > sy && sy > uy) {
+		t.Errorf("rows at %s and %s, bottom caption at %s", ay, sy, uy)
+	}
+}
+
+// TestCaptionContinued: -bag-caption-continued repeats the caption with
+// the text appended above the header rows of every continuation page, also
+// for a table without header rows.
+func TestCaptionContinued(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+caption { -bag-caption-continued: " FORTSETZUNG"; }`
+	for _, head := range []string{"<thead><tr><th>KOPF</th><th>Spalte</th></tr></thead>", ""} {
+		pages := renderHTMLPages(t, css, `<html><body><table><caption>TABELLE</caption>`+head+`<tbody>`+captionRows(80)+`</tbody></table></body></html>`)
+		if len(pages) < 2 {
+			t.Fatalf("got %d pages, want the table to break", len(pages))
+		}
+		if pageWith(pages, "TABELLE") != 0 || pageWith(pages, "FORTSETZUNG") != 1 {
+			t.Errorf("caption on page %d, continued caption on page %d", pageWith(pages, "TABELLE"), pageWith(pages, "FORTSETZUNG"))
+		}
+		_, cy, ok := textPos(pages[1], "FORTSETZUNG")
+		if !ok {
+			continue
+		}
+		first := "KOPF"
+		if head == "" {
+			for i := range 80 {
+				if pageWith(pages, fmt.Sprintf("ZEILE%02d", i)) == 1 {
+					first = fmt.Sprintf("ZEILE%02d", i)
+					break
+				}
+			}
+		}
+		if _, ry, ok := textPos(pages[1], first); !ok || ry >= cy {
+			t.Errorf("continued caption at %s, %s at %s, want the caption above", cy, first, ry)
+		}
+	}
+}
+
+// TestCaptionTagged: the caption is the first child of the Table structure
+// element, a bottom caption the last.
+func TestCaptionTagged(t *testing.T) {
+	root := renderForStructTree(t, `<!DOCTYPE html><html><body>
+<table><caption>Oben</caption><tr><td>a</td></tr></table>
+<table><caption style="caption-side: bottom">Unten</caption><tr><td>b</td></tr></table>
+</body></html>`)
+	var tables []*document.StructureElement
+	walkStruct(root, func(se *document.StructureElement) {
+		if se.Role == "Table" {
+			tables = append(tables, se)
+		}
+	})
+	if len(tables) != 2 {
+		t.Fatalf("got %d Table elements, want 2", len(tables))
+	}
+	for i, want := range []string{"Oben", "Unten"} {
+		children := tables[i].Children()
+		c := children[0]
+		if i == 1 {
+			c = children[len(children)-1]
+		}
+		if c.Role != "Caption" || c.ActualText != want {
+			t.Errorf("table %d: child %s %q, want Caption %q", i, c.Role, c.ActualText, want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package htmlbag
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+)
+
+// boxClip is the clipping request carried by settingClip: overflow hidden
+// clips to the padding box (CSS Overflow 3 §3) along the axes whose
+// overflow hides, clipPath is a CSS Masking 1 basic shape resolved against
+// the border box. The font sizes resolve em lengths inside the shape.
+type boxClip struct {
+	overflowX    bool
+	overflowY    bool
+	clipPath     string
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+}
+
+// elementClip returns the clipping request of an element's styles, if any.
+func elementClip(styles *FormattingStyles) (boxClip, bool) {
+	bc := boxClip{
+		clipPath:     styles.clipPath,
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+	}
+	bc.overflowX = overflowHides(styles.overflowX)
+	bc.overflowY = overflowHides(styles.overflowY)
+	return bc, bc.overflowX || bc.overflowY || bc.clipPath != ""
+}
+
+// overflowHides reports whether an overflow value clips the box along its
+// axis. There is no scrolling on paper, so hidden and clip behave alike.
+func overflowHides(o string) bool {
+	return o == "hidden" || o == "clip"
+}
+
+// clipVList wraps vl so that everything it paints is clipped. The wrapper
+// list is
+//
+//	StartStop "q" → Rule (clip path, W n) → vl → StartStop "Q"
+//
+// The save/restore pair is emitted in page mode by the start/stop nodes;
+// the rule sits at the top left corner of the box, so its path is drawn in
+// box coordinates (y grows upward, the box spans 0 … -height). The wrapper
+// takes over vl's attributes so the paginator and the tagging code see the
+// same node as before. A splittable box stays splittable: outputBlockSplit
+// clips every fragment to its own padding box again (_splittableClip).
+func (cb *CSSBuilder) clipVList(vl *node.VList, hv HTMLValues, bc boxClip) *node.VList {
+	wd := vl.Width
+	ht := vl.Height + vl.Depth
+	var paths []string
+	if bc.clipPath != "" {
+		if shape := clipShape(bc, wd, ht); shape != nil {
+			paths = append(paths, shape.Clip().Endpath().String())
+		}
+	}
+	switch {
+	case bc.overflowX && bc.overflowY:
+		// The padding box: the inner edge of the border, whose corners
+		// follow the border radii reduced by the border widths.
+		inner, _ := getBorderPaths(0, 0, 0, 0, 0, 0, wd, -ht, hv)
+		paths = append(paths, inner.Clip().Endpath().String())
+	case bc.overflowX || bc.overflowY:
+		// One axis only: the padding edges of that axis, the other axis
+		// left open far beyond the page.
+		x, y := -clipFar, clipFar
+		w, h := wd+2*clipFar, ht+2*clipFar
+		if bc.overflowX {
+			x, w = hv.BorderLeftWidth, wd-hv.BorderLeftWidth-hv.BorderRightWidth
+		}
+		if bc.overflowY {
+			y, h = -hv.BorderTopWidth, ht-hv.BorderTopWidth-hv.BorderBottomWidth
+		}
+		paths = append(paths, pdfdraw.New().Rect(x, y-h, w, h).Clip().Endpath().String())
+	}
+	if len(paths) == 0 {
+		return vl
+	}
+
+	save := node.NewStartStop()
+	save.Position = node.PDFOutputPage
+	save.ShipoutCallback = func(n node.Node) string {
+		return "q "
+	}
+	restore := node.NewStartStop()
+	restore.Position = node.PDFOutputPage
+	restore.ShipoutCallback = func(n node.Node) string {
+		return "Q "
+	}
+	r := node.NewRule()
+	r.Hide = true
+	r.Pre = strings.Join(paths, " ")
+	r.Attributes = node.H{"origin": "clip path"}
+
+	var head node.Node = save
+	head = node.InsertAfter(head, save, r)
+	head = node.InsertAfter(head, r, vl)
+	head = node.InsertAfter(head, vl, restore)
+	wrapper := node.Vpack(head)
+	wrapper.Width = vl.Width
+	wrapper.Height = vl.Height
+	wrapper.Depth = vl.Depth
+	wrapper.Attributes = vl.Attributes
+	if wrapper.Attributes == nil {
+		wrapper.Attributes = node.H{}
+	}
+	if _, ok := wrapper.Attributes["_splittable"]; ok {
+		wrapper.Attributes["_splittableClip"] = fragmentClip{bc: bc, hv: hv, width: wd}
+	}
+	vl.Attributes = node.H{"origin": "clipped content"}
+	return wrapper
+}
+
+// fragmentClip is the clip of a splittable box (_splittableClip): the
+// request, the box's border and radii and its full width, which a fragment
+// without a border wrapper does not have.
+type fragmentClip struct {
+	bc    boxClip
+	hv    HTMLValues
+	width bag.ScaledPoint
+}
+
+// clipFragment clips one fragment of a split box. The cut edges have
+// neither a border nor rounded corners, so the clip runs straight along
+// them.
+func (cb *CSSBuilder) clipFragment(vl *node.VList, fc fragmentClip, first, last bool) *node.VList {
+	hv := fc.hv
+	if !first {
+		hv.BorderTopWidth = 0
+		hv.BorderTopLeftRadius = 0
+		hv.BorderTopRightRadius = 0
+	}
+	if !last {
+		hv.BorderBottomWidth = 0
+		hv.BorderBottomLeftRadius = 0
+		hv.BorderBottomRightRadius = 0
+	}
+	vl.Width = fc.width
+	return cb.clipVList(vl, hv, fc.bc)
+}
+
+// clipFar is the extent of a clip path along an axis that is not clipped.
+const clipFar = 10000 * bag.Factor
+
+// cropVList implements a fixed CSS height with overflow: hidden: the nodes
+// that start below h are dropped and the box is set to exactly h. What
+// still reaches past h is cut off by the clip path of clipVList. It reports
+// whether anything was cut.
+func cropVList(vl *node.VList, h bag.ScaledPoint) bool {
+	if vl.Height+vl.Depth <= h {
+		return false
+	}
+	var sum bag.ScaledPoint
+	for n := vl.List; n != nil; n = n.Next() {
+		sum += verticalExtent(n)
+		if sum >= h {
+			n.SetNext(nil)
+			break
+		}
+	}
+	vl.Height = h
+	vl.Depth = 0
+	return true
+}
+
+// clipShape builds the path of a CSS basic shape (inset(), circle(),
+// ellipse(), polygon()) for a box of the given size. Percentages resolve
+// against the box width (x), the box height (y) or, for circle radii, the
+// normalized diagonal (CSS Shapes 1 §3.1). Returns nil for unsupported
+// values; the reference-box keywords are not supported, every shape uses
+// the border box.
+func clipShape(bc boxClip, wd, ht bag.ScaledPoint) *pdfdraw.Object {
+	v := strings.TrimSpace(bc.clipPath)
+	open := strings.Index(v, "(")
+	if open < 0 || !strings.HasSuffix(v, ")") {
+		return nil
+	}
+	fn := strings.TrimSpace(v[:open])
+	args := v[open+1 : len(v)-1]
+	length := func(s string, ref bag.ScaledPoint) bag.ScaledPoint {
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return 0
+			}
+			return bag.MultiplyFloat(ref, f/100)
+		}
+		return ParseRelativeSize(s, bc.fontsize, bc.rootFontsize)
+	}
+	// position resolves an "at <x> <y>" center, defaulting to the middle.
+	position := func(s string) (bag.ScaledPoint, bag.ScaledPoint) {
+		cx, cy := wd/2, ht/2
+		f := strings.Fields(s)
+		keyword := map[string]string{"left": "0%", "center": "50%", "right": "100%", "top": "0%", "bottom": "100%"}
+		for i := range f {
+			if k, ok := keyword[f[i]]; ok {
+				f[i] = k
+			}
+		}
+		if len(f) > 0 {
+			cx = length(f[0], wd)
+		}
+		if len(f) > 1 {
+			cy = length(f[1], ht)
+		}
+		return cx, cy
+	}
+
+	d := pdfdraw.New()
+	switch fn {
+	case "inset":
+		// inset( <length-percentage>{1,4} [ round <border-radius> ]? )
+		shape, round, _ := strings.Cut(args, "round")
+		f := strings.Fields(shape)
+		if len(f) == 0 {
+			return nil
+		}
+		// Expand like the margin shorthand: top, right, bottom, left.
+		for len(f) < 4 {
+			switch len(f) {
+			case 1:
+				f = append(f, f[0])
+			case 2:
+				f = append(f, f[0])
+			case 3:
+				f = append(f, f[1])
+			}
+		}
+		t, r, b, l := length(f[0], ht), length(f[1], wd), length(f[2], ht), length(f[3], wd)
+		var hv HTMLValues
+		if rf := strings.Fields(round); len(rf) > 0 {
+			rad := length(rf[0], wd)
+			hv.BorderTopLeftRadius = rad
+			hv.BorderTopRightRadius = rad
+			hv.BorderBottomLeftRadius = rad
+			hv.BorderBottomRightRadius = rad
+		}
+		_, outer := getBorderPaths(l, -t, 0, 0, 0, 0, wd-r, -(ht - b), hv)
+		return outer
+	case "circle", "ellipse":
+		shape, at, _ := strings.Cut(args, " at ")
+		if strings.HasPrefix(strings.TrimSpace(args), "at ") {
+			shape, at = "", strings.TrimPrefix(strings.TrimSpace(args), "at ")
+		}
+		cx, cy := position(at)
+		// closest-side (the default) and farthest-side per axis.
+		closestX, farthestX := min(cx, wd-cx), max(cx, wd-cx)
+		closestY, farthestY := min(cy, ht-cy), max(cy, ht-cy)
+		f := strings.Fields(shape)
+		var rx, ry bag.ScaledPoint
+		if fn == "circle" {
+			rx = min(closestX, closestY)
+			if len(f) > 0 {
+				switch f[0] {
+				case "closest-side":
+				case "farthest-side":
+					rx = max(farthestX, farthestY)
+				default:
+					diag := bag.ScaledPoint(math.Hypot(float64(wd), float64(ht)) / math.Sqrt2)
+					rx = length(f[0], diag)
+				}
+			}
+			ry = rx
+		} else {
+			rx, ry = closestX, closestY
+			side := func(s string, closest, farthest, ref bag.ScaledPoint) bag.ScaledPoint {
+				switch s {
+				case "closest-side":
+					return closest
+				case "farthest-side":
+					return farthest
+				}
+				return length(s, ref)
+			}
+			if len(f) > 1 {
+				rx = side(f[0], closestX, farthestX, wd)
+				ry = side(f[1], closestY, farthestY, ht)
+			}
+		}
+		return ellipsePath(d, cx, -cy, rx, ry)
+	case "polygon":
+		points := strings.Split(args, ",")
+		if len(points) > 0 {
+			switch strings.TrimSpace(points[0]) {
+			case "nonzero", "evenodd":
+				points = points[1:]
+			}
+		}
+		if len(points) < 3 {
+			return nil
+		}
+		for i, pt := range points {
+			f := strings.Fields(pt)
+			if len(f) != 2 {
+				return nil
+			}
+			x, y := length(f[0], wd), -length(f[1], ht)
+			if i == 0 {
+				d.Moveto(x, y)
+			} else {
+				d.Lineto(x, y)
+			}
+		}
+		d.Close()
+		return d
+	}
+	return nil
+}
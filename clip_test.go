@@ -0,0 +1,170 @@
+package htmlbag
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// findClipRule returns the clip path rule emitted by clipVList below n.
+func findClipRule(n node.Node) *node.Rule {
+	for ; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.Rule:
+			if o, _ := v.Attributes["origin"].(string); o == "clip path" {
+				return v
+			}
+		case *node.HList:
+			if r := findClipRule(v.List); r != nil {
+				return r
+			}
+		case *node.VList:
+			if r := findClipRule(v.List); r != nil {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+func pageClipRule(pg *document.Page) *node.Rule {
+	for _, obj := range pg.Objects {
+		if obj.Vlist == nil {
+			continue
+		}
+		if r := findClipRule(obj.Vlist.List); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// TestOverflowHiddenFixedHeight: a box with a fixed height and overflow:
+// hidden reserves exactly its height, drops the lines below it and is
+// wrapped in a clip path.
+func TestOverflowHiddenFixedHeight(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p, div { margin: 0; font-size: 10pt; line-height: 12pt; }
+.box { height: 30pt; overflow: hidden; }`
+	body := "ANFANG " + strings.Repeat("Katalogtext mit vielen Wörtern ", 60) + "ENDWORT"
+	html := `<html><body><div class="box">` + body + `</div><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if strings.Contains(pageText(pages[0]), "ENDWORT") {
+		t.Error("overflow: hidden box still shows its last word")
+	}
+	top := lineTopY(pages[0], "ANFANG")
+	next := lineTopY(pages[0], "NACHHER")
+	if got, want := top-next, bag.MustSP("30pt"); got != want {
+		t.Errorf("following paragraph starts %s below the box, want %s (height)", got, want)
+	}
+	r := pageClipRule(pages[0])
+	if r == nil {
+		t.Fatal("no clip path rule on the page")
+	}
+	if !strings.Contains(r.Pre, "W n") {
+		t.Errorf("clip rule does not clip: %q", r.Pre)
+	}
+}
+
+// TestClipPathShapes covers the basic shape parser.
+func TestClipPathShapes(t *testing.T) {
+	wd, ht := bag.MustSP("100pt"), bag.MustSP("50pt")
+	cases := []struct {
+		in string
+		ok bool
+	}{
+		{in: "inset(10pt)", ok: true},
+		{in: "inset(10% 5pt round 4pt)", ok: true},
+		{in: "circle()", ok: true},
+		{in: "circle(20pt at left top)", ok: true},
+		{in: "ellipse(closest-side farthest-side at 30% 50%)", ok: true},
+		{in: "polygon(50% 0%, 100% 100%, 0% 100%)", ok: true},
+		{in: "polygon(evenodd, 0 0, 10pt 0, 10pt 10pt)", ok: true},
+		{in: "polygon(0 0, 10pt 0)", ok: false},
+		{in: "url(#mask)", ok: false},
+		{in: "border-box", ok: false},
+	}
+	for _, tc := range cases {
+		bc := boxClip{clipPath: tc.in, fontsize: bag.MustSP("10pt"), rootFontsize: bag.MustSP("10pt")}
+		if got := clipShape(bc, wd, ht) != nil; got != tc.ok {
+			t.Errorf("clipShape(%q) ok = %v, want %v", tc.in, got, tc.ok)
+		}
+	}
+}
+
+// TestOverflowLonghandPrecedence: a longhand wins over the shorthand
+// whatever the order of the declarations, and visible resets an axis.
+func TestOverflowLonghandPrecedence(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	cases := []struct {
+		decls map[string]string
+		x, y  string
+	}{
+		{decls: map[string]string{"overflow": "hidden", "overflow-y": "visible"}, x: "hidden", y: "visible"},
+		{decls: map[string]string{"overflow": "visible", "overflow-x": "clip"}, x: "clip", y: "visible"},
+		{decls: map[string]string{"overflow": "hidden clip"}, x: "hidden", y: "clip"},
+	}
+	for _, tc := range cases {
+		// Map iteration order is random, repeat to cover both orders.
+		for i := 0; i < 20; i++ {
+			ih := &FormattingStyles{DefaultFontSize: bag.MustSP("10pt"), Fontsize: bag.MustSP("10pt")}
+			if err := StylesToStyles(ih, tc.decls, fe, ih.Fontsize); err != nil {
+				t.Fatalf("StylesToStyles: %v", err)
+			}
+			if ih.overflowX != tc.x || ih.overflowY != tc.y {
+				t.Fatalf("%v: overflow x/y = %q/%q, want %q/%q", tc.decls, ih.overflowX, ih.overflowY, tc.x, tc.y)
+			}
+		}
+	}
+
+	// overflow-y: visible keeps the lines below the fixed height.
+	css := `@page { size: a4; margin: 20mm; }
+p, div { margin: 0; font-size: 10pt; line-height: 12pt; }
+.box { height: 30pt; overflow: hidden; overflow-y: visible; }`
+	body := "ANFANG " + strings.Repeat("Katalogtext mit vielen Wörtern ", 20) + "ENDWORT"
+	pages := renderHTMLPages(t, css, `<html><body><div class="box">`+body+`</div></body></html>`)
+	if !strings.Contains(pageText(pages[0]), "ENDWORT") {
+		t.Error("overflow-y: visible box lost its last word")
+	}
+	if pageClipRule(pages[0]) == nil {
+		t.Error("overflow-x: hidden box is not clipped")
+	}
+}
+
+// TestOverflowHiddenSplits: a box with overflow: hidden but no fixed
+// height breaks across pages like any other box, and every fragment is
+// clipped on its own page.
+func TestOverflowHiddenSplits(t *testing.T) {
+	css := `@page { size: a5; margin: 20mm; }
+p, div { margin: 0; font-size: 10pt; line-height: 12pt; }
+.box { overflow: hidden; border: 1pt solid black; border-radius: 4pt; }`
+	body := "ANFANG " + strings.Repeat("Katalogtext mit vielen Wörtern ", 300) + "ENDWORT"
+	for _, html := range []string{
+		`<html><body><div class="box"><p>` + body + `</p><p>` + body + `</p></div></body></html>`,
+		`<html><body><p class="box">` + body + `</p></body></html>`,
+	} {
+		pages := renderHTMLPages(t, css, html)
+		if len(pages) < 2 {
+			t.Fatalf("got %d pages, want the box split across pages", len(pages))
+		}
+		if !strings.Contains(pageText(pages[len(pages)-1]), "ENDWORT") {
+			t.Error("split overflow: hidden box lost its last word")
+		}
+		for i, pg := range pages {
+			if pageClipRule(pg) == nil {
+				t.Errorf("page %d: fragment is not clipped", i+1)
+			}
+		}
+	}
+}
@@ -104,6 +104,10 @@ type CSSBuilder struct {
 	enableTagging         bool
 	ElementCallback       ElementCallbackFunc
 	PageInitCallback      PageInitCallbackFunc
+	// TableBreakCallback generates the rows placed where a table breaks
+	// across pages (see TableBreakCallbackFunc). It takes the place of
+	// -bag-running-sum for every table.
+	TableBreakCallback TableBreakCallbackFunc
 	// Counters holds named counter values used when evaluating CSS content
 	// properties (e.g. "page" for the current page, "pages" for the total).
 	// The "page" counter is set automatically during shipout; other counters
@@ -152,9 +156,39 @@ type CSSBuilder struct {
 	// calls. Drained into the table VList's "inserts" attribute at the
 	// end of buildTable.
 	tableInserts []*Insert
+	// tableRowInserts holds which of tableInserts come from the cells of
+	// each row of the in-flight table. Filled by buildTR, read by
+	// markRowInserts.
+	tableRowInserts map[*frontend.TableRow][]*Insert
 	// tableInsertWidth is the width to format insert bodies inside a
 	// table cell. Set by buildTable at entry, read by buildTD.
 	tableInsertWidth bag.ScaledPoint
+	// tableCellBorders holds the borders of the in-flight table's cells
+	// whose style the frontend cannot draw (dashed, double, ...). Filled
+	// by buildTD, drawn by drawCellDecorations after BuildTable.
+	tableCellBorders map[*frontend.TableCell]HTMLValues
+	// tableCellBackgrounds holds the backgrounds of the in-flight table's
+	// cells that have background images (or a background-clip); these
+	// cells paint their background color themselves as well. Filled by
+	// buildTD, drawn by drawCellDecorations.
+	tableCellBackgrounds map[*frontend.TableCell]HTMLValues
+	// tableCellTransforms holds the CSS transforms of the in-flight
+	// table's cells. Filled by buildTD, applied by drawCellDecorations.
+	tableCellTransforms map[*frontend.TableCell]*boxTransform
+	// tableCellTransparencies holds the opacity and blend modes of the
+	// in-flight table's cells. Filled by buildTD, applied by
+	// drawCellDecorations.
+	tableCellTransparencies map[*frontend.TableCell]*boxTransparency
+	// tableCellDiagonals holds the diagonal lines of the in-flight table's
+	// cells. Filled by buildTD, drawn by drawCellDecorations.
+	tableCellDiagonals map[*frontend.TableCell]*cellDiagonals
+	// tableCellModels holds what the border model of the in-flight table
+	// makes of its cells' borders (see cellBorderModels), keyed by the
+	// source Text of the cell. nil for the original model.
+	tableCellModels map[*frontend.Text]*cellBorderModel
+	// extGStates are the ExtGStates (constant alpha, blend mode) written so
+	// far, see gs.
+	extGStates map[extGState]extGStateResource
 	// pageBuf collects body content for the current page that has been
 	// committed by the page builder but not yet painted. flushInserts
 	// drains it at shipout time, *after* the float reservation at the top
@@ -185,15 +219,29 @@ type CSSBuilder struct {
 	// the initial containing block is always available.
 	positioningContext []positioningContext
 	// positionedItems collects PositionedInsert entries for the
-	// current page. Filled by handlePositioned when an out-of-flow
-	// `position: absolute` element is encountered; drained and
-	// painted by paintPositionedItems from inside flushInserts
+	// current page. Filled by queuePositioned from the positioned
+	// inserts whose anchors landed on the page and by addFixedItems;
+	// drained and painted by paintPositionedItems from inside
+	// flushInserts
 	// (after the buffered body, before bottom-floats — see CSS 2.1
 	// App. E painting order). Kept as a parallel buffer (not in
 	// pageInserts) because positioned items carry resolved pixel
 	// coordinates and must not influence pageInsertHeight or the
 	// flow's trial-fit calculations.
 	positionedItems []*PositionedInsert
+	// pagePositioned holds positioned elements waiting for a later
+	// page, keyed by the 1-based page number: those placed by
+	// -bag-position-page and those whose offsets reach beyond the
+	// page their anchor landed on (see schedulePositioned).
+	pagePositioned map[int][]*PositionedInsert
+	// positionedOrder numbers the positioned elements in document
+	// order, see nextPositionedOrder.
+	positionedOrder int
+	// fixedElements holds the position: fixed elements, captured by
+	// captureFixedElement and queued on every page by addFixedItems.
+	// fixedElementsPage is the last page they were queued for.
+	fixedElements     []fixedElement
+	fixedElementsPage *document.Page
 	// runningElements holds the body Text of every element removed from
 	// the normal flow via `position: running(name)` (CSS GCPM running
 	// elements), keyed by name. Filled during HTMLNodeToText; consumed by
@@ -245,6 +293,7 @@ func New(fd *frontend.Document, c *csshtml.CSS) (*CSSBuilder, error) {
 		Counters:                map[string]int{},
 		PendingVLists:           map[string]*node.VList{},
 		pageInserts:             map[InsertClass][]*Insert{},
+		pagePositioned:          map[int][]*PositionedInsert{},
 		runningElements:         map[string]*frontend.Text{},
 		pageInsertHeight:        map[InsertClass]bag.ScaledPoint{},
 		FootnoteSeparatorHeight: defaultFootnoteSeparatorHeight,
@@ -775,7 +824,11 @@ func (cb *CSSBuilder) OutputPages(vl *node.VList) error {
 		if !ok || inner.Next() != nil {
 			break
 		}
-		propagateInsertsAttr(inner, inner.List)
+		if o, _ := inner.Attributes["origin"].(string); o == "table" {
+			spreadRowInserts(inner)
+		} else {
+			propagateInsertsAttr(inner, inner.List)
+		}
 		contentList = inner.List
 		if inner.Width > 0 {
 			contentWidth = inner.Width
@@ -948,6 +1001,9 @@ func (cb *CSSBuilder) OutputPages(vl *node.VList) error {
 	if err := cb.flushInserts(); err != nil {
 		return err
 	}
+	if err := cb.addPositionedPages(); err != nil {
+		return err
+	}
 	if err := cb.BeforeShipout(); err != nil {
 		return err
 	}
@@ -1136,6 +1192,9 @@ func (cb *CSSBuilder) OutputPagesFromText(te *frontend.Text) error {
 	if err := cb.flushInserts(); err != nil {
 		return err
 	}
+	if err := cb.addPositionedPages(); err != nil {
+		return err
+	}
 	if err := cb.BeforeShipout(); err != nil {
 		return err
 	}
@@ -1246,6 +1305,11 @@ func stampGroupItemIndices(wrapper *frontend.Text, vl *node.VList) {
 		if !ok {
 			continue
 		}
+		// The column parts after the first of a table split by
+		// -bag-table-overflow belong to the item of the first part.
+		if part, _ := child.Attributes["_tablePart"].(bool); part {
+			continue
+		}
 		idx := nextItemIdx()
 		if idx < 0 {
 			return
@@ -1366,7 +1430,11 @@ func (cb *CSSBuilder) outputGroupNodes(vl *node.VList, pd PageDimensions) (int,
 				break
 			}
 		}
-		propagateInsertsAttr(inner, inner.List)
+		if o, _ := inner.Attributes["origin"].(string); o == "table" {
+			spreadRowInserts(inner)
+		} else {
+			propagateInsertsAttr(inner, inner.List)
+		}
 		contentList = inner.List
 		if inner.Width > 0 {
 			contentWidth = inner.Width
@@ -1421,6 +1489,11 @@ func (cb *CSSBuilder) outputGroupNodes(vl *node.VList, pd PageDimensions) (int,
 					c[key] = v
 				}
 			}
+			// Positioned inserts may ride on the item's descendants,
+			// the rebuilt item carries them itself.
+			if ins := insertsOnNode(nvl); len(ins) > 0 {
+				c["inserts"] = ins
+			}
 			if len(c) > 0 {
 				carry[idx] = c
 			}
@@ -1516,19 +1589,15 @@ func (cb *CSSBuilder) outputGroupNodes(vl *node.VList, pd PageDimensions) (int,
 					yLocal := pd.Height - pd.PageAreaTop - topFloatH - flushedBodyH
 					yLimitLocal := pd.pageAreaBottom()
 					phc := flushedBodyH > 0 || topFloatH > 0
+					// outputTableRows queues the inserts of the cells
+					// (typically footnotes) on the pages of their rows.
+					// The others go to the page that holds the table's
+					// last rows; they paint at the next flushInserts.
+					_, tableRest := rowInserts(tableVL, tableIncoming)
 					if err := cb.outputTableRows(tableVL, buildHeadersFn, &yLocal, &yLimitLocal, &phc, &pd); err != nil {
 						return -1, nil, err
 					}
-					// Commit the table's own inserts (typically footnotes
-					// from cells) to the page that holds the table's last
-					// rows; they paint at the next flushInserts.
-					if len(tableIncoming) > 0 {
-						for _, ins := range tableIncoming {
-							cb.pageInserts[ins.Class] = append(cb.pageInserts[ins.Class], ins)
-						}
-						cb.pageInsertHeight[InsertFloatTop] = cb.totalFloatTopHeight(cb.pageInserts[InsertFloatTop])
-						cb.pageInsertHeight[InsertFootnote] = cb.totalFootnoteHeight(cb.pageInserts[InsertFootnote])
-					}
+					cb.queueInserts(tableRest)
 					// Siblings after the table continue on the table's
 					// last page. The body buffer paints from the top of the
 					// content area at flushInserts, so buffer a spacer
@@ -1566,9 +1635,9 @@ func (cb *CSSBuilder) outputGroupNodes(vl *node.VList, pd PageDimensions) (int,
 			o, _ := tableVL.Attributes["origin"].(string)
 			_, hasHeaders := tableVL.Attributes["_buildHeaders"]
 			if o == "table" && !hasHeaders && cb.pageBufHeight+h > contentArea && tableVL.List != nil {
-				// Move any cell inserts onto the first row so they are still
+				// Move the cell inserts onto their rows so they are still
 				// reserved once the wrapper VList is dropped.
-				propagateInsertsAttr(tableVL, tableVL.List)
+				spreadRowInserts(tableVL)
 				first := tableVL.List
 				last := node.Tail(first)
 				last.SetNext(next)
@@ -1827,26 +1896,17 @@ func (cb *CSSBuilder) outputBlockSplit(blockVL *node.VList, pd *PageDimensions,
 			frontend.ParagraphTailStep{Width: teWidth, Lines: placedLines})
 		// Strip the htmlbag-private sentinels around the frontend call:
 		// they would hit the strict unknown-setting default in Mknodes.
-		pbi, hasPBI := splitTe.Settings[settingPageBreakInside]
-		delete(splitTe.Settings, settingPageBreakInside)
-		ch, hasCH := splitTe.Settings[settingCSSHeight]
-		delete(splitTe.Settings, settingCSSHeight)
-		bm, hasBM := splitTe.Settings[settingBookmark]
-		delete(splitTe.Settings, settingBookmark)
+		private := stripPrivateSettings(splitTe.Settings)
 		tailVL, err := cb.frontend.FormatParagraphTail(splitTe, steps, newTeWidth)
-		if hasPBI {
-			splitTe.Settings[settingPageBreakInside] = pbi
-		}
-		if hasCH {
-			splitTe.Settings[settingCSSHeight] = ch
-		}
-		if hasBM {
-			splitTe.Settings[settingBookmark] = bm
-		}
+		private.restore(splitTe.Settings)
 		if err != nil || tailVL == nil {
 			slog.Debug("width reflow of splittable block failed, keeping built width", "error", err)
 			return nil
 		}
+		// The re-broken lines need the alpha of a translucent text color
+		// again (see translucentLines).
+		bt, _ := private[settingTransparency].(*boxTransparency)
+		cb.translucentLines(tailVL, bt)
 
 		var newChildren []node.Node
 		if i == 0 && placedLines == 0 {
@@ -1920,6 +1980,7 @@ func (cb *CSSBuilder) outputBlockSplit(blockVL *node.VList, pd *PageDimensions,
 		fragOnly
 	)
 
+	var fragOffset bag.ScaledPoint
 	// buildFragment wraps a slice of inner children with HTMLBorder using a
 	// per-fragment HTMLValues that drops paddings/borders on the cut sides.
 	// Bei noWrapper bleibt der innere VList unverpackt — kein extra Box-Frame.
@@ -1960,6 +2021,51 @@ func (cb *CSSBuilder) outputBlockSplit(blockVL *node.VList, pd *PageDimensions,
 		// rendering, so the fragment is nested one level deeper — the
 		// buffered box is placed by OutputAt, which ignores its own
 		// ShiftX. Same shape as the non-split path in outputGroupNodes.
+		// position: relative: every fragment hangs the positioned
+		// descendants whose top edge it holds (those above the box go with
+		// the first, those below it with the last one) and is shifted by
+		// the offset on its own (see attachPositioned and shiftVList).
+		// fragOffset is the top of the fragment in box coordinates.
+		hang := func(vl *node.VList) *node.VList {
+			from := fragOffset
+			to := from + vl.Height + vl.Depth
+			fragOffset = to
+			hangs, _ := blockVL.Attributes["_splittablePositioned"].([]positionedHang)
+			if len(hangs) == 0 {
+				return vl
+			}
+			first := kind == fragTop || kind == fragOnly
+			last := kind == fragBottom || kind == fragOnly
+			var sel []positionedHang
+			for _, h := range hangs {
+				if (h.top >= from || first) && (h.top < to || last) {
+					sel = append(sel, h)
+				}
+			}
+			return cb.hangPositioned(vl, sel, from)
+		}
+		shift := func(vl *node.VList) *node.VList {
+			if dy, ok := blockVL.Attributes["_splittableShift"].(bag.ScaledPoint); ok {
+				return cb.shiftVList(vl, dy)
+			}
+			return vl
+		}
+		// overflow: hidden: every fragment is clipped to its own padding
+		// box (see clipFragment).
+		clip := func(vl *node.VList) *node.VList {
+			if fc, ok := blockVL.Attributes["_splittableClip"].(fragmentClip); ok {
+				return cb.clipFragment(vl, fc, kind == fragTop || kind == fragOnly, kind == fragBottom || kind == fragOnly)
+			}
+			return vl
+		}
+		// opacity and mix-blend-mode: every fragment selects the graphics
+		// state of the block on its own (see groupVList).
+		group := func(vl *node.VList) *node.VList {
+			if bt, ok := blockVL.Attributes["_splittableGroup"].(*boxTransparency); ok {
+				return cb.groupVList(vl, bt)
+			}
+			return vl
+		}
 		shiftWrap := func(vl *node.VList) *node.VList {
 			if blockVL.ShiftX == 0 {
 				return vl
@@ -1973,7 +2079,7 @@ func (cb *CSSBuilder) outputBlockSplit(blockVL *node.VList, pd *PageDimensions,
 			return outer
 		}
 		if noWrapper {
-			out := shiftWrap(innerVL)
+			out := shiftWrap(shift(group(clip(hang(innerVL)))))
 			return out, vlistNodeHeight(out)
 		}
 		fragHv := hv
@@ -1998,7 +2104,7 @@ func (cb *CSSBuilder) outputBlockSplit(blockVL *node.VList, pd *PageDimensions,
 				}
 			}
 		}
-		out := shiftWrap(wrapped)
+		out := shiftWrap(shift(group(clip(hang(wrapped)))))
 		return out, vlistNodeHeight(out)
 	}
 
@@ -2198,6 +2304,9 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 	if bf, ok := tableVL.Attributes["_buildFooters"].(func() ([]*node.HList, error)); ok {
 		buildFooters = bf
 	}
+	// A caption with -bag-caption-continued repeats above the header rows
+	// of every continuation page.
+	caption := tableCaptionOf(tableVL)
 
 	// Collect all row nodes from the table VList. The trailing
 	// footerCount rows are pulled out of the normal stream and placed
@@ -2208,6 +2317,49 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 	}
 	dataEnd := len(rows) - footerCount
 
+	// Rows generated where the table breaks: the carried row goes below
+	// the last body row of a page, the brought row below the repeated
+	// header rows of the next one.
+	breaks := cb.newTableBreaks(tableVL, headerCount, dataEnd)
+	placeGenerated := func(cells []TableBreakCell) error {
+		if len(cells) == 0 {
+			return nil
+		}
+		hl, err := breaks.buildRow(cells)
+		if err != nil || hl == nil {
+			return err
+		}
+		h := hl.Height + hl.Depth
+		box := node.NewVList()
+		box.List = hl
+		box.Width = tableWidth
+		box.Height = h
+		cb.frontend.Doc.CurrentPage.OutputAt(pd.PageAreaLeft, *y, box)
+		*y -= h
+		*pageHasContent = true
+		return nil
+	}
+
+	// Rowspan groups are kept together and tall rows split (see
+	// tableRowPager). restOf is the row whose rest rows[restOf] holds
+	// after a split, forceBreak breaks the page before the next row.
+	pager := cb.newTableRowPager(tableVL, len(rows))
+	restOf := -1
+	forceBreak := false
+
+	// The inserts of the cells go to the page their row is placed on, a
+	// split row's with its first part. Footnotes and bottom floats take
+	// their space from the page of the row; top floats cannot go above
+	// the rows already placed and wait for the next page, they move the
+	// rows down there.
+	perRow, _ := rowInserts(tableVL, insertsOnNode(tableVL))
+	var pendingTop []*Insert
+
+	// -bag-row-banding: the body rows are painted again as they are
+	// placed, pageRow counts them on the current page.
+	banding, _ := tableVL.Attributes["_banding"].(*rowBanding)
+	pageRow := 0
+
 	placeFooters := func() error {
 		if footerCount == 0 {
 			return nil
@@ -2256,10 +2408,51 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 		// avoid rows, but only when a fresh page would actually fit the
 		// row — otherwise the loop is pointless and risks infinite breaks
 		// for rows taller than a full page.
+		// Every row but the last one leaves room for the carried row.
 		pageContent := pd.ContentHeight
-		effectiveLimit := *yLimit + footerHeight
-		avoidForcesBreak := avoidBreakInside(row) && *y-h < effectiveLimit && !*pageHasContent && h+footerHeight <= pageContent
-		if (*y-h < effectiveLimit && *pageHasContent) || avoidForcesBreak {
+		reserve := footerHeight
+		if breaks != nil && i < dataEnd-1 {
+			reserve += breaks.reserve
+		}
+		var rowIns []*Insert
+		if i < len(perRow) && restOf != i {
+			rowIns = perRow[i]
+		}
+		effectiveLimit := *yLimit + reserve + cb.bottomInsertsHeight(rowIns)
+		avoidForcesBreak := avoidBreakInside(row) && *y-h < effectiveLimit && !*pageHasContent && h+reserve <= pageContent
+		// A rowspan group starts on a page it fits on as a whole, unless
+		// it does not fit on any. A row that may be split fills the rest
+		// of the page instead of moving to the next one.
+		fitH := h
+		var splitOK, splitEager bool
+		if pager != nil && restOf != i {
+			if end := pager.keptGroup(i); end > i && end < dataEnd {
+				var gh bag.ScaledPoint
+				for k := i; k <= end; k++ {
+					gh += vlistNodeHeight(rows[k])
+				}
+				if gh+reserve <= pageContent {
+					fitH = gh
+				}
+			}
+		}
+		if pager != nil && i >= headerCount {
+			splitOK, splitEager = pager.splittable(i, row)
+			splitOK = splitOK && (splitEager || h+reserve > pageContent)
+		}
+		breakHere := forceBreak || (*y-fitH < effectiveLimit && *pageHasContent && !splitOK) || avoidForcesBreak
+		forceBreak = false
+		if breakHere {
+			pageRow = 0
+			var brought []TableBreakCell
+			if breaks != nil {
+				var carried []TableBreakCell
+				carried, brought = breaks.rowCells()
+				if err := placeGenerated(carried); err != nil {
+					return err
+				}
+				breaks.pageRows = nil
+			}
 			// Place footer at the bottom of the current page before
 			// breaking so it appears on every spanned page.
 			if err := placeFooters(); err != nil {
@@ -2293,6 +2486,11 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 									newRows = append(newRows, n)
 								}
 								if len(newRows) == len(rows) {
+									// A split row keeps its rest at the old
+									// width.
+									if restOf == i {
+										newRows[i] = rows[i]
+									}
 									rows = newRows
 									tableVL = newVL
 									tableWidth = newVL.Width
@@ -2305,6 +2503,14 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 									if bf, ok := newVL.Attributes["_buildFooters"].(func() ([]*node.HList, error)); ok {
 										buildFooters = bf
 									}
+									caption = tableCaptionOf(newVL)
+									if pager != nil {
+										pager.remeasure(newVL)
+									}
+									if breaks != nil && !breaks.remeasure(newVL) {
+										slog.Debug("table break rows: cannot measure the rebuilt table columns")
+										breaks, brought = nil, nil
+									}
 									row = rows[i]
 									h = vlistNodeHeight(row)
 								} else {
@@ -2319,6 +2525,24 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 				curContentWidth = pd.ContentWidth
 			}
 
+			// The top floats of the rows on the last page open this
+			// one.
+			if len(pendingTop) > 0 {
+				cb.queueInserts(pendingTop)
+				pendingTop = nil
+				*y -= cb.pageInsertHeight[InsertFloatTop]
+			}
+
+			if caption != nil && caption.buildContinued != nil {
+				box, err := caption.buildContinued()
+				if err != nil {
+					return err
+				}
+				cb.frontend.Doc.CurrentPage.OutputAt(pd.PageAreaLeft, *y, box)
+				*y -= box.Height + box.Depth
+				*pageHasContent = true
+			}
+
 			// Repeat header rows on the new page (skip if this IS a header row).
 			if i >= headerCount {
 				headers, err := buildHeaders()
@@ -2338,6 +2562,43 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 				}
 				*pageHasContent = true
 			}
+			if err := placeGenerated(brought); err != nil {
+				return err
+			}
+			effectiveLimit = *yLimit + reserve + cb.bottomInsertsHeight(rowIns)
+		}
+
+		// A row too tall for the rest of the page: place as many of its
+		// lines as fit and go on with the rest on the next page.
+		split := false
+		if splitOK && *y-h < effectiveLimit {
+			first, rest, err := pager.split(i, row.(*node.HList), *y-effectiveLimit)
+			if err != nil {
+				return err
+			}
+			switch {
+			case first == nil && *pageHasContent && !breakHere:
+				// Not one line fits: break and split on the next page.
+				// Right after a break the row is placed whole.
+				forceBreak = true
+				i--
+				continue
+			case first != nil && rest != nil:
+				row, h = first, vlistNodeHeight(first)
+				split = true
+				rows[i] = rest
+			}
+		}
+
+		if banding != nil && i >= headerCount {
+			k := i - headerCount
+			if banding.restart {
+				k = pageRow
+			}
+			paintRowBand(row, banding.color(k))
+			if !split {
+				pageRow++
+			}
 		}
 
 		// Detach row from linked list and place it.
@@ -2352,8 +2613,27 @@ func (cb *CSSBuilder) outputTableRows(tableVL *node.VList, buildHeadersFn any, y
 		cb.frontend.Doc.CurrentPage.OutputAt(pd.PageAreaLeft, *y, box)
 		*y -= h
 		*pageHasContent = true
+		var onPage []*Insert
+		for _, ins := range rowIns {
+			if ins.Class == InsertFloatTop {
+				pendingTop = append(pendingTop, ins)
+			} else {
+				onPage = append(onPage, ins)
+			}
+		}
+		cb.queueInserts(onPage)
+		// The running sums count a split row with its first part.
+		if breaks != nil && i >= headerCount && restOf != i {
+			breaks.placed(i)
+		}
+		if split {
+			restOf = i
+			forceBreak = true
+			i--
+		}
 	}
 
+	cb.queueInserts(pendingTop)
 	// Footer on the last page.
 	return placeFooters()
 }
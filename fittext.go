@@ -0,0 +1,177 @@
+package htmlbag
+
+import (
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// fitTextPrecision ends the binary search of fitParagraph: once the
+// interval between a fitting and a non-fitting value is this small, the
+// fitting value is taken.
+var fitTextPrecision = bag.MustSP("0.1pt")
+
+// fitText is the copy-fitting request carried by settingFitText. The
+// paragraph is re-typeset with a font size (mode "font-size") or letter
+// spacing (mode "letter-spacing") between min and max until it fits into
+// height.
+type fitText struct {
+	mode     string
+	min, max bag.ScaledPoint
+	height   bag.ScaledPoint
+}
+
+// parseFitText parses a -bag-fit-text value:
+//
+//	none | [ font-size | letter-spacing ] [ <min> <max>? ]?
+//
+// Lengths resolve against the element's font size, so percentages are
+// relative to the declared size. Without explicit bounds font-size shrinks
+// down to 50% of the declared size and letter-spacing tightens down to
+// -0.05em; the declared value is the upper bound in both cases. A single
+// bound is the minimum and keeps the declared value as the maximum. A
+// minimum above the maximum is clamped to it with a warning.
+func parseFitText(v string, styles *FormattingStyles) (fitText, bool) {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return fitText{}, false
+	}
+	ft := fitText{mode: fields[0]}
+	switch ft.mode {
+	case "font-size":
+		ft.min = styles.Fontsize / 2
+		ft.max = styles.Fontsize
+	case "letter-spacing":
+		ft.min = -styles.Fontsize / 20
+		ft.max = styles.letterSpacing
+	default:
+		return fitText{}, false
+	}
+	switch len(fields) {
+	case 1:
+	case 2:
+		ft.min = ParseRelativeSize(fields[1], styles.Fontsize, styles.DefaultFontSize)
+	case 3:
+		ft.min = ParseRelativeSize(fields[1], styles.Fontsize, styles.DefaultFontSize)
+		ft.max = ParseRelativeSize(fields[2], styles.Fontsize, styles.DefaultFontSize)
+	default:
+		bag.Logger.Warn("invalid -bag-fit-text", "value", v)
+		return fitText{}, false
+	}
+	if ft.min > ft.max {
+		// Copy-fitting only shrinks: a minimum above the declared value
+		// (or the given maximum) would make the text grow.
+		bag.Logger.Warn("-bag-fit-text: minimum above the maximum, using the maximum", "value", v, "max", ft.max)
+		ft.min = ft.max
+	}
+	if ft.mode == "font-size" && ft.min <= 0 {
+		return fitText{}, false
+	}
+	return ft, true
+}
+
+// fitSettings is the part of a Text's settings copy-fitting rewrites.
+type fitSettings struct {
+	size, leading, letterSpacing          bag.ScaledPoint
+	hasSize, hasLeading, hasLetterSpacing bool
+}
+
+// snapshotFitSettings records the font size, leading and letter spacing of
+// te and every nested Text so fitParagraph can scale from (and restore to)
+// the declared values.
+func snapshotFitSettings(te *frontend.Text, saved map[*frontend.Text]fitSettings) {
+	var fs fitSettings
+	fs.size, fs.hasSize = te.Settings[frontend.SettingSize].(bag.ScaledPoint)
+	fs.leading, fs.hasLeading = te.Settings[frontend.SettingLeading].(bag.ScaledPoint)
+	fs.letterSpacing, fs.hasLetterSpacing = te.Settings[frontend.SettingLetterSpacing].(bag.ScaledPoint)
+	saved[te] = fs
+	for _, itm := range te.Items {
+		if t, ok := itm.(*frontend.Text); ok {
+			snapshotFitSettings(t, saved)
+		}
+	}
+}
+
+// fitParagraph typesets te with format at the largest font size (or widest
+// letter spacing) within ft's bounds whose result is no taller than
+// ft.height, found by binary search. When even the minimum overflows, the
+// minimum is used and a warning is logged. The declared settings are
+// restored before returning, so a rebuild starts from the same input.
+func (cb *CSSBuilder) fitParagraph(te *frontend.Text, ft fitText, format func() (*node.VList, error)) (*node.VList, error) {
+	saved := map[*frontend.Text]fitSettings{}
+	snapshotFitSettings(te, saved)
+	base := saved[te].size
+	if ft.mode == "font-size" && base <= 0 {
+		return format()
+	}
+	defer func() {
+		for t, fs := range saved {
+			restoreFitSetting(t, frontend.SettingSize, fs.size, fs.hasSize)
+			restoreFitSetting(t, frontend.SettingLeading, fs.leading, fs.hasLeading)
+			restoreFitSetting(t, frontend.SettingLetterSpacing, fs.letterSpacing, fs.hasLetterSpacing)
+		}
+	}()
+
+	try := func(v bag.ScaledPoint) (*node.VList, bool, error) {
+		for t, fs := range saved {
+			switch ft.mode {
+			case "font-size":
+				// Scale every size in the paragraph by the same factor so
+				// inline runs keep their proportions to the block text.
+				f := float64(v) / float64(base)
+				if fs.hasSize {
+					t.Settings[frontend.SettingSize] = bag.MultiplyFloat(fs.size, f)
+				}
+				if fs.hasLeading && fs.leading > 0 {
+					t.Settings[frontend.SettingLeading] = bag.MultiplyFloat(fs.leading, f)
+				}
+			case "letter-spacing":
+				t.Settings[frontend.SettingLetterSpacing] = v
+			}
+		}
+		vl, err := format()
+		if err != nil {
+			return nil, false, err
+		}
+		return vl, vl.Height+vl.Depth <= ft.height, nil
+	}
+
+	vl, fits, err := try(ft.max)
+	if err != nil || fits {
+		return vl, err
+	}
+	best, fits, err := try(ft.min)
+	if err != nil {
+		return nil, err
+	}
+	if !fits {
+		bag.Logger.Warn("-bag-fit-text: content does not fit at the minimum", "mode", ft.mode, "min", ft.min)
+		return best, nil
+	}
+	lo, hi := ft.min, ft.max
+	for hi-lo > fitTextPrecision {
+		mid := lo + (hi-lo)/2
+		vl, fits, err := try(mid)
+		if err != nil {
+			return nil, err
+		}
+		if fits {
+			lo, best = mid, vl
+		} else {
+			hi = mid
+		}
+	}
+	return best, nil
+}
+
+// restoreFitSetting puts a snapshotted setting back, or removes it when the
+// Text did not carry it.
+func restoreFitSetting(t *frontend.Text, key frontend.SettingType, v bag.ScaledPoint, has bool) {
+	if has {
+		t.Settings[key] = v
+	} else {
+		delete(t.Settings, key)
+	}
+}
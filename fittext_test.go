@@ -0,0 +1,151 @@
+package htmlbag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// TestFitTextFontSize: -bag-fit-text: font-size shrinks the text until the
+// whole paragraph fits into the declared height. All words stay on the page
+// and the following flow starts exactly at the box's height (nothing grew
+// past it).
+func TestFitTextFontSize(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p, div { margin: 0; }
+.fit { width: 60mm; height: 24pt; font-size: 20pt; line-height: 1.2; -bag-fit-text: font-size 4pt 20pt; }`
+	body := "ANFANG " + strings.Repeat("Datenblatt ", 12) + "ENDWORT"
+	html := `<html><body><div class="fit">` + body + `</div><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if !strings.Contains(pageText(pages[0]), "ENDWORT") {
+		t.Error("copy-fitted text lost content")
+	}
+	top := lineTopY(pages[0], "ANFANG")
+	next := lineTopY(pages[0], "NACHHER")
+	if got, want := top-next, bag.MustSP("24pt"); got != want {
+		t.Errorf("following paragraph starts %s below the fitted box, want %s", got, want)
+	}
+}
+
+// naturalWidth returns the width of the glyphs, kerns and glue (at their
+// natural size) of the list starting at n, nested HLists included.
+func naturalWidth(n node.Node) bag.ScaledPoint {
+	var wd bag.ScaledPoint
+	for ; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.Glyph:
+			wd += v.Width
+		case *node.Kern:
+			wd += v.Kern
+		case *node.Glue:
+			wd += v.Width
+		case *node.HList:
+			wd += naturalWidth(v.List)
+		}
+	}
+	return wd
+}
+
+// lineHas reports whether the glyphs directly in hl spell needle.
+func lineHas(hl *node.HList, needle string) bool {
+	var sb strings.Builder
+	for n := hl.List; n != nil; n = n.Next() {
+		if g, ok := n.(*node.Glyph); ok {
+			sb.WriteString(g.Components)
+		}
+	}
+	return strings.Contains(sb.String(), needle)
+}
+
+// lineWith returns the innermost HList on pg whose glyphs contain needle.
+func lineWith(pg *document.Page, needle string) *node.HList {
+	var walk func(n node.Node) *node.HList
+	walk = func(n node.Node) *node.HList {
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.HList:
+				if lineHas(v, needle) {
+					return v
+				}
+				if hl := walk(v.List); hl != nil {
+					return hl
+				}
+			case *node.VList:
+				if hl := walk(v.List); hl != nil {
+					return hl
+				}
+			}
+		}
+		return nil
+	}
+	for _, obj := range pg.Objects {
+		if obj.Vlist != nil {
+			if hl := walk(obj.Vlist.List); hl != nil {
+				return hl
+			}
+		}
+	}
+	return nil
+}
+
+// TestFitTextLetterSpacing: -bag-fit-text: letter-spacing tightens a line
+// that breaks at the declared spacing until it fits into the one line the
+// height allows, and the tightened line is no wider than the box.
+func TestFitTextLetterSpacing(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p, div { margin: 0; }
+.fit { width: 50mm; height: 12pt; font-size: 10pt; line-height: 12pt; letter-spacing: 3pt; -bag-fit-text: letter-spacing -0.5pt; }`
+	html := `<html><body><div class="fit">ANFANG Datenblatt ENDWORT</div><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	hl := lineWith(pages[0], "ANFANG")
+	if hl == nil || !lineHas(hl, "ENDWORT") {
+		t.Fatal("the fitted paragraph is not a single line")
+	}
+	if wd, max := naturalWidth(hl.List), bag.MustSP("50mm"); wd > max {
+		t.Errorf("fitted line is %s wide, the box %s", wd, max)
+	}
+	if got, want := lineTopY(pages[0], "ANFANG")-lineTopY(pages[0], "NACHHER"), bag.MustSP("12pt"); got != want {
+		t.Errorf("following paragraph starts %s below the fitted box, want %s", got, want)
+	}
+}
+
+// TestParseFitText covers the property grammar and its defaults.
+func TestParseFitText(t *testing.T) {
+	styles := &FormattingStyles{Fontsize: bag.MustSP("10pt"), DefaultFontSize: bag.MustSP("10pt")}
+	cases := []struct {
+		in       string
+		ok       bool
+		min, max bag.ScaledPoint
+	}{
+		{in: "font-size", ok: true, min: bag.MustSP("5pt"), max: bag.MustSP("10pt")},
+		{in: "font-size 6pt 12pt", ok: true, min: bag.MustSP("6pt"), max: bag.MustSP("12pt")},
+		{in: "font-size 12pt 6pt", ok: true, min: bag.MustSP("6pt"), max: bag.MustSP("6pt")},
+		{in: "font-size 8pt", ok: true, min: bag.MustSP("8pt"), max: bag.MustSP("10pt")},
+		{in: "font-size 20pt", ok: true, min: bag.MustSP("10pt"), max: bag.MustSP("10pt")},
+		{in: "letter-spacing", ok: true, min: -bag.MustSP("0.5pt"), max: 0},
+		{in: "letter-spacing -1pt", ok: true, min: -bag.MustSP("1pt"), max: 0},
+		{in: "letter-spacing 1pt", ok: true, min: 0, max: 0},
+		{in: "font-size 4pt 8pt 12pt", ok: false},
+		{in: "word-spacing", ok: false},
+		{in: "", ok: false},
+	}
+	for _, tc := range cases {
+		ft, ok := parseFitText(tc.in, styles)
+		if ok != tc.ok {
+			t.Errorf("parseFitText(%q) ok = %v, want %v", tc.in, ok, tc.ok)
+			continue
+		}
+		if ok && (ft.min != tc.min || ft.max != tc.max) {
+			t.Errorf("parseFitText(%q) = [%s, %s], want [%s, %s]", tc.in, ft.min, ft.max, tc.min, tc.max)
+		}
+	}
+}
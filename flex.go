@@ -0,0 +1,616 @@
+package htmlbag
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// flexContainer is carried by settingFlex from Output() to
+// buildVlistInternal: the container properties of a display: flex element
+// (CSS Flexbox 1) and the item properties of its children, keyed by the
+// children's Texts. Children without an entry (anonymous runs of inline
+// content) are items with the initial values.
+//
+// The subset: flex-direction, flex-wrap, justify-content, align-items,
+// align-self, gap, order and flex-grow/-shrink/-basis. Items are formatted
+// by buildVlistInternal at the width the flex layout resolves for them.
+// Not supported (v1): align-content (lines are packed at the start),
+// baseline alignment (treated as flex-start), auto margins, min-/max-width
+// constraints on items and flexible sizes in the column direction.
+type flexContainer struct {
+	direction    string // row, row-reverse, column, column-reverse
+	wrap         string // nowrap, wrap, wrap-reverse
+	justify      string
+	alignItems   string
+	rowGap       string
+	columnGap    string
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+	items        map[*frontend.Text]flexItem
+}
+
+// flexItem holds the properties of a flex item. basis is "auto", "content"
+// or a length; with auto the CSS width (if any) is the flex base size.
+type flexItem struct {
+	grow         float64
+	shrink       float64
+	basis        string
+	width        string
+	alignSelf    string
+	order        int
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+}
+
+// initialFlexItem has the initial values flex: 0 1 auto.
+var initialFlexItem = flexItem{shrink: 1, basis: "auto"}
+
+// elementFlex returns the flex container of an element with display: flex
+// or inline-flex, nil otherwise. Table-internal elements and images keep
+// their own layout.
+func elementFlex(styles *FormattingStyles, tag string) *flexContainer {
+	if styles.display != "flex" && styles.display != "inline-flex" {
+		return nil
+	}
+	switch tag {
+	case "table", "thead", "tbody", "tfoot", "tr", "td", "th", "col", "colgroup", "img":
+		return nil
+	}
+	fc := &flexContainer{
+		direction:    "row",
+		wrap:         "nowrap",
+		justify:      styles.justifyContent,
+		alignItems:   styles.alignItems,
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+		items:        map[*frontend.Text]flexItem{},
+	}
+	setDirection := func(v string) bool {
+		switch v {
+		case "row", "row-reverse", "column", "column-reverse":
+			fc.direction = v
+			return true
+		}
+		return false
+	}
+	setWrap := func(v string) bool {
+		switch v {
+		case "nowrap", "wrap", "wrap-reverse":
+			fc.wrap = v
+			return true
+		}
+		return false
+	}
+	for _, v := range strings.Fields(styles.flexFlow) {
+		if !setDirection(v) {
+			setWrap(v)
+		}
+	}
+	if styles.flexDirection != "" {
+		setDirection(styles.flexDirection)
+	}
+	if styles.flexWrap != "" {
+		setWrap(styles.flexWrap)
+	}
+	// gap: <row-gap> [<column-gap>]
+	if g := strings.Fields(styles.gap); len(g) > 0 {
+		fc.rowGap, fc.columnGap = g[0], g[0]
+		if len(g) > 1 {
+			fc.columnGap = g[1]
+		}
+	}
+	if styles.rowGap != "" {
+		fc.rowGap = styles.rowGap
+	}
+	if styles.columnGap != "" {
+		fc.columnGap = styles.columnGap
+	}
+	return fc
+}
+
+// elementFlexItem returns the flex item properties of an element's styles.
+func elementFlexItem(styles *FormattingStyles) flexItem {
+	fi := initialFlexItem
+	fi.width = styles.width
+	fi.alignSelf = styles.alignSelf
+	fi.order = styles.order
+	fi.fontsize = styles.Fontsize
+	fi.rootFontsize = styles.DefaultFontSize
+	// The flex shorthand (CSS Flexbox 1 §7.1.1): a unitless number is a
+	// flex factor, the first one flex-grow, the second flex-shrink. When
+	// the basis is omitted it is 0, a lone basis means flex: 1 1 <basis>.
+	switch styles.flex {
+	case "":
+	case "none":
+		fi.grow, fi.shrink, fi.basis = 0, 0, "auto"
+	case "auto":
+		fi.grow, fi.shrink, fi.basis = 1, 1, "auto"
+	case "initial":
+		fi.grow, fi.shrink, fi.basis = 0, 1, "auto"
+	default:
+		var factors []float64
+		basis := "0"
+		for _, v := range strings.Fields(styles.flex) {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && len(factors) < 2 {
+				factors = append(factors, f)
+			} else {
+				basis = v
+			}
+		}
+		fi.grow, fi.shrink, fi.basis = 1, 1, basis
+		if len(factors) > 0 {
+			fi.grow = factors[0]
+		}
+		if len(factors) > 1 {
+			fi.shrink = factors[1]
+		}
+	}
+	if f, err := strconv.ParseFloat(styles.flexGrow, 64); err == nil && f >= 0 {
+		fi.grow = f
+	}
+	if f, err := strconv.ParseFloat(styles.flexShrink, 64); err == nil && f >= 0 {
+		fi.shrink = f
+	}
+	if styles.flexBasis != "" {
+		fi.basis = styles.flexBasis
+	}
+	return fi
+}
+
+// addItem records the item properties of the flex item te, built from the
+// child element item. The element's styles are probed like in
+// handlePositioned.
+func (fc *flexContainer) addItem(te *frontend.Text, item *HTMLItem, ss StylesStack, df *frontend.Document) error {
+	probe := ss.CurrentStyle().Clone()
+	if err := StylesToStyles(probe, item.Styles, df, ss.CurrentStyle().Fontsize); err != nil {
+		return err
+	}
+	fc.items[te] = elementFlexItem(probe)
+	return nil
+}
+
+// column reports whether the main axis is vertical.
+func (fc *flexContainer) column() bool {
+	return strings.HasPrefix(fc.direction, "column")
+}
+
+// flexLength resolves a length or a percentage of ref. "auto", "normal" and
+// an empty value are 0.
+func flexLength(v string, ref, fontsize, rootFontsize bag.ScaledPoint) bag.ScaledPoint {
+	switch v {
+	case "", "auto", "normal", "content", "0":
+		return 0
+	}
+	if p, ok := strings.CutSuffix(v, "%"); ok {
+		if pct, err := strconv.ParseFloat(p, 64); err == nil {
+			return bag.ScaledPoint(float64(ref) * pct / 100)
+		}
+		return 0
+	}
+	return ParseRelativeSize(v, fontsize, rootFontsize)
+}
+
+// flexEntry is a flex item during layout, base its flex base size.
+type flexEntry struct {
+	layoutItem
+	item  flexItem
+	align string // start, end, center or stretch
+	base  bag.ScaledPoint
+}
+
+// flexLayout is the resolved layout of a flex container (a childLayout):
+// the items in their lines and at their widths.
+//
+// Fragmentation: a flex line is monolithic, a container breaks between its
+// lines (row direction) or between its items (column direction), just like
+// a block container breaks between its children. A single row taller than
+// the page is not split (v1).
+type flexLayout struct {
+	fc        *flexContainer
+	avail     bag.ScaledPoint // inner width of the container
+	height    bag.ScaledPoint // definite inner height, 0 = auto
+	mainGap   bag.ScaledPoint
+	crossGap  bag.ScaledPoint
+	ordered   []*flexEntry
+	lines     [][]*flexEntry
+	lineCross []bag.ScaledPoint
+	entries   map[*frontend.Text]*flexEntry
+	taken     takenMarkers
+}
+
+// layoutFlex resolves the item widths and flex lines of the flex container
+// te with an inner width of avail.
+func (cb *CSSBuilder) layoutFlex(te *frontend.Text, fc *flexContainer, avail bag.ScaledPoint) (*flexLayout, error) {
+	fl := &flexLayout{
+		fc:      fc,
+		avail:   avail,
+		entries: map[*frontend.Text]*flexEntry{},
+	}
+	fl.height, _ = te.Settings[settingCSSHeight].(bag.ScaledPoint)
+	if fc.column() {
+		fl.mainGap = flexLength(fc.rowGap, fl.height, fc.fontsize, fc.rootFontsize)
+		fl.crossGap = flexLength(fc.columnGap, avail, fc.fontsize, fc.rootFontsize)
+	} else {
+		fl.mainGap = flexLength(fc.columnGap, avail, fc.fontsize, fc.rootFontsize)
+		fl.crossGap = flexLength(fc.rowGap, fl.height, fc.fontsize, fc.rootFontsize)
+	}
+	for _, t := range layoutChildren(te) {
+		fi, ok := fc.items[t]
+		if !ok {
+			fi = initialFlexItem
+			fi.fontsize, fi.rootFontsize = fc.fontsize, fc.rootFontsize
+		}
+		e := &flexEntry{layoutItem: newLayoutItem(t), item: fi}
+		e.align = flexAlign(fi.alignSelf, fc.alignItems)
+		fl.ordered = append(fl.ordered, e)
+		fl.entries[t] = e
+		if err := cb.takeMarkers(t, avail, &fl.taken); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(fl.ordered, func(i, j int) bool {
+		return fl.ordered[i].item.order < fl.ordered[j].item.order
+	})
+	if fc.column() {
+		return fl, cb.layoutFlexColumn(fl)
+	}
+	return fl, cb.layoutFlexRow(fl)
+}
+
+// flexAlign returns the cross axis alignment of an item: align-self, or
+// align-items of the container for align-self: auto.
+func flexAlign(self, items string) string {
+	v := self
+	if v == "" || v == "auto" {
+		v = items
+	}
+	switch v {
+	case "flex-end", "end", "self-end":
+		return "end"
+	case "center":
+		return "center"
+	case "", "normal", "stretch":
+		return "stretch"
+	}
+	// flex-start, start, self-start and the baseline values.
+	return "start"
+}
+
+// layoutFlexRow resolves the flexible widths (CSS Flexbox 1 §9.7) and the
+// lines of a row container and measures the cross size of each line.
+func (cb *CSSBuilder) layoutFlexRow(fl *flexLayout) error {
+	fc := fl.fc
+	for _, e := range fl.ordered {
+		switch {
+		case e.item.basis != "auto" && e.item.basis != "content":
+			e.base = flexLength(e.item.basis, fl.avail, e.item.fontsize, e.item.rootFontsize)
+		case e.item.basis == "auto" && e.item.width != "" && e.item.width != "auto":
+			w, err := cb.itemWidth(e.t, e.item.width, fl.avail, e.item.fontsize, e.item.rootFontsize)
+			if err != nil {
+				return err
+			}
+			e.base = w
+		default:
+			// The max-content width, capped at the container width.
+			wd := bag.Max(fl.avail-e.ml-e.mr, 0)
+			vl, err := cb.measureItem(e.t, wd)
+			if err != nil {
+				return err
+			}
+			e.base = maxContentWidth(vl, wd)
+		}
+		e.base = bag.Max(e.base, boxFrameWidth(e.t))
+	}
+
+	// Collect the items into lines.
+	var line []*flexEntry
+	var used bag.ScaledPoint
+	for _, e := range fl.ordered {
+		outer := e.base + e.ml + e.mr
+		if fc.wrap != "nowrap" && len(line) > 0 && used+fl.mainGap+outer > fl.avail {
+			fl.lines = append(fl.lines, line)
+			line, used = nil, 0
+		}
+		if len(line) > 0 {
+			used += fl.mainGap
+		}
+		used += outer
+		line = append(line, e)
+	}
+	if len(line) > 0 {
+		fl.lines = append(fl.lines, line)
+	}
+
+	for _, line := range fl.lines {
+		// Distribute the free space by flex-grow, or the overflow by
+		// flex-shrink weighted with the base size. A sum of grow
+		// factors below 1 hands out only that fraction of the space.
+		free := fl.avail - fl.mainGap*bag.ScaledPoint(len(line)-1)
+		var sumGrow, sumShrink float64
+		for _, e := range line {
+			free -= e.base + e.ml + e.mr
+			sumGrow += e.item.grow
+			sumShrink += e.item.shrink * float64(e.base)
+		}
+		for _, e := range line {
+			e.width = e.base
+			switch {
+			case free > 0 && sumGrow > 0:
+				share := float64(free) / sumGrow
+				if sumGrow < 1 {
+					share = float64(free)
+				}
+				e.width += bag.ScaledPoint(share * e.item.grow)
+			case free < 0 && sumShrink > 0:
+				e.width += bag.ScaledPoint(float64(free) * e.item.shrink * float64(e.base) / sumShrink)
+				// The automatic minimum size (§4.5): an item does not
+				// shrink below its min-content width (or its base size
+				// when that is smaller).
+				if e.width < e.base {
+					iw, err := cb.intrinsicWidths(e.t)
+					if err != nil {
+						return err
+					}
+					e.width = bag.Max(e.width, bag.Min(iw.min, e.base))
+				}
+			}
+			e.width = bag.Max(e.width, boxFrameWidth(e.t))
+		}
+
+		// The cross size of the line is the tallest item. A single line
+		// fills a container with a definite height.
+		var cross bag.ScaledPoint
+		for _, e := range line {
+			vl, err := cb.measureItem(e.t, e.width)
+			if err != nil {
+				return err
+			}
+			cross = bag.Max(cross, vl.Height+vl.Depth+e.mt+e.mb)
+		}
+		if fc.wrap == "nowrap" && fl.height > cross {
+			cross = fl.height
+		}
+		fl.lineCross = append(fl.lineCross, cross)
+		for _, e := range line {
+			if e.align == "stretch" {
+				e.stretch(cross)
+			}
+		}
+	}
+	return nil
+}
+
+// layoutFlexColumn resolves the widths of the items of a column container.
+// Every item forms its own line: stretched items span the container, the
+// others get their CSS width or their max-content width.
+func (cb *CSSBuilder) layoutFlexColumn(fl *flexLayout) error {
+	for _, e := range fl.ordered {
+		wd := bag.Max(fl.avail-e.ml-e.mr, 0)
+		switch {
+		case e.item.width != "" && e.item.width != "auto":
+			w, err := cb.itemWidth(e.t, e.item.width, fl.avail, e.item.fontsize, e.item.rootFontsize)
+			if err != nil {
+				return err
+			}
+			e.width = w
+		case e.align == "stretch":
+			e.width = wd
+		default:
+			vl, err := cb.measureItem(e.t, wd)
+			if err != nil {
+				return err
+			}
+			e.width = bag.Max(maxContentWidth(vl, wd), boxFrameWidth(e.t))
+		}
+		fl.lines = append(fl.lines, []*flexEntry{e})
+	}
+	return nil
+}
+
+// justify returns the space before the first item and the extra space
+// between two items for the free space of a line with n items.
+func (fl *flexLayout) justify(free bag.ScaledPoint, n int) (lead, between bag.ScaledPoint) {
+	if free <= 0 || n == 0 {
+		return 0, 0
+	}
+	v := fl.fc.justify
+	if strings.HasSuffix(fl.fc.direction, "-reverse") {
+		// flex-start and flex-end follow the main axis, the items
+		// start at the end of the line.
+		switch v {
+		case "", "normal", "flex-start":
+			v = "flex-end"
+		case "flex-end":
+			v = "flex-start"
+		}
+	}
+	switch v {
+	case "flex-end", "end", "right":
+		return free, 0
+	case "center":
+		return free / 2, 0
+	case "space-between":
+		if n > 1 {
+			return 0, free / bag.ScaledPoint(n-1)
+		}
+	case "space-around":
+		return free / bag.ScaledPoint(2*n), free / bag.ScaledPoint(n)
+	case "space-evenly":
+		return free / bag.ScaledPoint(n+1), free / bag.ScaledPoint(n+1)
+	}
+	return 0, 0
+}
+
+// arrange replaces the children of the flex container vls, built in source
+// order, by the flex lines. shift is the horizontal offset of the content
+// box (padding-left of a box without HTMLBorder).
+func (fl *flexLayout) arrange(vls *node.VList, shift bag.ScaledPoint) {
+	var nodes []node.Node
+	if fl.fc.column() {
+		nodes = fl.arrangeColumn(shift)
+	} else {
+		nodes = fl.arrangeRows(shift)
+	}
+	fl.taken.attach(nodes)
+	items := make([]*layoutItem, 0, len(fl.ordered))
+	for _, e := range fl.ordered {
+		items = append(items, &e.layoutItem)
+	}
+	replaceChildren(vls, items, nodes, fl.avail)
+}
+
+// item returns the layout item of the child t (childLayout).
+func (fl *flexLayout) item(t *frontend.Text) *layoutItem {
+	if e, ok := fl.entries[t]; ok {
+		return &e.layoutItem
+	}
+	return nil
+}
+
+// arrangeRows packs every line into an HList of its items, tops aligned,
+// and returns the lines as VLists with the row gaps between them. The
+// headings and anchors of the items move up to the line, where the page
+// builder finds them.
+func (fl *flexLayout) arrangeRows(shift bag.ScaledPoint) []node.Node {
+	var lines []*node.VList
+	for i, line := range fl.lines {
+		cross := fl.lineCross[i]
+		for _, e := range line {
+			if e.vl != nil {
+				cross = bag.Max(cross, e.vl.Height+e.vl.Depth+e.mt+e.mb)
+			}
+		}
+		order := line
+		if strings.HasSuffix(fl.fc.direction, "-reverse") {
+			order = make([]*flexEntry, len(line))
+			for j, e := range line {
+				order[len(line)-1-j] = e
+			}
+		}
+		free := fl.avail - fl.mainGap*bag.ScaledPoint(len(line)-1)
+		for _, e := range line {
+			free -= e.width + e.ml + e.mr
+		}
+		lead, between := fl.justify(free, len(line))
+
+		lineVL := node.NewVList()
+		lineVL.Attributes = node.H{"origin": "flex line"}
+		var head node.Node
+		add := func(n node.Node) {
+			head = node.InsertAfter(head, node.Tail(head), n)
+		}
+		for j, e := range order {
+			advance := e.ml
+			if j == 0 {
+				advance += lead
+			} else {
+				advance += fl.mainGap + between
+			}
+			if advance != 0 {
+				add(layoutKern(advance, "flex-space"))
+			}
+			// The item hangs from the top of the line: its cell
+			// reports the full line height and no depth.
+			cell := node.NewVList()
+			if e.vl != nil {
+				var offset bag.ScaledPoint
+				switch e.align {
+				case "end":
+					offset = cross - e.vl.Height - e.vl.Depth - e.mt - e.mb
+				case "center":
+					offset = (cross - e.vl.Height - e.vl.Depth - e.mt - e.mb) / 2
+				}
+				var inner node.Node
+				if offset+e.mt > 0 {
+					inner = layoutKern(offset+e.mt, "flex-align")
+				}
+				inner = node.InsertAfter(inner, node.Tail(inner), e.vl)
+				cell = node.Vpack(inner)
+				liftItemAttributes(lineVL, e.vl)
+			}
+			cell.Width = e.width
+			cell.Height = cross
+			cell.Depth = 0
+			cell.Attributes = node.H{"origin": "flex item"}
+			add(cell)
+			if e.mr != 0 {
+				add(layoutKern(e.mr, "flex-space"))
+			}
+		}
+		hl := node.Hpack(head)
+		hl.Height = cross
+		hl.Depth = 0
+		lineVL.List = hl
+		lineVL.Width = fl.avail
+		lineVL.Height = cross
+		lineVL.ShiftX = shift
+		lines = append(lines, lineVL)
+	}
+	if fl.fc.wrap == "wrap-reverse" {
+		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+	}
+	var nodes []node.Node
+	for i, l := range lines {
+		if i > 0 && fl.crossGap > 0 {
+			nodes = append(nodes, layoutKern(fl.crossGap, "flex-gap"))
+		}
+		nodes = append(nodes, l)
+	}
+	return nodes
+}
+
+// arrangeColumn stacks the items of a column container, aligned on the
+// cross axis, with their margins and the gaps between them (margins do not
+// collapse in a flex container). The free space of a container with a
+// definite height is distributed by justify-content.
+func (fl *flexLayout) arrangeColumn(shift bag.ScaledPoint) []node.Node {
+	var items []*flexEntry
+	for _, line := range fl.lines {
+		if line[0].vl != nil {
+			items = append(items, line[0])
+		}
+	}
+	if strings.HasSuffix(fl.fc.direction, "-reverse") {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	var lead, between bag.ScaledPoint
+	if fl.height > 0 && len(items) > 0 {
+		free := fl.height - fl.mainGap*bag.ScaledPoint(len(items)-1)
+		for _, e := range items {
+			free -= e.vl.Height + e.vl.Depth + e.mt + e.mb
+		}
+		lead, between = fl.justify(free, len(items))
+	}
+	var nodes []node.Node
+	for i, e := range items {
+		var offset bag.ScaledPoint
+		switch e.align {
+		case "end":
+			offset = fl.avail - e.width - e.ml - e.mr
+		case "center":
+			offset = (fl.avail - e.width - e.ml - e.mr) / 2
+		}
+		e.vl.ShiftX += shift + e.ml + bag.Max(offset, 0)
+		before := e.mt + lead
+		if i > 0 {
+			before = e.mt + fl.mainGap + between
+		}
+		if before > 0 {
+			nodes = append(nodes, layoutKern(before, "flex-space"))
+		}
+		nodes = append(nodes, e.vl)
+		if e.mb > 0 {
+			nodes = append(nodes, layoutKern(e.mb, "margin-bottom"))
+		}
+	}
+	return nodes
+}
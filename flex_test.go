@@ -0,0 +1,263 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// textPos returns the left end and the top of the line holding needle,
+// following the boxes side by side in an HList as well as stacked in a
+// VList. ok is false when the text is not on the page.
+func textPos(pg *document.Page, needle string) (x, y bag.ScaledPoint, ok bool) {
+	x, y, _, ok = findLine(pg, needle)
+	return x, y, ok
+}
+
+// findLine is textPos that returns the line as well.
+func findLine(pg *document.Page, needle string) (bag.ScaledPoint, bag.ScaledPoint, *node.HList, bool) {
+	var walkV func(n node.Node, x, y bag.ScaledPoint) (bag.ScaledPoint, bag.ScaledPoint, *node.HList, bool)
+	var walkH func(hl *node.HList, x, y bag.ScaledPoint) (bag.ScaledPoint, bag.ScaledPoint, *node.HList, bool)
+	walkV = func(n node.Node, x, y bag.ScaledPoint) (bag.ScaledPoint, bag.ScaledPoint, *node.HList, bool) {
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.VList:
+				if fx, fy, fl, ok := walkV(v.List, x+v.ShiftX, y); ok {
+					return fx, fy, fl, true
+				}
+				y -= v.Height + v.Depth
+			case *node.HList:
+				if lineHas(v, needle) {
+					return x, y, v, true
+				}
+				if fx, fy, fl, ok := walkH(v, x, y); ok {
+					return fx, fy, fl, true
+				}
+				y -= v.Height + v.Depth
+			case *node.Kern:
+				y -= v.Kern
+			case *node.Glue:
+				y -= v.Width
+			}
+		}
+		return 0, 0, nil, false
+	}
+	walkH = func(hl *node.HList, x, y bag.ScaledPoint) (bag.ScaledPoint, bag.ScaledPoint, *node.HList, bool) {
+		for n := hl.List; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.VList:
+				if fx, fy, fl, ok := walkV(v.List, x+v.ShiftX, y-hl.Height+v.Height); ok {
+					return fx, fy, fl, true
+				}
+				x += v.Width
+			case *node.HList:
+				if fx, fy, fl, ok := walkH(v, x, y-hl.Height+v.Height); ok {
+					return fx, fy, fl, true
+				}
+				x += v.Width
+			case *node.Glyph:
+				x += v.Width
+			case *node.Glue:
+				x += v.Width
+			case *node.Kern:
+				x += v.Kern
+			case *node.Rule:
+				x += v.Width
+			}
+		}
+		return 0, 0, nil, false
+	}
+	for _, obj := range pg.Objects {
+		if obj.Vlist == nil {
+			continue
+		}
+		if fx, fy, fl, ok := walkV(obj.Vlist.List, obj.X, obj.Y); ok {
+			return fx, fy, fl, true
+		}
+	}
+	return 0, 0, nil, false
+}
+
+// nearly compares two positions with a tolerance for the rounding of the
+// distributed free space.
+func nearly(got, want bag.ScaledPoint) bool {
+	d := got - want
+	return d > -4 && d < 4
+}
+
+func TestElementFlexItem(t *testing.T) {
+	cases := []struct {
+		flex, grow, shrink, basis string
+		want                      flexItem
+	}{
+		{"", "", "", "", flexItem{grow: 0, shrink: 1, basis: "auto"}},
+		{"1", "", "", "", flexItem{grow: 1, shrink: 1, basis: "0"}},
+		{"none", "", "", "", flexItem{grow: 0, shrink: 0, basis: "auto"}},
+		{"auto", "", "", "", flexItem{grow: 1, shrink: 1, basis: "auto"}},
+		{"2 3 10pt", "", "", "", flexItem{grow: 2, shrink: 3, basis: "10pt"}},
+		{"30%", "", "", "", flexItem{grow: 1, shrink: 1, basis: "30%"}},
+		// The longhands win over the shorthand.
+		{"1", "4", "0", "5em", flexItem{grow: 4, shrink: 0, basis: "5em"}},
+	}
+	for _, tc := range cases {
+		styles := &FormattingStyles{flex: tc.flex, flexGrow: tc.grow, flexShrink: tc.shrink, flexBasis: tc.basis}
+		got := elementFlexItem(styles)
+		if got.grow != tc.want.grow || got.shrink != tc.want.shrink || got.basis != tc.want.basis {
+			t.Errorf("flex %q (grow %q shrink %q basis %q) = %g %g %s, want %g %g %s", tc.flex, tc.grow, tc.shrink, tc.basis,
+				got.grow, got.shrink, got.basis, tc.want.grow, tc.want.shrink, tc.want.basis)
+		}
+	}
+}
+
+// TestFlexGrowAndGap: the free space of the line goes to the items by
+// their flex-grow factors, the gap stays between them.
+func TestFlexGrowAndGap(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; gap: 10pt; }
+.a { flex: 1; }
+.b { flex: 2; }
+.c { width: 50pt; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="f"><div class="a">AAA</div><div class="b">BBB</div><span class="c">CCC</span></div></body></html>`)
+	left := bag.MustSP("20mm")
+	free := bag.MustSP("170mm") - bag.MustSP("50pt") - bag.MustSP("20pt")
+	want := map[string]bag.ScaledPoint{
+		"AAA": left,
+		"BBB": left + free/3 + bag.MustSP("10pt"),
+		"CCC": left + bag.MustSP("170mm") - bag.MustSP("50pt"),
+	}
+	var tops []bag.ScaledPoint
+	for _, needle := range []string{"AAA", "BBB", "CCC"} {
+		x, y, ok := textPos(pages[0], needle)
+		if !ok {
+			t.Fatalf("%s not found", needle)
+		}
+		if !nearly(x, want[needle]) {
+			t.Errorf("%s at x=%s, want %s", needle, x, want[needle])
+		}
+		tops = append(tops, y)
+	}
+	if tops[0] != tops[1] || tops[1] != tops[2] {
+		t.Errorf("items of one line start at different heights: %v", tops)
+	}
+}
+
+// TestFlexJustifyAndDirection: justify-content distributes the space of a
+// line, row-reverse and order rearrange the items.
+func TestFlexJustifyAndDirection(t *testing.T) {
+	left, right := bag.MustSP("20mm"), bag.MustSP("190mm")
+	item := bag.MustSP("40pt")
+	cases := []struct {
+		css  string
+		want [3]bag.ScaledPoint
+	}{
+		{"justify-content: space-between", [3]bag.ScaledPoint{left, left + (right-left-item)/2, right - item}},
+		{"justify-content: center", [3]bag.ScaledPoint{left + (right-left)/2 - 3*item/2, left + (right-left)/2 - item/2, left + (right-left)/2 + item/2}},
+		{"flex-direction: row-reverse", [3]bag.ScaledPoint{right - item, right - 2*item, right - 3*item}},
+	}
+	for _, tc := range cases {
+		css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; ` + tc.css + ` }
+.f div { width: 40pt; }`
+		pages := renderHTMLPages(t, css, `<html><body><div class="f"><div>EINS</div><div>ZWEI</div><div>DREI</div></div></body></html>`)
+		for i, needle := range []string{"EINS", "ZWEI", "DREI"} {
+			x, _, ok := textPos(pages[0], needle)
+			if !ok {
+				t.Fatalf("%s: %s not found", tc.css, needle)
+			}
+			if !nearly(x, tc.want[i]) {
+				t.Errorf("%s: %s at x=%s, want %s", tc.css, needle, x, tc.want[i])
+			}
+		}
+	}
+
+	css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; }
+.f div { width: 40pt; }
+.first { order: -1; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="f"><div>EINS</div><div class="first">ZWEI</div></div></body></html>`)
+	if x, _, _ := textPos(pages[0], "ZWEI"); x != left {
+		t.Errorf("order: -1 item at x=%s, want %s", x, left)
+	}
+	if x, _, _ := textPos(pages[0], "EINS"); !nearly(x, left+item) {
+		t.Errorf("second item at x=%s, want %s", x, left+item)
+	}
+}
+
+// TestFlexWrapAndAlign: items that do not fit wrap to the next line,
+// align-items places the short items in the height of the tall one.
+func TestFlexWrapAndAlign(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; flex-wrap: wrap; row-gap: 10pt; align-items: flex-end; }
+.f div { width: 60%; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="f"><div>OBEN<br>zwei<br>drei</div><div>UNTEN</div></div></body></html>`)
+	x1, y1, _ := textPos(pages[0], "OBEN")
+	x2, y2, ok := textPos(pages[0], "UNTEN")
+	if !ok {
+		t.Fatal("UNTEN not found")
+	}
+	if x1 != x2 || y2 >= y1 {
+		t.Errorf("wrapped item at %s/%s, want below the first at x=%s", x2, y2, x1)
+	}
+
+	css = `@page { size: a4; margin: 20mm; }
+.f { display: flex; align-items: flex-end; }`
+	pages = renderHTMLPages(t, css, `<html><body><div class="f"><div>HOCH<br>zwei<br>drei</div><div>TIEF</div></div></body></html>`)
+	_, top, _ := textPos(pages[0], "HOCH")
+	_, last, _ := textPos(pages[0], "drei")
+	_, short, _ := textPos(pages[0], "TIEF")
+	if short != last {
+		t.Errorf("align-items: flex-end: short item at y=%s, want the last line of the tall one at %s (top %s)", short, last, top)
+	}
+}
+
+// TestFlexColumn: a column container stacks its items with the gap
+// between them and aligns them on the horizontal axis.
+func TestFlexColumn(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; flex-direction: column; gap: 12pt; align-items: flex-end; }
+.f div { width: 50pt; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="f"><div>ERSTES</div><div>ZWEITES</div></div></body></html>`)
+	x1, y1, ok1 := textPos(pages[0], "ERSTES")
+	x2, y2, ok2 := textPos(pages[0], "ZWEITES")
+	if !ok1 || !ok2 {
+		t.Fatal("items not found")
+	}
+	want := bag.MustSP("190mm") - bag.MustSP("50pt")
+	if x1 != want || x2 != want {
+		t.Errorf("items at x=%s and %s, want %s", x1, x2, want)
+	}
+	if y2 >= y1-bag.MustSP("12pt") {
+		t.Errorf("second item at y=%s, want at least the gap below %s", y2, y1)
+	}
+}
+
+// TestFlexFragmentation: a flex container breaks between its lines, the
+// items of one line stay together on a page.
+func TestFlexFragmentation(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.f { display: flex; flex-wrap: wrap; }
+.f div { width: 45%; }`
+	var sb strings.Builder
+	for i := range 60 {
+		fmt.Fprintf(&sb, "<div>ITEM%02d<br>zweite Zeile<br>ENDE%02d</div>", i, i)
+	}
+	pages := renderHTMLPages(t, css, `<html><body><div class="f">`+sb.String()+`</div></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the container to break", len(pages))
+	}
+	for i := 0; i < 60; i += 2 {
+		first := pageWith(pages, fmt.Sprintf("ITEM%02d", i))
+		if first < 0 {
+			t.Fatalf("ITEM%02d missing", i)
+		}
+		for _, needle := range []string{fmt.Sprintf("ENDE%02d", i), fmt.Sprintf("ITEM%02d", i+1), fmt.Sprintf("ENDE%02d", i+1)} {
+			if p := pageWith(pages, needle); p != first {
+				t.Errorf("%s on page %d, want page %d with ITEM%02d", needle, p, first, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,996 @@
+package htmlbag
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// gridContainer is carried by settingGrid from Output() to
+// buildVlistInternal: the container properties of a display: grid element
+// (CSS Grid 1) and the placement of its children, keyed by the children's
+// Texts. Children without an entry (anonymous runs of inline content) are
+// auto-placed.
+//
+// The subset: grid-template-columns/-rows with lengths, percentages, fr,
+// auto, min-content, max-content, minmax(), fit-content() and repeat()
+// (auto-fill and auto-fit included), grid-template-areas, grid-auto-rows,
+// grid-auto-columns, grid-auto-flow, gap, grid-row/-column/-area with line
+// numbers, spans and area names, justify-items/-self and
+// align-items/-self. Not supported (v1): named lines, dense packing,
+// justify-content/align-content (the tracks start at the top left) and
+// subgrids.
+type gridContainer struct {
+	columns      string
+	rows         string
+	areas        [][]string
+	autoFlow     string // row or column
+	autoRows     string
+	autoColumns  string
+	rowGap       string
+	columnGap    string
+	justifyItems string
+	alignItems   string
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+	items        map[*frontend.Text]gridItem
+}
+
+// gridItem holds the placement properties of a grid item, the raw values
+// of grid-row-start/-end and grid-column-start/-end.
+type gridItem struct {
+	rowStart     string
+	rowEnd       string
+	columnStart  string
+	columnEnd    string
+	justifySelf  string
+	alignSelf    string
+	width        string
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+}
+
+var gridAreaRow = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'([^']*)'`)
+
+// elementGrid returns the grid container of an element with display: grid
+// or inline-grid, nil otherwise. Table-internal elements and images keep
+// their own layout.
+func elementGrid(styles *FormattingStyles, tag string) *gridContainer {
+	if styles.display != "grid" && styles.display != "inline-grid" {
+		return nil
+	}
+	switch tag {
+	case "table", "thead", "tbody", "tfoot", "tr", "td", "th", "col", "colgroup", "img":
+		return nil
+	}
+	gc := &gridContainer{
+		columns:      styles.gridTemplateColumns,
+		rows:         styles.gridTemplateRows,
+		autoFlow:     "row",
+		autoRows:     styles.gridAutoRows,
+		autoColumns:  styles.gridAutoColumns,
+		justifyItems: styles.justifyItems,
+		alignItems:   styles.alignItems,
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+		items:        map[*frontend.Text]gridItem{},
+	}
+	if strings.Contains(styles.gridAutoFlow, "column") {
+		gc.autoFlow = "column"
+	}
+	for _, m := range gridAreaRow.FindAllStringSubmatch(styles.gridTemplateAreas, -1) {
+		row := m[1]
+		if row == "" {
+			row = m[2]
+		}
+		gc.areas = append(gc.areas, strings.Fields(row))
+	}
+	if g := strings.Fields(styles.gap); len(g) > 0 {
+		gc.rowGap, gc.columnGap = g[0], g[0]
+		if len(g) > 1 {
+			gc.columnGap = g[1]
+		}
+	}
+	if styles.rowGap != "" {
+		gc.rowGap = styles.rowGap
+	}
+	if styles.columnGap != "" {
+		gc.columnGap = styles.columnGap
+	}
+	return gc
+}
+
+// splitGridLines splits the value of grid-row, grid-column or grid-area
+// into its grid lines. The value arrives without its slashes, so the lines
+// are told apart by their grammar: `span <n>`, `<n>`, `auto` or a name.
+func splitGridLines(v string) []string {
+	var lines []string
+	f := strings.Fields(strings.ReplaceAll(v, "/", " "))
+	for i := 0; i < len(f); i++ {
+		if f[i] == "span" && i+1 < len(f) {
+			lines = append(lines, "span "+f[i+1])
+			i++
+			continue
+		}
+		lines = append(lines, f[i])
+	}
+	return lines
+}
+
+// isGridName reports whether a grid line value is a name (an area).
+func isGridName(v string) bool {
+	if v == "" || v == "auto" || strings.HasPrefix(v, "span ") {
+		return false
+	}
+	_, err := strconv.Atoi(v)
+	return err != nil
+}
+
+// elementGridItem returns the placement of a grid item from its styles.
+// The longhands win over grid-row, grid-column and grid-area.
+func elementGridItem(styles *FormattingStyles) gridItem {
+	gi := gridItem{
+		justifySelf:  styles.justifySelf,
+		alignSelf:    styles.alignSelf,
+		width:        styles.width,
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+	}
+	// grid-area: <name> | <row-start> / <column-start> / <row-end> / <column-end>
+	if a := splitGridLines(styles.gridArea); len(a) > 0 {
+		gi.rowStart = a[0]
+		gi.columnStart, gi.rowEnd, gi.columnEnd = a[0], a[0], a[0]
+		if !isGridName(a[0]) {
+			gi.columnStart, gi.rowEnd, gi.columnEnd = "", "", ""
+		}
+		if len(a) > 1 {
+			gi.columnStart, gi.columnEnd = a[1], ""
+			if isGridName(a[1]) {
+				gi.columnEnd = a[1]
+			}
+		}
+		if len(a) > 2 {
+			gi.rowEnd = a[2]
+		}
+		if len(a) > 3 {
+			gi.columnEnd = a[3]
+		}
+	}
+	// grid-row / grid-column: <start> [/ <end>], a lone name is both.
+	pair := func(v string, start, end *string) {
+		l := splitGridLines(v)
+		if len(l) == 0 {
+			return
+		}
+		*start, *end = l[0], ""
+		if isGridName(l[0]) {
+			*end = l[0]
+		}
+		if len(l) > 1 {
+			*end = l[1]
+		}
+	}
+	pair(styles.gridRow, &gi.rowStart, &gi.rowEnd)
+	pair(styles.gridColumn, &gi.columnStart, &gi.columnEnd)
+	for _, lh := range []struct {
+		v   string
+		dst *string
+	}{
+		{styles.gridRowStart, &gi.rowStart},
+		{styles.gridRowEnd, &gi.rowEnd},
+		{styles.gridColumnStart, &gi.columnStart},
+		{styles.gridColumnEnd, &gi.columnEnd},
+	} {
+		if l := splitGridLines(lh.v); len(l) > 0 {
+			*lh.dst = l[0]
+		}
+	}
+	return gi
+}
+
+// addItem records the placement of the grid item te, built from the child
+// element item.
+func (gc *gridContainer) addItem(te *frontend.Text, item *HTMLItem, ss StylesStack, df *frontend.Document) error {
+	probe := ss.CurrentStyle().Clone()
+	if err := StylesToStyles(probe, item.Styles, df, ss.CurrentStyle().Fontsize); err != nil {
+		return err
+	}
+	gc.items[te] = elementGridItem(probe)
+	return nil
+}
+
+// area returns the tracks (0-based, end exclusive) a named area of
+// grid-template-areas covers, rows first.
+func (gc *gridContainer) area(name string) (r0, c0, r1, c1 int, ok bool) {
+	for r, row := range gc.areas {
+		for c, cell := range row {
+			if cell != name {
+				continue
+			}
+			if !ok {
+				r0, c0, r1, c1, ok = r, c, r+1, c+1, true
+				continue
+			}
+			r0, c0 = min(r0, r), min(c0, c)
+			r1, c1 = max(r1, r+1), max(c1, c+1)
+		}
+	}
+	return
+}
+
+// gridTrackSize is one sizing function of a track: a length or
+// percentage, a flex factor, or auto / min-content / max-content.
+type gridTrackSize struct {
+	kind   string // length, fr, auto, min-content, max-content
+	length string
+	fr     float64
+}
+
+// gridTrack is a track with its minimum and maximum sizing function.
+// fit-content(x) is minmax(auto, max-content) limited to x.
+type gridTrack struct {
+	min, max gridTrackSize
+	fit      string
+}
+
+// content reports whether the size depends on the items.
+func (ts gridTrackSize) content() bool {
+	switch ts.kind {
+	case "auto", "min-content", "max-content":
+		return true
+	}
+	return false
+}
+
+// parseTrackSize parses a single sizing function.
+func parseTrackSize(v string) (gridTrackSize, bool) {
+	switch v {
+	case "auto", "min-content", "max-content":
+		return gridTrackSize{kind: v}, true
+	}
+	if f, ok := strings.CutSuffix(v, "fr"); ok {
+		if x, err := strconv.ParseFloat(f, 64); err == nil && x >= 0 {
+			return gridTrackSize{kind: "fr", fr: x}, true
+		}
+		return gridTrackSize{}, false
+	}
+	if v == "0" || strings.HasSuffix(v, "%") || strings.IndexFunc(v, func(r rune) bool { return r >= 'a' && r <= 'z' }) > 0 {
+		return gridTrackSize{kind: "length", length: v}, true
+	}
+	return gridTrackSize{}, false
+}
+
+// gridTokens splits a track list into its tokens: sizes, function names
+// with their opening parenthesis, commas and closing parentheses.
+func gridTokens(v string) []string {
+	r := strings.NewReplacer("(", "( ", ")", " ) ", ",", " , ")
+	return strings.Fields(r.Replace(v))
+}
+
+// parseTracks parses a track list (grid-template-columns/-rows,
+// grid-auto-rows/-columns). Line names are skipped. repeat(auto-fill, …)
+// and repeat(auto-fit, …) repeat as often as the tracks fit into avail;
+// size resolves the fixed sizes for that.
+func parseTracks(v string, avail, gap bag.ScaledPoint, size func(gridTrackSize) bag.ScaledPoint) []gridTrack {
+	toks := gridTokens(v)
+	var parse func() []gridTrack
+	i := 0
+	// args returns the tokens up to the matching closing parenthesis,
+	// split at the top level commas.
+	args := func() [][]string {
+		var out [][]string
+		var cur []string
+		depth := 0
+		for ; i < len(toks); i++ {
+			t := toks[i]
+			switch {
+			case strings.HasSuffix(t, "("):
+				depth++
+			case t == ")":
+				if depth == 0 {
+					i++
+					return append(out, cur)
+				}
+				depth--
+			case t == "," && depth == 0:
+				out = append(out, cur)
+				cur = nil
+				continue
+			}
+			cur = append(cur, t)
+		}
+		return append(out, cur)
+	}
+	parse = func() []gridTrack {
+		var tracks []gridTrack
+		for i < len(toks) {
+			t := toks[i]
+			i++
+			switch t {
+			case "repeat(":
+				a := args()
+				if len(a) != 2 {
+					continue
+				}
+				saved, savedI := toks, i
+				toks, i = a[1], 0
+				pattern := parse()
+				toks, i = saved, savedI
+				if len(pattern) == 0 {
+					continue
+				}
+				count := 1
+				if len(a[0]) == 1 {
+					switch a[0][0] {
+					case "auto-fill", "auto-fit":
+						var w bag.ScaledPoint
+						for _, tr := range pattern {
+							s := tr.max
+							if s.kind != "length" {
+								s = tr.min
+							}
+							w += size(s) + gap
+						}
+						if w > 0 && avail > 0 {
+							count = max(int((avail+gap)/w), 1)
+						}
+					default:
+						if n, err := strconv.Atoi(a[0][0]); err == nil && n > 0 {
+							count = n
+						}
+					}
+				}
+				for range count {
+					tracks = append(tracks, pattern...)
+				}
+			case "minmax(":
+				a := args()
+				if len(a) != 2 || len(a[0]) != 1 || len(a[1]) != 1 {
+					continue
+				}
+				lo, ok1 := parseTrackSize(a[0][0])
+				hi, ok2 := parseTrackSize(a[1][0])
+				if ok1 && ok2 {
+					if lo.kind == "fr" {
+						lo = gridTrackSize{kind: "auto"}
+					}
+					tracks = append(tracks, gridTrack{min: lo, max: hi})
+				}
+			case "fit-content(":
+				a := args()
+				if len(a) == 1 && len(a[0]) == 1 {
+					tracks = append(tracks, gridTrack{min: gridTrackSize{kind: "auto"}, max: gridTrackSize{kind: "max-content"}, fit: a[0][0]})
+				}
+			default:
+				ts, ok := parseTrackSize(t)
+				if !ok {
+					// A line name.
+					continue
+				}
+				tr := gridTrack{min: ts, max: ts}
+				if ts.kind == "fr" {
+					tr.min = gridTrackSize{kind: "auto"}
+				}
+				tracks = append(tracks, tr)
+			}
+		}
+		return tracks
+	}
+	return parse()
+}
+
+// gridEntry is a grid item during layout: its area (0-based tracks, end
+// exclusive) and its alignment in the area.
+type gridEntry struct {
+	layoutItem
+	item           gridItem
+	row, col       int
+	rowSpan        int
+	colSpan        int
+	justify, align string // start, end, center or stretch
+	intrinsic      intrinsicWidths
+	offsetX        bag.ScaledPoint
+}
+
+// gridLayout is the resolved layout of a grid container (a childLayout):
+// the items in their areas and the track sizes.
+//
+// Fragmentation: the rows of the grid are laid out like table rows, each
+// row (together with the rows an item spans into) is a monolithic box the
+// page builder can break before, as outputTableRows breaks between table
+// rows. The container is a transparent block container for the page
+// builder, a row taller than the page is not split (v1).
+type gridLayout struct {
+	gc       *gridContainer
+	avail    bag.ScaledPoint
+	height   bag.ScaledPoint
+	rowGap   bag.ScaledPoint
+	colGap   bag.ScaledPoint
+	colSizes []bag.ScaledPoint
+	rowSizes []bag.ScaledPoint
+	entries  []*gridEntry
+	byText   map[*frontend.Text]*gridEntry
+	taken    takenMarkers
+}
+
+// length resolves a length of the container, percentages against ref.
+func (gc *gridContainer) length(v string, ref bag.ScaledPoint) bag.ScaledPoint {
+	return flexLength(v, ref, gc.fontsize, gc.rootFontsize)
+}
+
+// gridLine is a resolved grid-*-start or grid-*-end value: a line number
+// (1-based, 0 = auto) or a span.
+type gridLine struct {
+	line int
+	span int
+}
+
+// resolveGridLine resolves a line value on an axis with count explicit
+// tracks. name resolves an area name to its start and end line.
+func resolveGridLine(v string, count int, end bool, name func(string) (int, int, bool)) gridLine {
+	switch {
+	case v == "" || v == "auto":
+		return gridLine{}
+	case strings.HasPrefix(v, "span "):
+		if n, err := strconv.Atoi(strings.TrimPrefix(v, "span ")); err == nil && n > 0 {
+			return gridLine{span: n}
+		}
+		return gridLine{span: 1}
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 0 {
+			// Negative lines count from the last explicit line.
+			n = count + 2 + n
+		}
+		if n < 1 {
+			n = 1
+		}
+		return gridLine{line: n}
+	}
+	// An area name, or the implicit <area>-start / <area>-end lines.
+	base := strings.TrimSuffix(strings.TrimSuffix(v, "-start"), "-end")
+	if s, e, ok := name(base); ok {
+		switch {
+		case strings.HasSuffix(v, "-start"):
+			return gridLine{line: s}
+		case strings.HasSuffix(v, "-end"), end:
+			return gridLine{line: e}
+		}
+		return gridLine{line: s}
+	}
+	return gridLine{}
+}
+
+// placement combines the start and end line of an axis to a track index
+// (-1 = auto) and a span.
+func placement(start, end gridLine) (int, int) {
+	switch {
+	case start.line > 0 && end.line > 0:
+		s, e := start.line, end.line
+		if e < s {
+			s, e = e, s
+		}
+		if e == s {
+			e = s + 1
+		}
+		return s - 1, e - s
+	case start.line > 0:
+		return start.line - 1, max(end.span, 1)
+	case end.line > 0:
+		span := max(start.span, 1)
+		return max(end.line-1-span, 0), span
+	}
+	return -1, max(start.span, end.span, 1)
+}
+
+// layoutGrid places the items of the grid container te and sizes the
+// tracks for an inner width of avail.
+func (cb *CSSBuilder) layoutGrid(te *frontend.Text, gc *gridContainer, avail bag.ScaledPoint) (*gridLayout, error) {
+	gl := &gridLayout{
+		gc:     gc,
+		avail:  avail,
+		byText: map[*frontend.Text]*gridEntry{},
+	}
+	gl.height, _ = te.Settings[settingCSSHeight].(bag.ScaledPoint)
+	gl.rowGap = gc.length(gc.rowGap, gl.height)
+	gl.colGap = gc.length(gc.columnGap, avail)
+	fixed := func(ref bag.ScaledPoint) func(gridTrackSize) bag.ScaledPoint {
+		return func(ts gridTrackSize) bag.ScaledPoint {
+			if ts.kind != "length" {
+				return 0
+			}
+			return gc.length(ts.length, ref)
+		}
+	}
+	cols := parseTracks(gc.columns, avail, gl.colGap, fixed(avail))
+	rows := parseTracks(gc.rows, gl.height, gl.rowGap, fixed(gl.height))
+	for _, r := range gc.areas {
+		for len(cols) < len(r) {
+			cols = append(cols, gridTrack{min: gridTrackSize{kind: "auto"}, max: gridTrackSize{kind: "auto"}})
+		}
+	}
+	for len(rows) < len(gc.areas) {
+		rows = append(rows, gridTrack{min: gridTrackSize{kind: "auto"}, max: gridTrackSize{kind: "auto"}})
+	}
+
+	for _, t := range layoutChildren(te) {
+		gi, ok := gc.items[t]
+		if !ok {
+			gi = gridItem{fontsize: gc.fontsize, rootFontsize: gc.rootFontsize}
+		}
+		e := &gridEntry{layoutItem: newLayoutItem(t), item: gi}
+		e.justify = gridAlign(gi.justifySelf, gc.justifyItems)
+		e.align = gridAlign(gi.alignSelf, gc.alignItems)
+		rowName := func(n string) (int, int, bool) {
+			r0, _, r1, _, ok := gc.area(n)
+			return r0 + 1, r1 + 1, ok
+		}
+		colName := func(n string) (int, int, bool) {
+			_, c0, _, c1, ok := gc.area(n)
+			return c0 + 1, c1 + 1, ok
+		}
+		e.row, e.rowSpan = placement(resolveGridLine(gi.rowStart, len(rows), false, rowName), resolveGridLine(gi.rowEnd, len(rows), true, rowName))
+		e.col, e.colSpan = placement(resolveGridLine(gi.columnStart, len(cols), false, colName), resolveGridLine(gi.columnEnd, len(cols), true, colName))
+		gl.entries = append(gl.entries, e)
+		gl.byText[t] = e
+		if err := cb.takeMarkers(t, avail, &gl.taken); err != nil {
+			return nil, err
+		}
+	}
+	ncols, nrows := gl.place(len(cols), len(rows))
+
+	// Implicit tracks follow grid-auto-columns / grid-auto-rows.
+	implicit := func(tracks []gridTrack, n int, auto string, ref, gap bag.ScaledPoint) []gridTrack {
+		pattern := parseTracks(auto, ref, gap, fixed(ref))
+		if len(pattern) == 0 {
+			pattern = []gridTrack{{min: gridTrackSize{kind: "auto"}, max: gridTrackSize{kind: "auto"}}}
+		}
+		for i := 0; len(tracks) < n; i++ {
+			tracks = append(tracks, pattern[i%len(pattern)])
+		}
+		return tracks
+	}
+	cols = implicit(cols, ncols, gc.autoColumns, avail, gl.colGap)
+	rows = implicit(rows, nrows, gc.autoRows, gl.height, gl.rowGap)
+
+	// Column widths from the intrinsic widths of the items, an item with a
+	// CSS width contributes that.
+	var colSpans []gridSpan
+	for _, e := range gl.entries {
+		if e.item.width != "" && e.item.width != "auto" {
+			w, err := cb.itemWidth(e.t, e.item.width, avail, e.item.fontsize, e.item.rootFontsize)
+			if err != nil {
+				return nil, err
+			}
+			e.intrinsic = intrinsicWidths{min: w, max: w}
+		} else {
+			iw, err := cb.intrinsicWidths(e.t)
+			if err != nil {
+				return nil, err
+			}
+			e.intrinsic = iw
+		}
+		m := e.ml + e.mr
+		colSpans = append(colSpans, gridSpan{e.col, e.colSpan, e.intrinsic.min + m, e.intrinsic.max + m})
+	}
+	gl.colSizes = sizeTracks(cols, colSpans, avail, true, gl.colGap, fixed(avail))
+
+	// The items get their widths, their heights size the rows.
+	var rowSpans []gridSpan
+	for _, e := range gl.entries {
+		area := gl.span(gl.colSizes, gl.colGap, e.col, e.colSpan) - e.ml - e.mr
+		e.width = e.intrinsic.fit(area)
+		if e.justify == "stretch" && (e.item.width == "" || e.item.width == "auto") {
+			e.width = area
+		}
+		e.width = bag.Max(e.width, boxFrameWidth(e.t))
+		switch e.justify {
+		case "end":
+			e.offsetX = area - e.width
+		case "center":
+			e.offsetX = (area - e.width) / 2
+		}
+		vl, err := cb.measureItem(e.t, e.width)
+		if err != nil {
+			return nil, err
+		}
+		h := vl.Height + vl.Depth + e.mt + e.mb
+		rowSpans = append(rowSpans, gridSpan{e.row, e.rowSpan, h, h})
+	}
+	gl.rowSizes = sizeTracks(rows, rowSpans, gl.height, gl.height > 0, gl.rowGap, fixed(gl.height))
+	// Content taller than a fixed row enlarges it: nothing overlaps the
+	// next row.
+	for _, s := range rowSpans {
+		if have := gl.span(gl.rowSizes, gl.rowGap, s.start, s.n); have < s.max {
+			gl.rowSizes[s.start+s.n-1] += s.max - have
+		}
+	}
+	for _, e := range gl.entries {
+		if e.align == "stretch" {
+			e.stretch(gl.span(gl.rowSizes, gl.rowGap, e.row, e.rowSpan))
+		}
+	}
+	return gl, nil
+}
+
+// gridAlign returns the alignment of an item in its area: the -self value,
+// or the -items value of the container for auto.
+func gridAlign(self, items string) string {
+	v := self
+	if v == "" || v == "auto" {
+		v = items
+	}
+	switch v {
+	case "end", "flex-end", "self-end", "right":
+		return "end"
+	case "center":
+		return "center"
+	case "start", "flex-start", "self-start", "left", "baseline", "first baseline", "last baseline":
+		return "start"
+	}
+	return "stretch"
+}
+
+// place puts the items into the grid (CSS Grid 1 §8.5, sparse packing) and
+// returns the number of columns and rows. cols and rows are the explicit
+// track counts.
+func (gl *gridLayout) place(cols, rows int) (int, int) {
+	// Work in flow coordinates: the major axis is the one auto-placement
+	// advances along line by line (rows for grid-auto-flow: row).
+	column := gl.gc.autoFlow == "column"
+	flow := func(e *gridEntry) (major, minor, majorSpan, minorSpan *int) {
+		if column {
+			return &e.col, &e.row, &e.colSpan, &e.rowSpan
+		}
+		return &e.row, &e.col, &e.rowSpan, &e.colSpan
+	}
+	minorCount := cols
+	if column {
+		minorCount = rows
+	}
+	for _, e := range gl.entries {
+		_, minor, _, minorSpan := flow(e)
+		minorCount = max(minorCount, *minorSpan, *minor+*minorSpan)
+	}
+	minorCount = max(minorCount, 1)
+
+	occupied := map[[2]int]bool{}
+	fits := func(ma, mi, mas, mis int) bool {
+		if mi+mis > minorCount {
+			return false
+		}
+		for a := ma; a < ma+mas; a++ {
+			for b := mi; b < mi+mis; b++ {
+				if occupied[[2]int{a, b}] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	mark := func(ma, mi, mas, mis int) {
+		for a := ma; a < ma+mas; a++ {
+			for b := mi; b < mi+mis; b++ {
+				occupied[[2]int{a, b}] = true
+			}
+		}
+	}
+	// Items with a definite position first, then the items locked to a
+	// major track, then the rest in order.
+	for _, e := range gl.entries {
+		major, minor, majorSpan, minorSpan := flow(e)
+		if *major >= 0 && *minor >= 0 {
+			mark(*major, *minor, *majorSpan, *minorSpan)
+		}
+	}
+	for _, e := range gl.entries {
+		major, minor, majorSpan, minorSpan := flow(e)
+		if *major >= 0 && *minor < 0 {
+			*minor = 0
+			for !fits(*major, *minor, *majorSpan, *minorSpan) && *minor+*minorSpan < minorCount {
+				*minor++
+			}
+			mark(*major, *minor, *majorSpan, *minorSpan)
+		}
+	}
+	curMajor, curMinor := 0, 0
+	for _, e := range gl.entries {
+		major, minor, majorSpan, minorSpan := flow(e)
+		if *major >= 0 {
+			continue
+		}
+		if *minor >= 0 {
+			if *minor < curMinor {
+				curMajor++
+			}
+			curMinor = *minor
+			for !fits(curMajor, curMinor, *majorSpan, *minorSpan) {
+				curMajor++
+			}
+		} else {
+			for !fits(curMajor, curMinor, *majorSpan, *minorSpan) {
+				curMinor++
+				if curMinor+*minorSpan > minorCount {
+					curMajor++
+					curMinor = 0
+				}
+			}
+		}
+		*major, *minor = curMajor, curMinor
+		mark(*major, *minor, *majorSpan, *minorSpan)
+		curMinor += *minorSpan
+	}
+
+	ncols, nrows := cols, rows
+	for _, e := range gl.entries {
+		ncols = max(ncols, e.col+e.colSpan)
+		nrows = max(nrows, e.row+e.rowSpan)
+	}
+	return ncols, nrows
+}
+
+// gridSpan is the size an item needs in n tracks from start on: its
+// min-content and max-content contribution (both its height for rows).
+type gridSpan struct {
+	start, n int
+	min, max bag.ScaledPoint
+}
+
+// span returns the size of n tracks from start on, with the gaps between
+// them.
+func (gl *gridLayout) span(sizes []bag.ScaledPoint, gap bag.ScaledPoint, start, n int) bag.ScaledPoint {
+	var s bag.ScaledPoint
+	for i := start; i < start+n && i < len(sizes); i++ {
+		s += sizes[i]
+	}
+	return s + gap*bag.ScaledPoint(max(n-1, 0))
+}
+
+// sizeTracks is the track sizing algorithm (CSS Grid 1 §11, simplified):
+// fixed sizes first, then the content sized tracks get the min-content
+// contributions of their items and grow toward the max-content
+// contributions in equal shares of the free space, like the columns of an
+// auto table. The remaining space goes to the fr tracks or, without fr
+// tracks, to the auto tracks. Without a definite size (rows of a container
+// without a height) fr tracks are sized like auto tracks.
+func sizeTracks(tracks []gridTrack, spans []gridSpan, avail bag.ScaledPoint, definite bool, gap bag.ScaledPoint, fixed func(gridTrackSize) bag.ScaledPoint) []bag.ScaledPoint {
+	n := len(tracks)
+	base := make([]bag.ScaledPoint, n)
+	limit := make([]bag.ScaledPoint, n)
+	flexible := func(i int) bool { return tracks[i].max.kind == "fr" && definite }
+	for i, tr := range tracks {
+		if tr.min.kind == "length" {
+			base[i] = fixed(tr.min)
+		}
+		if tr.max.kind == "length" {
+			limit[i] = fixed(tr.max)
+		}
+	}
+	// grow distributes what an item needs beyond the tracks it spans in
+	// equal shares to the eligible ones.
+	grow := func(target []bag.ScaledPoint, s gridSpan, size bag.ScaledPoint, eligible func(int) bool) {
+		have := gap * bag.ScaledPoint(s.n-1)
+		var idx []int
+		for i := s.start; i < s.start+s.n; i++ {
+			have += target[i]
+			if eligible(i) {
+				idx = append(idx, i)
+			}
+		}
+		if deficit := size - have; deficit > 0 && len(idx) > 0 {
+			for _, i := range idx {
+				target[i] += deficit / bag.ScaledPoint(len(idx))
+			}
+		}
+	}
+	minSized := func(i int) bool {
+		return tracks[i].min.content() || (!definite && tracks[i].max.kind == "fr")
+	}
+	maxSized := func(i int) bool {
+		return tracks[i].max.content() || (!definite && tracks[i].max.kind == "fr")
+	}
+	// Items in a single track first, then the spanning ones (§11.5). A
+	// single track sized max-content at the minimum or min-content at the
+	// maximum takes the other contribution.
+	for _, single := range []bool{true, false} {
+		for _, s := range spans {
+			if (s.n == 1) != single {
+				continue
+			}
+			lo, hi := s.min, s.max
+			if single && tracks[s.start].min.kind == "max-content" {
+				lo = s.max
+			}
+			if single && tracks[s.start].max.kind == "min-content" {
+				hi = s.min
+			}
+			grow(base, s, lo, minSized)
+			grow(limit, s, hi, maxSized)
+		}
+	}
+	for i, tr := range tracks {
+		if tr.fit != "" {
+			limit[i] = bag.Min(limit[i], fixed(gridTrackSize{kind: "length", length: tr.fit}))
+		}
+		limit[i] = bag.Max(limit[i], base[i])
+	}
+	if !definite {
+		return base
+	}
+
+	free := avail - gap*bag.ScaledPoint(max(n-1, 0))
+	var frSum float64
+	for i := range tracks {
+		if flexible(i) {
+			frSum += tracks[i].max.fr
+		} else {
+			free -= base[i]
+		}
+	}
+	// Grow the tracks with room up to their limits.
+	for free > 0 {
+		var room []int
+		for i := range tracks {
+			if !flexible(i) && limit[i] > base[i] {
+				room = append(room, i)
+			}
+		}
+		if len(room) == 0 {
+			break
+		}
+		share := free / bag.ScaledPoint(len(room))
+		if share == 0 {
+			break
+		}
+		for _, i := range room {
+			d := bag.Min(share, limit[i]-base[i])
+			base[i] += d
+			free -= d
+		}
+	}
+	if frSum > 0 {
+		// Find the size of 1fr (§11.7.1): a flexible track whose base
+		// size exceeds its share keeps the base size, the others share
+		// what is left.
+		fixedFr := map[int]bool{}
+		for {
+			unit := float64(bag.Max(free, 0)) / math.Max(frSum, 1)
+			changed := false
+			for i := range tracks {
+				if flexible(i) && !fixedFr[i] && float64(base[i]) > unit*tracks[i].max.fr {
+					fixedFr[i] = true
+					free -= base[i]
+					frSum -= tracks[i].max.fr
+					changed = true
+				}
+			}
+			if changed {
+				continue
+			}
+			for i := range tracks {
+				if flexible(i) && !fixedFr[i] {
+					base[i] = bag.ScaledPoint(unit * tracks[i].max.fr)
+				}
+			}
+			return base
+		}
+	}
+	// No fr tracks: auto tracks stretch into the remaining space.
+	if free > 0 {
+		var auto []int
+		for i, tr := range tracks {
+			if tr.max.kind == "auto" {
+				auto = append(auto, i)
+			}
+		}
+		for _, i := range auto {
+			base[i] += free / bag.ScaledPoint(len(auto))
+		}
+	}
+	return base
+}
+
+// item returns the layout item of the child t (childLayout).
+func (gl *gridLayout) item(t *frontend.Text) *layoutItem {
+	if e, ok := gl.byText[t]; ok {
+		return &e.layoutItem
+	}
+	return nil
+}
+
+// arrange replaces the children of the grid container vls by the grid rows.
+// Rows an item spans are joined into one box. Within a row box every item
+// hangs from the top of its area, positioned by kerns that return to the
+// start of the row after each item.
+func (gl *gridLayout) arrange(vls *node.VList, shift bag.ScaledPoint) {
+	nrows := len(gl.rowSizes)
+	// A band is the first and the last row of a row box.
+	var bands [][2]int
+	for r := 0; r < nrows; {
+		end := r
+		for changed := true; changed; {
+			changed = false
+			for _, e := range gl.entries {
+				if e.row >= r && e.row <= end && e.row+e.rowSpan-1 > end {
+					end = e.row + e.rowSpan - 1
+					changed = true
+				}
+			}
+		}
+		bands = append(bands, [2]int{r, end})
+		r = end + 1
+	}
+	colX := make([]bag.ScaledPoint, len(gl.colSizes))
+	for i := 1; i < len(colX); i++ {
+		colX[i] = colX[i-1] + gl.colSizes[i-1] + gl.colGap
+	}
+
+	var nodes []node.Node
+	for b, band := range bands {
+		if b > 0 && gl.rowGap > 0 {
+			nodes = append(nodes, layoutKern(gl.rowGap, "grid-gap"))
+		}
+		height := gl.span(gl.rowSizes, gl.rowGap, band[0], band[1]-band[0]+1)
+		box := node.NewVList()
+		box.Attributes = node.H{"origin": "grid row"}
+		var head node.Node
+		add := func(n node.Node) {
+			head = node.InsertAfter(head, node.Tail(head), n)
+		}
+		for _, e := range gl.entries {
+			if e.row < band[0] || e.row > band[1] || e.vl == nil {
+				continue
+			}
+			areaHeight := gl.span(gl.rowSizes, gl.rowGap, e.row, e.rowSpan)
+			y := gl.span(gl.rowSizes, gl.rowGap, band[0], e.row-band[0])
+			if e.row > band[0] {
+				y += gl.rowGap
+			}
+			switch e.align {
+			case "end":
+				y += areaHeight - e.outerHeight()
+			case "center":
+				y += (areaHeight - e.outerHeight()) / 2
+			}
+			var inner node.Node
+			if y+e.mt > 0 {
+				inner = layoutKern(y+e.mt, "grid-align")
+			}
+			inner = node.InsertAfter(inner, node.Tail(inner), e.vl)
+			cell := node.Vpack(inner)
+			cell.Width = e.width
+			cell.Height = height
+			cell.Depth = 0
+			cell.Attributes = node.H{"origin": "grid item"}
+			liftItemAttributes(box, e.vl)
+			x := colX[e.col] + e.ml + e.offsetX
+			if x != 0 {
+				add(layoutKern(x, "grid-place"))
+			}
+			add(cell)
+			add(layoutKern(-x-e.width, "grid-place"))
+		}
+		if head != nil {
+			hl := node.Hpack(head)
+			hl.Width = gl.avail
+			hl.Height = height
+			hl.Depth = 0
+			box.List = hl
+		}
+		box.Width = gl.avail
+		box.Height = height
+		box.ShiftX = shift
+		nodes = append(nodes, box)
+	}
+	gl.taken.attach(nodes)
+	items := make([]*layoutItem, 0, len(gl.entries))
+	for _, e := range gl.entries {
+		items = append(items, &e.layoutItem)
+	}
+	replaceChildren(vls, items, nodes, gl.avail)
+}
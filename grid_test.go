@@ -0,0 +1,183 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func TestParseTracks(t *testing.T) {
+	size := func(ts gridTrackSize) bag.ScaledPoint {
+		if ts.kind != "length" {
+			return 0
+		}
+		return bag.MustSP(ts.length)
+	}
+	cases := []struct {
+		v    string
+		want []string // min/max kinds
+	}{
+		{"100pt 1fr auto", []string{"length/length", "auto/fr", "auto/auto"}},
+		{"repeat( 3 , 1fr )", []string{"auto/fr", "auto/fr", "auto/fr"}},
+		{"minmax(50pt, 2fr) max-content", []string{"length/fr", "max-content/max-content"}},
+		{"full-start 1fr full-end", []string{"auto/fr"}},
+		{"fit-content(80pt)", []string{"auto/max-content"}},
+		// 100pt tracks with 10pt gaps: four fit into 430pt.
+		{"repeat(auto-fill, 100pt)", []string{"length/length", "length/length", "length/length", "length/length"}},
+	}
+	for _, tc := range cases {
+		tracks := parseTracks(tc.v, bag.MustSP("430pt"), bag.MustSP("10pt"), size)
+		var got []string
+		for _, tr := range tracks {
+			got = append(got, tr.min.kind+"/"+tr.max.kind)
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("parseTracks(%q) = %v, want %v", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestElementGridItem(t *testing.T) {
+	cases := []struct {
+		styles FormattingStyles
+		want   [4]string // row start/end, column start/end
+	}{
+		// The slashes are gone when the value arrives.
+		{FormattingStyles{gridColumn: "1 3"}, [4]string{"", "", "1", "3"}},
+		{FormattingStyles{gridColumn: "span 2", gridRow: "2"}, [4]string{"2", "", "span 2", ""}},
+		{FormattingStyles{gridRow: "span 2 -1"}, [4]string{"span 2", "-1", "", ""}},
+		{FormattingStyles{gridArea: "main"}, [4]string{"main", "main", "main", "main"}},
+		{FormattingStyles{gridArea: "1 2 3 4"}, [4]string{"1", "3", "2", "4"}},
+		// The longhands win over the shorthands.
+		{FormattingStyles{gridColumn: "1 3", gridColumnEnd: "span 3"}, [4]string{"", "", "1", "span 3"}},
+	}
+	for _, tc := range cases {
+		gi := elementGridItem(&tc.styles)
+		got := [4]string{gi.rowStart, gi.rowEnd, gi.columnStart, gi.columnEnd}
+		if got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.styles, got, tc.want)
+		}
+	}
+}
+
+func TestGridPlacement(t *testing.T) {
+	gc := &gridContainer{autoFlow: "row"}
+	gl := &gridLayout{gc: gc}
+	add := func(row, col, rs, cs int) *gridEntry {
+		e := &gridEntry{row: row, col: col, rowSpan: rs, colSpan: cs}
+		gl.entries = append(gl.entries, e)
+		return e
+	}
+	a := add(-1, -1, 1, 1)
+	b := add(0, 1, 2, 1) // definite, placed first
+	c := add(-1, -1, 1, 2)
+	d := add(-1, -1, 1, 1)
+	ncols, nrows := gl.place(3, 0)
+	if ncols != 3 || nrows != 3 {
+		t.Errorf("grid is %dx%d, want 3x3", ncols, nrows)
+	}
+	for _, tc := range []struct {
+		name     string
+		e        *gridEntry
+		row, col int
+	}{
+		{"a", a, 0, 0},
+		{"b", b, 0, 1},
+		// The two column item does not fit beside b in the second row.
+		{"c", c, 2, 0},
+		{"d", d, 2, 2},
+	} {
+		if tc.e.row != tc.row || tc.e.col != tc.col {
+			t.Errorf("%s at %d/%d, want %d/%d", tc.name, tc.e.row, tc.e.col, tc.row, tc.col)
+		}
+	}
+}
+
+// TestGridColumns: fixed, fr and auto columns, the gap between them and an
+// item spanning two columns.
+func TestGridColumns(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.g { display: grid; grid-template-columns: 100pt 1fr 2fr; column-gap: 10pt; }
+.wide { grid-column: 2 / span 2; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="g"><div>EINS</div><div>ZWEI</div><div>DREI</div><div>VIER</div><div class="wide">FUENF</div></div></body></html>`)
+	left := bag.MustSP("20mm")
+	fr := (bag.MustSP("170mm") - bag.MustSP("120pt")) / 3
+	want := map[string]bag.ScaledPoint{
+		"EINS":  left,
+		"ZWEI":  left + bag.MustSP("110pt"),
+		"DREI":  left + bag.MustSP("120pt") + fr,
+		"VIER":  left,
+		"FUENF": left + bag.MustSP("110pt"),
+	}
+	ys := map[string]bag.ScaledPoint{}
+	for needle, wx := range want {
+		x, y, ok := textPos(pages[0], needle)
+		if !ok {
+			t.Fatalf("%s not found", needle)
+		}
+		if !nearly(x, wx) {
+			t.Errorf("%s at x=%s, want %s", needle, x, wx)
+		}
+		ys[needle] = y
+	}
+	if ys["EINS"] != ys["DREI"] || ys["VIER"] != ys["FUENF"] || ys["VIER"] >= ys["EINS"] {
+		t.Errorf("rows at %v", ys)
+	}
+}
+
+// TestGridAreas: items placed by name in grid-template-areas, a row height
+// follows the tallest item of the row.
+func TestGridAreas(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.g { display: grid; grid-template-columns: 120pt auto; gap: 6pt;
+  grid-template-areas: "head head" "side main"; }
+.h { grid-area: head; }
+.s { grid-area: side; }
+.m { grid-area: main; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="g"><div class="m">HAUPT<br>zwei<br>drei</div><div class="s">SEITE</div><div class="h">KOPF</div></div></body></html>`)
+	left := bag.MustSP("20mm")
+	hx, hy, _ := textPos(pages[0], "KOPF")
+	sx, sy, _ := textPos(pages[0], "SEITE")
+	mx, my, ok := textPos(pages[0], "HAUPT")
+	if !ok {
+		t.Fatal("HAUPT not found")
+	}
+	if hx != left || sx != left || !nearly(mx, left+bag.MustSP("126pt")) {
+		t.Errorf("x: head %s side %s main %s", hx, sx, mx)
+	}
+	if sy != my || hy <= my {
+		t.Errorf("y: head %s side %s main %s, want head above side and main", hy, sy, my)
+	}
+}
+
+// TestGridFragmentation: a grid breaks between its rows, an item spanning
+// two rows keeps them on one page.
+func TestGridFragmentation(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.g { display: grid; grid-template-columns: repeat(2, 1fr); }
+.tall { grid-column: 1; grid-row: span 2; }`
+	var sb strings.Builder
+	for i := range 40 {
+		if i%5 == 0 {
+			fmt.Fprintf(&sb, `<div class="tall">HOCH%02d</div>`, i)
+		}
+		fmt.Fprintf(&sb, "<div>ZELLE%02d<br>zweite Zeile<br>ENDE%02d</div>", i, i)
+	}
+	pages := renderHTMLPages(t, css, `<html><body><div class="g">`+sb.String()+`</div></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the grid to break", len(pages))
+	}
+	for i := range 40 {
+		if pageWith(pages, fmt.Sprintf("ZELLE%02d", i)) != pageWith(pages, fmt.Sprintf("ENDE%02d", i)) {
+			t.Errorf("cell %d split across pages", i)
+		}
+		if i%5 == 0 {
+			// The tall item spans the rows of its two neighbours.
+			if p, q := pageWith(pages, fmt.Sprintf("HOCH%02d", i)), pageWith(pages, fmt.Sprintf("ENDE%02d", i+1)); p != q {
+				t.Errorf("HOCH%02d on page %d, the second row it spans on page %d", i, p, q)
+			}
+		}
+	}
+}
@@ -38,6 +38,20 @@ type HTMLValues struct {
 	PaddingRight            bag.ScaledPoint
 	PaddingBottom           bag.ScaledPoint
 	PaddingLeft             bag.ScaledPoint
+	// boxShadows is the CSS box-shadow list (settingBoxShadow).
+	boxShadows []shadow
+	// background holds the background images and background-clip
+	// (settingBackground).
+	background *boxBackground
+	// transparency holds the opacity and the color alphas of the box
+	// (settingTransparency), nil when everything is opaque.
+	transparency *boxTransparency
+}
+
+// hasDecoration reports whether HTMLBorder has anything to draw around the
+// box: a border, a background or a box shadow.
+func (hv HTMLValues) hasDecoration() bool {
+	return hv.hasBorder() || hv.BackgroundColor != nil || hv.background != nil || len(hv.boxShadows) > 0
 }
 
 func (hv HTMLValues) hasBorder() bool {
@@ -167,11 +181,8 @@ func (cb *CSSBuilder) CSSPropertiesToValues(p HTMLProperties) HTMLValues {
 		case "border-left-color":
 			hv.BorderLeftColor = d.GetColor(v)
 		case "border-top-style", "border-right-style", "border-bottom-style", "border-left-style":
-			var sty frontend.BorderStyle
-			switch v {
-			case "none":
-				sty = frontend.BorderStyleNone
-			default:
+			sty, ok := parseBorderStyle(v)
+			if !ok {
 				sty = frontend.BorderStyleSolid
 			}
 			switch k {
@@ -292,19 +303,6 @@ func getBorderPaths(x0, y0, x1, y1, x2, y2, x3, y3 bag.ScaledPoint, hv HTMLValue
 func (cb *CSSBuilder) HTMLBorder(vl *node.VList, hv HTMLValues) *node.VList {
 	width := vl.Width
 	height := vl.Height
-	black := cb.frontend.GetColor("black")
-	if hv.BorderTopColor == nil {
-		hv.BorderTopColor = black
-	}
-	if hv.BorderRightColor == nil {
-		hv.BorderRightColor = black
-	}
-	if hv.BorderBottomColor == nil {
-		hv.BorderBottomColor = black
-	}
-	if hv.BorderLeftColor == nil {
-		hv.BorderLeftColor = black
-	}
 
 	// We start with 4 trapezoids (1 for each border).
 	//
@@ -348,22 +346,56 @@ func (cb *CSSBuilder) HTMLBorder(vl *node.VList, hv HTMLValues) *node.VList {
 	xbg3 := xbg2 + hv.BorderRightWidth + hv.PaddingRight
 
 	ybg0 := bag.ScaledPoint(0) + hv.PaddingTop + hv.BorderTopWidth
-	ybg1 := ybg0 - hv.BorderTopWidth
 	ybg3 := ybg0 - height - hv.PaddingTop - hv.BorderTopWidth - hv.PaddingBottom - hv.BorderBottomWidth
-	ybg2 := ybg3 + hv.BorderBottomWidth
 
+	// bgTail is the last node that paints the background; the inset
+	// shadows go after it.
+	var bgTail node.Node
 	if hv.BackgroundColor != nil && hv.BackgroundColor.Space != color.ColorNone {
 		// this is the rule node for the background
-		rbg := node.NewRule()
-		rbg.Hide = true
-		// Clip to the outer (border-box) path so background extends through
-		// padding and under the border, matching CSS background-clip: border-box.
-		_, outerBG := getBorderPaths(xbg0, ybg0, xbg1, ybg1, xbg2, ybg2, xbg3, ybg3, hv)
-		outerBG.Clip().Endpath()
-		outerBG.ColorNonstroking(*hv.BackgroundColor).Rect(xbg0, ybg3, xbg3-xbg0, ybg0-ybg3).Fill()
-		rbg.Pre = "q " + outerBG.String() + " Q"
-		rbg.Attributes = node.H{"origin": "html background color"}
+		rbg := backgroundColorRule(hv, xbg0, ybg0, xbg3, ybg3)
+		if t := hv.transparency; t != nil {
+			rbg.Pre = cb.withState(rbg.Pre, t.state(t.background))
+		}
 		vl.List = node.InsertBefore(vl.List, vl.List, rbg)
+		bgTail = rbg
+	}
+
+	// Background images are painted above the background color, in the
+	// coordinates of the background rule.
+	if hv.background != nil {
+		for _, n := range cb.backgroundNodes(hv, xbg0, ybg0, xbg3, ybg3) {
+			if bgTail == nil {
+				vl.List = node.InsertBefore(vl.List, vl.List, n)
+			} else {
+				vl.List = node.InsertAfter(vl.List, bgTail, n)
+			}
+			bgTail = n
+		}
+	}
+
+	// box-shadow: inset shadows are painted above the background, outer
+	// shadows below it (CSS Backgrounds 3 §7.1). Both rules sit in front
+	// of the content, in the coordinates of the background rule.
+	if len(hv.boxShadows) > 0 {
+		if pre := boxShadowPre(hv, xbg0, ybg0, xbg3, ybg3, true); pre != "" {
+			r := node.NewRule()
+			r.Hide = true
+			r.Pre = pre
+			r.Attributes = node.H{"origin": "html inset box shadow"}
+			if bgTail != nil {
+				vl.List = node.InsertAfter(vl.List, bgTail, r)
+			} else {
+				vl.List = node.InsertBefore(vl.List, vl.List, r)
+			}
+		}
+		if pre := boxShadowPre(hv, xbg0, ybg0, xbg3, ybg3, false); pre != "" {
+			r := node.NewRule()
+			r.Hide = true
+			r.Pre = pre
+			r.Attributes = node.H{"origin": "html box shadow"}
+			vl.List = node.InsertBefore(vl.List, vl.List, r)
+		}
 	}
 
 	lgWd := hv.PaddingLeft + hv.BorderLeftWidth
@@ -398,105 +430,7 @@ func (cb *CSSBuilder) HTMLBorder(vl *node.VList, hv HTMLValues) *node.VList {
 		r := node.NewRule()
 		r.Attributes = node.H{"origin": "html border + clipping"}
 		r.Hide = true
-
-		inner, outer := getBorderPaths(x0, y0, x1, y1, x2, y2, x3, y3, hv)
-		inner.Clip().Endpath()
-		// for debugging:
-		// inner.Stroke().Endpath()
-
-		// Draw border fills for each side. For rounded corners, the inner
-		// corner points are moved to the inner arc center so that the fill
-		// extends through the curved corner area. The clip (outer minus
-		// inner path) restricts the fill to the actual border region.
-		hasLeft := hv.BorderLeftWidth > 0 && hv.BorderLeftStyle != frontend.BorderStyleNone
-		hasRight := hv.BorderRightWidth > 0 && hv.BorderRightStyle != frontend.BorderStyleNone
-		hasTop := hv.BorderTopWidth > 0 && hv.BorderTopStyle != frontend.BorderStyleNone
-		hasBottom := hv.BorderBottomWidth > 0 && hv.BorderBottomStyle != frontend.BorderStyleNone
-
-		// Inner radii for each corner (matching getBorderPaths calculations).
-		innerTLR := bag.Max(0, hv.BorderTopLeftRadius-hv.BorderLeftWidth)
-		innerTRR := bag.Max(0, hv.BorderTopRightRadius-hv.BorderRightWidth)
-		innerBLR := bag.Max(0, hv.BorderBottomLeftRadius-hv.BorderLeftWidth)
-		innerBRR := bag.Max(0, hv.BorderBottomRightRadius-hv.BorderRightWidth)
-
-		if hasTop {
-			tlx, tly := x1, y1
-			if !hasLeft {
-				tlx = x0
-			} else if innerTLR > 0 {
-				tlx = x1 + innerTLR
-				tly = y1 - innerTLR
-			}
-			trx, try_ := x2, y1
-			if !hasRight {
-				trx = x3
-			} else if innerTRR > 0 {
-				trx = x2 - innerTRR
-				try_ = y1 - innerTRR
-			}
-			if hv.BorderTopColor.Space != color.ColorNone {
-				inner.ColorNonstroking(*hv.BorderTopColor).Moveto(x0, y0).Lineto(tlx, tly).Lineto(trx, try_).Lineto(x3, y0).Close().Fill()
-			}
-		}
-		if hasLeft {
-			ltx, lty := x1, y1
-			if !hasTop {
-				lty = y0
-			} else if innerTLR > 0 {
-				ltx = x1 + innerTLR
-				lty = y1 - innerTLR
-			}
-			lbx, lby := x1, y2
-			if !hasBottom {
-				lby = y3
-			} else if innerBLR > 0 {
-				lbx = x1 + innerBLR
-				lby = y2 + innerBLR
-			}
-			if hv.BorderLeftColor.Space != color.ColorNone {
-				inner.ColorNonstroking(*hv.BorderLeftColor).Moveto(x0, y3).Lineto(lbx, lby).Lineto(ltx, lty).Lineto(x0, y0).Close().Fill()
-			}
-		}
-		if hasBottom {
-			blx, bly := x1, y2
-			if !hasLeft {
-				blx = x0
-			} else if innerBLR > 0 {
-				blx = x1 + innerBLR
-				bly = y2 + innerBLR
-			}
-			brx, bry := x2, y2
-			if !hasRight {
-				brx = x3
-			} else if innerBRR > 0 {
-				brx = x2 - innerBRR
-				bry = y2 + innerBRR
-			}
-			if hv.BorderBottomColor.Space != color.ColorNone {
-				inner.ColorNonstroking(*hv.BorderBottomColor).Moveto(x0, y3).Lineto(x3, y3).Lineto(brx, bry).Lineto(blx, bly).Close().Fill()
-			}
-		}
-		if hasRight {
-			rtx, rty := x2, y1
-			if !hasTop {
-				rty = y0
-			} else if innerTRR > 0 {
-				rtx = x2 - innerTRR
-				rty = y1 - innerTRR
-			}
-			rbx, rby := x2, y2
-			if !hasBottom {
-				rby = y3
-			} else if innerBRR > 0 {
-				rbx = x2 - innerBRR
-				rby = y2 + innerBRR
-			}
-			if hv.BorderRightColor.Space != color.ColorNone {
-				inner.ColorNonstroking(*hv.BorderRightColor).Moveto(rbx, rby).Lineto(x3, y3).Lineto(x3, y0).Lineto(rtx, rty).Close().Fill()
-			}
-		}
-
-		r.Pre = "q " + outer.String() + " " + inner.String() + " Q"
+		r.Pre = cb.borderRulePre(x0, y0, x1, y1, x2, y2, x3, y3, hv)
 		head = node.InsertAfter(head, tail, r)
 	}
 
@@ -522,3 +456,161 @@ func (cb *CSSBuilder) HTMLBorder(vl *node.VList, hv HTMLValues) *node.VList {
 
 	return vl
 }
+
+// borderRulePre returns the PDF instructions of a border rule: the border
+// ring (outer minus inner path) is the clip, each side is filled as a
+// trapezoid inside it. Solid sides are filled directly, the other border
+// styles are drawn by styledBorderSide. The coordinates are those of the
+// trapezoid sketch in HTMLBorder. Missing border colors default to black.
+func (cb *CSSBuilder) borderRulePre(x0, y0, x1, y1, x2, y2, x3, y3 bag.ScaledPoint, hv HTMLValues) string {
+	black := cb.frontend.GetColor("black")
+	if hv.BorderTopColor == nil {
+		hv.BorderTopColor = black
+	}
+	if hv.BorderRightColor == nil {
+		hv.BorderRightColor = black
+	}
+	if hv.BorderBottomColor == nil {
+		hv.BorderBottomColor = black
+	}
+	if hv.BorderLeftColor == nil {
+		hv.BorderLeftColor = black
+	}
+
+	// alpha returns the alpha of the color of a side (0 top, 1 right,
+	// 2 bottom, 3 left).
+	alpha := func(side int) float64 {
+		if hv.transparency == nil {
+			return 1
+		}
+		return hv.transparency.border[side]
+	}
+
+	inner, outer := getBorderPaths(x0, y0, x1, y1, x2, y2, x3, y3, hv)
+	inner.Clip().Endpath()
+	// for debugging:
+	// inner.Stroke().Endpath()
+
+	// Draw border fills for each side. For rounded corners, the inner
+	// corner points are moved to the inner arc center so that the fill
+	// extends through the curved corner area. The clip (outer minus
+	// inner path) restricts the fill to the actual border region.
+	hasLeft := hv.BorderLeftWidth > 0 && hv.BorderLeftStyle != frontend.BorderStyleNone
+	hasRight := hv.BorderRightWidth > 0 && hv.BorderRightStyle != frontend.BorderStyleNone
+	hasTop := hv.BorderTopWidth > 0 && hv.BorderTopStyle != frontend.BorderStyleNone
+	hasBottom := hv.BorderBottomWidth > 0 && hv.BorderBottomStyle != frontend.BorderStyleNone
+
+	// Inner radii for each corner (matching getBorderPaths calculations).
+	innerTLR := bag.Max(0, hv.BorderTopLeftRadius-hv.BorderLeftWidth)
+	innerTRR := bag.Max(0, hv.BorderTopRightRadius-hv.BorderRightWidth)
+	innerBLR := bag.Max(0, hv.BorderBottomLeftRadius-hv.BorderLeftWidth)
+	innerBRR := bag.Max(0, hv.BorderBottomRightRadius-hv.BorderRightWidth)
+
+	var sides []borderSide
+	if hasTop {
+		tlx, tly := x1, y1
+		if !hasLeft {
+			tlx = x0
+		} else if innerTLR > 0 {
+			tlx = x1 + innerTLR
+			tly = y1 - innerTLR
+		}
+		trx, try_ := x2, y1
+		if !hasRight {
+			trx = x3
+		} else if innerTRR > 0 {
+			trx = x2 - innerTRR
+			try_ = y1 - innerTRR
+		}
+		sides = append(sides, borderSide{
+			style: hv.BorderTopStyle, color: hv.BorderTopColor, width: hv.BorderTopWidth, topLeft: true, alpha: alpha(0),
+			poly: [8]bag.ScaledPoint{x0, y0, tlx, tly, trx, try_, x3, y0},
+		})
+	}
+	if hasLeft {
+		ltx, lty := x1, y1
+		if !hasTop {
+			lty = y0
+		} else if innerTLR > 0 {
+			ltx = x1 + innerTLR
+			lty = y1 - innerTLR
+		}
+		lbx, lby := x1, y2
+		if !hasBottom {
+			lby = y3
+		} else if innerBLR > 0 {
+			lbx = x1 + innerBLR
+			lby = y2 + innerBLR
+		}
+		sides = append(sides, borderSide{
+			style: hv.BorderLeftStyle, color: hv.BorderLeftColor, width: hv.BorderLeftWidth, topLeft: true, alpha: alpha(3),
+			poly: [8]bag.ScaledPoint{x0, y3, lbx, lby, ltx, lty, x0, y0},
+		})
+	}
+	if hasBottom {
+		blx, bly := x1, y2
+		if !hasLeft {
+			blx = x0
+		} else if innerBLR > 0 {
+			blx = x1 + innerBLR
+			bly = y2 + innerBLR
+		}
+		brx, bry := x2, y2
+		if !hasRight {
+			brx = x3
+		} else if innerBRR > 0 {
+			brx = x2 - innerBRR
+			bry = y2 + innerBRR
+		}
+		sides = append(sides, borderSide{
+			style: hv.BorderBottomStyle, color: hv.BorderBottomColor, width: hv.BorderBottomWidth, alpha: alpha(2),
+			poly: [8]bag.ScaledPoint{x0, y3, x3, y3, brx, bry, blx, bly},
+		})
+	}
+	if hasRight {
+		rtx, rty := x2, y1
+		if !hasTop {
+			rty = y0
+		} else if innerTRR > 0 {
+			rtx = x2 - innerTRR
+			rty = y1 - innerTRR
+		}
+		rbx, rby := x2, y2
+		if !hasBottom {
+			rby = y3
+		} else if innerBRR > 0 {
+			rbx = x2 - innerBRR
+			rby = y2 + innerBRR
+		}
+		sides = append(sides, borderSide{
+			style: hv.BorderRightStyle, color: hv.BorderRightColor, width: hv.BorderRightWidth, alpha: alpha(1),
+			poly: [8]bag.ScaledPoint{rbx, rby, x3, y3, x3, y0, rtx, rty},
+		})
+	}
+
+	var styled string
+	for _, bs := range sides {
+		if bs.color.Space == color.ColorNone {
+			continue
+		}
+		if t := hv.transparency; t != nil && !t.state(bs.alpha).opaque() {
+			// A translucent side is drawn on its own, in the graphics
+			// state of its alpha and the opacity of the box.
+			var pre string
+			if isPrivateBorderStyle(bs.style) {
+				pre = styledBorderSide(bs, x0, y0, x3, y3, hv)
+			} else {
+				pre = bs.trapezoid(pdfdraw.New().ColorNonstroking(*bs.color)).Fill().String()
+			}
+			styled += " " + cb.withState(pre, hv.transparency.state(bs.alpha))
+			continue
+		}
+		if isPrivateBorderStyle(bs.style) {
+			styled += " " + styledBorderSide(bs, x0, y0, x3, y3, hv)
+			continue
+		}
+		p := bs.poly
+		inner.ColorNonstroking(*bs.color).Moveto(p[0], p[1]).Lineto(p[2], p[3]).Lineto(p[4], p[5]).Lineto(p[6], p[7]).Close().Fill()
+	}
+	return "q " + outer.String() + " " + inner.String() + styled + " Q"
+}
@@ -60,12 +60,34 @@ func (cb *CSSBuilder) buildTable(te *frontend.Text, wd bag.ScaledPoint) (*node.V
 	// Push a fresh insert-collection scope; restore on exit so nested
 	// tables don't leak their inserts into the enclosing table.
 	savedInserts := cb.tableInserts
+	savedRowInserts := cb.tableRowInserts
 	savedWidth := cb.tableInsertWidth
+	savedCellBorders := cb.tableCellBorders
+	savedCellBackgrounds := cb.tableCellBackgrounds
+	savedCellTransforms := cb.tableCellTransforms
+	savedCellTransparencies := cb.tableCellTransparencies
+	savedCellDiagonals := cb.tableCellDiagonals
+	savedCellModels := cb.tableCellModels
 	cb.tableInserts = nil
+	cb.tableRowInserts = map[*frontend.TableRow][]*Insert{}
 	cb.tableInsertWidth = tbl.MaxWidth
+	cb.tableCellBorders = map[*frontend.TableCell]HTMLValues{}
+	cb.tableCellBackgrounds = map[*frontend.TableCell]HTMLValues{}
+	cb.tableCellTransforms = map[*frontend.TableCell]*boxTransform{}
+	cb.tableCellTransparencies = map[*frontend.TableCell]*boxTransparency{}
+	cb.tableCellDiagonals = map[*frontend.TableCell]*cellDiagonals{}
+	tb, _ := te.Settings[settingTableBorders].(*tableBorders)
+	cb.tableCellModels = cellBorderModels(te, tb)
 	defer func() {
 		cb.tableInserts = savedInserts
+		cb.tableRowInserts = savedRowInserts
 		cb.tableInsertWidth = savedWidth
+		cb.tableCellBorders = savedCellBorders
+		cb.tableCellBackgrounds = savedCellBackgrounds
+		cb.tableCellTransforms = savedCellTransforms
+		cb.tableCellTransparencies = savedCellTransparencies
+		cb.tableCellDiagonals = savedCellDiagonals
+		cb.tableCellModels = savedCellModels
 	}()
 
 	// Process colgroup for column specifications
@@ -129,6 +151,9 @@ func (cb *CSSBuilder) buildTable(te *frontend.Text, wd bag.ScaledPoint) (*node.V
 			}
 		}
 	}
+	if err := cb.layoutTableColumns(te, tbl); err != nil {
+		return nil, err
+	}
 	// Collect source tr Texts in the same order BuildTable will emit row
 	// HLists (thead first, then tbody). Capture the page-break-inside
 	// sentinel off each tr's Settings before BuildTable runs, and delete
@@ -225,30 +250,60 @@ func (cb *CSSBuilder) buildTable(te *frontend.Text, wd bag.ScaledPoint) (*node.V
 		}
 	}
 
+	if len(cb.tableCellBorders) > 0 || len(cb.tableCellBackgrounds) > 0 || len(cb.tableCellTransforms) > 0 || len(cb.tableCellTransparencies) > 0 || len(cb.tableCellDiagonals) > 0 {
+		cb.drawCellDecorations(vl, tbl)
+		cb.decorateRepeatedRows(vl, tbl)
+	}
+
 	// Attach all inserts collected from this table's cells. The page
 	// builder will reserve space at the bottom of the page where the
-	// table lands. Multi-page tables (outputTableRows path) are a known
-	// limitation: all inserts attach to the first row's VList.
+	// table lands. A table broken across pages queues them row by row
+	// (markRowInserts).
 	if len(cb.tableInserts) > 0 {
 		if vl.Attributes == nil {
 			vl.Attributes = node.H{}
 		}
 		vl.Attributes["inserts"] = cb.tableInserts
+		cb.markRowInserts(vl, tbl)
+	}
+
+	// The caption is as wide as the table. The callers place it with
+	// withCaption; a continued caption is repeated by outputTableRows,
+	// so a table with one takes that path even without header rows. So
+	// does a table with rows generated at its page breaks and one whose
+	// rows need the row pager.
+	caption, err := cb.buildCaption(te, vl.Width)
+	if err != nil {
+		return nil, err
+	}
+	if vl.Attributes == nil {
+		vl.Attributes = node.H{}
+	}
+	if caption != nil {
+		vl.Attributes["_caption"] = caption
+	}
+	// Banding that restarts on every page needs outputTableRows as well.
+	rb, _ := te.Settings[settingRowBanding].(*rowBanding)
+	if rb != nil {
+		cb.bandTableRows(vl, te, tbl, rb)
+	}
+	_, hasRunningSum := te.Settings[settingRunningSum]
+	if _, ok := vl.Attributes["_buildHeaders"]; !ok && (caption != nil && caption.buildContinued != nil || hasRunningSum || rb != nil && rb.restart || cb.TableBreakCallback != nil || cb.needsRowPager(te, vl)) {
+		vl.Attributes["_buildHeaders"] = func() ([]*node.HList, error) { return nil, nil }
+		vl.Attributes["_headerCount"] = 0
 	}
 
 	// PDF/UA: tag the table structure.
-	// Repeated headers on continuation pages are left untagged
-	// (the backend will wrap them as artifacts in PDF/UA mode).
+	// Repeated headers and continued captions on continuation pages are
+	// left untagged (the backend will wrap them as artifacts in PDF/UA
+	// mode).
 	if cb.enableTagging {
-		cb.tagTable(vl, tbl)
+		cb.tagTable(vl, tbl, caption)
 	}
 
 	// Source Text and its formatting width: lets outputTableRows rebuild
 	// the remaining rows when an automatic page break switches to a page
 	// with a different content width.
-	if vl.Attributes == nil {
-		vl.Attributes = node.H{}
-	}
 	vl.Attributes["_tableTe"] = te
 	vl.Attributes["_tableTeWidth"] = wd
 
@@ -294,6 +349,16 @@ func (cb *CSSBuilder) buildTBody(te *frontend.Text, tbl *frontend.Table) {
 
 func (cb *CSSBuilder) buildTR(te *frontend.Text, tbl *frontend.Table) {
 	tr := &frontend.TableRow{}
+	// The background images of a row are painted by its cells, each cell
+	// getting its own copy of the layers (a gradient starts over in every
+	// cell), unless the cell has a background of its own.
+	rowBackground, hasRowBackground := te.Settings[settingBackground]
+	// A translucent background color of the row is not inherited (see
+	// mixTranslucentColors), the cells without one of their own paint it
+	// in the state of the row.
+	rowBT, _ := te.Settings[settingTransparency].(*boxTransparency)
+	rowColor, _ := te.Settings[frontend.SettingBackgroundColor].(*color.Color)
+	start := len(cb.tableInserts)
 	for _, itm := range te.Items {
 		switch t := itm.(type) {
 		case *frontend.Text:
@@ -302,13 +367,42 @@ func (cb *CSSBuilder) buildTR(te *frontend.Text, tbl *frontend.Table) {
 				continue
 			}
 			if elt == "td" || elt == "th" {
+				if _, ok := t.Settings[settingBackground]; hasRowBackground && !ok {
+					t.Settings[settingBackground] = rowBackground
+				}
+				if c, _ := t.Settings[frontend.SettingBackgroundColor].(*color.Color); c == nil && rowColor != nil && rowBT != nil && rowBT.background < 1 {
+					t.Settings[frontend.SettingBackgroundColor] = rowColor
+					t.Settings[settingTransparency] = rowBackgroundTransparency(rowBT, t.Settings)
+				}
 				cb.buildTD(t, tr, elt == "th", tbl.MaxWidth)
 			}
 		}
 	}
+	if len(cb.tableInserts) > start && cb.tableRowInserts != nil {
+		cb.tableRowInserts[tr] = cb.tableInserts[start:len(cb.tableInserts):len(cb.tableInserts)]
+	}
 	tbl.Rows = append(tbl.Rows, tr)
 }
 
+// rowBackgroundTransparency returns the transparency of a cell that paints
+// the translucent background of its row: the cell's own one (or an opaque
+// one inside the row's group) with the background alpha of the row.
+func rowBackgroundTransparency(rowBT *boxTransparency, cell frontend.TypesettingSettings) *boxTransparency {
+	bt := boxTransparency{
+		opacity:      1,
+		text:         1,
+		border:       [4]float64{1, 1, 1, 1},
+		ambient:      rowBT.ambient * rowBT.opacity,
+		ambientBlend: rowBT.groupState().blend,
+		reopen:       true,
+	}
+	if own, ok := cell[settingTransparency].(*boxTransparency); ok {
+		bt = *own
+	}
+	bt.background = rowBT.background
+	return &bt
+}
+
 // buildTD converts a <td>/<th> Text into a TableCell. tableWidth is the
 // table's maximum width and resolves a percentage `width` on the cell.
 func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeader bool, tableWidth bag.ScaledPoint) {
@@ -317,6 +411,12 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 
 	// Extract colspan and rowspan
 	settings := te.Settings
+	// The collapsing border model replaces the cell's borders by the
+	// resolved ones.
+	model := cb.tableCellModels[te]
+	if model != nil && model.collapse {
+		settings = model.settings(settings)
+	}
 
 	// CSS `width` on the cell. The table layout treats it as a lower
 	// bound for the column (CSS 2.1 §17.5.2.2), so `width: 50%` on both
@@ -391,6 +491,149 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 		td.BackgroundColor = v.(*color.Color)
 	}
 
+	// The frontend draws every cell border solid and opaque. A side with
+	// another border style (dashed, double, ...) or a translucent color is
+	// drawn by drawCellDecorations instead: the frontend sees no border on
+	// that side but the border width as extra padding, so the cell keeps
+	// its size.
+	bt, _ := settings[settingTransparency].(*boxTransparency)
+	borderBT := cellBorderTransparency(bt, model)
+	decorated := func(k frontend.SettingType, side int) (frontend.BorderStyle, bool) {
+		sty, _ := settings[k].(frontend.BorderStyle)
+		if isPrivateBorderStyle(sty) {
+			return sty, true
+		}
+		return frontend.BorderStyleSolid, borderBT != nil && borderBT.border[side] < 1
+	}
+	styled := HTMLValues{transparency: borderBT}
+	if sty, ok := decorated(frontend.SettingBorderTopStyle, sideTop); ok && td.BorderTopWidth > 0 {
+		styled.BorderTopStyle, styled.BorderTopWidth, styled.BorderTopColor = sty, td.BorderTopWidth, td.BorderTopColor
+		td.PaddingTop += td.BorderTopWidth
+		td.BorderTopWidth = 0
+	}
+	if sty, ok := decorated(frontend.SettingBorderRightStyle, sideRight); ok && td.BorderRightWidth > 0 {
+		styled.BorderRightStyle, styled.BorderRightWidth, styled.BorderRightColor = sty, td.BorderRightWidth, td.BorderRightColor
+		td.PaddingRight += td.BorderRightWidth
+		td.BorderRightWidth = 0
+	}
+	if sty, ok := decorated(frontend.SettingBorderBottomStyle, sideBottom); ok && td.BorderBottomWidth > 0 {
+		styled.BorderBottomStyle, styled.BorderBottomWidth, styled.BorderBottomColor = sty, td.BorderBottomWidth, td.BorderBottomColor
+		td.PaddingBottom += td.BorderBottomWidth
+		td.BorderBottomWidth = 0
+	}
+	if sty, ok := decorated(frontend.SettingBorderLeftStyle, sideLeft); ok && td.BorderLeftWidth > 0 {
+		styled.BorderLeftStyle, styled.BorderLeftWidth, styled.BorderLeftColor = sty, td.BorderLeftWidth, td.BorderLeftColor
+		td.PaddingLeft += td.BorderLeftWidth
+		td.BorderLeftWidth = 0
+	}
+	if styled.hasBorder() && cb.tableCellBorders != nil {
+		cb.tableCellBorders[td] = styled
+	}
+
+	// Background images are drawn by drawCellDecorations as well. The
+	// cell's background color moves along, so that it stays beneath the
+	// images instead of being painted over them by the frontend. So does
+	// a background color that needs a graphics state of its own.
+	bb, hasImages := settings[settingBackground].(*boxBackground)
+	if (hasImages || bt != nil && td.BackgroundColor != nil) && cb.tableCellBackgrounds != nil {
+		cb.tableCellBackgrounds[td] = HTMLValues{
+			background:        bb,
+			transparency:      bt,
+			BackgroundColor:   td.BackgroundColor,
+			BorderTopWidth:    td.BorderTopWidth + styled.BorderTopWidth,
+			BorderRightWidth:  td.BorderRightWidth + styled.BorderRightWidth,
+			BorderBottomWidth: td.BorderBottomWidth + styled.BorderBottomWidth,
+			BorderLeftWidth:   td.BorderLeftWidth + styled.BorderLeftWidth,
+			PaddingTop:        td.PaddingTop - styled.BorderTopWidth,
+			PaddingRight:      td.PaddingRight - styled.BorderRightWidth,
+			PaddingBottom:     td.PaddingBottom - styled.BorderBottomWidth,
+			PaddingLeft:       td.PaddingLeft - styled.BorderLeftWidth,
+		}
+		td.BackgroundColor = nil
+	}
+
+	// border-spacing (separated borders model, CSS 2.1 §17.6.1): the
+	// spacing lies between the border boxes of the cells and shows the
+	// table background. The frontend sees it as padding; the border and
+	// the background of the cell move to drawCellDecorations, which paints
+	// them inset by the spacing.
+	if model != nil && !model.collapse && cb.tableCellBorders != nil {
+		sp := model.spacing
+		if td.BorderTopWidth > 0 {
+			styled.BorderTopStyle, styled.BorderTopWidth, styled.BorderTopColor = frontend.BorderStyleSolid, td.BorderTopWidth, td.BorderTopColor
+			td.PaddingTop += td.BorderTopWidth
+			td.BorderTopWidth = 0
+		}
+		if td.BorderRightWidth > 0 {
+			styled.BorderRightStyle, styled.BorderRightWidth, styled.BorderRightColor = frontend.BorderStyleSolid, td.BorderRightWidth, td.BorderRightColor
+			td.PaddingRight += td.BorderRightWidth
+			td.BorderRightWidth = 0
+		}
+		if td.BorderBottomWidth > 0 {
+			styled.BorderBottomStyle, styled.BorderBottomWidth, styled.BorderBottomColor = frontend.BorderStyleSolid, td.BorderBottomWidth, td.BorderBottomColor
+			td.PaddingBottom += td.BorderBottomWidth
+			td.BorderBottomWidth = 0
+		}
+		if td.BorderLeftWidth > 0 {
+			styled.BorderLeftStyle, styled.BorderLeftWidth, styled.BorderLeftColor = frontend.BorderStyleSolid, td.BorderLeftWidth, td.BorderLeftColor
+			td.PaddingLeft += td.BorderLeftWidth
+			td.BorderLeftWidth = 0
+		}
+		bg, hasBg := cb.tableCellBackgrounds[td]
+		if !hasBg && td.BackgroundColor != nil {
+			bg = HTMLValues{
+				transparency:      bt,
+				BackgroundColor:   td.BackgroundColor,
+				BorderTopWidth:    styled.BorderTopWidth,
+				BorderRightWidth:  styled.BorderRightWidth,
+				BorderBottomWidth: styled.BorderBottomWidth,
+				BorderLeftWidth:   styled.BorderLeftWidth,
+				PaddingTop:        td.PaddingTop - styled.BorderTopWidth,
+				PaddingRight:      td.PaddingRight - styled.BorderRightWidth,
+				PaddingBottom:     td.PaddingBottom - styled.BorderBottomWidth,
+				PaddingLeft:       td.PaddingLeft - styled.BorderLeftWidth,
+			}
+			hasBg = true
+			td.BackgroundColor = nil
+		}
+		if hasBg && cb.tableCellBackgrounds != nil {
+			bg.MarginTop, bg.MarginRight, bg.MarginBottom, bg.MarginLeft = sp[sideTop], sp[sideRight], sp[sideBottom], sp[sideLeft]
+			cb.tableCellBackgrounds[td] = bg
+		}
+		styled.MarginTop, styled.MarginRight, styled.MarginBottom, styled.MarginLeft = sp[sideTop], sp[sideRight], sp[sideBottom], sp[sideLeft]
+		if styled.hasBorder() {
+			cb.tableCellBorders[td] = styled
+		}
+		td.PaddingTop += sp[sideTop]
+		td.PaddingRight += sp[sideRight]
+		td.PaddingBottom += sp[sideBottom]
+		td.PaddingLeft += sp[sideLeft]
+	}
+
+	// A transformed cell (e.g. a rotated th) keeps its place in the grid;
+	// drawCellDecorations paints its box through the transform. Like
+	// everything the table code draws itself, the frontend's own borders
+	// and background color stay untransformed.
+	if tf, ok := settings[settingTransform].(*boxTransform); ok && cb.tableCellTransforms != nil {
+		cb.tableCellTransforms[td] = tf
+	}
+
+	// opacity and mix-blend-mode: drawCellDecorations selects the state of
+	// the cell around its box, contents and decorations.
+	if bt.group() && cb.tableCellTransparencies != nil {
+		cb.tableCellTransparencies[td] = bt
+	}
+
+	// The diagonals run between the corners of the border box, inside the
+	// border spacing of the separated model.
+	if cd, ok := settings[settingCellDiagonals].(*cellDiagonals); ok && cb.tableCellDiagonals != nil {
+		cd := *cd
+		if model != nil && !model.collapse {
+			cd.inset = model.spacing
+		}
+		cb.tableCellDiagonals[td] = &cd
+	}
+
 	// If this cell references a pre-rendered VList, use it directly as content.
 	if vlid, ok := settings[frontend.SettingPrerenderedVListID].(string); ok {
 		if vl, vlOK := cb.PendingVLists[vlid]; vlOK {
@@ -402,6 +645,10 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 
 	for _, itm := range te.Items {
 		switch t := itm.(type) {
+		case positionedMarker:
+			// An absolutely positioned child of the cell: goes to the
+			// page with the table like the cell's footnotes.
+			cb.tableInserts = append(cb.tableInserts, &Insert{Class: InsertPositioned, Positioned: t.pi})
 		case *frontend.Text:
 			// Pull any insertMarkers out of this cell's text tree before
 			// it reaches FormatParagraph / BuildTable. Footnote markers
@@ -421,9 +668,12 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 			if err == nil && len(bottomFls) > 0 {
 				cb.tableInserts = append(cb.tableInserts, bottomFls...)
 			}
+			cb.tableInserts = append(cb.tableInserts, extractPositionedMarkers(t)...)
 			// For box elements (ul, ol, div, etc.), create a FormatToVList function
-			// that uses CreateVlist - this ensures the same code path as outside tables
-			if isBox, ok := t.Settings[frontend.SettingBox]; ok && isBox.(bool) {
+			// that uses CreateVlist - this ensures the same code path as outside tables.
+			// Paragraphs carrying a buildVlistInternal-only sentinel (line
+			// clamp, shadows, ...) take the same path.
+			if isBox, ok := t.Settings[frontend.SettingBox]; ok && isBox.(bool) || hasBlockOnlySettings(t.Settings) {
 				textCopy := t
 				ftv := func(wd bag.ScaledPoint) (*node.VList, error) {
 					vl, err := cb.CreateVlist(textCopy, wd)
@@ -464,7 +714,23 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 				td.Contents = append(td.Contents, frontend.FormatToVList(func(wd bag.ScaledPoint) (*node.VList, error) {
 					resolveDeferredSizing(tCaptured.Items, wd)
 					vl, _, err := cb.frontend.FormatParagraph(tCaptured, wd)
-					return vl, err
+					if err != nil {
+						return nil, err
+					}
+					cb.translucentLines(vl, bt)
+					return vl, nil
+				}))
+			} else if bt != nil && bt.text < 1 {
+				// A translucent text color: the lines of the cell get
+				// its alpha, see translucentLines.
+				tCaptured := t
+				td.Contents = append(td.Contents, frontend.FormatToVList(func(wd bag.ScaledPoint) (*node.VList, error) {
+					vl, _, err := cb.frontend.FormatParagraph(tCaptured, wd)
+					if err != nil {
+						return nil, err
+					}
+					cb.translucentLines(vl, bt)
+					return vl, nil
 				}))
 			} else {
 				td.Contents = append(td.Contents, itm)
@@ -498,14 +764,203 @@ func (cb *CSSBuilder) buildTD(te *frontend.Text, row *frontend.TableRow, isHeade
 			}
 		}
 	}
+	// max-lines / max-height with overflow: hidden on the cell (settingLineClamp):
+	// the clamp counts lines across all of the cell's contents, so format
+	// them here at the final cell width, stack them and clamp the stack.
+	if lc, ok := settings[settingLineClamp].(lineClamp); ok && len(td.Contents) > 0 {
+		contents := td.Contents
+		td.Contents = nil
+		td.Contents = append(td.Contents, frontend.FormatToVList(func(wd bag.ScaledPoint) (*node.VList, error) {
+			var head node.Node
+			for _, c := range contents {
+				vl, err := cb.formatCellContent(c, wd)
+				if err != nil {
+					return nil, err
+				}
+				if vl != nil {
+					head = node.InsertAfter(head, node.Tail(head), vl)
+				}
+			}
+			vl := node.Vpack(head)
+			if _, err := cb.applyLineClamp(vl, lc, settings); err != nil {
+				return nil, err
+			}
+			return vl, nil
+		}))
+	}
+	// A vertical writing-mode turns the contents of the cell, whatever
+	// width the column gives it.
+	if wm, ok := settings[settingCellWritingMode].(string); ok && len(td.Contents) > 0 {
+		contents := td.Contents
+		td.Contents = nil
+		td.Contents = append(td.Contents, frontend.FormatToVList(func(_ bag.ScaledPoint) (*node.VList, error) {
+			return cb.rotatedCellContent(contents, wm == "sideways-lr")
+		}))
+	}
 	row.Cells = append(row.Cells, td)
 }
 
-// tagTable walks the table VList and creates Table/TR/TH/TD structure elements.
-func (cb *CSSBuilder) tagTable(tableVL *node.VList, tbl *frontend.Table) {
+// cellDecorations are the borders, backgrounds, transforms, transparencies
+// and diagonals buildTD records for the cells of a table, drawn by
+// decorateRows.
+type cellDecorations struct {
+	borders        map[*frontend.TableCell]HTMLValues
+	backgrounds    map[*frontend.TableCell]HTMLValues
+	transforms     map[*frontend.TableCell]*boxTransform
+	transparencies map[*frontend.TableCell]*boxTransparency
+	diagonals      map[*frontend.TableCell]*cellDiagonals
+}
+
+// currentCellDecorations returns the decorations of the table being built.
+func (cb *CSSBuilder) currentCellDecorations() cellDecorations {
+	return cellDecorations{
+		borders:        cb.tableCellBorders,
+		backgrounds:    cb.tableCellBackgrounds,
+		transforms:     cb.tableCellTransforms,
+		transparencies: cb.tableCellTransparencies,
+		diagonals:      cb.tableCellDiagonals,
+	}
+}
+
+// drawCellDecorations adds the borders and backgrounds recorded by buildTD
+// to the cell boxes of the built table. Like tagTable it walks the row
+// HLists and their cell VLists in step with tbl.Rows.
+func (cb *CSSBuilder) drawCellDecorations(tableVL *node.VList, tbl *frontend.Table) {
+	var rowHLs []*node.HList
+	for cur := tableVL.List; cur != nil; cur = cur.Next() {
+		if hl, ok := cur.(*node.HList); ok {
+			rowHLs = append(rowHLs, hl)
+		}
+	}
+	cb.decorateRows(rowHLs, tbl.Rows, cb.currentCellDecorations())
+}
+
+// decorateRepeatedRows gives the header and footer rows the frontend
+// builds again for every continuation page (_buildHeaders, _buildFooters)
+// the decorations of the rows they repeat. The closures run after
+// buildTable has returned, so they keep the decorations of this table.
+func (cb *CSSBuilder) decorateRepeatedRows(vl *node.VList, tbl *frontend.Table) {
+	deco := cb.currentCellDecorations()
+	wrap := func(key string, rows []*frontend.TableRow) {
+		build, ok := vl.Attributes[key].(func() ([]*node.HList, error))
+		if !ok || len(rows) == 0 {
+			return
+		}
+		vl.Attributes[key] = func() ([]*node.HList, error) {
+			hls, err := build()
+			if err != nil {
+				return nil, err
+			}
+			cb.decorateRows(hls, rows, deco)
+			return hls, nil
+		}
+	}
+	wrap("_buildHeaders", tbl.Rows[:tbl.HeaderRows])
+	wrap("_buildFooters", tbl.Rows[len(tbl.Rows)-tbl.FooterRows:])
+}
+
+// decorateRows draws the decorations d on the row HLists rowHLs, built
+// from rows. Each cell gets hidden nodes at the top left corner of its
+// box: the background color and images first, then the rule that draws
+// the border around the whole cell and the diagonals. A translucent cell
+// is then enclosed in the graphics state of its opacity and a transformed
+// one in the nodes of its transform, so the decorations and the contents
+// fade and turn together.
+func (cb *CSSBuilder) decorateRows(rowHLs []*node.HList, rows []*frontend.TableRow, d cellDecorations) {
+	for rowIdx, rowHL := range rowHLs {
+		if rowIdx >= len(rows) {
+			break
+		}
+		row := rows[rowIdx]
+		cellIdx := 0
+		for cellCur := rowHL.List; cellCur != nil && cellIdx < len(row.Cells); cellCur = cellCur.Next() {
+			cellVL, ok := cellCur.(*node.VList)
+			if !ok {
+				continue
+			}
+			wd, ht := cellVL.Width, cellVL.Height+cellVL.Depth
+			var decorations []node.Node
+			// The margins are the border-spacing around the border box
+			// of the cell.
+			if hv, ok := d.backgrounds[row.Cells[cellIdx]]; ok {
+				x0, y0, x1, y1 := hv.MarginLeft, -hv.MarginTop, wd-hv.MarginRight, -ht+hv.MarginBottom
+				if hv.BackgroundColor != nil && hv.BackgroundColor.Space != color.ColorNone {
+					r := backgroundColorRule(hv, x0, y0, x1, y1)
+					if t := hv.transparency; t != nil {
+						r.Pre = cb.withState(r.Pre, t.state(t.background))
+					}
+					decorations = append(decorations, r)
+				}
+				if hv.background != nil {
+					decorations = append(decorations, cb.backgroundNodes(hv, x0, y0, x1, y1)...)
+				}
+			}
+			if hv, ok := d.borders[row.Cells[cellIdx]]; ok {
+				x0, y0, x1, y1 := hv.MarginLeft, -hv.MarginTop, wd-hv.MarginRight, -ht+hv.MarginBottom
+				r := node.NewRule()
+				r.Hide = true
+				r.Attributes = node.H{"origin": "table cell border"}
+				r.Pre = cb.borderRulePre(x0, y0, x0+hv.BorderLeftWidth, y0-hv.BorderTopWidth,
+					x1-hv.BorderRightWidth, y1+hv.BorderBottomWidth, x1, y1, hv)
+				decorations = append(decorations, r)
+			}
+			if cd, ok := d.diagonals[row.Cells[cellIdx]]; ok {
+				decorations = append(decorations, diagonalRule(cd, wd, ht))
+			}
+			first := cellVL.List
+			for _, n := range decorations {
+				if first == nil {
+					cellVL.List = node.InsertAfter(cellVL.List, node.Tail(cellVL.List), n)
+				} else {
+					cellVL.List = node.InsertBefore(cellVL.List, first, n)
+				}
+			}
+			if bt, ok := d.transparencies[row.Cells[cellIdx]]; ok {
+				start, stop := cb.stateNodes(bt.groupState())
+				cellVL.List = node.InsertBefore(cellVL.List, cellVL.List, start)
+				cellVL.List = node.InsertAfter(cellVL.List, node.Tail(cellVL.List), stop)
+			}
+			if tf, ok := d.transforms[row.Cells[cellIdx]]; ok {
+				if save, r, restore := cb.transformNodes(tf, wd, ht); save != nil {
+					cellVL.List = node.InsertBefore(cellVL.List, cellVL.List, r)
+					cellVL.List = node.InsertBefore(cellVL.List, cellVL.List, save)
+					cellVL.List = node.InsertAfter(cellVL.List, node.Tail(cellVL.List), restore)
+				}
+			}
+			cellIdx++
+		}
+	}
+}
+
+// formatCellContent formats one of the contents buildTD collected for a
+// cell at the width wd of the cell's content box. Other contents give nil.
+func (cb *CSSBuilder) formatCellContent(c any, wd bag.ScaledPoint) (*node.VList, error) {
+	switch t := c.(type) {
+	case frontend.FormatToVList:
+		return t(wd)
+	case *frontend.Text:
+		vl, _, err := cb.frontend.FormatParagraph(t, wd)
+		return vl, err
+	}
+	return nil, nil
+}
+
+// tagTable walks the table VList and creates Table/TR/TH/TD structure
+// elements. The caption, if any, becomes the first or the last child of
+// the Table, on the side it is painted (PDF 1.7 §14.8.4.3.4).
+func (cb *CSSBuilder) tagTable(tableVL *node.VList, tbl *frontend.Table, caption *tableCaption) {
 	format := cb.frontend.Doc.Format
 	tableSE := newSE("Table", format)
 	cb.structureCurrent.AddChild(tableSE)
+	var captionSE *document.StructureElement
+	if caption != nil {
+		captionSE = newSE("Caption", format)
+		captionSE.ActualText = caption.text
+		tagVList(caption.vl, captionSE)
+		if !caption.bottom {
+			tableSE.AddChild(captionSE)
+		}
+	}
 
 	// Create THead/TBody/TFoot grouping SEs. PDF/UA-1 §7.5 maps these
 	// directly to the HTML element names; TFoot is added in source
@@ -580,6 +1035,9 @@ func (cb *CSSBuilder) tagTable(tableVL *node.VList, tbl *frontend.Table) {
 		}
 		rowIdx++
 	}
+	if caption != nil && caption.bottom {
+		tableSE.AddChild(captionSE)
+	}
 }
 
 // extractCellText extracts text content from a table cell's contents.
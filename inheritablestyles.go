@@ -60,6 +60,154 @@ const settingBookmark frontend.SettingType = -2
 // something a typesetting engine should do by default).
 const settingCSSHeight frontend.SettingType = -3
 
+// settingLineClamp is an htmlbag-private frontend.SettingType sentinel that
+// carries a lineClamp (max-lines / -webkit-line-clamp, max-height with
+// overflow: hidden, text-overflow: ellipsis) from Output() to
+// buildVlistInternal, which truncates the finished VList. Like
+// settingCSSHeight it is stripped before frontend.FormatParagraph and
+// restored afterwards.
+const settingLineClamp frontend.SettingType = -4
+
+// settingFitText is an htmlbag-private frontend.SettingType sentinel that
+// carries a fitText (-bag-fit-text copy-fitting) from Output() to the leaf
+// branch of buildVlistInternal, stripped and restored around
+// frontend.FormatParagraph like the other sentinels.
+const settingFitText frontend.SettingType = -5
+
+// settingClip is an htmlbag-private frontend.SettingType sentinel that
+// carries a boxClip (overflow: hidden, clip-path) from Output() to
+// buildVlistInternal, which wraps the finished box in a PDF clip. Stripped
+// and restored around frontend.FormatParagraph like the other sentinels.
+const settingClip frontend.SettingType = -6
+
+// settingBoxShadow is an htmlbag-private frontend.SettingType sentinel that
+// carries the parsed box-shadow list ([]shadow) from Output() to
+// settingsToHTMLValues, so HTMLBorder draws the shadows with the border.
+const settingBoxShadow frontend.SettingType = -7
+
+// settingTextShadow is an htmlbag-private frontend.SettingType sentinel that
+// carries the parsed text-shadow list ([]shadow) of a block from Output() to
+// the leaf branch of buildVlistInternal, which typesets the shadow copies.
+const settingTextShadow frontend.SettingType = -8
+
+// settingBackground is an htmlbag-private frontend.SettingType sentinel that
+// carries the background layers (*boxBackground) of a box from Output() to
+// settingsToHTMLValues, so HTMLBorder paints them with the background color.
+const settingBackground frontend.SettingType = -9
+
+// settingTransparency is an htmlbag-private frontend.SettingType sentinel
+// that carries the opacity, blend mode and color alphas of a block
+// (*boxTransparency) from Output() to buildVlistInternal and HTMLBorder.
+const settingTransparency frontend.SettingType = -10
+
+// settingTransform is an htmlbag-private frontend.SettingType sentinel that
+// carries the CSS transform of a box (*boxTransform) from Output() to
+// buildVlistInternal and buildTD, which paint the finished box through it.
+const settingTransform frontend.SettingType = -11
+
+// settingPositionedBox is an htmlbag-private frontend.SettingType sentinel
+// that carries the vertical offset and the absolutely positioned
+// descendants of a position: relative block (*positionedBox) from Output()
+// to buildVlistInternal.
+const settingPositionedBox frontend.SettingType = -12
+
+// settingFlex is an htmlbag-private frontend.SettingType sentinel that
+// carries the flex container properties and its items (*flexContainer) from
+// Output() to buildVlistInternal, which lays the children out as flex items.
+const settingFlex frontend.SettingType = -13
+
+// settingGrid is an htmlbag-private frontend.SettingType sentinel that
+// carries the grid container properties and the placement of its items
+// (*gridContainer) from Output() to buildVlistInternal.
+const settingGrid frontend.SettingType = -14
+
+// settingCaption is an htmlbag-private frontend.SettingType sentinel that
+// carries caption-side and -bag-caption-continued (*captionStyle) of a
+// <caption> from Output() to buildTable, which strips it before building
+// the caption.
+const settingCaption frontend.SettingType = -15
+
+// settingTableBorders is an htmlbag-private frontend.SettingType sentinel
+// that carries the border model of a <table> (*tableBorders) from Output()
+// to buildTable. The table Text never reaches FormatParagraph.
+const settingTableBorders frontend.SettingType = -16
+
+// settingRunningSum is an htmlbag-private frontend.SettingType sentinel
+// that carries the -bag-running-sum of a <table> (*runningSum) from
+// Output() to outputTableRows. The table Text never reaches FormatParagraph.
+const settingRunningSum frontend.SettingType = -17
+
+// settingRowspanBreak is an htmlbag-private frontend.SettingType sentinel
+// that carries -bag-rowspan-break of a <table> from Output() to
+// outputTableRows. Only "auto" is stamped, avoid is the default.
+const settingRowspanBreak frontend.SettingType = -18
+
+// settingTableLayout is an htmlbag-private frontend.SettingType sentinel
+// that carries table-layout of a <table> from Output() to buildTable. Only
+// "fixed" is stamped, auto is the default.
+const settingTableLayout frontend.SettingType = -19
+
+// settingTableOverflow is an htmlbag-private frontend.SettingType sentinel
+// that carries -bag-table-overflow of a <table> (*tableOverflow) from
+// Output() to buildTableParts.
+const settingTableOverflow frontend.SettingType = -20
+
+// settingCellWritingMode is an htmlbag-private frontend.SettingType
+// sentinel that carries a vertical writing-mode of a <td>/<th> from
+// Output() to buildTD, which turns the cell contents.
+const settingCellWritingMode frontend.SettingType = -21
+
+// settingCellDiagonals is an htmlbag-private frontend.SettingType sentinel
+// that carries the -bag-border-diagonal-* lines of a <td>/<th>
+// (*cellDiagonals) from Output() to buildTD.
+const settingCellDiagonals frontend.SettingType = -22
+
+// settingRowBanding is an htmlbag-private frontend.SettingType sentinel
+// that carries -bag-row-banding of a <table> (*rowBanding) from Output() to
+// buildTable and outputTableRows.
+const settingRowBanding frontend.SettingType = -23
+
+// hasBlockOnlySettings reports whether settings carry one of the sentinels
+// only buildVlistInternal understands. A Text with such a sentinel must not
+// be handed to the frontend directly (e.g. as table cell content).
+func hasBlockOnlySettings(settings frontend.TypesettingSettings) bool {
+	for _, k := range []frontend.SettingType{settingLineClamp, settingFitText, settingClip, settingBoxShadow, settingTextShadow, settingBackground, settingTransparency, settingTransform, settingPositionedBox, settingFlex, settingGrid} {
+		if _, ok := settings[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// paragraphPrivateSettings are the sentinels a paragraph Text can carry
+// to the paragraph builder. None of them may reach the frontend.
+var paragraphPrivateSettings = []frontend.SettingType{settingPageBreakInside, settingBookmark, settingCSSHeight, settingLineClamp, settingFitText, settingClip, settingBoxShadow, settingTextShadow, settingBackground, settingTransparency, settingTransform, settingPositionedBox}
+
+// privateSettings are the sentinels stripPrivateSettings took off a Text.
+type privateSettings map[frontend.SettingType]any
+
+// stripPrivateSettings removes the paragraphPrivateSettings from settings
+// before a frontend call (FormatParagraph, FormatParagraphTail), whose
+// strict "unknown setting" default they would hit, and returns them.
+func stripPrivateSettings(settings frontend.TypesettingSettings) privateSettings {
+	ps := privateSettings{}
+	for _, k := range paragraphPrivateSettings {
+		if v, ok := settings[k]; ok {
+			ps[k] = v
+			delete(settings, k)
+		}
+	}
+	return ps
+}
+
+// restore puts the stripped sentinels back onto settings, so a rebuild at
+// another width sees the same input.
+func (ps privateSettings) restore(settings frontend.TypesettingSettings) {
+	for k, v := range ps {
+		settings[k] = v
+	}
+}
+
 // isCSSHeightExempt reports whether an element's CSS height is the business
 // of a dedicated layout path (table layout, replaced elements) rather than
 // the settingCSSHeight flow-space mechanism.
@@ -296,8 +444,76 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 			}
 		case "display":
 			ih.Hide = (v == "none")
+			ih.display = strings.ToLower(strings.TrimSpace(v))
+		case "flex-direction":
+			ih.flexDirection = strings.ToLower(strings.TrimSpace(v))
+		case "flex-wrap":
+			ih.flexWrap = strings.ToLower(strings.TrimSpace(v))
+		case "flex-flow":
+			// Shorthand, resolved by elementFlex: the longhands win.
+			ih.flexFlow = strings.ToLower(strings.TrimSpace(v))
+		case "justify-content":
+			ih.justifyContent = strings.ToLower(strings.TrimSpace(v))
+		case "align-items":
+			ih.alignItems = strings.ToLower(strings.TrimSpace(v))
+		case "align-self":
+			ih.alignSelf = strings.ToLower(strings.TrimSpace(v))
+		case "order":
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				ih.order = n
+			}
+		case "flex":
+			// Shorthand, resolved by elementFlexItem: the longhands win.
+			ih.flex = strings.ToLower(strings.TrimSpace(v))
+		case "flex-grow":
+			ih.flexGrow = strings.TrimSpace(v)
+		case "flex-shrink":
+			ih.flexShrink = strings.TrimSpace(v)
+		case "flex-basis":
+			ih.flexBasis = strings.ToLower(strings.TrimSpace(v))
+		case "gap", "grid-gap":
+			ih.gap = strings.ToLower(strings.TrimSpace(v))
+		case "row-gap", "grid-row-gap":
+			ih.rowGap = strings.ToLower(strings.TrimSpace(v))
+		case "column-gap", "grid-column-gap":
+			ih.columnGap = strings.ToLower(strings.TrimSpace(v))
+		case "justify-items":
+			ih.justifyItems = strings.ToLower(strings.TrimSpace(v))
+		case "justify-self":
+			ih.justifySelf = strings.ToLower(strings.TrimSpace(v))
+		case "grid-template-columns":
+			ih.gridTemplateColumns = strings.ToLower(strings.TrimSpace(v))
+		case "grid-template-rows":
+			ih.gridTemplateRows = strings.ToLower(strings.TrimSpace(v))
+		case "grid-template-areas":
+			// Area names are case-sensitive.
+			ih.gridTemplateAreas = strings.TrimSpace(v)
+		case "grid-auto-flow":
+			ih.gridAutoFlow = strings.ToLower(strings.TrimSpace(v))
+		case "grid-auto-rows":
+			ih.gridAutoRows = strings.ToLower(strings.TrimSpace(v))
+		case "grid-auto-columns":
+			ih.gridAutoColumns = strings.ToLower(strings.TrimSpace(v))
+		case "grid-row":
+			// Shorthand, resolved by elementGridItem: the longhands win.
+			ih.gridRow = strings.TrimSpace(v)
+		case "grid-column":
+			ih.gridColumn = strings.TrimSpace(v)
+		case "grid-area":
+			ih.gridArea = strings.TrimSpace(v)
+		case "grid-row-start":
+			ih.gridRowStart = strings.TrimSpace(v)
+		case "grid-row-end":
+			ih.gridRowEnd = strings.TrimSpace(v)
+		case "grid-column-start":
+			ih.gridColumnStart = strings.TrimSpace(v)
+		case "grid-column-end":
+			ih.gridColumnEnd = strings.TrimSpace(v)
 		case "background-color":
-			ih.BackgroundColor = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.BackgroundColor = df.GetColor(c)
+			ih.backgroundTransparency = 1 - alpha
+			ih.ownBackground = true
 		case "border-right-width", "border-left-width", "border-top-width", "border-bottom-width":
 			size := ParseRelativeSize(v, curFontSize, ih.DefaultFontSize)
 			switch k {
@@ -323,14 +539,9 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 				ih.BorderBottomRightRadius = size
 			}
 		case "border-right-style", "border-left-style", "border-top-style", "border-bottom-style":
-			var sty frontend.BorderStyle
-			switch v {
-			case "none":
-				// default
-			case "solid":
-				sty = frontend.BorderStyleSolid
-			default:
-				// logger.Error(fmt.Sprintf("not implemented: border style %q", v))
+			sty, ok := parseBorderStyle(v)
+			if !ok {
+				bag.Logger.Warn("unknown border style", "style", v)
 			}
 			switch k {
 			case "border-right-style":
@@ -344,17 +555,35 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 			}
 
 		case "border-right-color":
-			ih.BorderRightColor = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.BorderRightColor = df.GetColor(c)
+			ih.borderTransparency[1] = 1 - alpha
 		case "border-left-color":
-			ih.BorderLeftColor = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.BorderLeftColor = df.GetColor(c)
+			ih.borderTransparency[3] = 1 - alpha
 		case "border-top-color":
-			ih.BorderTopColor = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.BorderTopColor = df.GetColor(c)
+			ih.borderTransparency[0] = 1 - alpha
 		case "border-bottom-color":
-			ih.BorderBottomColor = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.BorderBottomColor = df.GetColor(c)
+			ih.borderTransparency[2] = 1 - alpha
 		case "border-spacing":
-			// ignore
+			ih.borderSpacing = strings.TrimSpace(v)
 		case "color":
-			ih.color = df.GetColor(v)
+			c, alpha := splitAlpha(v)
+			ih.color = df.GetColor(c)
+			ih.colorTransparency = 1 - alpha
+		case "opacity":
+			ih.opacity = strings.TrimSpace(v)
+		case "mix-blend-mode":
+			ih.mixBlendMode = strings.TrimSpace(v)
+		case "transform":
+			ih.transform = strings.TrimSpace(v)
+		case "transform-origin":
+			ih.transformOrigin = strings.TrimSpace(v)
 		case "content":
 			// Check for leader() function: leader('.') or leader(".")
 			if strings.HasPrefix(v, "leader(") && strings.HasSuffix(v, ")") {
@@ -462,6 +691,53 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 			ih.leftOffset = parseOffsetValue(v, curFontSize, ih.DefaultFontSize)
 		case "z-index":
 			ih.zIndex = parseZIndexValue(v)
+		case "caption-side":
+			ih.captionSide = strings.ToLower(strings.TrimSpace(v))
+		case "-bag-caption-continued":
+			// boxesandglue-specific: `none | <string>`, the text appended to
+			// the caption of a table on the pages the table continues onto.
+			if v = strings.TrimSpace(v); v == "none" {
+				ih.captionContinued = ""
+			} else {
+				ih.captionContinued = strings.Trim(v, "'\"")
+			}
+		case "-bag-running-sum":
+			// boxesandglue-specific: `none | column <integer> [<string>
+			// [<string>]]`, the column of a table summed up in the
+			// carried and brought forward rows at its page breaks.
+			ih.runningSum = strings.TrimSpace(v)
+		case "-bag-rowspan-break":
+			// boxesandglue-specific: `avoid | auto`, whether a table may
+			// break between the rows joined by a cell with rowspan.
+			ih.rowspanBreak = strings.ToLower(strings.TrimSpace(v))
+		case "table-layout":
+			ih.tableLayout = strings.ToLower(strings.TrimSpace(v))
+		case "writing-mode":
+			ih.writingMode = strings.ToLower(strings.TrimSpace(v))
+		case "-bag-border-diagonal", "-bag-border-diagonal-down":
+			// boxesandglue-specific: the line from the top left to the
+			// bottom right corner of a table cell, in the syntax of the
+			// border shorthand.
+			ih.borderDiagonalDown = v
+		case "-bag-border-diagonal-up":
+			// boxesandglue-specific: the line from the bottom left to the
+			// top right corner of a table cell.
+			ih.borderDiagonalUp = v
+		case "-bag-table-overflow":
+			// boxesandglue-specific: `visible | shrink | rotate |
+			// split-columns [<integer>]`, what becomes of a table too
+			// wide for its container.
+			ih.tableOverflow = strings.TrimSpace(v)
+		case "-bag-row-banding":
+			// boxesandglue-specific: `none | <color> [<color>]?
+			// [<integer>]? [restart]?`, alternating backgrounds of the
+			// body rows of a table.
+			ih.rowBanding = strings.TrimSpace(v)
+		case "-bag-position-page":
+			// boxesandglue-specific: `current | next | <integer>`, the
+			// page a position: absolute element is painted on. Read by
+			// handlePositioned.
+			ih.positionPage = strings.ToLower(strings.TrimSpace(v))
 		case "padding-inline-start":
 			ih.paddingInlineStart = ParseRelativeSize(v, curFontSize, ih.DefaultFontSize)
 		case "padding-bottom":
@@ -481,7 +757,7 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 		case "text-align":
 			ih.Halign = ParseHorizontalAlign(v, ih)
 		case "border-collapse":
-			// handled by table builder
+			ih.borderCollapse = strings.ToLower(strings.TrimSpace(v))
 		case "text-decoration-style":
 			// not yet implemented
 		case "text-decoration-line":
@@ -544,11 +820,78 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 				ih.Valign = frontend.VAlignBottom
 			}
 		case "width":
-			ih.width = v
+			ih.width = resolveFitContentWidth(v, ih)
 		case "height":
 			ih.height = v
+		case "max-height":
+			if v == "none" {
+				ih.maxHeight = ""
+			} else {
+				ih.maxHeight = v
+			}
+		case "overflow":
+			// One value for both axes or x and y. A longhand in the same
+			// declaration block wins over the shorthand whatever the map
+			// order.
+			fields := strings.Fields(strings.ToLower(v))
+			if len(fields) == 0 || len(fields) > 2 {
+				bag.Logger.Warn("invalid overflow", "value", v)
+				break
+			}
+			x, y := fields[0], fields[len(fields)-1]
+			if _, ok := attributes["overflow-x"]; !ok {
+				ih.overflowX = x
+			}
+			if _, ok := attributes["overflow-y"]; !ok {
+				ih.overflowY = y
+			}
+		case "overflow-x":
+			ih.overflowX = strings.ToLower(strings.TrimSpace(v))
+		case "overflow-y":
+			ih.overflowY = strings.ToLower(strings.TrimSpace(v))
+		case "background-image":
+			ih.backgroundImage = strings.TrimSpace(v)
+		case "background-size":
+			ih.backgroundSize = strings.TrimSpace(v)
+		case "background-position":
+			ih.backgroundPosition = strings.TrimSpace(v)
+		case "background-repeat":
+			ih.backgroundRepeat = strings.TrimSpace(v)
+		case "background-clip":
+			ih.backgroundClip = strings.TrimSpace(v)
+		case "background-origin":
+			ih.backgroundOrigin = strings.TrimSpace(v)
+		case "box-shadow":
+			ih.boxShadow = strings.TrimSpace(v)
+		case "text-shadow":
+			ih.textShadow = strings.TrimSpace(v)
+		case "clip-path":
+			if v == "none" {
+				ih.clipPath = ""
+			} else {
+				ih.clipPath = strings.TrimSpace(v)
+			}
+		case "max-lines", "-webkit-line-clamp":
+			// CSS Overflow 4 max-lines and its legacy -webkit- spelling.
+			// Browsers only honour -webkit-line-clamp together with
+			// display: -webkit-box; here it applies on any block. The
+			// legacy property always ends in an ellipsis.
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+				ih.maxLines = n
+				if k == "-webkit-line-clamp" {
+					ih.lineClampEllipsis = true
+				}
+			} else {
+				ih.maxLines = 0
+			}
+		case "text-overflow":
+			ih.textOverflow = strings.ToLower(strings.TrimSpace(v))
 		case "white-space":
 			ih.preserveWhitespace = (v == "pre")
+		case "-bag-fit-text":
+			// boxesandglue-specific copy-fitting, resolved in Output()
+			// against the element's font size. See parseFitText.
+			ih.fitText = strings.ToLower(strings.TrimSpace(v))
 		case "-bag-font-expansion":
 			if strings.HasSuffix(v, "%") {
 				p := strings.TrimSuffix(v, "%")
@@ -571,16 +914,16 @@ func StylesToStyles(ih *FormattingStyles, attributes map[string]string, df *fron
 	// and the map the loop walks has no defined order, so the width may
 	// well be seen last. Without this, `border: none` — the usual way to
 	// take borders off table cells — draws a 1pt line.
-	if ih.BorderTopStyle == frontend.BorderStyleNone {
+	if ih.BorderTopStyle == frontend.BorderStyleNone || ih.BorderTopStyle == borderStyleHidden {
 		ih.BorderTopWidth = 0
 	}
-	if ih.BorderRightStyle == frontend.BorderStyleNone {
+	if ih.BorderRightStyle == frontend.BorderStyleNone || ih.BorderRightStyle == borderStyleHidden {
 		ih.BorderRightWidth = 0
 	}
-	if ih.BorderBottomStyle == frontend.BorderStyleNone {
+	if ih.BorderBottomStyle == frontend.BorderStyleNone || ih.BorderBottomStyle == borderStyleHidden {
 		ih.BorderBottomWidth = 0
 	}
-	if ih.BorderLeftStyle == frontend.BorderStyleNone {
+	if ih.BorderLeftStyle == frontend.BorderStyleNone || ih.BorderLeftStyle == borderStyleHidden {
 		ih.BorderLeftWidth = 0
 	}
 	return nil
@@ -666,11 +1009,60 @@ type FormattingStyles struct {
 	Valign             frontend.VerticalAlignment
 	width              string
 	height             string
+	maxHeight          string // CSS max-height raw value ("" = none)
+	overflowX          string // CSS overflow-x ("" = visible)
+	overflowY          string // CSS overflow-y ("" = visible)
+	clipPath           string // CSS clip-path basic shape ("" = none)
+	boxShadow          string // CSS box-shadow raw value ("" = none)
+	backgroundImage    string // CSS background-image raw value ("" = none)
+	// The other background-* raw values, matched to the background-image
+	// layers by elementBackground.
+	backgroundSize     string
+	backgroundPosition string
+	backgroundRepeat   string
+	backgroundClip     string
+	backgroundOrigin   string
+	textShadow         string // CSS text-shadow raw value, inherited ("" = none)
+	captionSide        string // CSS caption-side, inherited ("" = top)
+	writingMode        string // CSS writing-mode, inherited ("" = horizontal-tb); only table cells turn
+	captionContinued   string // -bag-caption-continued, inherited ("" = none)
+	borderCollapse     string // CSS border-collapse, inherited ("" = not declared)
+	borderSpacing      string // CSS border-spacing raw value, inherited
+	maxLines           int    // max-lines / -webkit-line-clamp (0 = none)
+	lineClampEllipsis  bool   // set by -webkit-line-clamp, which implies an ellipsis
+	textOverflow       string // CSS text-overflow ("" = clip)
+	fitText            string // -bag-fit-text raw value ("" = none)
 	pageBreakAfter     string
 	pageBreakBefore    string
 	pageBreakInside    string
 	bookmark           string // -bag-bookmark raw value (non-inherited; "" = unset)
+	runningSum         string // -bag-running-sum raw value (non-inherited; "" = none)
+	rowspanBreak       string // -bag-rowspan-break (non-inherited; "" = avoid)
+	tableLayout        string // CSS table-layout (non-inherited; "" = auto)
+	tableOverflow      string // -bag-table-overflow raw value (non-inherited; "" = visible)
+	rowBanding         string // -bag-row-banding raw value (non-inherited; "" = none)
+	borderDiagonalDown string // -bag-border-diagonal-down raw value (non-inherited)
+	borderDiagonalUp   string // -bag-border-diagonal-up raw value (non-inherited)
 	yoffset            bag.ScaledPoint
+
+	// The alpha channels of color, BackgroundColor and the border colors,
+	// stored as 1 - alpha so that the zero value is opaque. The first
+	// three inherit along with their colors, see boxTransparency.
+	colorTransparency      float64
+	blockColorTransparency float64 // colorTransparency of the nearest block with a boxTransparency
+	backgroundTransparency float64
+	ownBackground          bool       // background-color is declared on this element, not inherited
+	borderTransparency     [4]float64 // top, right, bottom, left
+	opacity                string     // CSS opacity raw value ("" = 1)
+	mixBlendMode           string     // CSS mix-blend-mode ("" = normal)
+	// groupTransparency (1 - opacity) and groupBlend are the combined
+	// opacity and the blend mode of the enclosing elements, inherited.
+	groupTransparency float64
+	groupBlend        string
+	// CSS transform and transform-origin raw values (non-inherited, "" =
+	// none / 50% 50%), see boxTransform.
+	transform       string
+	transformOrigin string
 	// CSS positioning (CSS 2.1 §9-§10). None of these inherit; Clone()
 	// deliberately drops them so every element starts at the default
 	// (position: static, all offsets/z-index auto).
@@ -679,7 +1071,46 @@ type FormattingStyles struct {
 	rightOffset  *bag.ScaledPoint
 	bottomOffset *bag.ScaledPoint
 	leftOffset   *bag.ScaledPoint
-	zIndex       *int // nil = auto; *0 = explicit zero
+	zIndex       *int   // nil = auto; *0 = explicit zero
+	positionPage string // -bag-position-page, "" = not set
+	// CSS display and the flexbox properties (CSS Flexbox 1), raw values,
+	// none of them inherit. The shorthands flex, flex-flow and gap are
+	// kept apart from their longhands and resolved by elementFlex and
+	// elementFlexItem, so a longhand wins regardless of the order in
+	// which the declarations are applied.
+	display        string
+	flexDirection  string
+	flexWrap       string
+	flexFlow       string
+	justifyContent string
+	alignItems     string
+	alignSelf      string
+	order          int
+	flex           string
+	flexGrow       string
+	flexShrink     string
+	flexBasis      string
+	gap            string
+	rowGap         string
+	columnGap      string
+	// The grid properties (CSS Grid 1), raw values, resolved by
+	// elementGrid and elementGridItem. Like the flex shorthands, grid-row,
+	// grid-column and grid-area are kept apart from their longhands.
+	justifyItems        string
+	justifySelf         string
+	gridTemplateColumns string
+	gridTemplateRows    string
+	gridTemplateAreas   string
+	gridAutoFlow        string
+	gridAutoRows        string
+	gridAutoColumns     string
+	gridRow             string
+	gridColumn          string
+	gridArea            string
+	gridRowStart        string
+	gridRowEnd          string
+	gridColumnStart     string
+	gridColumnEnd       string
 }
 
 // IsPositioned reports whether the element participates in CSS positioning
@@ -763,6 +1194,18 @@ func (is *FormattingStyles) Clone() *FormattingStyles {
 		tabsizeSpaces:      is.tabsizeSpaces,
 		Valign:             is.Valign,
 		Halign:             is.Halign,
+		textShadow:         is.textShadow,
+		captionSide:        is.captionSide,
+		writingMode:        is.writingMode,
+		captionContinued:   is.captionContinued,
+		borderCollapse:     is.borderCollapse,
+		borderSpacing:      is.borderSpacing,
+
+		colorTransparency:      is.colorTransparency,
+		blockColorTransparency: is.blockColorTransparency,
+		backgroundTransparency: is.backgroundTransparency,
+		groupTransparency:      is.groupTransparency,
+		groupBlend:             is.groupBlend,
 	}
 	return newis
 }
@@ -852,6 +1295,7 @@ func ApplySettings(settings frontend.TypesettingSettings, ih *FormattingStyles)
 	settings[frontend.SettingBorderBottomLeftRadius] = ih.BorderBottomLeftRadius
 	settings[frontend.SettingBorderBottomRightRadius] = ih.BorderBottomRightRadius
 	settings[frontend.SettingColor] = ih.color
+	mixTranslucentColors(settings, ih)
 	if ih.fontexpansion != nil {
 		settings[frontend.SettingFontExpansion] = *ih.fontexpansion
 	} else {
@@ -1168,7 +1612,17 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 	// stack walks performed by counter()/counters() at content time read
 	// these values directly off the styles in the stack.
 	ss.applyCounters()
+	// opacity, mix-blend-mode and translucent colors: the block draws its
+	// own colors with their alpha (settingTransparency, stamped below), the
+	// descendants see the group it opens.
+	var transparency *boxTransparency
+	if item.Typ == html.ElementNode {
+		transparency = enterTransparency(styles, item.Data)
+	}
 	ApplySettings(newte.Settings, styles)
+	if transparency != nil {
+		unmixTranslucentColors(newte.Settings, styles)
+	}
 	newte.Settings[frontend.SettingDebug] = item.Data
 	// Remember the element's own resolved CSS height: `styles` is
 	// reassigned when an inline run starts below, but the empty-block
@@ -1177,13 +1631,27 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 	if styles.height != "" {
 		elementCSSHeight = ParseRelativeSize(styles.height, styles.Fontsize, styles.DefaultFontSize)
 	}
+	// max-height caps the declared height (CSS 2.1 §10.7). Content is only
+	// cut at max-height when overflow hides it, see settingLineClamp.
+	clamp := lineClamp{maxLines: styles.maxLines}
+	if styles.maxHeight != "" {
+		maxHeight := ParseRelativeSize(styles.maxHeight, styles.Fontsize, styles.DefaultFontSize)
+		if elementCSSHeight > maxHeight {
+			elementCSSHeight = maxHeight
+		}
+		if overflowHides(styles.overflowY) {
+			clamp.maxHeight = maxHeight
+		}
+	}
+	clamp.ellipsis = styles.textOverflow == "ellipsis" || styles.lineClampEllipsis
 	// CSS 2.1 §9.4.3 position: relative — element stays in flow,
-	// reserving its original slot, but renders at an offset. v1
-	// supports horizontal offsets via SettingShiftX (consumed by
-	// vlistbuilder when wrapping the child VList). Vertical offsets
-	// would need a backend VList.ShiftY, deferred to v2; we warn
-	// when authors set top/bottom on a relative element so the gap
-	// between intent and v1 capability is visible.
+	// reserving its original slot, but renders at an offset.
+	// Horizontal offsets go into SettingShiftX (consumed by
+	// vlistbuilder when wrapping the child VList). The vertical offset
+	// and the absolutely positioned descendants, for which the element
+	// is the containing block, are collected in a positionedBox and
+	// stamped below.
+	var positioned *positionedBox
 	if styles.position == "relative" {
 		switch {
 		case styles.leftOffset != nil:
@@ -1191,10 +1659,18 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 		case styles.rightOffset != nil:
 			newte.Settings[frontend.SettingShiftX] = -*styles.rightOffset
 		}
-		if styles.topOffset != nil || styles.bottomOffset != nil {
-			bag.Logger.Warn("position: relative top/bottom offsets are not implemented in v1; only left/right take effect")
+		if item.Typ == html.ElementNode && !isPositionedBoxExempt(item.Data) {
+			positioned = &positionedBox{shiftY: relativeShiftY(styles)}
 		}
 	}
+	// display: flex / grid — the children become flex or grid items, laid
+	// out by buildVlistInternal (settingFlex, settingGrid, stamped below).
+	var flex *flexContainer
+	var grid *gridContainer
+	if item.Typ == html.ElementNode {
+		flex = elementFlex(styles, item.Data)
+		grid = elementGrid(styles, item.Data)
+	}
 	// Any element with an id attribute creates a named PDF destination.
 	if id, ok := item.Attributes["id"]; ok {
 		newte.Settings[frontend.SettingDest] = id
@@ -1489,6 +1965,10 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 		return newte, nil
 	}
 
+	if positioned != nil {
+		cb.pushPositioningContext(positioningContext{deferred: positioned})
+		defer cb.popPositioningContext()
+	}
 	for _, itm := range item.Children {
 		if itm.Dir == ModeHorizontal {
 			// Strip leading whitespace from text nodes that immediately
@@ -1550,6 +2030,12 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 				if err := cb.captureRunningElement(name, itm, ss, df, anchorPages); err != nil {
 					return nil, err
 				}
+			} else if isFixedElement(itm) {
+				// Likewise an inline element with position: fixed,
+				// which repeats on every page.
+				if err := cb.captureFixedElement(itm, ss, df, anchorPages); err != nil {
+					return nil, err
+				}
 			} else {
 				if err := collectHorizontalNodes(cb, te, itm, ss, ss.CurrentStyle().Fontsize, ss.CurrentStyle().DefaultFontSize, df, anchorPages); err != nil {
 					return nil, err
@@ -1577,11 +2063,20 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 				// Out of flow: handlePositioned formats the body
 				// against the resolved containing-block geometry,
 				// resolves top/right/bottom/left into PDF
-				// coordinates, and appends a PositionedInsert that
-				// flushInserts paints. The element contributes
-				// nothing to newte.Items — it must not influence
-				// in-flow layout.
-				if err := cb.handlePositioned(itm, ss, df, anchorPages); err != nil {
+				// coordinates, and leaves a positionedMarker in
+				// newte.Items that anchors the element to the page
+				// its place in the flow lands on. The element
+				// takes no space — it must not influence in-flow
+				// layout.
+				if err := cb.handlePositioned(itm, newte, ss, df, anchorPages); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if isFixedElement(itm) {
+				// position: fixed: out of flow as well, painted on
+				// every page against the page box.
+				if err := cb.captureFixedElement(itm, ss, df, anchorPages); err != nil {
 					return nil, err
 				}
 				continue
@@ -1613,6 +2108,22 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 			// height (visible swatches / spacers, settingCSSHeight).
 			if len(te.Items) > 0 || itm.Data == "td" || itm.Data == "th" || itm.Data == "col" || te.Settings[settingCSSHeight] != nil {
 				newte.Items = append(newte.Items, te)
+			} else if flex != nil || grid != nil {
+				// An empty flex item still takes its share of the
+				// line (a spacer with flex-grow, a fixed-width swatch),
+				// an empty grid item its area.
+				te.Settings[frontend.SettingBox] = true
+				newte.Items = append(newte.Items, te)
+			}
+			if flex != nil {
+				if err := flex.addItem(te, itm, ss, df); err != nil {
+					return nil, err
+				}
+			}
+			if grid != nil {
+				if err := grid.addItem(te, itm, ss, df); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -1655,6 +2166,151 @@ func Output(cb *CSSBuilder, item *HTMLItem, ss StylesStack, df *frontend.Documen
 		}
 		newte.Settings[settingCSSHeight] = elementCSSHeight
 	}
+	// Line clamping: stamp the truncation request for buildVlistInternal.
+	// Table cells carry it too (buildTD clamps the cell contents); the
+	// other table-internal and replaced elements have nothing to cut.
+	if item.Typ == html.ElementNode && (clamp.maxLines > 0 || clamp.maxHeight > 0) {
+		switch item.Data {
+		case "table", "thead", "tbody", "tfoot", "tr", "col", "colgroup", "img":
+		default:
+			newte.Settings[settingLineClamp] = clamp
+		}
+	}
+	// overflow: hidden / clip-path: buildVlistInternal wraps the box in a
+	// clip path. Table-internal boxes are built by the table code, a
+	// clipped img carries its clip on the image node itself.
+	if bc, ok := elementClip(blockStyles); ok && item.Typ == html.ElementNode {
+		switch item.Data {
+		case "table", "thead", "tbody", "tfoot", "tr", "td", "th", "col", "colgroup", "img":
+		default:
+			newte.Settings[settingClip] = bc
+		}
+	}
+	// box-shadow and text-shadow. Table-internal boxes are built by the
+	// table code and keep neither (v1).
+	if item.Typ == html.ElementNode && (blockStyles.boxShadow != "" || blockStyles.textShadow != "") {
+		switch item.Data {
+		case "table", "thead", "tbody", "tfoot", "tr", "td", "th", "col", "colgroup", "img":
+		default:
+			if bs := parseShadows(blockStyles.boxShadow, blockStyles, df, false); len(bs) > 0 {
+				newte.Settings[settingBoxShadow] = bs
+			}
+			if ts := parseShadows(blockStyles.textShadow, blockStyles, df, true); len(ts) > 0 {
+				newte.Settings[settingTextShadow] = ts
+			}
+		}
+	}
+	// Background images and background-clip. Row groups and columns paint
+	// nothing of their own (a tr passes its layers on to its cells, see
+	// buildTR); an img has no box around it.
+	if bb, ok := elementBackground(blockStyles); ok && item.Typ == html.ElementNode {
+		switch item.Data {
+		case "thead", "tbody", "tfoot", "col", "colgroup", "img":
+		default:
+			newte.Settings[settingBackground] = bb
+		}
+	}
+	if transparency != nil {
+		newte.Settings[settingTransparency] = transparency
+	}
+	if flex != nil {
+		newte.Settings[frontend.SettingBox] = true
+		newte.Settings[settingFlex] = flex
+	}
+	if grid != nil {
+		newte.Settings[frontend.SettingBox] = true
+		newte.Settings[settingGrid] = grid
+	}
+	// border-collapse and border-spacing: the border model of the table,
+	// applied to the cells by buildTable.
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.borderCollapse != "" {
+		tb := &tableBorders{collapse: blockStyles.borderCollapse == "collapse"}
+		if !tb.collapse {
+			tb.spacingH, tb.spacingV = parseBorderSpacing(blockStyles.borderSpacing, blockStyles.Fontsize, blockStyles.DefaultFontSize)
+		}
+		newte.Settings[settingTableBorders] = tb
+	}
+	// -bag-running-sum: the rows outputTableRows generates where the table
+	// breaks.
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.runningSum != "" {
+		if rs := parseRunningSum(blockStyles.runningSum); rs != nil {
+			newte.Settings[settingRunningSum] = rs
+		}
+	}
+	// -bag-rowspan-break: auto lets outputTableRows break a table between
+	// the rows of a rowspan group.
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.rowspanBreak == "auto" {
+		newte.Settings[settingRowspanBreak] = "auto"
+	}
+	// table-layout: fixed takes the column widths from the colgroup and
+	// the first row only (layoutTableColumns).
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.tableLayout == "fixed" {
+		newte.Settings[settingTableLayout] = "fixed"
+	}
+	// A vertical writing-mode and the diagonal lines of a table cell:
+	// applied by buildTD.
+	if item.Typ == html.ElementNode && (item.Data == "td" || item.Data == "th") {
+		if isVerticalWritingMode(blockStyles.writingMode) {
+			newte.Settings[settingCellWritingMode] = blockStyles.writingMode
+		}
+		cd := &cellDiagonals{
+			down: parseCellDiagonal(blockStyles.borderDiagonalDown, blockStyles, df),
+			up:   parseCellDiagonal(blockStyles.borderDiagonalUp, blockStyles, df),
+		}
+		if cd.down != nil || cd.up != nil {
+			newte.Settings[settingCellDiagonals] = cd
+		}
+	}
+	// -bag-table-overflow: applied by buildTableParts when the table is
+	// wider than its container.
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.tableOverflow != "" {
+		if to := parseTableOverflow(blockStyles.tableOverflow); to != nil {
+			newte.Settings[settingTableOverflow] = to
+		}
+	}
+	// -bag-row-banding: painted by buildTable, repainted page by page by
+	// outputTableRows.
+	if item.Typ == html.ElementNode && item.Data == "table" && blockStyles.rowBanding != "" {
+		if rb := parseRowBanding(blockStyles.rowBanding, blockStyles, df); rb != nil {
+			newte.Settings[settingRowBanding] = rb
+		}
+	}
+	// caption-side and -bag-caption-continued: read by buildTable, which
+	// builds the caption apart from the rows.
+	if item.Typ == html.ElementNode && item.Data == "caption" {
+		newte.Settings[settingCaption] = &captionStyle{
+			bottom:    blockStyles.captionSide == "bottom",
+			continued: blockStyles.captionContinued,
+		}
+	}
+	if positioned != nil && (positioned.shiftY != 0 || len(positioned.children) > 0) {
+		newte.Settings[settingPositionedBox] = positioned
+	}
+	// transform: painted around the finished box. Table cells carry it to
+	// buildTD; rows and row groups are built by the table code and an img
+	// is transformed where its node is made.
+	if bt, ok := elementTransform(blockStyles); ok && item.Typ == html.ElementNode {
+		switch item.Data {
+		case "table", "thead", "tbody", "tfoot", "tr", "col", "colgroup", "img":
+		default:
+			newte.Settings[settingTransform] = bt
+		}
+	}
+	// -bag-fit-text: copy-fit the paragraph into its fixed height. Only a
+	// block holding a single paragraph can be re-typeset as a whole.
+	if item.Typ == html.ElementNode && blockStyles.fitText != "" && blockStyles.fitText != "none" && !isCSSHeightExempt(item.Data) {
+		if ft, ok := parseFitText(blockStyles.fitText, blockStyles); ok {
+			switch {
+			case elementCSSHeight <= 0:
+				bag.Logger.Warn("-bag-fit-text needs a fixed height", "element", item.Data)
+			case newte.Settings[frontend.SettingBox] == true:
+				bag.Logger.Warn("-bag-fit-text applies to blocks with inline content only", "element", item.Data)
+			default:
+				ft.height = elementCSSHeight
+				newte.Settings[settingFitText] = ft
+			}
+		}
+	}
 	// CSS initial-letter: carve the paragraph's first letter out as a
 	// dropcap spanning several lines.
 	if blockStyles.initialLetterLines > 1 {
@@ -1672,6 +2328,21 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 	case html.TextNode:
 		te.Items = append(te.Items, item.Data)
 	case html.ElementNode:
+		// display: inline-block / inline-table: the element is a block
+		// in the line, one unbreakable box (see inlineBlock).
+		if isInlineBlock(item) {
+			vl, err := cb.inlineBlock(item, ss, df, anchorPages)
+			if err != nil {
+				return err
+			}
+			te.Items = append(te.Items, vl)
+			return nil
+		}
+		// Text shadows are drawn for the lines of a block (see
+		// textShadowLines); an inline element has none of its own.
+		if v, ok := item.Styles["text-shadow"]; ok && strings.TrimSpace(v) != "none" {
+			bag.Logger.Warn("text-shadow on inline elements is not drawn", "element", item.Data)
+		}
 		childSettings := make(frontend.TypesettingSettings, 8)
 
 		// Inline element with id="..." → record as anchor target for
@@ -1975,7 +2646,7 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 					if alt, ok := item.Attributes["alt"]; ok {
 						vl.Attributes["alt"] = alt
 					}
-					setDeferredFormatter(vl, newInlineSVGFormatter(svgDoc, widthPct, ht, df))
+					setDeferredFormatter(vl, cb.fadedFormatter(newInlineSVGFormatter(svgDoc, widthPct, ht, df), imageTransparency(cs)))
 					te.Items = append(te.Items, vl)
 				} else {
 					textRenderer := frontend.NewSVGTextRenderer(df)
@@ -1992,6 +2663,12 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 					if alt, ok := item.Attributes["alt"]; ok {
 						svgVL.Attributes["alt"] = alt
 					}
+					if bt := imageTransparency(cs); bt != nil {
+						svgVL = cb.groupVList(svgVL, bt)
+					}
+					if tf, ok := elementTransform(cs); ok {
+						svgVL = cb.transformVList(svgVL, tf)
+					}
 					te.Items = append(te.Items, svgVL)
 				}
 			} else {
@@ -2027,7 +2704,7 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 					if alt, ok := item.Attributes["alt"]; ok {
 						vl.Attributes["alt"] = alt
 					}
-					setDeferredFormatter(vl, newRasterImageFormatter(imgNode, intrinsicWd, intrinsicHt, widthPct, ht))
+					setDeferredFormatter(vl, cb.fadedFormatter(newRasterImageFormatter(imgNode, intrinsicWd, intrinsicHt, widthPct, ht), imageTransparency(cs)))
 					te.Items = append(te.Items, vl)
 					ss.PopStyles()
 					break
@@ -2058,6 +2735,31 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 						imgNode.Height = ascent
 					}
 				}
+				// clip-path, opacity and transform on the image: wrap it in
+				// a VList that carries them (a block-level img goes through
+				// here as well). The transform acts on the clipped image.
+				tf, hasTransform := elementTransform(cs)
+				bt := imageTransparency(cs)
+				if cs.clipPath != "" || bt != nil || hasTransform {
+					vl := node.Vpack(imgNode)
+					vl.Attributes = node.H{"origin": "img", "attr": item.Attributes}
+					if alt, ok := item.Attributes["alt"]; ok {
+						vl.Attributes["alt"] = alt
+					}
+					if cs.clipPath != "" {
+						bc := boxClip{clipPath: cs.clipPath, fontsize: cs.Fontsize, rootFontsize: cs.DefaultFontSize}
+						vl = cb.clipVList(vl, HTMLValues{}, bc)
+					}
+					if bt != nil {
+						vl = cb.groupVList(vl, bt)
+					}
+					if hasTransform {
+						vl = cb.transformVList(vl, tf)
+					}
+					te.Items = append(te.Items, vl)
+					ss.PopStyles()
+					break
+				}
 				te.Items = append(te.Items, imgNode)
 			}
 			ss.PopStyles()
@@ -2163,6 +2865,11 @@ func collectHorizontalNodes(cb *CSSBuilder, te *frontend.Text, item *HTMLItem, s
 				return err
 			}
 			applyLangAndHyphens(sty, item.Attributes, df)
+			if sty.position == "relative" {
+				// An inline box moves as a baseline shift (up is
+				// positive), see positionedBox.
+				sty.yoffset -= relativeShiftY(sty)
+			}
 			ApplySettings(cld.Settings, sty)
 			for k, v := range childSettings {
 				cld.Settings[k] = v
@@ -0,0 +1,180 @@
+package htmlbag
+
+import (
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"golang.org/x/net/html"
+)
+
+// isInlineBlock reports whether item is an element with display:
+// inline-block or inline-table, an atomic inline-level box (CSS 2.1
+// §9.2.2). Replaced elements are atomic already and keep their own path.
+func isInlineBlock(item *HTMLItem) bool {
+	if item.Typ != html.ElementNode {
+		return false
+	}
+	switch item.Data {
+	case "img", "svg", "math", "barcode":
+		return false
+	}
+	switch item.Styles["display"] {
+	case "inline-block", "inline-table":
+		return true
+	}
+	return false
+}
+
+// inlineBlock formats the inline-block item as a block container and
+// returns the box that stands for it in the line. The width of the box
+// depends on the width of the line, so the box is a DeferredSizer wrapper
+// (see deferred_sizing.go) materialised by the paragraph or the table cell
+// that holds it.
+//
+// v1 limitations: the contents are not tagged (they travel with the
+// paragraph), footnotes and floats inside an inline-block are dropped, and
+// vertical-align other than baseline is ignored.
+func (cb *CSSBuilder) inlineBlock(item *HTMLItem, ss StylesStack, df *frontend.Document, anchorPages map[string]int) (*node.VList, error) {
+	// Output formats a block element; the element only sits in the inline
+	// flow.
+	dir := item.Dir
+	item.Dir = ModeVertical
+	te, err := Output(cb, item, ss, df, anchorPages)
+	item.Dir = dir
+	if err != nil {
+		return nil, err
+	}
+	vl := node.NewVList()
+	vl.Attributes = node.H{"origin": "inline-block"}
+	setDeferredFormatter(vl, cb.newInlineBlockFormatter(te))
+	return vl, nil
+}
+
+// newInlineBlockFormatter returns the FormatToVList closure of an
+// inline-block: the block te at its CSS width or, with width: auto, at its
+// shrink-to-fit width (CSS 2.1 §10.3.9, the fit-content width of
+// intrinsicWidths): the max-content width, at most the width of the line,
+// at least the min-content width. An inline-table is a table at its
+// natural width.
+//
+// The box sits on the baseline of its last line, an inline-table on the
+// baseline of its first row. Without lines, or with overflow other than
+// visible, the bottom margin edge sits on the baseline (CSS 2.1 §10.8.1).
+func (cb *CSSBuilder) newInlineBlockFormatter(te *frontend.Text) frontend.FormatToVList {
+	li := newLayoutItem(te)
+	dbg, _ := te.Settings[frontend.SettingDebug].(string)
+	return func(containerWidth bag.ScaledPoint) (*node.VList, error) {
+		avail := bag.Max(containerWidth-li.ml-li.mr, 0)
+		wd := avail
+		w, _ := te.Settings[frontend.SettingWidth].(string)
+		switch {
+		case isIntrinsicWidth(w):
+			iwd, err := cb.intrinsicWidth(te, w, avail)
+			if err != nil {
+				return nil, err
+			}
+			wd = iwd
+		case w != "" && w != "auto":
+			wd = ParseRelativeSize(w, avail, avail)
+		case dbg != "table":
+			iw, err := cb.intrinsicWidths(te)
+			if err != nil {
+				return nil, err
+			}
+			wd = iw.fit(avail)
+		}
+		box, err := cb.measureItem(te, wd)
+		if err != nil {
+			return nil, err
+		}
+		if dbg == "table" {
+			wd = box.Width
+		}
+
+		var head node.Node
+		if li.ml != 0 {
+			head = layoutKern(li.ml, "inline-block-margin")
+		}
+		head = node.InsertAfter(head, node.Tail(head), box)
+		if li.mr != 0 {
+			head = node.InsertAfter(head, node.Tail(head), layoutKern(li.mr, "inline-block-margin"))
+		}
+		row := node.Hpack(head)
+		row.Width = li.ml + wd + li.mr
+		row.Height = box.Height
+		row.Depth = box.Depth
+
+		var list node.Node
+		if li.mt != 0 {
+			list = layoutKern(li.mt, "inline-block-margin")
+		}
+		list = node.InsertAfter(list, node.Tail(list), row)
+		if li.mb != 0 {
+			list = node.InsertAfter(list, node.Tail(list), layoutKern(li.mb, "inline-block-margin"))
+		}
+		total := li.mt + box.Height + box.Depth + li.mb
+		vl := node.NewVList()
+		vl.List = list
+		vl.Width = row.Width
+		vl.Height = total
+		_, clipped := te.Settings[settingClip]
+		if baseline, ok := lineBaseline(box, dbg != "table"); ok && !clipped {
+			vl.Height = li.mt + baseline
+			vl.Depth = total - vl.Height
+		}
+		return vl, nil
+	}
+}
+
+// lineBaseline returns the distance from the top of vl to the baseline of
+// its last line (or its first line with last == false). Like lineSlack it
+// searches the boxes of an HList holding boxes (the HTMLBorder frame, a
+// table row) instead of taking it as a line, unless none of them has a
+// line (an inline image). A table caption is not a row of the table and
+// has no baseline for it.
+func lineBaseline(vl *node.VList, last bool) (bag.ScaledPoint, bool) {
+	var y, baseline bag.ScaledPoint
+	found := false
+	take := func(b bag.ScaledPoint) bool {
+		baseline, found = b, true
+		return !last
+	}
+	for n := vl.List; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.VList:
+			if o, _ := v.Attributes["origin"].(string); o == "caption" {
+				y += v.Height + v.Depth
+				continue
+			}
+			if b, ok := lineBaseline(v, last); ok && take(y+b) {
+				return baseline, true
+			}
+			y += v.Height + v.Depth
+		case *node.HList:
+			inner := false
+			for c := v.List; c != nil; c = c.Next() {
+				box, ok := c.(*node.VList)
+				if !ok {
+					continue
+				}
+				// The box hangs from the baseline of the HList by its
+				// height.
+				if b, ok := lineBaseline(box, last); ok {
+					inner = true
+					if take(y + v.Height - box.Height + b) {
+						return baseline, true
+					}
+				}
+			}
+			if !inner && take(y+v.Height) {
+				return baseline, true
+			}
+			y += v.Height + v.Depth
+		case *node.Kern:
+			y += v.Kern
+		case *node.Glue:
+			y += v.Width
+		}
+	}
+	return baseline, found
+}
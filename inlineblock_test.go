@@ -0,0 +1,108 @@
+package htmlbag
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// baselineOf returns the left end and the baseline of the line holding
+// needle.
+func baselineOf(t *testing.T, pages []*document.Page, needle string) (bag.ScaledPoint, bag.ScaledPoint) {
+	t.Helper()
+	x, y, hl, ok := findLine(pages[0], needle)
+	if !ok {
+		t.Fatalf("%s not found", needle)
+	}
+	return x, y - hl.Height
+}
+
+// glyphWidth returns the width of the glyphs of hl.
+func glyphWidth(hl *node.HList) bag.ScaledPoint {
+	var wd bag.ScaledPoint
+	for n := hl.List; n != nil; n = n.Next() {
+		if g, ok := n.(*node.Glyph); ok {
+			wd += g.Width
+		}
+	}
+	return wd
+}
+
+// TestInlineBlockBaseline: an inline-block sits in the line with the
+// baseline of its last line on the baseline of the line, its borders and
+// padding around it.
+func TestInlineBlockBaseline(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.b { display: inline-block; border: 1pt solid black; padding: 4pt; }`
+	pages := renderHTMLPages(t, css, `<html><body><p>VORHER <span class="b">OBEN<br>UNTEN</span> NACHHER</p></body></html>`)
+	vx, vy := baselineOf(t, pages, "VORHER")
+	ox, oy := baselineOf(t, pages, "OBEN")
+	ux, uy := baselineOf(t, pages, "UNTEN")
+	nx, ny := baselineOf(t, pages, "NACHHER")
+	if uy != vy || ny != vy {
+		t.Errorf("baselines: line %s, last line of the box %s, after the box %s", vy, uy, ny)
+	}
+	if oy <= uy {
+		t.Errorf("first line of the box at %s, want above the last one at %s", oy, uy)
+	}
+	if ox != ux || ox <= vx+bag.MustSP("5pt") || nx <= ox {
+		t.Errorf("x: before %s, box lines %s/%s, after %s", vx, ox, ux, nx)
+	}
+}
+
+// TestInlineBlockWidth: an inline-block is as wide as its CSS width or,
+// with width: auto, as its contents.
+func TestInlineBlockWidth(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.w { display: inline-block; width: 100pt; }
+.s { display: inline-block; }`
+	pages := renderHTMLPages(t, css, `<html><body><div><span class="w">AAA</span><span class="w">BBB</span></div><div><span class="s">CCC</span><span class="s">DDD</span></div></body></html>`)
+	ax, _ := baselineOf(t, pages, "AAA")
+	bx, _ := baselineOf(t, pages, "BBB")
+	if !nearly(bx-ax, bag.MustSP("100pt")) {
+		t.Errorf("width: 100pt box is %s wide", bx-ax)
+	}
+	cx, _, hl, _ := findLine(pages[0], "CCC")
+	dx, _ := baselineOf(t, pages, "DDD")
+	if want := glyphWidth(hl); !nearly(dx-cx, want) {
+		t.Errorf("shrink-to-fit box is %s wide, want its text width %s", dx-cx, want)
+	}
+}
+
+// TestInlineBlockWraps: an inline-block with more text than fits on the
+// line gets the width of the line and breaks its own lines.
+func TestInlineBlockWraps(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.b { display: inline-block; }`
+	long := "ANFANG lorem ipsum dolor sit amet consectetur adipisici elit sed eiusmod tempor incidunt ut labore et dolore magna aliqua ut enim ad minim veniam ENDE"
+	pages := renderHTMLPages(t, css, `<html><body><div><span class="b">`+long+`</span></div></body></html>`)
+	ax, ay := baselineOf(t, pages, "ANFANG")
+	ex, ey := baselineOf(t, pages, "ENDE")
+	if ax != bag.MustSP("20mm") || ex != ax || ey >= ay {
+		t.Errorf("lines at %s/%s and %s/%s, want two lines of the box at the left margin", ax, ay, ex, ey)
+	}
+}
+
+// TestInlineTable: an inline-table sits in the line with the baseline of
+// its first row on the baseline of the line.
+func TestInlineTable(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { display: inline-table; }`
+	pages := renderHTMLPages(t, css, `<html><body><div>LINKS <table><tr><td>ZELLE1</td><td>ZELLE2</td></tr><tr><td>ZELLE3</td><td>ZELLE4</td></tr></table> RECHTS</div></body></html>`)
+	lx, ly := baselineOf(t, pages, "LINKS")
+	x1, y1 := baselineOf(t, pages, "ZELLE1")
+	x2, y2 := baselineOf(t, pages, "ZELLE2")
+	_, y3 := baselineOf(t, pages, "ZELLE3")
+	rx, ry := baselineOf(t, pages, "RECHTS")
+	if y1 != ly || y2 != ly || ry != ly {
+		t.Errorf("baselines: line %s, first row %s/%s, after the table %s", ly, y1, y2, ry)
+	}
+	if y3 >= y1 {
+		t.Errorf("second row at %s, want below the first at %s", y3, y1)
+	}
+	if !(lx < x1 && x1 < x2 && x2 < rx) {
+		t.Errorf("x: %s %s %s %s, want left to right", lx, x1, x2, rx)
+	}
+}
@@ -29,6 +29,11 @@ const (
 	// body content and the footnote stack. No separator, no in-text
 	// marker. Same fit-or-ship semantics as InsertFloatTop.
 	InsertFloatBottom
+	// InsertPositioned: a position: absolute element anchored in the flow.
+	// It reserves no space; the page builder only records on which page
+	// its anchor lands, flushInserts paints it there at its resolved page
+	// coordinates (see schedulePositioned).
+	InsertPositioned
 )
 
 // Detection inputs for footnote inline elements.
@@ -78,10 +83,13 @@ const (
 // For InsertFootnote: Body width equals the footnote-area width (currently
 // the paragraph content width); Number is assigned at extraction time as a
 // running document counter (cb.Counters["footnote"]).
+//
+// For InsertPositioned: Body is nil, Positioned carries the element.
 type Insert struct {
-	Class  InsertClass
-	Number int
-	Body   *node.VList
+	Class      InsertClass
+	Number     int
+	Body       *node.VList
+	Positioned *PositionedInsert
 }
 
 // insertMarker is a sentinel value placed inside frontend.Text.Items at
@@ -402,7 +410,39 @@ func filterInserts(ins []*Insert, class InsertClass) []*Insert {
 // outputGroupNodes / OutputPages strips outer VLists and propagates the
 // attribute onto the first remaining node — which is typically the HList
 // of the paragraph's first line.
+//
+// Positioned inserts are not propagated upward when their block ends up
+// nested in a box the page builder places whole (a bordered container, a
+// table cell): they are collected from n's descendants as well, so the
+// element goes to the page on which n starts.
 func insertsOnNode(n node.Node) []*Insert {
+	ins := nodeInserts(n)
+	deep := positionedInsertsBelow(n, nil)
+	if len(deep) == 0 {
+		return ins
+	}
+	return append(append([]*Insert{}, ins...), deep...)
+}
+
+// positionedInsertsBelow appends the positioned inserts carried by the
+// descendants of n to out.
+func positionedInsertsBelow(n node.Node, out []*Insert) []*Insert {
+	var list node.Node
+	switch t := n.(type) {
+	case *node.VList:
+		list = t.List
+	case *node.HList:
+		list = t.List
+	}
+	for c := list; c != nil; c = c.Next() {
+		out = append(out, filterInserts(nodeInserts(c), InsertPositioned)...)
+		out = positionedInsertsBelow(c, out)
+	}
+	return out
+}
+
+// nodeInserts returns the []*Insert stored in n.Attributes["inserts"].
+func nodeInserts(n node.Node) []*Insert {
 	var attrs node.H
 	switch t := n.(type) {
 	case *node.VList:
@@ -486,7 +526,7 @@ func (cb *CSSBuilder) makeFootnoteSeparator(width bag.ScaledPoint) *node.VList {
 // state. Called by cb.NewPage() before shipout, and once at the end of
 // the final page in OutputPages / OutputPagesFromText.
 //
-// Painting order:
+// Painting order (positioned items in between, see paintPositionedItems):
 //  1. Top-floats at yStart, going down (placeFloatTopInserts).
 //  2. Buffered body entries (cb.pageBuf), starting just below the top
 //     float stack. Heading-index tracking happens here so the recorded
@@ -503,6 +543,17 @@ func (cb *CSSBuilder) flushInserts() error {
 		return err
 	}
 
+	// Absolutely positioned elements paint on the page their anchor
+	// landed on or the one -bag-position-page names. position: fixed
+	// elements repeat on every page. Positioned items with a negative
+	// z-index paint beneath everything in the flow.
+	pageNum := len(cb.frontend.Doc.Pages)
+	cb.queuePositioned(pageNum)
+	if err := cb.addFixedItems(pd); err != nil {
+		return err
+	}
+	cb.paintPositionedItems(true)
+
 	// Snapshot the top-float reservation height *before* placeFloatTopInserts
 	// clears it, so we know where the body cursor starts.
 	topFloatHeight := cb.pageInsertHeight[InsertFloatTop]
@@ -517,7 +568,6 @@ func (cb *CSSBuilder) flushInserts() error {
 	// acts as a content indent. Without @page border/padding these equal
 	// MarginLeft / MarginTop, so unpadded pages are unaffected.
 	yCursor := pd.Height - pd.PageAreaTop - topFloatHeight
-	pageNum := len(cb.frontend.Doc.Pages)
 	for _, entry := range cb.pageBuf {
 		cb.frontend.Doc.CurrentPage.OutputAt(pd.PageAreaLeft, yCursor, entry.box)
 		if entry.headingIdx >= 0 && entry.headingIdx < len(cb.Headings) {
@@ -539,10 +589,11 @@ func (cb *CSSBuilder) flushInserts() error {
 
 	// CSS 2.1 App. E: positioned descendants paint above in-flow
 	// non-positioned descendants and floats. Order within the page:
-	// top-floats → buffered body → positioned → bottom-floats →
-	// footnotes. Positioned items already carry resolved PDF
-	// coordinates, so paintPositionedItems just sorts and outputs.
-	cb.paintPositionedItems()
+	// positioned (z-index < 0) → top-floats → buffered body →
+	// positioned → bottom-floats → footnotes. Positioned items already
+	// carry resolved PDF coordinates, so paintPositionedItems just sorts
+	// and outputs.
+	cb.paintPositionedItems(false)
 
 	// Bottom-floats first (they need pageInsertHeight[InsertFootnote] to
 	// know their floor), then footnotes.
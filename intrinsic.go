@@ -0,0 +1,137 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// maxContentProbe is the width a box is built at to find its max-content
+// width: wide enough that only forced breaks end a line.
+var maxContentProbe = bag.MustSP("10000pt")
+
+// intrinsicWidths are the min-content and max-content widths of a box
+// (CSS Sizing 3 §5), border box widths like CSS width in this package.
+type intrinsicWidths struct {
+	min bag.ScaledPoint
+	max bag.ScaledPoint
+}
+
+// fit returns the fit-content width in the available width avail:
+// min(max-content, max(min-content, avail)).
+func (iw intrinsicWidths) fit(avail bag.ScaledPoint) bag.ScaledPoint {
+	return bag.Min(iw.max, bag.Max(iw.min, avail))
+}
+
+// intrinsicWidths measures the box te. The min-content width is the widest
+// line of a build as narrow as the padding and borders of te allow (every
+// line breaks at every opportunity and the overfull ones stick out), the
+// max-content width the widest line of a build at maxContentProbe.
+// Percentages in the subtree resolve against the probe widths (v1, CSS
+// treats them as auto here).
+func (cb *CSSBuilder) intrinsicWidths(te *frontend.Text) (intrinsicWidths, error) {
+	frame := boxFrameWidth(te)
+	narrow := frame + bag.MustSP("1pt")
+	vl, err := cb.measureItem(te, narrow)
+	if err != nil {
+		return intrinsicWidths{}, err
+	}
+	iw := intrinsicWidths{min: bag.Max(maxContentWidth(vl, narrow), frame)}
+	if vl, err = cb.measureItem(te, maxContentProbe); err != nil {
+		return intrinsicWidths{}, err
+	}
+	iw.max = bag.Max(maxContentWidth(vl, maxContentProbe), iw.min)
+	return iw, nil
+}
+
+// isIntrinsicWidth reports whether the CSS width v is one of the intrinsic
+// sizing keywords min-content, max-content, fit-content or
+// fit-content(<length-percentage>).
+func isIntrinsicWidth(v string) bool {
+	switch v {
+	case "min-content", "max-content", "fit-content":
+		return true
+	}
+	return strings.HasPrefix(v, "fit-content(")
+}
+
+// intrinsicWidth resolves the intrinsic sizing keyword v of the box te in
+// the available width avail. fit-content(<length-percentage>) uses the
+// length, or the percentage of avail, instead of avail.
+func (cb *CSSBuilder) intrinsicWidth(te *frontend.Text, v string, avail bag.ScaledPoint) (bag.ScaledPoint, error) {
+	iw, err := cb.intrinsicWidths(te)
+	if err != nil {
+		return 0, err
+	}
+	switch v {
+	case "min-content":
+		return iw.min, nil
+	case "max-content":
+		return iw.max, nil
+	case "fit-content":
+		return iw.fit(avail), nil
+	}
+	wd, ok := parseFitContentArg(fitContentArg(v), avail, 0, 0)
+	if !ok {
+		bag.Logger.Warn("invalid fit-content() width", "value", v)
+		return iw.fit(avail), nil
+	}
+	return iw.fit(wd), nil
+}
+
+// fitContentArg returns the argument of the width fit-content(arg).
+func fitContentArg(v string) string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(v, "fit-content("), ")"))
+}
+
+// parseFitContentArg parses the <length-percentage> argument of
+// fit-content(): a percentage of avail, a length in em or rem of fontsize
+// or rootFontsize, or an absolute length. Negative and malformed values are
+// not ok.
+func parseFitContentArg(arg string, avail, fontsize, rootFontsize bag.ScaledPoint) (bag.ScaledPoint, bool) {
+	if arg == "0" {
+		return 0, true
+	}
+	for _, u := range []struct {
+		suffix string
+		ref    bag.ScaledPoint
+		scale  float64
+	}{{"%", avail, 0.01}, {"rem", rootFontsize, 1}, {"em", fontsize, 1}} {
+		if p, ok := strings.CutSuffix(arg, u.suffix); ok {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil || f < 0 {
+				return 0, false
+			}
+			return bag.MultiplyFloat(u.ref, f*u.scale), true
+		}
+	}
+	wd, err := bag.SP(arg)
+	if err != nil || wd < 0 {
+		return 0, false
+	}
+	return wd, true
+}
+
+// resolveFitContentWidth resolves an em or rem argument of the width
+// fit-content() in the font sizes of ih, the element is measured later
+// without its styles. A percentage stays, it needs the available width. A
+// malformed argument is dropped with a warning, the box gets the
+// fit-content width.
+func resolveFitContentWidth(v string, ih *FormattingStyles) string {
+	if !strings.HasPrefix(v, "fit-content(") {
+		return v
+	}
+	arg := fitContentArg(v)
+	wd, ok := parseFitContentArg(arg, 0, ih.Fontsize, ih.DefaultFontSize)
+	switch {
+	case !ok:
+		bag.Logger.Warn("invalid fit-content() width", "value", v)
+		return "fit-content"
+	case strings.HasSuffix(arg, "em"):
+		return fmt.Sprintf("fit-content(%gpt)", wd.ToPT())
+	}
+	return v
+}
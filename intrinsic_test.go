@@ -0,0 +1,136 @@
+package htmlbag
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// glyphX returns the left end of the first glyph of the line holding
+// needle, after the glue of a centered or right aligned line.
+func glyphX(pg *document.Page, needle string) (bag.ScaledPoint, bool) {
+	x, _, hl, ok := findLine(pg, needle)
+	if !ok {
+		return 0, false
+	}
+	for n := hl.List; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.Glyph:
+			return x, true
+		case *node.Glue:
+			x += v.Width
+		case *node.Kern:
+			x += v.Kern
+		case *node.HList:
+			x += v.Width
+		}
+	}
+	return x, true
+}
+
+// TestWidthMaxContent: a max-content box hugs its text, right aligned text
+// starts at the left edge of the box, inside its border and padding.
+func TestWidthMaxContent(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.m { width: max-content; text-align: right; }
+.p { width: max-content; text-align: right; border: 1pt solid black; padding: 0 10pt; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="m">KURZ</div><div class="p">GERAHMT</div></body></html>`)
+	left := bag.MustSP("20mm")
+	if x, ok := glyphX(pages[0], "KURZ"); !ok || !nearly(x, left) {
+		t.Errorf("KURZ at x=%s, want %s", x, left)
+	}
+	// Padding and border, how far the frame reaches depends on where it
+	// paints the border.
+	if x, ok := glyphX(pages[0], "GERAHMT"); !ok || x < left+bag.MustSP("10pt") || x > left+bag.MustSP("11pt")+4 {
+		t.Errorf("GERAHMT at x=%s, want %s plus padding and border", x, left)
+	}
+}
+
+// TestWidthMinContent: a min-content box breaks at every opportunity.
+func TestWidthMinContent(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.n { width: min-content; }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="n">ERSTES ZWEITES</div></body></html>`)
+	x1, y1, ok1 := textPos(pages[0], "ERSTES")
+	x2, y2, ok2 := textPos(pages[0], "ZWEITES")
+	if !ok1 || !ok2 {
+		t.Fatal("words not found")
+	}
+	if x1 != x2 || y2 >= y1 {
+		t.Errorf("words at %s/%s and %s/%s, want one per line", x1, y1, x2, y2)
+	}
+}
+
+// TestWidthFitContent: a fit-content box hugs short text and takes the
+// available width for text that does not fit on one line.
+func TestWidthFitContent(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.f { width: fit-content; text-align: right; }`
+	long := "ANFANG lorem ipsum dolor sit amet consectetur adipisici elit sed eiusmod tempor incidunt ut labore et dolore magna aliqua ut enim ad minim veniam ENDE"
+	pages := renderHTMLPages(t, css, `<html><body><div class="f">KURZ</div><div class="f">`+long+`</div></body></html>`)
+	left := bag.MustSP("20mm")
+	if x, ok := glyphX(pages[0], "KURZ"); !ok || !nearly(x, left) {
+		t.Errorf("KURZ at x=%s, want %s", x, left)
+	}
+	_, ay, ok1 := textPos(pages[0], "ANFANG")
+	_, ey, ok2 := textPos(pages[0], "ENDE")
+	if !ok1 || !ok2 || ey >= ay {
+		t.Errorf("long text at y=%s and %s, want it to wrap", ay, ey)
+	}
+}
+
+// TestWidthFitContentEm: fit-content(<em>) limits the box to the length in
+// the font size of the element, it wraps like a box of that width.
+func TestWidthFitContentEm(t *testing.T) {
+	long := "ANFANG lorem ipsum dolor sit amet consectetur adipisici elit sed eiusmod tempor ENDE"
+	depth := func(css string) bag.ScaledPoint {
+		pages := renderHTMLPages(t, "@page { size: a4; margin: 20mm; }\n"+css, `<html><body><div class="f">`+long+`</div></body></html>`)
+		_, ay, ok1 := textPos(pages[0], "ANFANG")
+		_, ey, ok2 := textPos(pages[0], "ENDE")
+		if !ok1 || !ok2 {
+			t.Fatal("words not found")
+		}
+		return ay - ey
+	}
+	fit := depth(`.f { font-size: 10pt; width: fit-content(10em); }`)
+	fixed := depth(`.f { font-size: 10pt; width: 100pt; }`)
+	if fit == 0 || fit != fixed {
+		t.Errorf("fit-content(10em) wraps %s deep, a 100pt box %s", fit, fixed)
+	}
+}
+
+// TestParseFitContentArg covers the lengths and percentages of
+// fit-content().
+func TestParseFitContentArg(t *testing.T) {
+	avail, fs, root := bag.MustSP("200pt"), bag.MustSP("10pt"), bag.MustSP("12pt")
+	cases := []struct {
+		in string
+		ok bool
+		wd bag.ScaledPoint
+	}{
+		{in: "50%", ok: true, wd: bag.MustSP("100pt")},
+		{in: "3em", ok: true, wd: bag.MustSP("30pt")},
+		{in: "2rem", ok: true, wd: bag.MustSP("24pt")},
+		{in: "40pt", ok: true, wd: bag.MustSP("40pt")},
+		{in: "0", ok: true, wd: 0},
+		{in: "-1em", ok: false},
+		{in: "xem", ok: false},
+		{in: "wide", ok: false},
+		{in: "", ok: false},
+	}
+	for _, tc := range cases {
+		wd, ok := parseFitContentArg(tc.in, avail, fs, root)
+		if ok != tc.ok || ok && wd != tc.wd {
+			t.Errorf("parseFitContentArg(%q) = %s, %v, want %s, %v", tc.in, wd, ok, tc.wd, tc.ok)
+		}
+	}
+	ih := &FormattingStyles{Fontsize: fs, DefaultFontSize: root}
+	if got := resolveFitContentWidth("fit-content(2em)", ih); got != "fit-content(20pt)" {
+		t.Errorf("resolveFitContentWidth(fit-content(2em)) = %q", got)
+	}
+	if got := resolveFitContentWidth("fit-content(wide)", ih); got != "fit-content" {
+		t.Errorf("resolveFitContentWidth(fit-content(wide)) = %q, want fit-content", got)
+	}
+}
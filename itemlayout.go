@@ -0,0 +1,319 @@
+package htmlbag
+
+import (
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// childLayout is the layout of a container that places its children itself
+// (display: flex, display: grid). buildVlistInternal builds every child
+// through its regular child loop, at the width item reports and with the
+// settings prepared by layoutItem.prepare, then arrange replaces the
+// stacked children of the container by the laid out ones.
+type childLayout interface {
+	item(t *frontend.Text) *layoutItem
+	arrange(vls *node.VList, shift bag.ScaledPoint)
+}
+
+// layoutItem is a child of a flex or grid container during layout. width is
+// the border box width the item is built at, height a stretched content
+// height (0 = natural), vl the item once the child loop has built it.
+type layoutItem struct {
+	t              *frontend.Text
+	ml, mr, mt, mb bag.ScaledPoint
+	width          bag.ScaledPoint
+	height         bag.ScaledPoint
+	vl             *node.VList
+}
+
+// newLayoutItem returns the layout item of the child t with its margins.
+func newLayoutItem(t *frontend.Text) layoutItem {
+	li := layoutItem{t: t}
+	li.ml, _ = t.Settings[frontend.SettingMarginLeft].(bag.ScaledPoint)
+	li.mr, _ = t.Settings[frontend.SettingMarginRight].(bag.ScaledPoint)
+	li.mt, _ = t.Settings[frontend.SettingMarginTop].(bag.ScaledPoint)
+	li.mb, _ = t.Settings[frontend.SettingMarginBottom].(bag.ScaledPoint)
+	return li
+}
+
+// layoutChildren returns the children of te the child loop of
+// buildVlistInternal builds, in source order.
+func layoutChildren(te *frontend.Text) []*frontend.Text {
+	var children []*frontend.Text
+	for _, itm := range te.Items {
+		t, ok := itm.(*frontend.Text)
+		if !ok {
+			continue
+		}
+		// Same test as the child loop.
+		if _, hasTag := t.Settings[frontend.SettingDebug]; !hasTag && isWhitespaceOnly(t) {
+			continue
+		}
+		children = append(children, t)
+	}
+	return children
+}
+
+// prepare readies the item for its build by the child loop: the layout
+// decides the width, and a stretched item gets its height as a CSS height.
+// The returned function restores the settings.
+func (li *layoutItem) prepare() func() {
+	sWd, hasWd := li.t.Settings[frontend.SettingWidth]
+	delete(li.t.Settings, frontend.SettingWidth)
+	if li.height > 0 {
+		li.t.Settings[settingCSSHeight] = li.height
+	}
+	return func() {
+		if hasWd {
+			li.t.Settings[frontend.SettingWidth] = sWd
+		}
+		if li.height > 0 {
+			delete(li.t.Settings, settingCSSHeight)
+		}
+	}
+}
+
+// stretch sets the content height that makes the item fill an area of the
+// given height, margins included. Items with a CSS height keep it.
+func (li *layoutItem) stretch(area bag.ScaledPoint) {
+	if _, ok := li.t.Settings[settingCSSHeight]; ok {
+		return
+	}
+	hv := settingsToHTMLValues(li.t.Settings)
+	li.height = bag.Max(area-li.mt-li.mb-hv.PaddingTop-hv.PaddingBottom-hv.BorderTopWidth-hv.BorderBottomWidth, 0)
+}
+
+// outerHeight returns the height of the built item including its margins.
+func (li *layoutItem) outerHeight() bag.ScaledPoint {
+	if li.vl == nil {
+		return 0
+	}
+	return li.vl.Height + li.vl.Depth + li.mt + li.mb
+}
+
+// itemWidth resolves the CSS width v of the flex or grid item t, a
+// percentage of ref. The intrinsic sizing keywords measure the item.
+func (cb *CSSBuilder) itemWidth(t *frontend.Text, v string, ref, fontsize, rootFontsize bag.ScaledPoint) (bag.ScaledPoint, error) {
+	if isIntrinsicWidth(v) {
+		return cb.intrinsicWidth(t, v, ref)
+	}
+	return flexLength(v, ref, fontsize, rootFontsize), nil
+}
+
+// boxFrameWidth returns the horizontal padding and border of the box te,
+// the narrowest a flex or grid item can get.
+func boxFrameWidth(te *frontend.Text) bag.ScaledPoint {
+	hv := settingsToHTMLValues(te.Settings)
+	return hv.PaddingLeft + hv.PaddingRight + hv.BorderLeftWidth + hv.BorderRightWidth
+}
+
+// takenMarkers are the footnotes, floats, positioned elements and inline
+// anchors of the items of a flex or grid container. They are taken out
+// before the items are measured (a measuring build would consume them) and
+// travel on the first box of the container.
+type takenMarkers struct {
+	inserts []*Insert
+	anchors []int
+}
+
+// take removes the markers from the item t.
+func (cb *CSSBuilder) takeMarkers(t *frontend.Text, wd bag.ScaledPoint, tm *takenMarkers) error {
+	fns, err := cb.extractFootnotes(t, wd)
+	if err != nil {
+		return err
+	}
+	tm.inserts = append(tm.inserts, fns...)
+	for _, class := range []InsertClass{InsertFloatTop, InsertFloatBottom} {
+		fls, err := cb.extractFloats(t, wd, class)
+		if err != nil {
+			return err
+		}
+		tm.inserts = append(tm.inserts, fls...)
+	}
+	tm.inserts = append(tm.inserts, extractPositionedMarkers(t)...)
+	tm.anchors = append(tm.anchors, extractAnchorMarkers(t)...)
+	return nil
+}
+
+// attach puts the markers on the first box of nodes.
+func (tm *takenMarkers) attach(nodes []node.Node) {
+	for _, n := range nodes {
+		vl, ok := n.(*node.VList)
+		if !ok {
+			continue
+		}
+		addInsertsAttr(vl, tm.inserts)
+		if len(tm.anchors) > 0 {
+			if vl.Attributes == nil {
+				vl.Attributes = node.H{}
+			}
+			existing, _ := vl.Attributes["_anchor_indices"].([]int)
+			vl.Attributes["_anchor_indices"] = append(existing, tm.anchors...)
+		}
+		return
+	}
+}
+
+// replaceChildren makes nodes the children of the container vls. The items
+// are detached from the list the child loop built first.
+func replaceChildren(vls *node.VList, items []*layoutItem, nodes []node.Node, wd bag.ScaledPoint) {
+	for _, li := range items {
+		if li.vl != nil {
+			li.vl.SetPrev(nil)
+			li.vl.SetNext(nil)
+		}
+	}
+	vls.List = nil
+	vls.Height, vls.Depth = 0, 0
+	vls.Width = wd
+	for _, n := range nodes {
+		vls.List = node.InsertAfter(vls.List, node.Tail(vls.List), n)
+		switch v := n.(type) {
+		case *node.Kern:
+			vls.Height += v.Kern
+		case *node.VList:
+			vls.Height += v.Height + v.Depth
+		}
+	}
+}
+
+// measureItem builds the item te at width wd detached from the document:
+// like a reflow rebuild it registers no headings, anchors or element
+// callbacks, and it adds nothing to the structure tree. Measuring builds
+// are thrown away; an inline-block is built this way as well, its deferred
+// formatter may run more than once.
+func (cb *CSSBuilder) measureItem(te *frontend.Text, wd bag.ScaledPoint) (*node.VList, error) {
+	rebuild, tagging := cb.reflowRebuild, cb.enableTagging
+	cb.reflowRebuild, cb.enableTagging = true, false
+	defer func() {
+		cb.reflowRebuild, cb.enableTagging = rebuild, tagging
+	}()
+	// The bookmark sentinel must not reach FormatParagraph, the child
+	// loop of buildVlistInternal strips it the same way.
+	bm, hasBM := te.Settings[settingBookmark]
+	delete(te.Settings, settingBookmark)
+	sWd, hasWd := te.Settings[frontend.SettingWidth]
+	delete(te.Settings, frontend.SettingWidth)
+	defer func() {
+		if hasBM {
+			te.Settings[settingBookmark] = bm
+		}
+		if hasWd {
+			te.Settings[frontend.SettingWidth] = sWd
+		}
+	}()
+	if dbg, _ := te.Settings[frontend.SettingDebug].(string); dbg == "table" {
+		vl, err := cb.buildTable(te, wd)
+		if err != nil {
+			return nil, err
+		}
+		return withCaption(vl, tableCaptionOf(vl)), nil
+	}
+	return cb.buildVlistInternal(te, wd)
+}
+
+// maxContentWidth returns the max-content width of a box that was built at
+// width wd: wd less the smallest slack of its lines. A box without lines
+// has no content width.
+func maxContentWidth(vl *node.VList, wd bag.ScaledPoint) bag.ScaledPoint {
+	slack, ok := lineSlack(vl)
+	if !ok {
+		return 0
+	}
+	return bag.Max(wd-slack, 0)
+}
+
+// lineSlack returns the smallest difference between the width of a line
+// and the natural width of its contents in the subtree of n. An HList
+// holding boxes (the HTMLBorder frame) is not a line, its boxes are
+// searched instead.
+func lineSlack(n node.Node) (bag.ScaledPoint, bool) {
+	var least bag.ScaledPoint
+	found := false
+	take := func(s bag.ScaledPoint, ok bool) {
+		if ok && (!found || s < least) {
+			least, found = s, true
+		}
+	}
+	switch v := n.(type) {
+	case *node.VList:
+		for c := v.List; c != nil; c = c.Next() {
+			take(lineSlack(c))
+		}
+	case *node.HList:
+		var natural bag.ScaledPoint
+		frame := false
+		for c := v.List; c != nil; c = c.Next() {
+			switch w := c.(type) {
+			case *node.VList:
+				frame = true
+				take(lineSlack(w))
+			case *node.Glyph:
+				natural += w.Width
+			case *node.Glue:
+				natural += w.Width
+			case *node.Kern:
+				natural += w.Kern
+			case *node.HList:
+				natural += w.Width
+			case *node.Rule:
+				natural += w.Width
+			case *node.Image:
+				natural += w.Width
+			}
+		}
+		if !frame {
+			take(v.Width-natural, true)
+		}
+	}
+	return least, found
+}
+
+// liftItemAttributes moves the heading and anchor registrations and the
+// footnotes and floats of an item to the box that holds it at the top
+// level: the page builder only looks at the top level boxes. Only the
+// first heading of a box enters the page number bookkeeping.
+func liftItemAttributes(box, item *node.VList) {
+	if item.Attributes == nil {
+		return
+	}
+	if box.Attributes == nil {
+		box.Attributes = node.H{}
+	}
+	if idx, ok := item.Attributes["_heading_idx"].(int); ok {
+		if _, taken := box.Attributes["_heading_idx"]; !taken {
+			box.Attributes["_heading_idx"] = idx
+			delete(item.Attributes, "_heading_idx")
+		}
+	}
+	var anchors []int
+	if idx, ok := item.Attributes["_anchor_idx"].(int); ok {
+		anchors = append(anchors, idx)
+		delete(item.Attributes, "_anchor_idx")
+	}
+	if list, ok := item.Attributes["_anchor_indices"].([]int); ok {
+		anchors = append(anchors, list...)
+		delete(item.Attributes, "_anchor_indices")
+	}
+	if len(anchors) > 0 {
+		existing, _ := box.Attributes["_anchor_indices"].([]int)
+		box.Attributes["_anchor_indices"] = append(existing, anchors...)
+	}
+	// Positioned inserts are found below the box anyway.
+	var own []*Insert
+	for _, ins := range nodeInserts(item) {
+		if ins.Class != InsertPositioned {
+			own = append(own, ins)
+		}
+	}
+	addInsertsAttr(box, own)
+}
+
+// layoutKern returns a kern with the given origin.
+func layoutKern(k bag.ScaledPoint, origin string) *node.Kern {
+	kern := node.NewKern()
+	kern.Kern = k
+	kern.Attributes = node.H{"origin": origin}
+	return kern
+}
@@ -0,0 +1,276 @@
+package htmlbag
+
+import (
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// lineClamp is the truncation request carried by settingLineClamp. A zero
+// maxLines or maxHeight means "no limit" on that axis.
+type lineClamp struct {
+	maxLines  int             // max-lines / -webkit-line-clamp
+	maxHeight bag.ScaledPoint // max-height, only when overflow hides the rest
+	ellipsis  bool            // text-overflow: ellipsis (or -webkit-line-clamp)
+}
+
+// applyLineClamp truncates vl in place to the line and height limits of lc
+// and, when requested, ends the last visible line with an ellipsis. The cut
+// happens at line granularity: a line that does not fit completely below
+// max-height is dropped, and applyCSSHeight later pads the box back up to
+// max-height (the used height of an overflowing box, CSS 2.1 §10.7).
+// settings provides the font for the ellipsis. Reports whether anything was
+// cut, so the caller can keep the truncated box out of the page splitter.
+func (cb *CSSBuilder) applyLineClamp(vl *node.VList, lc lineClamp, settings frontend.TypesettingSettings) (bool, error) {
+	_, last, parent, cut := clampVList(vl, lc.maxLines, lc.maxHeight)
+	if !cut {
+		return false, nil
+	}
+	if lc.ellipsis && last != nil {
+		if err := cb.ellipsizeLine(parent, last, settings); err != nil {
+			return true, err
+		}
+	}
+	if lc.maxHeight > 0 {
+		applyCSSHeight(vl, lc.maxHeight)
+	}
+	return true, nil
+}
+
+// clampVList cuts vl's list after maxLines line boxes or before the first
+// node that would cross maxHeight (0 = unlimited each). Nested VLists
+// (child blocks of a box container) are clamped recursively against the
+// remaining budget; only paragraph lines (origin "line") count as lines,
+// other HLists such as bordered child boxes stay atomic. It returns the
+// number of lines kept, the last kept line together with the VList holding
+// it, and whether anything was cut.
+func clampVList(vl *node.VList, maxLines int, maxHeight bag.ScaledPoint) (lines int, last *node.HList, parent *node.VList, cut bool) {
+	var sum bag.ScaledPoint
+	var prev node.Node
+	for n := vl.List; n != nil; n = n.Next() {
+		var ht bag.ScaledPoint
+		switch v := n.(type) {
+		case *node.HList:
+			isLine := v.Attributes["origin"] == "line"
+			ht = v.Height + v.Depth
+			if (isLine && maxLines > 0 && lines == maxLines) || (maxHeight > 0 && sum+ht > maxHeight) {
+				cut = true
+				break
+			}
+			if isLine {
+				lines++
+				last, parent = v, vl
+			}
+		case *node.VList:
+			var remLines int
+			var remHeight bag.ScaledPoint
+			if maxLines > 0 {
+				if remLines = maxLines - lines; remLines == 0 {
+					cut = true
+					break
+				}
+			}
+			if maxHeight > 0 {
+				if remHeight = maxHeight - sum; remHeight <= 0 {
+					cut = true
+					break
+				}
+			}
+			l, lst, par, c := clampVList(v, remLines, remHeight)
+			if c && l == 0 {
+				// Nothing of the child fits: drop it entirely.
+				cut = true
+				break
+			}
+			lines += l
+			if lst != nil {
+				last, parent = lst, par
+			}
+			ht = v.Height + v.Depth
+			if c {
+				// The child was cut short, so everything after it goes.
+				// Its split snapshot still holds the cut nodes.
+				delete(v.Attributes, "_splittable")
+				sum += ht
+				prev = n
+				cut = true
+			}
+		default:
+			ht = verticalExtent(n)
+		}
+		if cut {
+			break
+		}
+		if maxHeight > 0 && ht > 0 && sum+ht > maxHeight {
+			cut = true
+			break
+		}
+		sum += ht
+		prev = n
+	}
+	if !cut {
+		return lines, last, parent, false
+	}
+	if prev == nil {
+		vl.List = nil
+	} else {
+		prev.SetNext(nil)
+	}
+	// Re-measure the kept list. Boxes contribute height and depth; the
+	// depth of the last box stays the VList's depth, matching how the box
+	// branch of buildVlistInternal accumulates its children.
+	var total, depth bag.ScaledPoint
+	for n := vl.List; n != nil; n = n.Next() {
+		depth = 0
+		switch v := n.(type) {
+		case *node.HList:
+			depth = v.Depth
+		case *node.VList:
+			depth = v.Depth
+		}
+		total += verticalExtent(n)
+	}
+	vl.Height = total - depth
+	vl.Depth = depth
+	return lines, last, parent, true
+}
+
+// verticalExtent returns the space n takes up in a vertical list.
+func verticalExtent(n node.Node) bag.ScaledPoint {
+	switch v := n.(type) {
+	case *node.HList:
+		return v.Height + v.Depth
+	case *node.VList:
+		return v.Height + v.Depth
+	case *node.Kern:
+		return v.Kern
+	case *node.Glue:
+		return v.Width
+	case *node.Rule:
+		return v.Height + v.Depth
+	}
+	return 0
+}
+
+// ellipsizeLine replaces line (a child of parent) by a line of the same
+// width that ends in "…": trailing content is dropped until the ellipsis
+// fits, trailing spaces and a dangling hyphen go too, and the rest is set
+// at its natural width. Zero-width start/stop nodes of the dropped tail are
+// kept so colour and link ranges stay balanced.
+func (cb *CSSBuilder) ellipsizeLine(parent *node.VList, line *node.HList, settings frontend.TypesettingSettings) error {
+	ell, err := cb.frontend.BuildNodelistFromString(ellipsisSettings(settings), "…")
+	if err != nil {
+		return err
+	}
+	ellBox := node.Hpack(ell)
+	avail := line.Width - ellBox.Width
+
+	var keep, marks []node.Node
+	var wd bag.ScaledPoint
+	n := line.List
+	for ; n != nil; n = n.Next() {
+		var w bag.ScaledPoint
+		switch v := n.(type) {
+		case *node.Glyph:
+			w = v.Width
+		case *node.Glue:
+			w = v.Width
+		case *node.Kern:
+			w = v.Kern
+		case *node.HList:
+			w = v.Width
+		case *node.VList:
+			w = v.Width
+		case *node.Rule:
+			w = v.Width
+		}
+		if wd+w > avail {
+			break
+		}
+		wd += w
+		keep = append(keep, n)
+	}
+	for ; n != nil; n = n.Next() {
+		if ss, ok := n.(*node.StartStop); ok {
+			marks = append(marks, ss)
+		}
+	}
+trim:
+	for len(keep) > 0 {
+		switch v := keep[len(keep)-1].(type) {
+		case *node.Glue, *node.Kern, *node.Penalty, *node.Disc:
+		case *node.Glyph:
+			if v.Components != "-" && v.Components != "\u00ad" {
+				break trim
+			}
+		default:
+			break trim
+		}
+		keep = keep[:len(keep)-1]
+	}
+
+	fill := node.NewGlue()
+	fill.Stretch = 1 * bag.Factor
+	fill.StretchOrder = node.StretchFil
+	keep = append(keep, ellBox)
+	keep = append(keep, marks...)
+	keep = append(keep, fill)
+	var head, tail node.Node
+	for _, k := range keep {
+		k.SetPrev(nil)
+		k.SetNext(nil)
+		if tail == nil {
+			head = k
+		} else {
+			tail.SetNext(k)
+			k.SetPrev(tail)
+		}
+		tail = k
+	}
+	newLine := node.HpackTo(head, line.Width)
+	// Keep the line's leading: the ellipsis must not change the line
+	// height or the box would no longer match its clamped size.
+	newLine.Height = line.Height
+	newLine.Depth = line.Depth
+	newLine.Attributes = line.Attributes
+
+	next := line.Next()
+	if prev := line.Prev(); prev != nil {
+		prev.SetNext(newLine)
+		newLine.SetPrev(prev)
+	} else {
+		parent.List = newLine
+	}
+	if next != nil {
+		newLine.SetNext(next)
+		next.SetPrev(newLine)
+	}
+	return nil
+}
+
+// ellipsisSettings picks the font-related entries of a block's settings for
+// typesetting the ellipsis glyph. The block settings also carry box and
+// htmlbag-private entries that must not reach BuildNodelistFromString.
+func ellipsisSettings(settings frontend.TypesettingSettings) frontend.TypesettingSettings {
+	s := frontend.TypesettingSettings{}
+	if ff, ok := settings[frontend.SettingFontFamily].(*frontend.FontFamily); ok && ff != nil {
+		s[frontend.SettingFontFamily] = ff
+	}
+	if c, ok := settings[frontend.SettingColor].(*color.Color); ok && c != nil {
+		s[frontend.SettingColor] = c
+	}
+	for _, k := range []frontend.SettingType{
+		frontend.SettingSize,
+		frontend.SettingFontWeight,
+		frontend.SettingStyle,
+		frontend.SettingOpenTypeFeature,
+		frontend.SettingFontVariationSettings,
+		frontend.SettingLetterSpacing,
+	} {
+		if v, ok := settings[k]; ok {
+			s[k] = v
+		}
+	}
+	return s
+}
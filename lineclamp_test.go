@@ -0,0 +1,87 @@
+package htmlbag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+const lineClampCSS = `@page { size: a4; margin: 20mm; }
+p { margin: 0; font-size: 10pt; line-height: 12pt; }`
+
+// lineClampBody is long enough to fill many lines at A4 width; ENDWORT
+// only survives when nothing is cut.
+var lineClampBody = strings.Repeat("Katalogtext mit vielen Wörtern ", 60) + "ENDWORT"
+
+// TestWebkitLineClampEllipsis: -webkit-line-clamp: 2 keeps the first two
+// lines and ends the second one with an ellipsis; the rest of the text is
+// gone and the following flow moves up accordingly.
+func TestWebkitLineClampEllipsis(t *testing.T) {
+	css := lineClampCSS + `
+.clamp { -webkit-line-clamp: 2; }`
+	html := `<html><body><p class="clamp">ANFANG ` + lineClampBody + `</p><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	text := pageText(pages[0])
+	if strings.Contains(text, "ENDWORT") {
+		t.Error("clamped paragraph still shows its last word")
+	}
+	if !strings.Contains(text, "…") {
+		t.Error("clamped paragraph has no ellipsis")
+	}
+	top := lineTopY(pages[0], "ANFANG")
+	next := lineTopY(pages[0], "NACHHER")
+	if got, want := top-next, bag.MustSP("24pt"); got != want {
+		t.Errorf("following paragraph starts %s below the clamped one, want %s (two lines)", got, want)
+	}
+}
+
+// TestMaxLinesWithoutEllipsis: max-lines alone truncates without adding an
+// ellipsis (text-overflow defaults to clip).
+func TestMaxLinesWithoutEllipsis(t *testing.T) {
+	css := lineClampCSS + `
+.clamp { max-lines: 1; }`
+	html := `<html><body><p class="clamp">ANFANG ` + lineClampBody + `</p><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	text := pageText(pages[0])
+	if strings.Contains(text, "ENDWORT") || strings.Contains(text, "…") {
+		t.Errorf("max-lines: 1 must cut without an ellipsis, page text: %.80q…", text)
+	}
+	top := lineTopY(pages[0], "ANFANG")
+	next := lineTopY(pages[0], "NACHHER")
+	if got, want := top-next, bag.MustSP("12pt"); got != want {
+		t.Errorf("following paragraph starts %s below the clamped one, want %s (one line)", got, want)
+	}
+}
+
+// TestMaxHeightOverflowHidden: max-height with overflow: hidden keeps the
+// lines that fit completely and reserves exactly max-height in the flow.
+// Without overflow: hidden the content keeps its natural size.
+func TestMaxHeightOverflowHidden(t *testing.T) {
+	css := lineClampCSS + `
+.box { max-height: 30pt; overflow: hidden; text-overflow: ellipsis; }
+.visible { max-height: 30pt; }`
+	html := `<html><body><p class="box">ANFANG ` + lineClampBody + `</p><p>NACHHER</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	text := pageText(pages[0])
+	if strings.Contains(text, "ENDWORT") {
+		t.Error("overflow: hidden paragraph still shows its last word")
+	}
+	if !strings.Contains(text, "…") {
+		t.Error("text-overflow: ellipsis missing on the last visible line")
+	}
+	top := lineTopY(pages[0], "ANFANG")
+	next := lineTopY(pages[0], "NACHHER")
+	if got, want := top-next, bag.MustSP("30pt"); got != want {
+		t.Errorf("following paragraph starts %s below the box, want %s (max-height)", got, want)
+	}
+
+	html = `<html><body><p class="visible">ANFANG ` + lineClampBody + `</p><p>NACHHER</p></body></html>`
+	pages = renderHTMLPages(t, css, html)
+	if !strings.Contains(pageText(pages[0]), "ENDWORT") {
+		t.Error("max-height without overflow: hidden must not cut content")
+	}
+}
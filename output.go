@@ -185,6 +185,9 @@ func firstContentElement(tokens []csshtml.ContentToken) string {
 func (cb *CSSBuilder) BeforeShipout() error {
 	var err error
 	df := cb.frontend
+	// Translucent content (margin boxes included) refers to ExtGStates by
+	// name; the page needs them in its resources.
+	defer cb.addPageExtGStates(df.Doc.CurrentPage)
 	dimensions := cb.currentPageDimensions
 	mp := dimensions.masterpage
 	if mp != nil {
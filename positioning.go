@@ -2,6 +2,7 @@ package htmlbag
 
 import (
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/boxesandglue/boxesandglue/backend/bag"
@@ -10,10 +11,11 @@ import (
 )
 
 // PositionedInsert is one CSS-positioned element fully resolved to PDF
-// page coordinates. Stored in cb.positionedItems for the current page;
-// painted in flushInserts between the buffered body and the bottom-
-// floats so positioned content overlays in-flow content per CSS 2.1
-// Appendix E.
+// page coordinates. It travels through the flow as an InsertPositioned
+// until the page of its anchor is known (see schedulePositioned), then
+// waits in cb.positionedItems for that page and is painted in
+// flushInserts between the buffered body and the bottom-floats so
+// positioned content overlays in-flow content per CSS 2.1 Appendix E.
 //
 // X/Y is the PDF top-left anchor expected by Page.OutputAt (y measured
 // from the page bottom upward). Width/Height are the resolved content
@@ -26,13 +28,69 @@ type PositionedInsert struct {
 	Height      bag.ScaledPoint
 	ZIndex      int
 	SourceOrder int
+
+	// pageOffset moves the element that many pages past the page its
+	// anchor lands on (-bag-position-page: next).
+	pageOffset int
+	// nested are the absolutely positioned descendants that resolved
+	// against Body's box; they go to the same page.
+	nested []*Insert
+}
+
+// positionedMarker is a sentinel placed in frontend.Text.Items by Output()
+// where a position: absolute element sits in the flow. buildVlistInternal
+// turns it into an InsertPositioned on the block that follows it (on the
+// paragraph, for a marker inside one), so the element is painted on the
+// page where that block starts: the containing block of the page box
+// exists once per page, and which one the element belongs to is only
+// known after pagination.
+type positionedMarker struct {
+	pi *PositionedInsert
+}
+
+// extractPositionedMarkers walks te.Items, removes every positionedMarker
+// (recursing into nested *frontend.Text) and returns them as inserts in
+// document order. Used by the leaf branch of buildVlistInternal, the box
+// branch picks up its direct markers itself.
+func extractPositionedMarkers(te *frontend.Text) []*Insert {
+	var out []*Insert
+	var walk func(t *frontend.Text)
+	walk = func(t *frontend.Text) {
+		kept := make([]any, 0, len(t.Items))
+		for _, itm := range t.Items {
+			switch v := itm.(type) {
+			case positionedMarker:
+				out = append(out, &Insert{Class: InsertPositioned, Positioned: v.pi})
+			case *frontend.Text:
+				walk(v)
+				kept = append(kept, v)
+			default:
+				kept = append(kept, itm)
+			}
+		}
+		t.Items = kept
+	}
+	walk(te)
+	return out
+}
+
+// addInsertsAttr appends ins to the inserts attribute of vl.
+func addInsertsAttr(vl *node.VList, ins []*Insert) {
+	if len(ins) == 0 {
+		return
+	}
+	if vl.Attributes == nil {
+		vl.Attributes = node.H{}
+	}
+	existing, _ := vl.Attributes["inserts"].([]*Insert)
+	vl.Attributes["inserts"] = append(append([]*Insert{}, existing...), ins...)
 }
 
 // isPositionedElement reports whether an HTMLItem is taken out of flow
 // by the CSS positioning pipeline. Only `position: absolute` is
-// handled in v1; `relative` stays in flow (Phase 4 will treat that
-// with a different mechanism), and `fixed`/`sticky` are intentionally
-// unimplemented per the positioning plan.
+// handled here; `relative` stays in flow (see positionedBox), `fixed`
+// is captured for every page by captureFixedElement, and `sticky` is
+// intentionally unimplemented per the positioning plan.
 func isPositionedElement(item *HTMLItem) bool {
 	if item == nil {
 		return false
@@ -44,6 +102,16 @@ func isPositionedElement(item *HTMLItem) bool {
 	return false
 }
 
+// isFixedElement reports whether an HTMLItem has position: fixed. In
+// paged media such an element repeats on every page, positioned against
+// the page box (CSS 2.1 §9.6.1).
+func isFixedElement(item *HTMLItem) bool {
+	if item == nil {
+		return false
+	}
+	return strings.ToLower(strings.TrimSpace(item.Styles["position"])) == "fixed"
+}
+
 // runningElementName extracts the name from a `position: running(name)`
 // declaration (CSS GCPM running elements). Returns "" when the element
 // has no such declaration. The raw style value is inspected (not
@@ -82,6 +150,69 @@ func (cb *CSSBuilder) captureRunningElement(name string, item *HTMLItem, ss Styl
 	return nil
 }
 
+// fixedElement is an element captured by captureFixedElement: its styles
+// (offsets, size, z-index) and its body, formatted anew for every page.
+type fixedElement struct {
+	styles      *FormattingStyles
+	body        *frontend.Text
+	sourceOrder int
+}
+
+// captureFixedElement formats nothing and paints nothing: like
+// captureRunningElement it stores the element's body Text, which
+// addFixedItems lays out against the page box of every page. The
+// element is out of flow, so the caller must not add anything to the
+// parent's Items for this child. Its absolutely positioned descendants
+// travel with it (see positionedBox).
+func (cb *CSSBuilder) captureFixedElement(item *HTMLItem, ss StylesStack, df *frontend.Document, anchorPages map[string]int) error {
+	probe := ss.CurrentStyle().Clone()
+	if err := StylesToStyles(probe, item.Styles, df, ss.CurrentStyle().Fontsize); err != nil {
+		return err
+	}
+	own := &positionedBox{}
+	cb.pushPositioningContext(positioningContext{deferred: own})
+	body, err := Output(cb, item, ss, df, anchorPages)
+	cb.popPositioningContext()
+	if err != nil {
+		return err
+	}
+	if len(own.children) > 0 {
+		body.Settings[settingPositionedBox] = own
+	}
+	cb.fixedElements = append(cb.fixedElements, fixedElement{styles: probe, body: body, sourceOrder: cb.nextPositionedOrder()})
+	return nil
+}
+
+// addFixedItems queues the fixed elements for the current page, once per
+// page: flushInserts may run more than once before a page ships out.
+func (cb *CSSBuilder) addFixedItems(pd PageDimensions) error {
+	page := cb.frontend.Doc.CurrentPage
+	if len(cb.fixedElements) == 0 || page == nil || page == cb.fixedElementsPage {
+		return nil
+	}
+	cb.fixedElementsPage = page
+	parent := positioningContext{y: pd.Height, width: pd.Width, height: pd.Height, isPageRoot: true}
+	pageNum := len(cb.frontend.Doc.Pages)
+	for _, fe := range cb.fixedElements {
+		pi, err := cb.positionedInsert(fe.styles, fe.body, resolvePositionedRect(fe.styles, parent), parent, fe.sourceOrder)
+		if err != nil {
+			return err
+		}
+		cb.positionedItems = append(cb.positionedItems, pi)
+		for _, ins := range pi.nested {
+			cb.schedulePositioned(ins.Positioned, pageNum+ins.Positioned.pageOffset, pageNum)
+		}
+	}
+	return nil
+}
+
+// nextPositionedOrder numbers the absolutely positioned and fixed
+// elements in document order, the tiebreaker for equal z-indexes.
+func (cb *CSSBuilder) nextPositionedOrder() int {
+	cb.positionedOrder++
+	return cb.positionedOrder
+}
+
 // resolvePositionedRect runs the simplified CSS 2.1 §10.3.7 /
 // §10.6.4 width/height/offset resolution for a position: absolute
 // element. The full spec algorithm allows shrink-to-fit and circular
@@ -92,12 +223,11 @@ func (cb *CSSBuilder) captureRunningElement(name string, item *HTMLItem, ss Styl
 // pass.
 //
 // The returned struct carries the resolved content-box geometry in
-// PDF coordinates (y measured from page bottom upward). Height is 0
-// when neither an explicit height nor both top+bottom offsets are
+// the coordinates of parent (PDF orientation, y growing upward). Height
+// is 0 when neither an explicit height nor both top+bottom offsets are
 // set — the caller must overwrite it with the formatted body's
 // natural height before painting.
-func (cb *CSSBuilder) resolvePositionedRect(styles *FormattingStyles) positioningContext {
-	parent := cb.currentContainingBlock()
+func resolvePositionedRect(styles *FormattingStyles, parent positioningContext) positioningContext {
 	var rect positioningContext
 	// Width.
 	switch {
@@ -147,9 +277,18 @@ func (cb *CSSBuilder) resolvePositionedRect(styles *FormattingStyles) positionin
 
 // handlePositioned takes an HTMLItem with position: absolute, resolves
 // its geometry against the current containing block, formats the body
-// into a VList, and appends a PositionedInsert to cb.positionedItems
-// for painting at flush time. The element is out of flow — the caller
-// must NOT add anything to its parent's Items list for this child.
+// into a VList, and appends a positionedMarker for it to te, the Text
+// of the parent's in-flow content. The element is out of flow — the
+// caller must NOT add anything else to its parent's Items list for this
+// child.
+//
+// The containing block of the page box resolves the offsets in page
+// coordinates; the page they apply to is the one the marker's block
+// lands on. `-bag-position-page` overrides that for form-like layouts:
+// `next` takes the page after it, a page number places the element on
+// that page no matter where it is declared. With the property set the
+// element always resolves against the page box, even inside a
+// positioned ancestor.
 //
 // The containing-block stack is pushed with the element's own
 // resolved rectangle for the duration of the recursive Output() call
@@ -157,8 +296,9 @@ func (cb *CSSBuilder) resolvePositionedRect(styles *FormattingStyles) positionin
 // box. The push uses width as resolved and height=0 (or the explicit
 // override) since the body's natural height is not yet known —
 // percentage heights on grand-descendants resolve to 0 in that case,
-// which is the documented v1 limitation.
-func (cb *CSSBuilder) handlePositioned(item *HTMLItem, ss StylesStack, df *frontend.Document, anchorPages map[string]int) error {
+// which is the documented v1 limitation. Inside a relatively positioned
+// block the element is collected by deferPositioned instead.
+func (cb *CSSBuilder) handlePositioned(item *HTMLItem, te *frontend.Text, ss StylesStack, df *frontend.Document, anchorPages map[string]int) error {
 	// HTMLToText runs before OutputPagesFromText calls InitPage, so
 	// in the HTML pipeline the page dimensions are still zero-valued
 	// at marker-emit time. PageSize() is idempotent and primes
@@ -175,7 +315,18 @@ func (cb *CSSBuilder) handlePositioned(item *HTMLItem, ss StylesStack, df *front
 	if err := StylesToStyles(probe, item.Styles, df, ss.CurrentStyle().Fontsize); err != nil {
 		return err
 	}
-	rect := cb.resolvePositionedRect(probe)
+	parent := cb.currentContainingBlock()
+	offset, page, onPage := parsePositionPage(probe.positionPage)
+	switch {
+	case onPage:
+		parent = cb.pageContainingBlock()
+	case probe.positionPage != "":
+		bag.Logger.Warn("ignoring -bag-position-page", "value", probe.positionPage)
+	}
+	if parent.deferred != nil {
+		return cb.deferPositioned(item, probe, parent.deferred, ss, df, anchorPages)
+	}
+	rect := resolvePositionedRect(probe, parent)
 	// Push CB so nested positioned descendants resolve against this
 	// element's own box. Height is 0 here; descendants needing
 	// percentage heights will get 0 (v1 limitation).
@@ -190,10 +341,125 @@ func (cb *CSSBuilder) handlePositioned(item *HTMLItem, ss StylesStack, df *front
 	if err != nil {
 		return err
 	}
-	body, err := cb.CreateVlist(bodyText, rect.width)
+	pi, err := cb.positionedInsert(probe, bodyText, rect, parent, cb.nextPositionedOrder())
 	if err != nil {
 		return err
 	}
+	if page > 0 {
+		cb.schedulePositioned(pi, page, 0)
+		return nil
+	}
+	pi.pageOffset = offset
+	te.Items = append(te.Items, positionedMarker{pi: pi})
+	return nil
+}
+
+// parsePositionPage interprets a -bag-position-page value: `current` (the
+// page the element's anchor lands on, the default) and `next` return the
+// page offset 0 or 1, an integer returns the page number. ok is false for
+// an empty or invalid value.
+func parsePositionPage(v string) (offset, page int, ok bool) {
+	switch v {
+	case "current":
+		return 0, 0, true
+	case "next":
+		return 1, 0, true
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return 0, n, true
+	}
+	return 0, 0, false
+}
+
+// schedulePositioned queues pi for the given 1-based page while current
+// is the page being flushed (0 before pagination): on the current page
+// it goes to cb.positionedItems, a later page keeps it in
+// cb.pagePositioned until flushInserts gets there. An element whose
+// offsets put its top edge below the page box moves on to the page where
+// it lands, counting the height of each page it passes (pageHeight); what
+// hangs beyond the bottom of its page is cut off by the sheet edge. The
+// descendants that resolved against its box are scheduled with it.
+func (cb *CSSBuilder) schedulePositioned(pi *PositionedInsert, page, current int) {
+	for _, ins := range pi.nested {
+		cb.schedulePositioned(ins.Positioned, page+ins.Positioned.pageOffset, current)
+	}
+	pi.nested = nil
+	for pi.Y <= 0 {
+		ht := cb.pageHeight(page + 1)
+		if ht <= 0 {
+			break
+		}
+		pi.Y += ht
+		page++
+	}
+	if page <= current {
+		cb.positionedItems = append(cb.positionedItems, pi)
+		return
+	}
+	cb.pagePositioned[page] = append(cb.pagePositioned[page], pi)
+}
+
+// pageHeight returns the height of the 1-based page: the dimensions stored
+// on a page already begun, otherwise those of the current page, which the
+// pages still to come start from.
+func (cb *CSSBuilder) pageHeight(page int) bag.ScaledPoint {
+	if pages := cb.frontend.Doc.Pages; page >= 1 && page <= len(pages) && pages[page-1].Userdata != nil {
+		if pd, ok := pages[page-1].Userdata[PageDimensionsKey].(PageDimensions); ok && pd.Height > 0 {
+			return pd.Height
+		}
+	}
+	if ht := cb.currentPageDimensions.Height; ht > 0 {
+		return ht
+	}
+	return cb.frontend.Doc.DefaultPageHeight
+}
+
+// queuePositioned moves the positioned inserts committed to the page
+// into their pages and the positioned elements waiting for the page
+// into cb.positionedItems. page is the 1-based number of the current
+// page.
+func (cb *CSSBuilder) queuePositioned(page int) {
+	for _, ins := range cb.pageInserts[InsertPositioned] {
+		cb.schedulePositioned(ins.Positioned, page+ins.Positioned.pageOffset, page)
+	}
+	delete(cb.pageInserts, InsertPositioned)
+	cb.positionedItems = append(cb.positionedItems, cb.pagePositioned[page]...)
+	delete(cb.pagePositioned, page)
+}
+
+// addPositionedPages appends pages to the document until every positioned
+// element waiting for a page beyond the last one is painted — placed
+// there by -bag-position-page or by offsets reaching past the end of the
+// document. Called after the final page has been flushed.
+func (cb *CSSBuilder) addPositionedPages() error {
+	for {
+		last := 0
+		for page := range cb.pagePositioned {
+			last = max(last, page)
+		}
+		if last <= len(cb.frontend.Doc.Pages) {
+			return nil
+		}
+		if err := cb.NewPage(); err != nil {
+			return err
+		}
+		if err := cb.flushInserts(); err != nil {
+			return err
+		}
+	}
+}
+
+// positionedInsert formats the body of a positioned element into rect,
+// resolved by resolvePositionedRect against parent, and completes the
+// geometry that needs the body's natural height.
+func (cb *CSSBuilder) positionedInsert(probe *FormattingStyles, bodyText *frontend.Text, rect, parent positioningContext, sourceOrder int) (*PositionedInsert, error) {
+	body, err := cb.CreateVlist(bodyText, rect.width)
+	if err != nil {
+		return nil, err
+	}
+	// Absolutely positioned descendants resolved against the page box
+	// inside this body; Body itself never reaches the page builder.
+	nested := insertsOnNode(body)
 	// Natural height = body height + depth. If an explicit height
 	// was set (via `height:` or via top+bottom offsets), keep that;
 	// the painter still places the top edge at rect.y so the
@@ -211,46 +477,58 @@ func (cb *CSSBuilder) handlePositioned(item *HTMLItem, ss StylesStack, df *front
 	// the natural height is known. When height was explicit the y
 	// value already reflects it — nothing to do.
 	if probe.bottomOffset != nil && probe.topOffset == nil && heightWasUnresolved {
-		parent := cb.currentContainingBlock()
 		rect.y = parent.y - parent.height + *probe.bottomOffset + height
 	}
 	z := 0
 	if probe.zIndex != nil {
 		z = *probe.zIndex
 	}
-	cb.positionedItems = append(cb.positionedItems, &PositionedInsert{
+	return &PositionedInsert{
 		Body:        body,
 		X:           rect.x,
 		Y:           rect.y,
 		Width:       rect.width,
 		Height:      height,
 		ZIndex:      z,
-		SourceOrder: len(cb.positionedItems),
-	})
-	return nil
+		SourceOrder: sourceOrder,
+		nested:      filterInserts(nested, InsertPositioned),
+	}, nil
 }
 
 // paintPositionedItems sorts the page's pending positioned inserts by
-// z-index (then source order as tiebreaker) and paints them on the
-// current page. CSS 2.1 Appendix E: positioned descendants paint
-// above in-flow non-positioned descendants and floats, so the caller
-// (flushInserts) must invoke this after the buffered body and
-// bottom-floats have been laid down but before the page is shipped.
-func (cb *CSSBuilder) paintPositionedItems() {
+// z-index (then source order as tiebreaker) and paints either those
+// with a negative z-index (belowFlow) or the others on the current
+// page. CSS 2.1 Appendix E: positioned descendants with z-index < 0
+// paint beneath the in-flow content, all others above in-flow
+// non-positioned descendants and floats, so the caller (flushInserts)
+// invokes this once before the flow is laid down and once after it.
+// The painted items are removed from the queue.
+func (cb *CSSBuilder) paintPositionedItems(belowFlow bool) {
 	if len(cb.positionedItems) == 0 {
 		return
 	}
-	sort.SliceStable(cb.positionedItems, func(i, j int) bool {
-		a, b := cb.positionedItems[i], cb.positionedItems[j]
+	sortPositioned(cb.positionedItems)
+	var rest []*PositionedInsert
+	for _, pi := range cb.positionedItems {
+		if (pi.ZIndex < 0) != belowFlow {
+			rest = append(rest, pi)
+			continue
+		}
+		cb.frontend.Doc.CurrentPage.OutputAt(pi.X, pi.Y, pi.Body)
+	}
+	cb.positionedItems = rest
+}
+
+// sortPositioned sorts positioned inserts into paint order: by z-index,
+// then by source order.
+func sortPositioned(items []*PositionedInsert) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
 		if a.ZIndex != b.ZIndex {
 			return a.ZIndex < b.ZIndex
 		}
 		return a.SourceOrder < b.SourceOrder
 	})
-	for _, pi := range cb.positionedItems {
-		cb.frontend.Doc.CurrentPage.OutputAt(pi.X, pi.Y, pi.Body)
-	}
-	cb.positionedItems = nil
 }
 
 // positioningContext represents one entry in the CSS containing-block
@@ -260,12 +538,18 @@ func (cb *CSSBuilder) paintPositionedItems() {
 //
 // PDF y-coordinates grow upward; CSS top grows downward. The painter
 // must convert when resolving offsets — see resolvePositionedRect.
+//
+// An in-flow containing block (position: relative) has no page
+// coordinates while its contents are collected; its entry carries the
+// positionedBox that gathers the absolutely positioned descendants
+// instead, see deferPositioned.
 type positioningContext struct {
 	x          bag.ScaledPoint
 	y          bag.ScaledPoint
 	width      bag.ScaledPoint
 	height     bag.ScaledPoint
 	isPageRoot bool
+	deferred   *positionedBox
 }
 
 // resetPositioningContextForPage replaces the positioning stack with a
@@ -292,6 +576,21 @@ func (cb *CSSBuilder) resetPositioningContextForPage() {
 	})
 }
 
+// pageContainingBlock returns the bottom entry of the positioning stack,
+// the containing block of the page box.
+func (cb *CSSBuilder) pageContainingBlock() positioningContext {
+	if len(cb.positioningContext) > 0 && cb.positioningContext[0].isPageRoot {
+		return cb.positioningContext[0]
+	}
+	pd := cb.currentPageDimensions
+	return positioningContext{
+		y:          cb.frontend.Doc.DefaultPageHeight,
+		width:      pd.Width,
+		height:     pd.Height,
+		isPageRoot: true,
+	}
+}
+
 // currentContainingBlock returns the topmost positioning context — the
 // containing block a position: absolute element would resolve against.
 // Falls back to a synthesized page-box entry if the stack is empty
@@ -330,3 +629,214 @@ func (cb *CSSBuilder) popPositioningContext() {
 	}
 	cb.positioningContext = cb.positioningContext[:n-1]
 }
+
+// positionedBox is carried by settingPositionedBox from Output() to
+// buildVlistInternal for a position: relative block (CSS 2.1 §9.4.3):
+// its vertical offset and the absolutely positioned descendants it is
+// the containing block for. Where the box lands on the page is only
+// known when the page is built, so the descendants are laid out against
+// the finished box and travel with it (attachPositioned) instead of
+// being painted at page coordinates by paintPositionedItems.
+//
+// A box with either of them can still break across pages: every fragment
+// is shifted by the offset, and each descendant goes with the fragment
+// that holds its top edge. Inline elements get their vertical offset as a
+// baseline shift and are not containing blocks (v1).
+type positionedBox struct {
+	// shiftY moves the painted box down (negative: up) without moving
+	// anything else.
+	shiftY   bag.ScaledPoint
+	children []positionedChild
+}
+
+// positionedChild is an absolutely positioned element collected by a
+// positionedBox: its styles (for the offsets, width and height) and its
+// body, formatted once the containing block is known.
+type positionedChild struct {
+	styles *FormattingStyles
+	body   *frontend.Text
+}
+
+// isPositionedBoxExempt reports whether an element cannot carry a
+// positionedBox: table-internal boxes are built by the table code, an img
+// has no box of its own. Their absolutely positioned descendants resolve
+// against the next containing block further up.
+func isPositionedBoxExempt(element string) bool {
+	switch element {
+	case "table", "thead", "tbody", "tfoot", "tr", "td", "th", "col", "colgroup", "img":
+		return true
+	}
+	return false
+}
+
+// relativeShiftY returns the vertical offset of a relatively positioned
+// box (positive = downward). CSS 2.1 §9.4.3: top wins over bottom, bottom
+// moves the box up.
+func relativeShiftY(styles *FormattingStyles) bag.ScaledPoint {
+	switch {
+	case styles.topOffset != nil:
+		return *styles.topOffset
+	case styles.bottomOffset != nil:
+		return -*styles.bottomOffset
+	}
+	return 0
+}
+
+// deferPositioned collects an absolutely positioned element whose
+// containing block is an in-flow box. The body is built right away (its
+// own absolutely positioned descendants are collected by it in turn), its
+// geometry is resolved by attachPositioned once the containing block is
+// built.
+func (cb *CSSBuilder) deferPositioned(item *HTMLItem, probe *FormattingStyles, pb *positionedBox, ss StylesStack, df *frontend.Document, anchorPages map[string]int) error {
+	own := &positionedBox{}
+	cb.pushPositioningContext(positioningContext{deferred: own})
+	bodyText, err := Output(cb, item, ss, df, anchorPages)
+	cb.popPositioningContext()
+	if err != nil {
+		return err
+	}
+	if len(own.children) > 0 {
+		bodyText.Settings[settingPositionedBox] = own
+	}
+	pb.children = append(pb.children, positionedChild{styles: probe, body: bodyText})
+	return nil
+}
+
+// positionedHang is an absolutely positioned descendant laid out by
+// attachPositioned: its insert and the distance of its top edge below the
+// top of the containing block's border box.
+type positionedHang struct {
+	pi  *PositionedInsert
+	top bag.ScaledPoint
+}
+
+// attachPositioned lays out the absolutely positioned descendants of pb
+// against the padding box of vl (the border widths are in hv) and returns
+// vl together with them (hangPositioned). Like clipVList the wrapper takes
+// over vl's attributes. A splittable box stays splittable: the
+// descendants are kept in _splittablePositioned, and outputBlockSplit
+// hangs each of them into the fragment that holds its top edge. The
+// descendants resolved against the page box ride along to the page the
+// box starts on.
+func (cb *CSSBuilder) attachPositioned(vl *node.VList, pb *positionedBox, hv HTMLValues) (*node.VList, error) {
+	if len(pb.children) == 0 {
+		return vl, nil
+	}
+	// The containing block in box coordinates: the top left corner of
+	// the border box is 0,0, y grows upward like on the page.
+	ht := vl.Height + vl.Depth
+	parent := positioningContext{
+		x:      hv.BorderLeftWidth,
+		y:      -hv.BorderTopWidth,
+		width:  vl.Width - hv.BorderLeftWidth - hv.BorderRightWidth,
+		height: ht - hv.BorderTopWidth - hv.BorderBottomWidth,
+	}
+	var below, above []*PositionedInsert
+	var nested []*Insert
+	for i, pc := range pb.children {
+		pi, err := cb.positionedInsert(pc.styles, pc.body, resolvePositionedRect(pc.styles, parent), parent, i)
+		if err != nil {
+			return nil, err
+		}
+		pi.Body.ShiftX += pi.X
+		nested = append(nested, pi.nested...)
+		if pi.ZIndex < 0 {
+			below = append(below, pi)
+		} else {
+			above = append(above, pi)
+		}
+	}
+	sortPositioned(below)
+	sortPositioned(above)
+	var hangs []positionedHang
+	for _, pi := range append(below, above...) {
+		hangs = append(hangs, positionedHang{pi: pi, top: -pi.Y})
+	}
+	wrapper := cb.hangPositioned(vl, hangs, 0)
+	addInsertsAttr(wrapper, nested)
+	if _, ok := wrapper.Attributes["_splittable"]; ok {
+		wrapper.Attributes["_splittablePositioned"] = hangs
+	}
+	return wrapper, nil
+}
+
+// hangPositioned returns vl together with the positioned descendants
+// hangs, for a vl whose top lies from below the top of the containing
+// block. Each body hangs in a VList without height at its offset:
+// descendants with a negative z-index before vl, the others after it, so
+// they paint below or above the box's own content. The wrapper takes over
+// vl's attributes.
+func (cb *CSSBuilder) hangPositioned(vl *node.VList, hangs []positionedHang, from bag.ScaledPoint) *node.VList {
+	if len(hangs) == 0 {
+		return vl
+	}
+	ht := vl.Height + vl.Depth
+	var head node.Node
+	add := func(n node.Node) {
+		if head == nil {
+			head = n
+		} else {
+			head = node.InsertAfter(head, node.Tail(head), n)
+		}
+	}
+	// hang places a body top at the given distance below the current
+	// position.
+	hang := func(pi *PositionedInsert, top bag.ScaledPoint) {
+		k := node.NewKern()
+		k.Kern = top
+		pi.Body.SetPrev(nil)
+		pi.Body.SetNext(nil)
+		hung := node.Vpack(node.InsertAfter(k, k, pi.Body))
+		hung.Width, hung.Height, hung.Depth = 0, 0, 0
+		hung.Attributes = node.H{"origin": "positioned"}
+		add(hung)
+	}
+	for _, h := range hangs {
+		if h.pi.ZIndex < 0 {
+			hang(h.pi, h.top-from)
+		}
+	}
+	add(vl)
+	for _, h := range hangs {
+		if h.pi.ZIndex >= 0 {
+			// After vl the current position is the bottom of the box.
+			hang(h.pi, h.top-from-ht)
+		}
+	}
+	wrapper := node.Vpack(head)
+	wrapper.Width = vl.Width
+	wrapper.Height = vl.Height
+	wrapper.Depth = vl.Depth
+	wrapper.Attributes = vl.Attributes
+	if wrapper.Attributes == nil {
+		wrapper.Attributes = node.H{}
+	}
+	vl.Attributes = node.H{"origin": "containing block"}
+	return wrapper
+}
+
+// shiftVList paints vl dy further down (negative: up) while it keeps its
+// place in the flow: a kern in front of it inside a wrapper of vl's size.
+// Like clipVList the wrapper takes over vl's attributes; a split box
+// shifts every fragment by dy (_splittableShift, see outputBlockSplit).
+func (cb *CSSBuilder) shiftVList(vl *node.VList, dy bag.ScaledPoint) *node.VList {
+	if dy == 0 {
+		return vl
+	}
+	k := node.NewKern()
+	k.Kern = dy
+	k.Attributes = node.H{"origin": "relative offset"}
+	wrapper := node.Vpack(node.InsertAfter(k, k, vl))
+	wrapper.Width = vl.Width
+	wrapper.Height = vl.Height
+	wrapper.Depth = vl.Depth
+	wrapper.Attributes = vl.Attributes
+	if wrapper.Attributes == nil {
+		wrapper.Attributes = node.H{}
+	}
+	if _, ok := wrapper.Attributes["_splittable"]; ok {
+		wrapper.Attributes["_splittableShift"] = dy
+	}
+	vl.Attributes = node.H{"origin": "relatively positioned content"}
+	return wrapper
+}
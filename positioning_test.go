@@ -1,11 +1,14 @@
 package htmlbag
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 
 	"github.com/boxesandglue/boxesandglue/backend/bag"
 	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/csshtml"
 )
 
 // positionedObject returns X and Y of the page object carrying the given
@@ -62,3 +65,259 @@ func TestAbsolutePositionAnchorsToPageBox(t *testing.T) {
 		t.Errorf("flow paragraph at y=%s, must be below the 10mm top margin", py)
 	}
 }
+
+// TestRelativeOffsets: top and bottom move the painted box of a relatively
+// positioned block, not the flow after it.
+func TestRelativeOffsets(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.card { position: relative; }`
+	render := func(style string) (card, after bag.ScaledPoint) {
+		html := `<html><body><p>OBEN</p><div class="card" style="` + style + `"><p>KARTE</p></div><p>NACHHER</p></body></html>`
+		pg := renderHTMLPages(t, css, html)[0]
+		return lineTopY(pg, "KARTE"), lineTopY(pg, "NACHHER")
+	}
+	card, after := render("")
+	for style, want := range map[string]bag.ScaledPoint{
+		"top: 6pt":               card - bag.MustSP("6pt"),
+		"bottom: 6pt":            card + bag.MustSP("6pt"),
+		"top: -2pt; bottom: 9pt": card + bag.MustSP("2pt"),
+	} {
+		gotCard, gotAfter := render(style)
+		if gotCard != want {
+			t.Errorf("%s: card text at y=%s, want %s", style, gotCard, want)
+		}
+		if gotAfter != after {
+			t.Errorf("%s: following paragraph at y=%s, want %s (unmoved)", style, gotAfter, after)
+		}
+	}
+}
+
+// TestRelativeContainingBlock: an absolutely positioned element inside a
+// relatively positioned block resolves against that block's box wherever
+// the block lands, and leaves the flow alone.
+func TestRelativeContainingBlock(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.card { position: relative; height: 40pt; border-top: 2pt solid black; }
+.badge { position: absolute; top: 5pt; right: 0; width: 30pt; }
+.low { bottom: 0; top: auto; }`
+	for _, lead := range []string{"", "<p>Eins</p><p>Zwei</p><p>Drei</p>"} {
+		html := `<html><body>` + lead + `<div class="card"><p>KARTE</p><div class="badge">BADGE</div><div class="badge low">UNTEN</div></div><p>NACHHER</p></body></html>`
+		pg := renderHTMLPages(t, css, html)[0]
+		card, badge := lineTopY(pg, "KARTE"), lineTopY(pg, "BADGE")
+		if card == -1 || badge == -1 {
+			t.Fatal("card or badge not found")
+		}
+		if want := card - bag.MustSP("5pt"); badge != want {
+			t.Errorf("badge at y=%s, want %s (5pt below the card's padding box)", badge, want)
+		}
+		if low := lineTopY(pg, "UNTEN"); low <= card-bag.MustSP("40pt") || low >= card-bag.MustSP("20pt") {
+			t.Errorf("bottom-anchored badge at y=%s, want it in the lower half of the card at %s", low, card)
+		}
+		plain := strings.NewReplacer(`<div class="badge">BADGE</div>`, "", `<div class="badge low">UNTEN</div>`, "").Replace(html)
+		if got, want := lineTopY(pg, "NACHHER"), lineTopY(renderHTMLPages(t, css, plain)[0], "NACHHER"); got != want {
+			t.Errorf("following paragraph at y=%s, want %s (as without the badges)", got, want)
+		}
+	}
+}
+
+// TestRelativeSplitsAcrossPages: a relatively positioned block taller than
+// the page still breaks across pages. Every fragment is moved by the
+// offset, and each absolutely positioned descendant is painted once, with
+// the fragment holding its top edge.
+func TestRelativeSplitsAcrossPages(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.card { position: relative; border: 1pt solid black; }
+.badge { position: absolute; top: 0; right: 0; width: 30mm; }
+.low { top: auto; bottom: 0; }`
+	render := func(style string) []*document.Page {
+		html := `<html><body><div class="card" style="` + style + `"><p>ERSTER</p>` +
+			strings.Repeat("<p>Wort Wort</p>", 120) +
+			`<p>LETZTER</p><div class="badge">MARKE</div><div class="badge low">SCHLUSS</div></div></body></html>`
+		return renderHTMLPages(t, css, html)
+	}
+	plain, pages := render(""), render("top: 5mm")
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want at least 2", len(pages))
+	}
+	if len(pages) != len(plain) {
+		t.Fatalf("got %d pages, want %d as without the offset", len(pages), len(plain))
+	}
+	// on returns the 0-based indexes of the pages carrying the given text.
+	on := func(needle string) []int {
+		var idx []int
+		for i, pg := range pages {
+			if strings.Contains(pageText(pg), needle) {
+				idx = append(idx, i)
+			}
+		}
+		return idx
+	}
+	if first := on("ERSTER"); len(first) != 1 || first[0] != 0 {
+		t.Fatalf("block starts on page indexes %v, want [0]", first)
+	}
+	last := on("LETZTER")
+	if len(last) != 1 {
+		t.Fatalf("last paragraph on page indexes %v, want one page", last)
+	}
+	for needle, want := range map[string]int{"MARKE": 0, "SCHLUSS": last[0]} {
+		if got := on(needle); len(got) != 1 || got[0] != want {
+			t.Errorf("%s on page indexes %v, want [%d]", needle, got, want)
+		}
+	}
+	for i, pg := range pages {
+		if got, want := lineTopY(pg, "Wort"), lineTopY(plain[i], "Wort")-bag.MustSP("5mm"); got != want {
+			t.Errorf("page %d: fragment text at y=%s, want %s (5mm lower)", i+1, got, want)
+		}
+	}
+}
+
+// objectIndex returns the index of the first page object carrying the
+// given text, or -1.
+func objectIndex(pg *document.Page, needle string) int {
+	for i, obj := range pg.Objects {
+		if obj.Vlist == nil {
+			continue
+		}
+		var sb strings.Builder
+		collectComponents(obj.Vlist.List, &sb)
+		if strings.Contains(sb.String(), needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestFixedRepeatsOnEveryPage: a position: fixed element is painted on
+// every page at the same place of the page box; with a negative z-index
+// it is painted before the flow, otherwise after it.
+func TestFixedRepeatsOnEveryPage(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.tab { position: fixed; top: 100mm; right: 0; width: 8mm; }
+.mark { position: fixed; bottom: 10mm; left: 30mm; width: 60mm; z-index: -1; }`
+	html := `<html><body><div class="tab">REITER</div><div class="mark">WASSERZEICHEN</div><p>` +
+		strings.Repeat("Wort ", 2000) + `</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want at least 2", len(pages))
+	}
+	for i, pg := range pages {
+		x, y := positionedObject(pg, "REITER")
+		if want := bag.MustSP("202mm"); x != want || y != bag.MustSP("197mm") {
+			t.Errorf("page %d: tab at %s/%s, want %s/%s", i+1, x, y, want, bag.MustSP("197mm"))
+		}
+		mark, flow := objectIndex(pg, "WASSERZEICHEN"), objectIndex(pg, "Wort")
+		if mark == -1 || flow == -1 {
+			t.Fatalf("page %d: watermark or flow not found", i+1)
+		}
+		if mark > flow {
+			t.Errorf("page %d: the watermark with z-index -1 is painted above the flow", i+1)
+		}
+		if tab := objectIndex(pg, "REITER"); tab < flow {
+			t.Errorf("page %d: the tab is painted beneath the flow", i+1)
+		}
+	}
+}
+
+// pageWith returns the 0-based index of the first page carrying the given
+// text, or -1.
+func pageWith(pages []*document.Page, needle string) int {
+	for i, pg := range pages {
+		if objectIndex(pg, needle) != -1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestAbsoluteOnAnchorPage: an absolutely positioned element is painted
+// on the page its place in the flow lands on, not on the first page.
+func TestAbsoluteOnAnchorPage(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.note { position: absolute; top: 30mm; left: 30mm; width: 50mm; }`
+	html := `<html><body><p>` + strings.Repeat("Wort ", 2000) +
+		`</p><div class="note">NACHTRAG</div><p>ENDE</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want at least 2", len(pages))
+	}
+	want := pageWith(pages, "ENDE")
+	if got := pageWith(pages, "NACHTRAG"); got != want {
+		t.Fatalf("positioned element on page %d, want page %d with its anchor", got+1, want+1)
+	}
+	x, y := positionedObject(pages[want], "NACHTRAG")
+	if x != bag.MustSP("30mm") || y != bag.MustSP("297mm")-bag.MustSP("30mm") {
+		t.Errorf("positioned element at %s/%s, want 30mm from the left and the top sheet edge", x, y)
+	}
+}
+
+// TestPositionPage: -bag-position-page puts an element on a given page or
+// the one after its anchor, and offsets beyond the bottom of the page box
+// move an element on to the next page. Pages are added when the flow ends
+// earlier.
+func TestPositionPage(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.field { position: absolute; -bag-position-page: 2; top: 50mm; left: 30mm; width: 40mm; }
+.later { position: absolute; -bag-position-page: next; top: 20mm; left: 20mm; width: 40mm; }
+.deep { position: absolute; top: 310mm; left: 20mm; width: 40mm; }`
+	html := `<html><body><p>Kurz</p><div class="field">FELD</div><div class="later">WEITER</div><div class="deep">UNTEN</div><p>Text</p></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	cases := []struct {
+		needle string
+		x, y   bag.ScaledPoint
+	}{
+		{"FELD", bag.MustSP("30mm"), bag.MustSP("247mm")},
+		{"WEITER", bag.MustSP("20mm"), bag.MustSP("277mm")},
+		{"UNTEN", bag.MustSP("20mm"), bag.MustSP("297mm") - bag.MustSP("13mm")},
+	}
+	for _, tc := range cases {
+		if p := pageWith(pages, tc.needle); p != 1 {
+			t.Errorf("%s on page %d, want page 2", tc.needle, p+1)
+			continue
+		}
+		if x, y := positionedObject(pages[1], tc.needle); x != tc.x || y != tc.y {
+			t.Errorf("%s at %s/%s, want %s/%s", tc.needle, x, y, tc.x, tc.y)
+		}
+	}
+	if pageWith(pages, "Kurz") != 0 {
+		t.Error("the flow does not start on page 1")
+	}
+}
+
+// TestPositionedPageHeights: offsets past the bottom of the page count the
+// height of every page the element passes, not the default page height.
+func TestPositionedPageHeights(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	cb, err := New(fe, csshtml.NewCSSParserWithDefaults())
+	if err != nil {
+		t.Fatalf("htmlbag.New: %v", err)
+	}
+	if err := cb.ParseCSSString(`@page { size: a4; margin: 20mm; }`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cb.PageSize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.NewPage(); err != nil {
+		t.Fatal(err)
+	}
+	// The second page and the pages after it are A5 landscape.
+	cb.currentPageDimensions.Height = bag.MustSP("148mm")
+	storePageDimensions(cb, cb.currentPageDimensions)
+	pi := &PositionedInsert{Y: -bag.MustSP("160mm")}
+	cb.schedulePositioned(pi, 1, 2)
+	if len(cb.pagePositioned[3]) != 1 {
+		t.Fatalf("element not scheduled for page 3: %v", cb.pagePositioned)
+	}
+	if want := 2*bag.MustSP("148mm") - bag.MustSP("160mm"); pi.Y != want {
+		t.Errorf("element at y %s on page 3, want %s", pi.Y, want)
+	}
+}
@@ -151,16 +151,20 @@ func GetHTMLItemFromHTMLNode(thisNode *html.Node, direction Mode, firstItem *HTM
 				}
 			}
 			// CSS `display` can override the tag-based block/inline
-			// classification above. Only the two basic keywords are
-			// honoured; `display: none` is consumed downstream via
-			// FormattingStyles.Hide, and exotic values (flex, grid,
-			// inline-block, ...) keep the tag default.
+			// classification above. Only the basic keywords, flex, grid
+			// and the atomic inlines are honoured; `display: none` is
+			// consumed downstream via FormattingStyles.Hide, and exotic
+			// values keep the tag default. An inline-flex or inline-grid
+			// container is laid out like a block level one (v1). An
+			// inline-block or inline-table sits in the inline flow and is
+			// formatted as a block by collectHorizontalNodes (see
+			// isInlineBlock).
 			if disp, ok := itm.Styles["display"]; ok {
 				switch disp {
-				case "block":
+				case "block", "flex", "inline-flex", "grid", "inline-grid":
 					newDir = ModeVertical
 					itm.Dir = ModeVertical
-				case "inline":
+				case "inline", "inline-block", "inline-table":
 					newDir = ModeHorizontal
 					itm.Dir = ModeHorizontal
 				}
@@ -223,6 +227,18 @@ func GetHTMLItemFromHTMLNode(thisNode *html.Node, direction Mode, firstItem *HTM
 					preserveWhitespace = append(preserveWhitespace, ws)
 					GetHTMLItemFromHTMLNode(thisNode.FirstChild, newDir, itm)
 					preserveWhitespace = preserveWhitespace[:len(preserveWhitespace)-1]
+					// The children of a flex or grid container are
+					// blockified (CSS Flexbox 1 §4, CSS Grid 1 §6): every
+					// element child becomes an item, an <a> or <span>
+					// included.
+					switch itm.Styles["display"] {
+					case "flex", "inline-flex", "grid", "inline-grid":
+						for _, c := range itm.Children {
+							if c.Typ == html.ElementNode {
+								c.Dir = ModeVertical
+							}
+						}
+					}
 				}
 			}
 		case html.DocumentNode:
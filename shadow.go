@@ -0,0 +1,447 @@
+package htmlbag
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+)
+
+// shadowBlurSteps is the number of nested paths a blurred box shadow is
+// drawn with. Each step is a little lighter than the next inner one, which
+// approximates the Gaussian edge of CSS blur as a linear ramp.
+const shadowBlurSteps = 8
+
+// shadow is one entry of a box-shadow or text-shadow list (CSS Backgrounds
+// 3 §7.1, CSS Text Decoration 3 §4). The color is opaque: an alpha value
+// has already been mixed with white (see shadowColor).
+type shadow struct {
+	inset        bool
+	dx, dy       bag.ScaledPoint
+	blur, spread bag.ScaledPoint
+	color        *color.Color
+}
+
+// parseShadows parses a box-shadow (text false) or text-shadow (text true)
+// value. Lengths resolve against the element's font size; a missing color
+// is currentColor. Invalid entries are skipped with a warning.
+func parseShadows(v string, styles *FormattingStyles, df *frontend.Document, text bool) []shadow {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "none" {
+		return nil
+	}
+	var shadows []shadow
+	for _, entry := range splitTopLevel(v, ',') {
+		var s shadow
+		var lengths []bag.ScaledPoint
+		var colorValue string
+		for _, tok := range splitTopLevel(entry, ' ') {
+			switch {
+			case tok == "inset" && !text:
+				s.inset = true
+			case isShadowLength(tok):
+				lengths = append(lengths, ParseRelativeSize(tok, styles.Fontsize, styles.DefaultFontSize))
+			default:
+				colorValue = tok
+			}
+		}
+		maxLengths := 4
+		if text {
+			maxLengths = 3
+		}
+		if len(lengths) < 2 || len(lengths) > maxLengths {
+			bag.Logger.Warn("invalid shadow", "value", entry)
+			continue
+		}
+		s.dx, s.dy = lengths[0], lengths[1]
+		if len(lengths) > 2 {
+			s.blur = bag.Max(0, lengths[2])
+		}
+		if len(lengths) > 3 {
+			s.spread = lengths[3]
+		}
+		s.color = shadowColor(colorValue, styles.color, df)
+		if s.color == nil {
+			continue
+		}
+		shadows = append(shadows, s)
+	}
+	return shadows
+}
+
+// isShadowLength reports whether tok is one of the lengths of a shadow
+// rather than its color or the inset keyword.
+func isShadowLength(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	c := tok[0]
+	return c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.'
+}
+
+// splitTopLevel splits v at sep outside of parentheses, so the commas and
+// spaces inside rgb() and friends stay in one piece. Empty pieces are
+// dropped.
+func splitTopLevel(v string, sep rune) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range v {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				if p := strings.TrimSpace(v[start:i]); p != "" {
+					parts = append(parts, p)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if p := strings.TrimSpace(v[start:]); p != "" {
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+// shadowColor resolves the color of a shadow. Without transparency groups
+// a translucent shadow is drawn opaque: its alpha is mixed with white, which
+// matches the look on the (white) page. An empty value is currentColor.
+func shadowColor(v string, current *color.Color, df *frontend.Document) *color.Color {
+	if v == "" || v == "currentcolor" || v == "currentColor" {
+		if current == nil {
+			return df.GetColor("black")
+		}
+		return current
+	}
+	v, alpha := splitAlpha(v)
+	c := df.GetColor(v)
+	if c == nil {
+		return nil
+	}
+	return mixWithWhite(c, alpha)
+}
+
+// splitAlpha removes the alpha component from a CSS color and returns it
+// separately (1 when there is none). It understands rgba()/hsla(), the
+// slash syntax of CSS Color 4 and #rgba/#rrggbbaa.
+func splitAlpha(v string) (string, float64) {
+	parseAlpha := func(a string) float64 {
+		a = strings.TrimSpace(a)
+		if p, ok := strings.CutSuffix(a, "%"); ok {
+			if f, err := strconv.ParseFloat(p, 64); err == nil {
+				return f / 100
+			}
+			return 1
+		}
+		if f, err := strconv.ParseFloat(a, 64); err == nil {
+			return f
+		}
+		return 1
+	}
+	lower := strings.ToLower(v)
+	if strings.HasPrefix(lower, "#") {
+		switch len(v) {
+		case 5:
+			if n, err := strconv.ParseUint(v[4:5], 16, 8); err == nil {
+				return v[:4], float64(n*17) / 255
+			}
+		case 9:
+			if n, err := strconv.ParseUint(v[7:9], 16, 8); err == nil {
+				return v[:7], float64(n) / 255
+			}
+		}
+		return v, 1
+	}
+	open := strings.Index(v, "(")
+	if open < 0 || !strings.HasSuffix(v, ")") {
+		return v, 1
+	}
+	fn := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v[:open])), "a")
+	args := v[open+1 : len(v)-1]
+	if comp, a, ok := strings.Cut(args, "/"); ok {
+		return fn + "(" + strings.TrimSpace(comp) + ")", parseAlpha(a)
+	}
+	if parts := strings.Split(args, ","); len(parts) == 4 {
+		return fn + "(" + strings.Join(parts[:3], ",") + ")", parseAlpha(parts[3])
+	}
+	return fn + "(" + args + ")", 1
+}
+
+// mixWithWhite returns c with the given coverage on a white backdrop (1 =
+// c itself, 0 = white). Color spaces other than RGB and CMYK keep c.
+func mixWithWhite(c *color.Color, coverage float64) *color.Color {
+	if coverage >= 1 {
+		return c
+	}
+	coverage = max(coverage, 0)
+	s := *c
+	switch s.Space {
+	case color.ColorRGB:
+		s.R = 1 - coverage*(1-s.R)
+		s.G = 1 - coverage*(1-s.G)
+		s.B = 1 - coverage*(1-s.B)
+	case color.ColorCMYK:
+		s.C, s.M, s.Y, s.K = s.C*coverage, s.M*coverage, s.Y*coverage, s.K*coverage
+	}
+	return &s
+}
+
+// roundedRect returns the path of the rectangle x0…x3, y3…y0 with the
+// border radii of hv grown by grow (a zero radius stays square, CSS
+// Backgrounds 3 §7.1.1).
+func roundedRect(x0, y0, x3, y3 bag.ScaledPoint, hv HTMLValues, grow bag.ScaledPoint) *pdfdraw.Object {
+	radius := func(r bag.ScaledPoint) bag.ScaledPoint {
+		if r == 0 {
+			return 0
+		}
+		return bag.Max(0, r+grow)
+	}
+	var r HTMLValues
+	r.BorderTopLeftRadius = radius(hv.BorderTopLeftRadius)
+	r.BorderTopRightRadius = radius(hv.BorderTopRightRadius)
+	r.BorderBottomLeftRadius = radius(hv.BorderBottomLeftRadius)
+	r.BorderBottomRightRadius = radius(hv.BorderBottomRightRadius)
+	_, outer := getBorderPaths(x0, y0, 0, 0, 0, 0, x3, y3, r)
+	return outer
+}
+
+// boxShadowPre returns the PDF instructions for the outer (inset false) or
+// the inset shadows of hv.boxShadows, or "" if there are none. The border
+// box spans x0…x3, y3…y0 (y grows upward). The first shadow in the list is
+// painted on top. Outer shadows are clipped to the outside of the border
+// box, inset shadows to the padding box. A blur is drawn as
+// shadowBlurSteps nested paths from blur outside to blur inside the shadow
+// edge.
+func boxShadowPre(hv HTMLValues, x0, y0, x3, y3 bag.ScaledPoint, inset bool) string {
+	// The padding box, where inset shadows live.
+	px0, py0 := x0+hv.BorderLeftWidth, y0-hv.BorderTopWidth
+	px3, py3 := x3-hv.BorderRightWidth, y3+hv.BorderBottomWidth
+	innerRadii := HTMLValues{
+		BorderTopLeftRadius:     bag.Max(0, hv.BorderTopLeftRadius-hv.BorderLeftWidth),
+		BorderBottomLeftRadius:  bag.Max(0, hv.BorderBottomLeftRadius-hv.BorderLeftWidth),
+		BorderTopRightRadius:    bag.Max(0, hv.BorderTopRightRadius-hv.BorderRightWidth),
+		BorderBottomRightRadius: bag.Max(0, hv.BorderBottomRightRadius-hv.BorderRightWidth),
+	}
+
+	var layers []string
+	var extent bag.ScaledPoint
+	for i := len(hv.boxShadows) - 1; i >= 0; i-- {
+		s := hv.boxShadows[i]
+		if s.inset != inset {
+			continue
+		}
+		extent = bag.Max(extent, absSP(s.dx)+absSP(s.dy)+absSP(s.spread)+s.blur)
+		steps := 1
+		if s.blur > 0 {
+			steps = shadowBlurSteps
+		}
+		for k := 0; k < steps; k++ {
+			t := (float64(k) + 0.5) / float64(steps)
+			// e runs from +blur (outermost, lightest) to -blur.
+			e := s.spread + bag.MultiplyFloat(s.blur, 1-2*t)
+			col := pdfdraw.New().ColorNonstroking(*mixWithWhite(s.color, float64(k+1)/float64(steps))).String()
+			if !inset {
+				if x3-x0+2*e <= 0 || y0-y3+2*e <= 0 {
+					continue
+				}
+				shape := roundedRect(x0+s.dx-e, y0-s.dy+e, x3+s.dx+e, y3-s.dy-e, hv, e)
+				layers = append(layers, col+" "+shape.Fill().String())
+				continue
+			}
+			// Inset: a frame around a hole, the hole being the padding box
+			// moved by the offset and shrunk by spread (and blur step). The
+			// frame is filled with the even-odd rule.
+			frame := pdfdraw.New().Rect(px0-extent, py3-extent, px3-px0+2*extent, py0-py3+2*extent).String()
+			if px3-px0-2*e <= 0 || py0-py3-2*e <= 0 {
+				layers = append(layers, col+" "+frame+" f")
+				continue
+			}
+			hole := roundedRect(px0+s.dx+e, py0-s.dy-e, px3+s.dx-e, py3-s.dy+e, innerRadii, -e)
+			layers = append(layers, col+" "+frame+" "+hole.String()+" f*")
+		}
+	}
+	if len(layers) == 0 {
+		return ""
+	}
+	var clip string
+	if inset {
+		clip = roundedRect(px0, py0, px3, py3, innerRadii, 0).Clip().Endpath().String()
+	} else {
+		// Everything but the border box: the big rectangle and the box
+		// outline filled with the even-odd rule.
+		m := extent + bag.MustSP("1pt")
+		big := pdfdraw.New().Rect(x0-m, y3-m, x3-x0+2*m, y0-y3+2*m).String()
+		clip = big + " " + roundedRect(x0, y0, x3, y3, hv, 0).String() + " W* n"
+	}
+	return "q " + clip + " " + strings.Join(layers, " ") + " Q"
+}
+
+// textShadowLines draws text shadows behind the lines of vl. For every
+// shadow there is a copy of the paragraph (shadowVLs, in the order of
+// shadows) typeset in the shadow color with the same line breaks. Each copy
+// line is moved into the matching line of vl as a zero-width box offset by
+// the shadow offset, in front of the line's content so it is painted
+// underneath. The first shadow ends up closest to the text (on top). Blur is
+// not drawn.
+func textShadowLines(vl *node.VList, shadowVLs []*node.VList, shadows []shadow) {
+	lines := paragraphLines(vl, nil)
+	for i, svl := range shadowVLs {
+		slines := paragraphLines(svl, nil)
+		if len(slines) != len(lines) {
+			continue
+		}
+		s := shadows[i]
+		for j, line := range lines {
+			sl := slines[j]
+			sl.SetPrev(nil)
+			sl.SetNext(nil)
+			stripShadowBoxes(sl)
+			k := node.NewKern()
+			k.Kern = s.dy
+			box := node.Vpack(node.InsertAfter(k, k, sl))
+			// Sits on the baseline like the line itself; the kern moves
+			// the copy down by dy without making the line any taller.
+			box.Width = sl.Width
+			box.Height = sl.Height
+			box.Depth = 0
+			box.Attributes = node.H{"origin": "text shadow"}
+
+			before := node.NewKern()
+			before.Kern = s.dx
+			after := node.NewKern()
+			after.Kern = -s.dx - sl.Width
+			var head node.Node = before
+			head = node.InsertAfter(head, before, box)
+			head = node.InsertAfter(head, box, after)
+			if line.List != nil {
+				after.SetNext(line.List)
+				line.List.SetPrev(after)
+			}
+			line.List = head
+		}
+	}
+}
+
+// paragraphLines collects the line boxes (origin "line") of vl, descending
+// into nested VLists.
+func paragraphLines(vl *node.VList, lines []*node.HList) []*node.HList {
+	for n := vl.List; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.HList:
+			if v.Attributes["origin"] == "line" {
+				lines = append(lines, v)
+			}
+		case *node.VList:
+			lines = paragraphLines(v, lines)
+		}
+	}
+	return lines
+}
+
+// stripShadowBoxes reduces a text shadow line to glyphs, glue, kerns and
+// penalties: only the glyphs cast a shadow. Images and other boxes become
+// kerns of the same width; links, destinations, structure tags and the
+// other start/stop and marker nodes are dropped, so the copy neither
+// repeats them nor carries the attributes (inserts, tags) of the line it
+// was typeset from.
+func stripShadowBoxes(hl *node.HList) {
+	hl.Attributes = nil
+	for n := hl.List; n != nil; {
+		next := n.Next()
+		var repl node.Node
+		switch v := n.(type) {
+		case *node.Glyph, *node.Glue, *node.Kern, *node.Penalty:
+			n = next
+			continue
+		case *node.HList:
+			stripShadowBoxes(v)
+			n = next
+			continue
+		case *node.VList:
+			repl = shadowKern(v.Width)
+		case *node.Image:
+			repl = shadowKern(v.Width)
+		case *node.Rule:
+			repl = shadowKern(v.Width)
+		}
+		prev := n.Prev()
+		if repl == nil {
+			// Unlink n.
+			if prev == nil {
+				hl.List = next
+			} else {
+				prev.SetNext(next)
+			}
+			if next != nil {
+				next.SetPrev(prev)
+			}
+		} else {
+			repl.SetPrev(prev)
+			repl.SetNext(next)
+			if prev == nil {
+				hl.List = repl
+			} else {
+				prev.SetNext(repl)
+			}
+			if next != nil {
+				next.SetPrev(repl)
+			}
+		}
+		n = next
+	}
+}
+
+// shadowKern returns a kern of width wd.
+func shadowKern(wd bag.ScaledPoint) *node.Kern {
+	k := node.NewKern()
+	k.Kern = wd
+	return k
+}
+
+func absSP(v bag.ScaledPoint) bag.ScaledPoint {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// recolorText sets the text color of te and of every nested Text that
+// carries its own color to c and returns a function that restores the
+// previous colors.
+func recolorText(te *frontend.Text, c *color.Color) func() {
+	type saved struct {
+		v   any
+		has bool
+	}
+	colors := map[*frontend.Text]saved{}
+	var walk func(t *frontend.Text, root bool)
+	walk = func(t *frontend.Text, root bool) {
+		v, has := t.Settings[frontend.SettingColor]
+		if root || has {
+			colors[t] = saved{v, has}
+			t.Settings[frontend.SettingColor] = c
+		}
+		for _, itm := range t.Items {
+			if nested, ok := itm.(*frontend.Text); ok {
+				walk(nested, false)
+			}
+		}
+	}
+	walk(te, true)
+	return func() {
+		for t, s := range colors {
+			if s.has {
+				t.Settings[frontend.SettingColor] = s.v
+			} else {
+				delete(t.Settings, frontend.SettingColor)
+			}
+		}
+	}
+}
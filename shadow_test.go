@@ -0,0 +1,175 @@
+package htmlbag
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+func TestParseShadows(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	styles := &FormattingStyles{Fontsize: bag.MustSP("10pt"), DefaultFontSize: bag.MustSP("10pt")}
+
+	got := parseShadows("2pt 3pt 4pt 1pt rgba(0, 0, 0, 0.5), inset 0 0 0.5em red", styles, fe, false)
+	if len(got) != 2 {
+		t.Fatalf("got %d box shadows, want 2", len(got))
+	}
+	if s := got[0]; s.inset || s.dx != bag.MustSP("2pt") || s.dy != bag.MustSP("3pt") || s.blur != bag.MustSP("4pt") || s.spread != bag.MustSP("1pt") {
+		t.Errorf("first shadow = %+v", s)
+	}
+	if s := got[1]; !s.inset || s.blur != bag.MustSP("5pt") {
+		t.Errorf("second shadow = %+v, want inset with 5pt blur", s)
+	}
+
+	// text-shadow has neither spread nor inset.
+	if got := parseShadows("1pt 1pt 1pt 1pt gray", styles, fe, true); len(got) != 0 {
+		t.Errorf("text-shadow with spread accepted: %+v", got)
+	}
+	if got := parseShadows("none", styles, fe, true); got != nil {
+		t.Errorf("none = %+v, want nil", got)
+	}
+}
+
+func TestSplitAlpha(t *testing.T) {
+	cases := []struct {
+		in, color string
+		alpha     float64
+	}{
+		{"rgba(0, 0, 0, 0.5)", "rgb(0, 0, 0)", 0.5},
+		{"rgb(0 0 0 / 25%)", "rgb(0 0 0)", 0.25},
+		{"#ff000080", "#ff0000", 128.0 / 255},
+		{"#f008", "#f00", 136.0 / 255},
+		{"red", "red", 1},
+	}
+	for _, tc := range cases {
+		c, a := splitAlpha(tc.in)
+		if c != tc.color || math.Abs(a-tc.alpha) > 1e-9 {
+			t.Errorf("splitAlpha(%q) = %q, %g, want %q, %g", tc.in, c, a, tc.color, tc.alpha)
+		}
+	}
+}
+
+// TestBoxShadow: a box with only a box-shadow still goes through HTMLBorder
+// and gets an outer and an inset shadow rule.
+func TestBoxShadow(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.card { box-shadow: 2pt 2pt 4pt gray, inset 0 0 3pt blue; border-radius: 4pt; }`
+	html := `<html><body><div class="card">Karte</div></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	outer := borderRulePres(pages[0], "html box shadow")
+	if len(outer) != 1 {
+		t.Fatalf("got %d outer shadow rules, want 1", len(outer))
+	}
+	if !strings.Contains(outer[0], "W* n") {
+		t.Errorf("outer shadow is not clipped to the outside of the box: %q", outer[0])
+	}
+	if n := len(borderRulePres(pages[0], "html inset box shadow")); n != 1 {
+		t.Errorf("got %d inset shadow rules, want 1", n)
+	}
+}
+
+// countTextShadows counts the text shadow boxes below n.
+func countTextShadows(n node.Node) int {
+	count := 0
+	for ; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.HList:
+			count += countTextShadows(v.List)
+		case *node.VList:
+			if v.Attributes["origin"] == "text shadow" {
+				count++
+			}
+			count += countTextShadows(v.List)
+		}
+	}
+	return count
+}
+
+func pageTextShadows(pg *document.Page) int {
+	count := 0
+	for _, obj := range pg.Objects {
+		if obj.Vlist != nil {
+			count += countTextShadows(obj.Vlist.List)
+		}
+	}
+	return count
+}
+
+// TestTextShadow: every line of a heading with text-shadow carries a shadow
+// copy, and the flow below is not moved by it.
+func TestTextShadow(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+h1 { margin: 0; font-size: 20pt; line-height: 24pt; }
+p { margin: 0; }
+.shadow { text-shadow: 1pt 1pt rgba(0, 0, 0, 0.3); }`
+	pages := renderHTMLPages(t, css, `<html><body><h1 class="shadow">Titel</h1><p>NACHHER</p></body></html>`)
+	if n := pageTextShadows(pages[0]); n != 1 {
+		t.Fatalf("got %d text shadow lines, want 1", n)
+	}
+	if n := strings.Count(pageText(pages[0]), "Titel"); n != 2 {
+		t.Errorf("heading text appears %d times, want 2 (text and shadow)", n)
+	}
+	shadowed := lineTopY(pages[0], "NACHHER")
+	pages = renderHTMLPages(t, css, `<html><body><h1>Titel</h1><p>NACHHER</p></body></html>`)
+	if plain := lineTopY(pages[0], "NACHHER"); plain != shadowed {
+		t.Errorf("text-shadow moved the following flow: %s, want %s", shadowed, plain)
+	}
+}
+
+// countStartStops counts the start/stop nodes (links, destinations, tags)
+// of the list starting at n, nested lists included.
+func countStartStops(n node.Node) int {
+	count := 0
+	for ; n != nil; n = n.Next() {
+		switch v := n.(type) {
+		case *node.StartStop:
+			count++
+		case *node.HList:
+			count += countStartStops(v.List)
+		case *node.VList:
+			count += countStartStops(v.List)
+		}
+	}
+	return count
+}
+
+// TestTextShadowLinkFootnote: the shadow copy of a paragraph with a link and
+// a footnote repeats neither the link nor the footnote.
+func TestTextShadowLinkFootnote(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.shadow { text-shadow: 1pt 1pt gray; }
+fn { text-shadow: none; }`
+	html := func(class string) string {
+		return `<html><body><p class="` + class + `">Siehe <a href="https://example.com/" id="ziel">VERWEIS</a> hier<fn>FUSSNOTE</fn>.</p></body></html>`
+	}
+	startStops := func(pages []*document.Page) int {
+		count := 0
+		for _, obj := range pages[0].Objects {
+			if obj.Vlist != nil {
+				count += countStartStops(obj.Vlist.List)
+			}
+		}
+		return count
+	}
+	plain := renderHTMLPages(t, css, html(""))
+	pages := renderHTMLPages(t, css, html("shadow"))
+	if n := pageTextShadows(pages[0]); n != 1 {
+		t.Fatalf("got %d text shadow lines, want 1", n)
+	}
+	if got, want := startStops(pages), startStops(plain); got != want {
+		t.Errorf("%d start/stop nodes with text-shadow, %d without", got, want)
+	}
+	if n := strings.Count(pageText(pages[0]), "FUSSNOTE"); n != 1 {
+		t.Errorf("footnote appears %d times, want 1", n)
+	}
+}
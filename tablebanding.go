@@ -0,0 +1,166 @@
+package htmlbag
+
+import (
+	"strconv"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+)
+
+// rowBanding is the -bag-row-banding of a table, carried by
+// settingRowBanding: the body rows get the two colors in turn, size rows at
+// a time. The banding counts the rows from the first body row of the
+// table, or with restart from the first body row on each page. Unlike
+// tr:nth-child backgrounds, which the cascade resolves once, the bands are
+// painted again wherever outputTableRows places the rows, so rebuilt and
+// split rows stay in phase.
+type rowBanding struct {
+	colors  [2]*color.Color // nil is transparent
+	size    int
+	restart bool
+}
+
+// parseRowBanding parses a -bag-row-banding value:
+//
+//	none | <color> [<color>]? [<integer>]? [restart]?
+//
+// The second color defaults to transparent, the integer (rows per band) to
+// 1. It returns nil for none and for invalid values.
+func parseRowBanding(v string, styles *FormattingStyles, df *frontend.Document) *rowBanding {
+	rb := &rowBanding{size: 1}
+	ncolors := 0
+	for _, tok := range splitTopLevel(v, ' ') {
+		if n, err := strconv.Atoi(tok); err == nil {
+			if n < 1 {
+				return nil
+			}
+			rb.size = n
+			continue
+		}
+		switch tok {
+		case "none":
+			return nil
+		case "restart":
+			rb.restart = true
+			continue
+		}
+		if ncolors == 2 {
+			return nil
+		}
+		if tok != "transparent" {
+			c := shadowColor(tok, styles.color, df)
+			if c == nil {
+				return nil
+			}
+			rb.colors[ncolors] = c
+		}
+		ncolors++
+	}
+	if ncolors == 0 {
+		return nil
+	}
+	return rb
+}
+
+// color returns the color of the body row k (counted from 0).
+func (rb *rowBanding) color(k int) *color.Color {
+	return rb.colors[(k/rb.size)%2]
+}
+
+// bandCell is what paintRowBand needs to know about a cell box of a banded
+// row: the border spacing around its border box, and whether the cell has a
+// background of its own, which covers the band.
+type bandCell struct {
+	inset [4]bag.ScaledPoint
+	own   bool
+}
+
+// bandTableRows paints the bands of rb on the body rows of the table vl,
+// built from te as tbl, and prepares the rows for paintRowBand. Called by
+// buildTable while the cell backgrounds of the table are still at hand.
+func (cb *CSSBuilder) bandTableRows(vl *node.VList, te *frontend.Text, tbl *frontend.Table, rb *rowBanding) {
+	g := newTableGrid(te)
+	if len(g.rows) != len(tbl.Rows) {
+		return
+	}
+	vl.Attributes["_banding"] = rb
+	r := 0
+	for n := vl.List; n != nil && r < len(tbl.Rows); n = n.Next() {
+		hl, ok := n.(*node.HList)
+		if !ok {
+			continue
+		}
+		k := r - tbl.HeaderRows
+		if k >= 0 && r < len(tbl.Rows)-tbl.FooterRows {
+			cells, _ := g.rowCells(r)
+			tds := tbl.Rows[r].Cells
+			if len(cells) == len(tds) {
+				bc := make([]bandCell, len(tds))
+				for i, td := range tds {
+					_, hasBg := cb.tableCellBackgrounds[td]
+					bc[i].own = hasBg || td.BackgroundColor != nil
+					if m := cb.tableCellModels[cells[i].te]; m != nil && !m.collapse {
+						bc[i].inset = m.spacing
+					}
+				}
+				if hl.Attributes == nil {
+					hl.Attributes = node.H{}
+				}
+				hl.Attributes["_bandCells"] = bc
+				paintRowBand(hl, rb.color(k))
+			}
+		}
+		r++
+	}
+}
+
+// paintRowBand paints the row (a row box prepared by bandTableRows or a
+// part of one) in the band color c, nil for none. The band is a hidden
+// rule at the top of every cell box without a background of its own,
+// below everything the cell draws; painting again recolors the rules.
+func paintRowBand(row node.Node, c *color.Color) {
+	hl, ok := row.(*node.HList)
+	if !ok || hl.Attributes == nil {
+		return
+	}
+	cells, _ := hl.Attributes["_bandCells"].([]bandCell)
+	boxes := cellBoxes(hl)
+	if cells == nil || len(cells) != len(boxes) {
+		return
+	}
+	rules, _ := hl.Attributes["_bandRules"].([]*node.Rule)
+	if rules == nil {
+		rules = make([]*node.Rule, len(boxes))
+		for i, box := range boxes {
+			if cells[i].own {
+				continue
+			}
+			r := node.NewRule()
+			r.Hide = true
+			r.Attributes = node.H{"origin": "table row band"}
+			if box.List == nil {
+				box.List = r
+			} else {
+				box.List = node.InsertBefore(box.List, box.List, r)
+			}
+			rules[i] = r
+		}
+		hl.Attributes["_bandRules"] = rules
+	}
+	for i, r := range rules {
+		if r == nil {
+			continue
+		}
+		r.Pre = ""
+		if c == nil || c.Space == color.ColorNone {
+			continue
+		}
+		wd, ht := boxes[i].Width, boxes[i].Height+boxes[i].Depth
+		in := cells[i].inset
+		x0, y0, x1, y1 := in[sideLeft], -in[sideTop], wd-in[sideRight], -ht+in[sideBottom]
+		r.Pre = "q " + pdfdraw.New().ColorNonstroking(*c).Rect(x0, y1, x1-x0, y0-y1).Fill().String() + " Q"
+	}
+}
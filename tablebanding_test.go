@@ -0,0 +1,87 @@
+package htmlbag
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+func TestParseRowBanding(t *testing.T) {
+	var buf bytes.Buffer
+	df, err := frontend.NewForWriter(&buf)
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	styles := &FormattingStyles{Fontsize: bag.MustSP("10pt"), DefaultFontSize: bag.MustSP("10pt")}
+	for _, tc := range []struct {
+		in      string
+		colors  [2]bool
+		size    int
+		restart bool
+	}{
+		{"#eee", [2]bool{true, false}, 1, false},
+		{"#eee #ddd 2", [2]bool{true, true}, 2, false},
+		{"transparent rgb(240, 240, 240) restart", [2]bool{false, true}, 1, true},
+	} {
+		rb := parseRowBanding(tc.in, styles, df)
+		if rb == nil {
+			t.Errorf("parseRowBanding(%q) = nil", tc.in)
+			continue
+		}
+		if (rb.colors[0] != nil) != tc.colors[0] || (rb.colors[1] != nil) != tc.colors[1] || rb.size != tc.size || rb.restart != tc.restart {
+			t.Errorf("parseRowBanding(%q) = %+v", tc.in, rb)
+		}
+	}
+	for _, v := range []string{"none", "restart", "#eee 0", "#eee #ddd #ccc", "nocolor"} {
+		if rb := parseRowBanding(v, styles, df); rb != nil {
+			t.Errorf("parseRowBanding(%q) = %+v, want nil", v, rb)
+		}
+	}
+}
+
+// TestRowBanding: every other body row is painted, the header row is not.
+func TestRowBanding(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { -bag-row-banding: #eee; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><thead><tr><th>KOPF</th></tr></thead><tbody>`+
+		`<tr><td>A</td></tr><tr><td>B</td></tr><tr><td>C</td></tr><tr><td>D</td></tr></tbody></table></body></html>`)
+	pres := borderRulePres(pages[0], "table row band")
+	if len(pres) != 4 {
+		t.Fatalf("got %d band rules, want 4", len(pres))
+	}
+	for i, pre := range pres {
+		if (pre != "") != (i%2 == 0) {
+			t.Errorf("row %d: band %q", i, pre)
+		}
+	}
+}
+
+// TestRowBandingPages: the banding of a table over several pages goes on
+// across the break, with restart it starts over on every page.
+func TestRowBandingPages(t *testing.T) {
+	for _, restart := range []bool{false, true} {
+		css := `@page { size: a4; margin: 20mm; }
+table { -bag-row-banding: #eee; }`
+		if restart {
+			css += `
+table { -bag-row-banding: #eee restart; }`
+		}
+		pages := renderHTMLPages(t, css, `<html><body><table><thead><tr><th>KOPF</th><th>Betrag</th></tr></thead><tbody>`+sumRows(80)+`</tbody></table></body></html>`)
+		if len(pages) < 2 {
+			t.Fatalf("got %d pages, want the table split", len(pages))
+		}
+		first, second := borderRulePres(pages[0], "table row band"), borderRulePres(pages[1], "table row band")
+		if len(first) == 0 || len(second) == 0 {
+			t.Fatalf("restart %t: no band rules", restart)
+		}
+		// Two cells per row; the first row of the second page is painted
+		// when it starts over or when the first page holds an even
+		// number of rows.
+		want := restart || len(first)/2%2 == 0
+		if (second[0] != "") != want {
+			t.Errorf("restart %t: %d rows on the first page, first band on the next %q", restart, len(first)/2, second[0])
+		}
+	}
+}
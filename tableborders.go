@@ -0,0 +1,423 @@
+package htmlbag
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// tableBorders is the border model of a table (CSS 2.1 §17.6), carried
+// from Output() to buildTable by settingTableBorders. Tables that declare
+// no border-collapse keep the original model: every cell draws its own
+// borders and the border-spacing of the user agent style sheet is ignored.
+type tableBorders struct {
+	collapse bool
+	// spacingH and spacingV are the border-spacing of the separated
+	// model.
+	spacingH, spacingV bag.ScaledPoint
+}
+
+// parseBorderSpacing parses the CSS border-spacing value v: one length for
+// both directions or a horizontal and a vertical one.
+func parseBorderSpacing(v string, fontsize, rootFontsize bag.ScaledPoint) (bag.ScaledPoint, bag.ScaledPoint) {
+	f := strings.Fields(v)
+	switch len(f) {
+	case 1:
+		s := ParseRelativeSize(f[0], fontsize, rootFontsize)
+		return s, s
+	case 2:
+		return ParseRelativeSize(f[0], fontsize, rootFontsize), ParseRelativeSize(f[1], fontsize, rootFontsize)
+	}
+	return 0, 0
+}
+
+// The sides of a box in the order of the CSS shorthands.
+const (
+	sideTop = iota
+	sideRight
+	sideBottom
+	sideLeft
+)
+
+var (
+	borderWidthSettings = [4]frontend.SettingType{frontend.SettingBorderTopWidth, frontend.SettingBorderRightWidth, frontend.SettingBorderBottomWidth, frontend.SettingBorderLeftWidth}
+	borderStyleSettings = [4]frontend.SettingType{frontend.SettingBorderTopStyle, frontend.SettingBorderRightStyle, frontend.SettingBorderBottomStyle, frontend.SettingBorderLeftStyle}
+	borderColorSettings = [4]frontend.SettingType{frontend.SettingBorderTopColor, frontend.SettingBorderRightColor, frontend.SettingBorderBottomColor, frontend.SettingBorderLeftColor}
+)
+
+// The origins of a border in a table, in the order in which they win a
+// border conflict of equal width and style (CSS 2.1 §17.6.2.1). Columns
+// and column groups carry no borders in this package (v1).
+const (
+	borderOriginTable = iota
+	borderOriginRowGroup
+	borderOriginRow
+	borderOriginCell
+)
+
+// borderEdge is one side of the border of a table element. alpha is the
+// constant alpha it is painted with, the opacity of the element and its
+// groups included.
+type borderEdge struct {
+	width  bag.ScaledPoint
+	style  frontend.BorderStyle
+	color  *color.Color
+	alpha  float64
+	origin int
+}
+
+// settingsEdge returns the border side of the element with the settings s.
+func settingsEdge(s frontend.TypesettingSettings, side, origin int) borderEdge {
+	e := borderEdge{origin: origin}
+	e.width, _ = s[borderWidthSettings[side]].(bag.ScaledPoint)
+	e.style, _ = s[borderStyleSettings[side]].(frontend.BorderStyle)
+	e.color, _ = s[borderColorSettings[side]].(*color.Color)
+	e.alpha = 1
+	if bt, ok := s[settingTransparency].(*boxTransparency); ok {
+		e.alpha = bt.state(bt.border[side]).fill
+	}
+	return e
+}
+
+// drawn returns the width of the border, 0 when it is not drawn.
+func (e borderEdge) drawn() bag.ScaledPoint {
+	if e.style == frontend.BorderStyleNone || e.style == borderStyleHidden {
+		return 0
+	}
+	return e.width
+}
+
+// borderStyleRank orders the border styles for a border conflict of equal
+// width: double wins over solid, solid over dashed and so on down to inset.
+func borderStyleRank(sty frontend.BorderStyle) int {
+	switch sty {
+	case borderStyleDouble:
+		return 8
+	case frontend.BorderStyleSolid:
+		return 7
+	case borderStyleDashed:
+		return 6
+	case borderStyleDotted:
+		return 5
+	case borderStyleRidge:
+		return 4
+	case borderStyleOutset:
+		return 3
+	case borderStyleGroove:
+		return 2
+	case borderStyleInset:
+		return 1
+	}
+	return 0
+}
+
+// beats reports whether e wins the border conflict against o: the wider
+// border, then the style, then the origin. Of two equal borders the one
+// given first wins, the caller lists them left to right and top to bottom.
+func (e borderEdge) beats(o borderEdge) bool {
+	if ew, ow := e.drawn(), o.drawn(); ew != ow {
+		return ew > ow
+	} else if ew == 0 {
+		return false
+	}
+	if r, s := borderStyleRank(e.style), borderStyleRank(o.style); r != s {
+		return r > s
+	}
+	return e.origin > o.origin
+}
+
+// resolveEdge resolves the border conflict between the candidates (CSS 2.1
+// §17.6.2.1): hidden suppresses all borders, otherwise the winner of beats.
+func resolveEdge(cands []borderEdge) borderEdge {
+	var win borderEdge
+	for i, c := range cands {
+		if c.style == borderStyleHidden {
+			return borderEdge{}
+		}
+		if i == 0 || c.beats(win) {
+			win = c
+		}
+	}
+	if win.drawn() == 0 {
+		return borderEdge{}
+	}
+	return win
+}
+
+// tableGridCell is a cell of the table grid: the first row and column it
+// occupies and the number of rows and columns it spans.
+type tableGridCell struct {
+	te                         *frontend.Text
+	row, col, rowSpan, colSpan int
+}
+
+// tableGrid is the grid of the cells of a table in the order buildTable
+// emits the rows: header rows, body rows, footer rows.
+type tableGrid struct {
+	at     [][]*tableGridCell // the cell at row, column; nil for an empty slot
+	rows   []*frontend.Text   // the tr of every row
+	groups []*frontend.Text   // the row group of every row
+	cells  []*tableGridCell
+	ncols  int
+}
+
+// newTableGrid places the cells of the table te like the HTML table model:
+// every cell takes the next column not covered by a row-spanning cell
+// above.
+func newTableGrid(te *frontend.Text) *tableGrid {
+	g := &tableGrid{}
+	for _, section := range []string{"thead", "tbody", "tfoot"} {
+		for _, itm := range te.Items {
+			grp, ok := itm.(*frontend.Text)
+			if !ok {
+				continue
+			}
+			if elt, _ := grp.Settings[frontend.SettingDebug].(string); elt != section {
+				continue
+			}
+			for _, itm := range grp.Items {
+				tr, ok := itm.(*frontend.Text)
+				if !ok {
+					continue
+				}
+				if elt, _ := tr.Settings[frontend.SettingDebug].(string); elt == "tr" {
+					g.rows = append(g.rows, tr)
+					g.groups = append(g.groups, grp)
+				}
+			}
+		}
+	}
+	g.at = make([][]*tableGridCell, len(g.rows))
+	for r, tr := range g.rows {
+		col := 0
+		for _, itm := range tr.Items {
+			td, ok := itm.(*frontend.Text)
+			if !ok {
+				continue
+			}
+			if elt, _ := td.Settings[frontend.SettingDebug].(string); elt != "td" && elt != "th" {
+				continue
+			}
+			c := &tableGridCell{te: td, row: r, rowSpan: 1, colSpan: 1}
+			if n, ok := td.Settings[frontend.SettingColspan].(int); ok && n > 1 {
+				c.colSpan = n
+			}
+			if n, ok := td.Settings[frontend.SettingRowspan].(int); ok && n > 1 {
+				c.rowSpan = min(n, len(g.rows)-r)
+			}
+			for col < len(g.at[r]) && g.at[r][col] != nil {
+				col++
+			}
+			c.col = col
+			for y := r; y < r+c.rowSpan; y++ {
+				for len(g.at[y]) < col+c.colSpan {
+					g.at[y] = append(g.at[y], nil)
+				}
+				for x := col; x < col+c.colSpan; x++ {
+					g.at[y][x] = c
+				}
+			}
+			col += c.colSpan
+			g.ncols = max(g.ncols, col)
+			g.cells = append(g.cells, c)
+		}
+	}
+	return g
+}
+
+// cell returns the cell at row r and column c, nil outside the grid.
+func (g *tableGrid) cell(r, c int) *tableGridCell {
+	if r < 0 || r >= len(g.at) || c < 0 || c >= len(g.at[r]) {
+		return nil
+	}
+	return g.at[r][c]
+}
+
+// cellBorderModel is what the border model of the table makes of the
+// borders of one cell: the borders left to the cell in the collapsing
+// model, the border spacing around its border box in the separated model.
+type cellBorderModel struct {
+	collapse bool
+	edges    [4]borderEdge
+	spacing  [4]bag.ScaledPoint
+}
+
+// cellBorderModels applies the border model tb to the cells of the table
+// te. It returns nil for the original model.
+func cellBorderModels(te *frontend.Text, tb *tableBorders) map[*frontend.Text]*cellBorderModel {
+	if tb == nil {
+		return nil
+	}
+	g := newTableGrid(te)
+	models := make(map[*frontend.Text]*cellBorderModel, len(g.cells))
+	if tb.collapse {
+		collapseBorders(g, te, models)
+		return models
+	}
+	if tb.spacingH == 0 && tb.spacingV == 0 {
+		return nil
+	}
+	// The spacing between two cells is split between them, the cells at
+	// the edges of the table take the whole spacing on the outside.
+	h, v := tb.spacingH/2, tb.spacingV/2
+	for _, c := range g.cells {
+		m := &cellBorderModel{spacing: [4]bag.ScaledPoint{v, h, v, h}}
+		if c.row == 0 {
+			m.spacing[sideTop] += tb.spacingV - v
+		}
+		if c.row+c.rowSpan == len(g.rows) {
+			m.spacing[sideBottom] += tb.spacingV - v
+		}
+		if c.col == 0 {
+			m.spacing[sideLeft] += tb.spacingH - h
+		}
+		if c.col+c.colSpan == g.ncols {
+			m.spacing[sideRight] += tb.spacingH - h
+		}
+		models[c.te] = m
+	}
+	return models
+}
+
+// collapseBorders resolves the border conflicts of the collapsing border
+// model (CSS 2.1 §17.6.2) for every segment of the grid lines, between the
+// cells, rows, row groups and the table meeting there. The frontend draws
+// the border of a cell inside the cell, so every segment is drawn by one
+// cell only: a horizontal line by the cell above it (by the cell below at
+// the top of the table), a vertical line by the cell left of it. A cell
+// side along several segments takes the strongest of their borders (v1,
+// CSS draws each segment on its own). The table border is merged into the
+// outer cells, the table itself draws none.
+func collapseBorders(g *tableGrid, te *frontend.Text, models map[*frontend.Text]*cellBorderModel) {
+	owned := map[*tableGridCell]*[4][]borderEdge{}
+	own := func(c *tableGridCell, side int, e borderEdge) {
+		if owned[c] == nil {
+			owned[c] = &[4][]borderEdge{}
+		}
+		owned[c][side] = append(owned[c][side], e)
+	}
+	nrows := len(g.rows)
+	// Horizontal lines, y is the line above row y.
+	for y := 0; y <= nrows; y++ {
+		for x := 0; x < g.ncols; x++ {
+			above, below := g.cell(y-1, x), g.cell(y, x)
+			if above == below {
+				// Inside a cell spanning rows, or outside the grid.
+				continue
+			}
+			var cands []borderEdge
+			if above != nil {
+				cands = append(cands, settingsEdge(above.te.Settings, sideBottom, borderOriginCell))
+			}
+			if below != nil {
+				cands = append(cands, settingsEdge(below.te.Settings, sideTop, borderOriginCell))
+			}
+			if y > 0 {
+				cands = append(cands, settingsEdge(g.rows[y-1].Settings, sideBottom, borderOriginRow))
+			}
+			if y < nrows {
+				cands = append(cands, settingsEdge(g.rows[y].Settings, sideTop, borderOriginRow))
+			}
+			if y > 0 && (y == nrows || g.groups[y] != g.groups[y-1]) {
+				cands = append(cands, settingsEdge(g.groups[y-1].Settings, sideBottom, borderOriginRowGroup))
+			}
+			if y < nrows && (y == 0 || g.groups[y] != g.groups[y-1]) {
+				cands = append(cands, settingsEdge(g.groups[y].Settings, sideTop, borderOriginRowGroup))
+			}
+			if y == 0 {
+				cands = append(cands, settingsEdge(te.Settings, sideTop, borderOriginTable))
+			}
+			if y == nrows {
+				cands = append(cands, settingsEdge(te.Settings, sideBottom, borderOriginTable))
+			}
+			if above != nil {
+				own(above, sideBottom, resolveEdge(cands))
+			} else {
+				own(below, sideTop, resolveEdge(cands))
+			}
+		}
+	}
+	// Vertical lines, x is the line left of column x.
+	for y := 0; y < nrows; y++ {
+		for x := 0; x <= g.ncols; x++ {
+			left, right := g.cell(y, x-1), g.cell(y, x)
+			if left == right {
+				continue
+			}
+			var cands []borderEdge
+			if left != nil {
+				cands = append(cands, settingsEdge(left.te.Settings, sideRight, borderOriginCell))
+			}
+			if right != nil {
+				cands = append(cands, settingsEdge(right.te.Settings, sideLeft, borderOriginCell))
+			}
+			// Rows, row groups and the table have borders on the
+			// outer edges only.
+			if x == 0 {
+				cands = append(cands,
+					settingsEdge(g.rows[y].Settings, sideLeft, borderOriginRow),
+					settingsEdge(g.groups[y].Settings, sideLeft, borderOriginRowGroup),
+					settingsEdge(te.Settings, sideLeft, borderOriginTable))
+			}
+			if x == g.ncols {
+				cands = append(cands,
+					settingsEdge(g.rows[y].Settings, sideRight, borderOriginRow),
+					settingsEdge(g.groups[y].Settings, sideRight, borderOriginRowGroup),
+					settingsEdge(te.Settings, sideRight, borderOriginTable))
+			}
+			if left != nil {
+				own(left, sideRight, resolveEdge(cands))
+			} else {
+				own(right, sideLeft, resolveEdge(cands))
+			}
+		}
+	}
+	for _, c := range g.cells {
+		m := &cellBorderModel{collapse: true}
+		if sides := owned[c]; sides != nil {
+			for side, segs := range sides {
+				if len(segs) > 0 {
+					m.edges[side] = resolveEdge(segs)
+				}
+			}
+		}
+		models[c.te] = m
+	}
+}
+
+// cellBorderTransparency returns the transparency the borders of a cell
+// with the transparency bt are drawn with. In the collapsing model it
+// carries the alpha of the element each border was taken from instead, nil
+// when they are all opaque.
+func cellBorderTransparency(bt *boxTransparency, m *cellBorderModel) *boxTransparency {
+	if m == nil || !m.collapse {
+		return bt
+	}
+	edges := &boxTransparency{opacity: 1, text: 1, background: 1, ambient: 1}
+	translucent := false
+	for side, e := range m.edges {
+		edges.border[side] = e.alpha
+		if e.drawn() > 0 && e.alpha < 1 {
+			translucent = true
+		}
+	}
+	if !translucent {
+		return nil
+	}
+	return edges
+}
+
+// settings returns the settings of the cell with the borders of the
+// collapsing model in place of its own. s is left untouched.
+func (m *cellBorderModel) settings(s frontend.TypesettingSettings) frontend.TypesettingSettings {
+	s = maps.Clone(s)
+	for side, e := range m.edges {
+		s[borderWidthSettings[side]] = e.drawn()
+		s[borderStyleSettings[side]] = e.style
+		s[borderColorSettings[side]] = e.color
+	}
+	return s
+}
@@ -0,0 +1,201 @@
+package htmlbag
+
+import (
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+func TestResolveEdge(t *testing.T) {
+	solid := func(w string, origin int) borderEdge {
+		return borderEdge{width: bag.MustSP(w), style: frontend.BorderStyleSolid, origin: origin}
+	}
+	double := borderEdge{width: bag.MustSP("1pt"), style: borderStyleDouble, origin: borderOriginTable}
+	hidden := borderEdge{style: borderStyleHidden, origin: borderOriginTable}
+	first := solid("1pt", borderOriginCell)
+	cases := []struct {
+		name  string
+		cands []borderEdge
+		want  borderEdge
+	}{
+		{"wider wins", []borderEdge{solid("1pt", borderOriginCell), solid("2pt", borderOriginTable)}, solid("2pt", borderOriginTable)},
+		{"double over solid", []borderEdge{solid("1pt", borderOriginCell), double}, double},
+		{"cell over row", []borderEdge{solid("1pt", borderOriginRow), solid("1pt", borderOriginCell)}, solid("1pt", borderOriginCell)},
+		{"first of equals", []borderEdge{first, solid("1pt", borderOriginCell)}, first},
+		{"hidden suppresses", []borderEdge{solid("3pt", borderOriginCell), hidden}, borderEdge{}},
+		{"none", []borderEdge{{origin: borderOriginCell}, {origin: borderOriginTable}}, borderEdge{}},
+	}
+	for _, tc := range cases {
+		if got := resolveEdge(tc.cands); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// tableText returns a table Text with one tbody of the given rows.
+func tableText(rows ...*frontend.Text) *frontend.Text {
+	tbody := frontend.NewText()
+	tbody.Settings[frontend.SettingDebug] = "tbody"
+	for _, tr := range rows {
+		tbody.Items = append(tbody.Items, tr)
+	}
+	table := frontend.NewText()
+	table.Settings[frontend.SettingDebug] = "table"
+	table.Items = append(table.Items, tbody)
+	return table
+}
+
+// rowText returns a tr Text with the cells.
+func rowText(cells ...*frontend.Text) *frontend.Text {
+	tr := frontend.NewText()
+	tr.Settings[frontend.SettingDebug] = "tr"
+	for _, td := range cells {
+		tr.Items = append(tr.Items, td)
+	}
+	return tr
+}
+
+// cellText returns a td Text with a solid border of width w on all sides.
+func cellText(w string, colspan, rowspan int) *frontend.Text {
+	td := frontend.NewText()
+	td.Settings[frontend.SettingDebug] = "td"
+	setBorders(td, w)
+	if colspan > 1 {
+		td.Settings[frontend.SettingColspan] = colspan
+	}
+	if rowspan > 1 {
+		td.Settings[frontend.SettingRowspan] = rowspan
+	}
+	return td
+}
+
+func setBorders(te *frontend.Text, w string) {
+	for side := range 4 {
+		te.Settings[borderWidthSettings[side]] = bag.MustSP(w)
+		te.Settings[borderStyleSettings[side]] = frontend.BorderStyleSolid
+	}
+}
+
+func TestTableGrid(t *testing.T) {
+	a, b, c, d, e := cellText("0pt", 1, 2), cellText("0pt", 2, 1), cellText("0pt", 1, 1), cellText("0pt", 1, 1), cellText("0pt", 1, 1)
+	g := newTableGrid(tableText(rowText(a, b), rowText(c, d), rowText(e)))
+	if g.ncols != 3 || len(g.rows) != 3 {
+		t.Fatalf("grid is %dx%d, want 3x3", g.ncols, len(g.rows))
+	}
+	for _, tc := range []struct {
+		name     string
+		te       *frontend.Text
+		row, col int
+	}{
+		{"a", a, 0, 0}, {"b", b, 0, 1},
+		// The row-spanning a covers the first column of the second row.
+		{"c", c, 1, 1}, {"d", d, 1, 2}, {"e", e, 2, 0},
+	} {
+		var found *tableGridCell
+		for _, gc := range g.cells {
+			if gc.te == tc.te {
+				found = gc
+			}
+		}
+		if found == nil || found.row != tc.row || found.col != tc.col {
+			t.Errorf("%s at %+v, want %d/%d", tc.name, found, tc.row, tc.col)
+		}
+	}
+}
+
+// TestCollapseBorders: every grid line is drawn once, by the cell above or
+// left of it, and the wider table border wins on the outside.
+func TestCollapseBorders(t *testing.T) {
+	a, b := cellText("1pt", 1, 1), cellText("1pt", 1, 1)
+	c, d := cellText("1pt", 1, 1), cellText("1pt", 1, 1)
+	table := tableText(rowText(a, b), rowText(c, d))
+	setBorders(table, "2pt")
+	models := cellBorderModels(table, &tableBorders{collapse: true})
+	one, two := bag.MustSP("1pt"), bag.MustSP("2pt")
+	want := map[*frontend.Text][4]bag.ScaledPoint{
+		a: {two, one, one, two},
+		b: {two, two, one, 0},
+		c: {0, one, two, two},
+		d: {0, two, two, 0},
+	}
+	for i, te := range []*frontend.Text{a, b, c, d} {
+		m := models[te]
+		var got [4]bag.ScaledPoint
+		for side := range 4 {
+			got[side] = m.edges[side].drawn()
+		}
+		if got != want[te] {
+			t.Errorf("cell %d: borders %v, want %v", i, got, want[te])
+		}
+	}
+}
+
+// TestBorderCollapseRender: collapsed borders between two rows take the
+// space of one border, not two.
+func TestBorderCollapseRender(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+td { border: 4pt solid black; padding: 0; }
+.c { border-collapse: collapse; }`
+	rowGap := func(class string) bag.ScaledPoint {
+		pages := renderHTMLPages(t, css, `<html><body><table class="`+class+`"><tr><td>OBEN</td></tr><tr><td>UNTEN</td></tr></table></body></html>`)
+		_, y1, ok1 := textPos(pages[0], "OBEN")
+		_, y2, ok2 := textPos(pages[0], "UNTEN")
+		if !ok1 || !ok2 {
+			t.Fatal("rows not found")
+		}
+		return y1 - y2
+	}
+	separate, collapsed := rowGap(""), rowGap("c")
+	if d := separate - collapsed; !nearly(d, bag.MustSP("4pt")) {
+		t.Errorf("rows %s apart collapsed, %s separate, want one border less", collapsed, separate)
+	}
+}
+
+// TestBorderSpacing: with border-collapse: separate the cells keep the
+// border-spacing to each other and to the table edge; a table without
+// border-collapse keeps the original layout.
+func TestBorderSpacing(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+td { padding: 0; }
+.s { border-collapse: separate; border-spacing: 10pt 6pt; }`
+	left := bag.MustSP("20mm")
+	pages := renderHTMLPages(t, css, `<html><body><table class="s"><tr><td>ERSTE</td><td>ZWEITE</td></tr><tr><td>DRITTE</td><td>VIERTE</td></tr></table></body></html>`)
+	x1, y1, _ := textPos(pages[0], "ERSTE")
+	x3, y3, ok := textPos(pages[0], "DRITTE")
+	if !ok {
+		t.Fatal("cells not found")
+	}
+	if !nearly(x1, left+bag.MustSP("10pt")) || x3 != x1 {
+		t.Errorf("first column at %s/%s, want %s", x1, x3, left+bag.MustSP("10pt"))
+	}
+	plain := renderHTMLPages(t, css, `<html><body><table><tr><td>ERSTE</td><td>ZWEITE</td></tr><tr><td>DRITTE</td><td>VIERTE</td></tr></table></body></html>`)
+	px, py1, _ := textPos(plain[0], "ERSTE")
+	_, py3, _ := textPos(plain[0], "DRITTE")
+	if px != left {
+		t.Errorf("table without border-collapse: first column at %s, want %s", px, left)
+	}
+	if d := (y1 - y3) - (py1 - py3); !nearly(d, bag.MustSP("6pt")) {
+		t.Errorf("rows %s further apart with border-spacing, want 6pt", d)
+	}
+}
+
+// TestBorderSpacingHeaderPages: in the separated model the header row the
+// table repeats on every page keeps its border and background.
+func TestBorderSpacingHeaderPages(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { border-collapse: separate; border-spacing: 2pt; }
+th { border: 1pt solid black; background-color: #ddd; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><thead><tr><th>KOPF</th><th>Betrag</th></tr></thead><tbody>`+sumRows(90)+`</tbody></table></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the table split", len(pages))
+	}
+	for p, pg := range pages {
+		if n := len(borderRulePres(pg, "table cell border")); n != 2 {
+			t.Errorf("page %d: %d header cell borders, want 2", p+1, n)
+		}
+		if n := len(borderRulePres(pg, "html background color")); n != 2 {
+			t.Errorf("page %d: %d header cell backgrounds, want 2", p+1, n)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package htmlbag
+
+import (
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// TableBreak describes a page break inside a table for a
+// TableBreakCallbackFunc. The rows hold the text of every cell, one entry
+// per table column; a cell spanning columns is given at its first column,
+// the columns it covers are empty.
+type TableBreak struct {
+	// Table is the <table> element.
+	Table *frontend.Text
+	// Columns is the number of table columns.
+	Columns int
+	// PageRows are the body rows on the page the table breaks off, Rows
+	// all body rows placed so far.
+	PageRows [][]string
+	Rows     [][]string
+}
+
+// TableBreakCell is a cell of a row generated at a table break. Colspan 0
+// is one column.
+type TableBreakCell struct {
+	Text    string
+	Colspan int
+}
+
+// TableBreakCallbackFunc returns the rows generated when a table breaks
+// across pages: carried goes below the last body row on the page the table
+// breaks off ("carried forward"), brought above the first body row on the
+// next page, below the repeated header rows ("brought forward"). A nil row
+// is left out. The callback is called once more before the table is placed,
+// with all body rows, to measure the space the carried row needs at the
+// bottom of a page.
+type TableBreakCallbackFunc func(tb *TableBreak) (carried, brought []TableBreakCell)
+
+// runningSum is the -bag-running-sum of a table: the 1-based column summed
+// up and the labels of the carried and brought rows, carried from Output()
+// to buildTable and outputTableRows by settingRunningSum.
+type runningSum struct {
+	column           int
+	carried, brought string
+}
+
+// parseRunningSum parses `none | column <integer> [<string> [<string>]]`.
+func parseRunningSum(v string) *runningSum {
+	toks := cssStringTokens(v)
+	if len(toks) < 2 || toks[0] != "column" {
+		return nil
+	}
+	col, err := strconv.Atoi(toks[1])
+	if err != nil || col < 1 {
+		return nil
+	}
+	rs := &runningSum{column: col, carried: "Carried forward", brought: "Brought forward"}
+	if len(toks) > 2 {
+		rs.carried = strings.Trim(toks[2], "'\"")
+	}
+	if len(toks) > 3 {
+		rs.brought = strings.Trim(toks[3], "'\"")
+	}
+	return rs
+}
+
+// cssStringTokens splits v at spaces outside of quoted strings. The quotes
+// stay on the strings.
+func cssStringTokens(v string) []string {
+	var toks []string
+	var quote rune
+	start := -1
+	for i, r := range v {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			if start < 0 {
+				start = i
+			}
+		case unicode.IsSpace(r):
+			if start >= 0 {
+				toks = append(toks, v[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, v[start:])
+	}
+	return toks
+}
+
+// amountFormat is the way the cells of a column write their numbers. The
+// running sum is written the same way.
+type amountFormat struct {
+	valid                  bool
+	decimal, group         rune
+	haveDecimal, haveGroup bool
+	decimals               int
+	prefix, suffix         string
+}
+
+// parseAmount reads the number in the cell text s, currency signs and other
+// text around it are kept in the format. Of "." and "," the last one is the
+// decimal separator unless exactly three digits follow it and it is the
+// only kind of separator, then it groups thousands ("1.234" is 1234).
+func parseAmount(s string) (float64, amountFormat, bool) {
+	var f amountFormat
+	first := strings.IndexFunc(s, unicode.IsDigit)
+	if first < 0 {
+		return 0, f, false
+	}
+	last := strings.LastIndexFunc(s, unicode.IsDigit)
+	f.prefix, f.suffix = s[:first], s[last+1:]
+	neg := false
+	if p := strings.TrimRightFunc(f.prefix, unicode.IsSpace); strings.HasSuffix(p, "-") || strings.HasSuffix(p, "−") {
+		neg = true
+		f.prefix = strings.TrimSuffix(strings.TrimSuffix(p, "-"), "−")
+	}
+	core := s[first : last+1]
+	sep := strings.LastIndexAny(core, ".,")
+	if sep >= 0 {
+		d := rune(core[sep])
+		other := ','
+		if d == ',' {
+			other = '.'
+		}
+		if len(core)-sep-1 != 3 || strings.ContainsRune(core[:sep], other) {
+			f.decimal, f.haveDecimal, f.decimals = d, true, len(core)-sep-1
+		}
+	}
+	var digits strings.Builder
+	for i, r := range core {
+		switch {
+		case unicode.IsDigit(r):
+			digits.WriteRune(r)
+		case f.haveDecimal && i == sep:
+			digits.WriteByte('.')
+		case r == '.' || r == ',' || r == '\'' || unicode.IsSpace(r):
+			if !f.haveGroup {
+				f.group, f.haveGroup = r, true
+			}
+		default:
+			return 0, f, false
+		}
+	}
+	v, err := strconv.ParseFloat(digits.String(), 64)
+	if err != nil {
+		return 0, f, false
+	}
+	if neg {
+		v = -v
+	}
+	f.valid = true
+	return v, f, true
+}
+
+// merge adds the format of another cell: the most decimals, the first
+// separators and the affixes seen.
+func (f *amountFormat) merge(o amountFormat) {
+	if !f.valid {
+		*f = o
+		return
+	}
+	f.decimals = max(f.decimals, o.decimals)
+	if !f.haveDecimal && o.haveDecimal {
+		f.decimal, f.haveDecimal = o.decimal, true
+	}
+	if !f.haveGroup && o.haveGroup {
+		f.group, f.haveGroup = o.group, true
+	}
+}
+
+// format writes v in the format f.
+func (f amountFormat) format(v float64) string {
+	num := strconv.FormatFloat(v, 'f', f.decimals, 64)
+	neg := strings.HasPrefix(num, "-")
+	num = strings.TrimPrefix(num, "-")
+	intPart, frac, _ := strings.Cut(num, ".")
+	if f.haveGroup {
+		var b strings.Builder
+		for i, r := range intPart {
+			if i > 0 && (len(intPart)-i)%3 == 0 {
+				b.WriteRune(f.group)
+			}
+			b.WriteRune(r)
+		}
+		intPart = b.String()
+	}
+	var b strings.Builder
+	b.WriteString(f.prefix)
+	if neg {
+		b.WriteByte('-')
+	}
+	b.WriteString(intPart)
+	if frac != "" {
+		if f.haveDecimal {
+			b.WriteRune(f.decimal)
+		} else {
+			b.WriteByte('.')
+		}
+		b.WriteString(frac)
+	}
+	b.WriteString(f.suffix)
+	return b.String()
+}
+
+// tableBreaks generates the rows of a table at its page breaks, from the
+// running sum of the table or from the TableBreakCallback. The generated
+// rows are built as tables of their own with the column widths of the
+// table, each cell styled like the cell of the last placed body row it
+// starts in.
+type tableBreaks struct {
+	cb       *CSSBuilder
+	te       *frontend.Text
+	grid     *tableGrid
+	sum      *runningSum
+	callback TableBreakCallbackFunc
+	cols     *tableColumns
+	texts    [][]string // the cell texts of every grid row, per column
+	// reserve is the height of the carried row, kept free at the bottom
+	// of every page the table breaks off.
+	reserve bag.ScaledPoint
+	// rows and pageRows are the grid rows of the body rows placed so far
+	// and on the current page.
+	rows, pageRows []int
+}
+
+// newTableBreaks returns the row generator of the table vl built by
+// buildTable, nil when the table has neither a running sum nor a
+// TableBreakCallback or its columns cannot be measured. first and end
+// delimit the body rows.
+func (cb *CSSBuilder) newTableBreaks(vl *node.VList, first, end int) *tableBreaks {
+	te, ok := vl.Attributes["_tableTe"].(*frontend.Text)
+	if !ok {
+		return nil
+	}
+	sum, _ := te.Settings[settingRunningSum].(*runningSum)
+	if sum == nil && cb.TableBreakCallback == nil {
+		return nil
+	}
+	tbk := &tableBreaks{cb: cb, te: te, sum: sum, callback: cb.TableBreakCallback, grid: newTableGrid(te)}
+	if tbk.cols = measureTableColumns(vl, tbk.grid); tbk.cols == nil {
+		slog.Debug("table break rows: cannot measure the table columns")
+		return nil
+	}
+	tbk.texts = make([][]string, len(tbk.grid.rows))
+	for _, c := range tbk.grid.cells {
+		if tbk.texts[c.row] == nil {
+			tbk.texts[c.row] = make([]string, tbk.grid.ncols)
+		}
+		tbk.texts[c.row][c.col] = strings.TrimSpace(extractTextContent(c.te))
+	}
+	// The carried row with all body rows: the widest sum there is.
+	all := make([]int, 0, end-first)
+	for r := first; r < end; r++ {
+		all = append(all, r)
+	}
+	tbk.rows, tbk.pageRows = all, all
+	carried, _ := tbk.rowCells()
+	if hl, err := tbk.buildRow(carried); err == nil && hl != nil {
+		tbk.reserve = hl.Height + hl.Depth
+	}
+	tbk.rows, tbk.pageRows = nil, nil
+	return tbk
+}
+
+// remeasure takes the column widths from the rebuilt table vl. It reports
+// false when they cannot be measured.
+func (tbk *tableBreaks) remeasure(vl *node.VList) bool {
+	tbk.cols = measureTableColumns(vl, tbk.grid)
+	return tbk.cols != nil
+}
+
+// placed records the grid row r as placed on the current page.
+func (tbk *tableBreaks) placed(r int) {
+	tbk.rows = append(tbk.rows, r)
+	tbk.pageRows = append(tbk.pageRows, r)
+}
+
+// rowCells returns the cells of the carried and the brought row for the
+// rows placed so far, nil for a row left out. Nothing is generated before
+// the first body row.
+func (tbk *tableBreaks) rowCells() (carried, brought []TableBreakCell) {
+	if len(tbk.rows) == 0 {
+		return nil, nil
+	}
+	if tbk.callback == nil {
+		return tbk.sumRows()
+	}
+	tb := &TableBreak{Table: tbk.te, Columns: tbk.grid.ncols}
+	for _, r := range tbk.pageRows {
+		tb.PageRows = append(tb.PageRows, tbk.texts[r])
+	}
+	for _, r := range tbk.rows {
+		tb.Rows = append(tb.Rows, tbk.texts[r])
+	}
+	return tbk.callback(tb)
+}
+
+// sumRows returns the rows of the running sum: the label across the
+// columns left of the summed column, the sum in it.
+func (tbk *tableBreaks) sumRows() (carried, brought []TableBreakCell) {
+	col := tbk.sum.column - 1
+	if col >= tbk.grid.ncols {
+		return nil, nil
+	}
+	var total float64
+	var f amountFormat
+	for _, r := range tbk.rows {
+		if v, vf, ok := parseAmount(tbk.texts[r][col]); ok {
+			total += v
+			f.merge(vf)
+		}
+	}
+	row := func(label string) []TableBreakCell {
+		s := f.format(total)
+		var cells []TableBreakCell
+		if col == 0 {
+			cells = append(cells, TableBreakCell{Text: label + " " + s})
+		} else {
+			cells = append(cells, TableBreakCell{Text: label, Colspan: col}, TableBreakCell{Text: s})
+		}
+		if rest := tbk.grid.ncols - col - 1; rest > 0 {
+			cells = append(cells, TableBreakCell{Colspan: rest})
+		}
+		return cells
+	}
+	return row(tbk.sum.carried), row(tbk.sum.brought)
+}
+
+// buildRow builds the generated row cells apart from the table.
+func (tbk *tableBreaks) buildRow(cells []TableBreakCell) (*node.HList, error) {
+	if len(cells) == 0 {
+		return nil, nil
+	}
+	tr := frontend.NewText()
+	tr.Settings[frontend.SettingDebug] = "tr"
+	col := 0
+	for _, c := range cells {
+		if col >= tbk.grid.ncols {
+			break
+		}
+		span := min(max(c.Colspan, 1), tbk.grid.ncols-col)
+		td := frontend.NewText()
+		para := frontend.NewText()
+		if tmpl := tbk.templateCell(col); tmpl != nil {
+			td.Settings = maps.Clone(tmpl.Settings)
+			p := textTemplate(tmpl)
+			if p == nil {
+				p = tmpl
+			}
+			para.Settings = maps.Clone(p.Settings)
+		}
+		for _, k := range []frontend.SettingType{frontend.SettingColspan, frontend.SettingRowspan, frontend.SettingWidth} {
+			delete(td.Settings, k)
+		}
+		td.Settings[frontend.SettingDebug] = "td"
+		if span > 1 {
+			td.Settings[frontend.SettingColspan] = span
+		}
+		if c.Text != "" {
+			para.Items = append(para.Items, c.Text)
+			td.Items = append(td.Items, para)
+		}
+		tr.Items = append(tr.Items, td)
+		col += span
+	}
+	rows, err := tbk.cb.buildRowsApart(tbk.te, tbk.cols, tr)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// templateCell returns the cell of the last placed body row that covers
+// column col.
+func (tbk *tableBreaks) templateCell(col int) *frontend.Text {
+	for i := len(tbk.rows) - 1; i >= 0; i-- {
+		if gc := tbk.grid.cell(tbk.rows[i], col); gc != nil {
+			return gc.te
+		}
+	}
+	return nil
+}
+
+// textTemplate returns the first Text in te that holds text itself, the
+// paragraph a generated cell copies its text settings from.
+func textTemplate(te *frontend.Text) *frontend.Text {
+	for _, itm := range te.Items {
+		switch t := itm.(type) {
+		case string:
+			return te
+		case *frontend.Text:
+			if p := textTemplate(t); p != nil {
+				return p
+			}
+		}
+	}
+	return nil
+}
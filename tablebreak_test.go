@@ -0,0 +1,140 @@
+package htmlbag
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/csshtml"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want float64
+		sum  float64
+		out  string
+	}{
+		{"1,234.50", 1234.5, 2469, "2,469.00"},
+		{"1.234,50", 1234.5, 2469, "2.469,00"},
+		{"1.234", 1234, 2469, "2.469"},
+		{"-12.5", -12.5, -25, "-25.0"},
+		{"€ 12,00", 12, 24, "€ 24,00"},
+		{"7 kg", 7, 14, "14 kg"},
+	} {
+		v, f, ok := parseAmount(tc.in)
+		if !ok || v != tc.want {
+			t.Errorf("parseAmount(%q) = %v, %v, want %v", tc.in, v, ok, tc.want)
+			continue
+		}
+		if got := f.format(tc.sum); got != tc.out {
+			t.Errorf("format of %q: got %q, want %q", tc.in, got, tc.out)
+		}
+	}
+	if _, _, ok := parseAmount("n/a"); ok {
+		t.Error("parseAmount(n/a) succeeded")
+	}
+}
+
+func TestParseRunningSum(t *testing.T) {
+	rs := parseRunningSum(`column 3 "Übertrag auf" "Übertrag von"`)
+	if rs == nil || rs.column != 3 || rs.carried != "Übertrag auf" || rs.brought != "Übertrag von" {
+		t.Errorf("got %+v", rs)
+	}
+	if rs := parseRunningSum("column 2"); rs == nil || rs.carried != "Carried forward" {
+		t.Errorf("got %+v, want the default labels", rs)
+	}
+	if rs := parseRunningSum("none"); rs != nil {
+		t.Errorf("none: got %+v", rs)
+	}
+}
+
+// sumRows returns n table rows with the cells POSnn and 10.00.
+func sumRows(n int) string {
+	var sb strings.Builder
+	for i := range n {
+		fmt.Fprintf(&sb, "<tr><td>POS%02d</td><td>10.00</td></tr>", i)
+	}
+	return sb.String()
+}
+
+// TestRunningSum: the carried row closes the page the table breaks off
+// with the sum of the rows so far, the brought row repeats it below the
+// header on the next page.
+func TestRunningSum(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { -bag-running-sum: column 2 "ÜBERTRAG" "VORTRAG"; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><thead><tr><th>KOPF</th><th>Betrag</th></tr></thead><tbody>`+sumRows(80)+`</tbody></table></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the table to break", len(pages))
+	}
+	n, last := 0, ""
+	for i := range 80 {
+		if pos := fmt.Sprintf("POS%02d", i); pageWith(pages, pos) == 0 {
+			n, last = n+1, pos
+		}
+	}
+	sum := fmt.Sprintf("%d.00", 10*n)
+	_, cy, ok1 := textPos(pages[0], "ÜBERTRAG")
+	_, sy, ok2 := textPos(pages[0], sum)
+	_, ly, _ := textPos(pages[0], last)
+	if !ok1 || !ok2 || cy != sy || cy >= ly {
+		t.Errorf("carried row %v at %s, sum %s %v at %s, last row at %s", ok1, cy, sum, ok2, sy, ly)
+	}
+	_, hy, _ := textPos(pages[1], "KOPF")
+	_, by, ok3 := textPos(pages[1], "VORTRAG")
+	_, fy, _ := textPos(pages[1], fmt.Sprintf("POS%02d", n))
+	if !ok3 || !(hy > by && by > fy) {
+		t.Errorf("header at %s, brought row %v at %s, first row at %s", hy, ok3, by, fy)
+	}
+	if pageWith(pages, "ÜBERTRAG") != 0 || pageWith(pages, "VORTRAG") != 1 {
+		t.Errorf("generated rows on pages %d and %d", pageWith(pages, "ÜBERTRAG"), pageWith(pages, "VORTRAG"))
+	}
+}
+
+// TestTableBreakCallback: the callback sees the rows of the page and makes
+// the generated rows, also for a table without running sum or header.
+func TestTableBreakCallback(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadIncludedFonts(fe); err != nil {
+		t.Fatal(err)
+	}
+	cb, err := New(fe, csshtml.NewCSSParserWithDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []int
+	cb.TableBreakCallback = func(tb *TableBreak) (carried, brought []TableBreakCell) {
+		calls = append(calls, len(tb.PageRows))
+		if tb.Columns != 2 || tb.PageRows[0][1] != "10.00" {
+			t.Errorf("columns %d, first row %q", tb.Columns, tb.PageRows[0])
+		}
+		return []TableBreakCell{{Text: fmt.Sprintf("SEITENZEILEN%d", len(tb.PageRows)), Colspan: 2}}, nil
+	}
+	if err := cb.ParseCSSString(`@page { size: a4; margin: 20mm; }`); err != nil {
+		t.Fatal(err)
+	}
+	te, err := cb.HTMLToText(`<html><body><table>` + sumRows(80) + `</table></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.OutputPagesFromText(te); err != nil {
+		t.Fatal(err)
+	}
+	pages := fe.Doc.Pages
+	if len(pages) < 2 || len(calls) != len(pages) {
+		t.Fatalf("%d pages, %d calls (%v), want one probe and one per break", len(pages), len(calls), calls)
+	}
+	// calls[0] is the probe with all rows.
+	if calls[0] != 80 {
+		t.Errorf("probe with %d rows, want 80", calls[0])
+	}
+	if got := pageWith(pages, fmt.Sprintf("SEITENZEILEN%d", calls[1])); got != 0 {
+		t.Errorf("carried row on page %d, want 0", got)
+	}
+}
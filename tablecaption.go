@@ -0,0 +1,150 @@
+package htmlbag
+
+import (
+	"maps"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// captionStyle carries caption-side and -bag-caption-continued of a
+// <caption> from Output() to buildTable (settingCaption).
+type captionStyle struct {
+	bottom    bool
+	continued string // appended to the caption on continuation pages, "" = no repeat
+}
+
+// tableCaption is the built caption of a table (CSS 2.1 §17.4.1). vl is the
+// caption box with its vertical margins, as wide as the table;
+// buildContinued builds the box outputTableRows repeats at the top of every
+// continuation page (nil unless -bag-caption-continued asks for one).
+type tableCaption struct {
+	vl             *node.VList
+	buildContinued func() (*node.VList, error)
+	bottom         bool
+	text           string
+}
+
+// buildCaption builds the first <caption> child of the table te at the
+// width wd of the table. It returns nil when the table has no caption.
+// The caption is built detached (see measureItem); tagTable tags it as
+// the Caption of the table.
+//
+// v1 limitations: footnotes, floats and anchors inside a caption are
+// dropped, a bottom caption is not repeated, and a bordered table is wrapped by its border after the caption
+// is built, so the caption spans the table's content width only.
+func (cb *CSSBuilder) buildCaption(te *frontend.Text, wd bag.ScaledPoint) (*tableCaption, error) {
+	var capTe *frontend.Text
+	for _, itm := range te.Items {
+		if t, ok := itm.(*frontend.Text); ok {
+			if elt, _ := t.Settings[frontend.SettingDebug].(string); elt == "caption" {
+				capTe = t
+				break
+			}
+		}
+	}
+	if capTe == nil {
+		return nil, nil
+	}
+	// The sentinel must not reach FormatParagraph; restore it for a
+	// rebuild of the table at another width.
+	cs, hasStyle := capTe.Settings[settingCaption].(*captionStyle)
+	delete(capTe.Settings, settingCaption)
+	if hasStyle {
+		defer func() { capTe.Settings[settingCaption] = cs }()
+	} else {
+		cs = &captionStyle{}
+	}
+
+	c := &tableCaption{bottom: cs.bottom, text: extractTextContent(capTe)}
+	var err error
+	if c.vl, err = cb.captionBox(capTe, wd); err != nil {
+		return nil, err
+	}
+	if cs.continued != "" && !cs.bottom {
+		// A fresh box for every page, like the repeated header rows.
+		continued := appendText(capTe, cs.continued)
+		c.buildContinued = func() (*node.VList, error) {
+			return cb.captionBox(continued, wd)
+		}
+	}
+	return c, nil
+}
+
+// captionBox builds the caption te at the width wd, its margins included.
+func (cb *CSSBuilder) captionBox(te *frontend.Text, wd bag.ScaledPoint) (*node.VList, error) {
+	li := newLayoutItem(te)
+	box, err := cb.measureItem(te, bag.Max(wd-li.ml-li.mr, 0))
+	if err != nil {
+		return nil, err
+	}
+	box.ShiftX += li.ml
+	var list node.Node
+	if li.mt != 0 {
+		list = layoutKern(li.mt, "caption-margin")
+	}
+	list = node.InsertAfter(list, node.Tail(list), box)
+	if li.mb != 0 {
+		list = node.InsertAfter(list, node.Tail(list), layoutKern(li.mb, "caption-margin"))
+	}
+	vl := node.NewVList()
+	vl.List = list
+	vl.Width = wd
+	vl.Height = li.mt + box.Height + box.Depth + li.mb
+	vl.Attributes = node.H{"origin": "caption"}
+	return vl, nil
+}
+
+// appendText returns a copy of te with s appended to its last line: to te
+// itself, or to its last block child. te is left untouched.
+func appendText(te *frontend.Text, s string) *frontend.Text {
+	c := frontend.NewText()
+	c.Settings = maps.Clone(te.Settings)
+	c.Items = append(c.Items, te.Items...)
+	if n := len(c.Items); n > 0 {
+		if t, ok := c.Items[n-1].(*frontend.Text); ok {
+			if isBox, _ := t.Settings[frontend.SettingBox].(bool); isBox {
+				c.Items[n-1] = appendText(t, s)
+				return c
+			}
+		}
+	}
+	c.Items = append(c.Items, s)
+	return c
+}
+
+// withCaption returns the table box vl, the table built by buildTable with
+// its border around it, together with its caption in a VList of origin
+// "table wrapper" (the table wrapper box, CSS 2.1 §17.4). The caption and
+// the table stay separate children, so the page builder can take the
+// wrapper apart and break the table as usual. The cell inserts travel on
+// the wrapper.
+func withCaption(vl *node.VList, c *tableCaption) *node.VList {
+	if c == nil {
+		return vl
+	}
+	wrapper := node.NewVList()
+	wrapper.Attributes = node.H{"origin": "table wrapper"}
+	if ins := nodeInserts(vl); len(ins) > 0 {
+		wrapper.Attributes["inserts"] = ins
+		delete(vl.Attributes, "inserts")
+	}
+	// The caption sits on its own side even when the page builder breaks
+	// the table.
+	if c.bottom {
+		wrapper.List = node.InsertAfter(vl, vl, c.vl)
+	} else {
+		c.vl.Attributes["pageBreakAfter"] = "avoid"
+		wrapper.List = node.InsertAfter(c.vl, c.vl, vl)
+	}
+	wrapper.Width = bag.Max(vl.Width, c.vl.Width)
+	wrapper.Height = vl.Height + vl.Depth + c.vl.Height + c.vl.Depth
+	return wrapper
+}
+
+// tableCaptionOf returns the caption buildTable stored on the table vl.
+func tableCaptionOf(vl *node.VList) *tableCaption {
+	c, _ := vl.Attributes["_caption"].(*tableCaption)
+	return c
+}
@@ -0,0 +1,156 @@
+package htmlbag
+
+import (
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/boxesandglue/frontend/pdfdraw"
+)
+
+// cellDiagonal is one diagonal line across a table cell.
+type cellDiagonal struct {
+	width bag.ScaledPoint
+	style frontend.BorderStyle
+	color *color.Color
+}
+
+// cellDiagonals are the diagonals of a table cell, carried by
+// settingCellDiagonals from Output() to buildTD: down runs from the top left
+// to the bottom right corner, up from the bottom left to the top right.
+// inset is the border spacing of the separated border model around the
+// border box of the cell, set by buildTD.
+type cellDiagonals struct {
+	down, up *cellDiagonal
+	inset    [4]bag.ScaledPoint
+}
+
+// parseCellDiagonal parses a -bag-border-diagonal-down/-up value, in the
+// syntax of the border shorthand: `none | <line-width> || <line-style> ||
+// <color>`. The width defaults to medium, the style to solid, the color to
+// currentColor. It returns nil for none.
+func parseCellDiagonal(v string, styles *FormattingStyles, df *frontend.Document) *cellDiagonal {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "none" {
+		return nil
+	}
+	d := &cellDiagonal{width: bag.MustSP("3pt"), style: frontend.BorderStyleSolid}
+	var colorValue string
+	for _, tok := range splitTopLevel(v, ' ') {
+		if sty, ok := parseBorderStyle(tok); ok {
+			d.style = sty
+			continue
+		}
+		switch {
+		case tok == "thin":
+			d.width = bag.MustSP("1pt")
+		case tok == "medium":
+			d.width = bag.MustSP("3pt")
+		case tok == "thick":
+			d.width = bag.MustSP("5pt")
+		case isShadowLength(tok):
+			d.width = ParseRelativeSize(tok, styles.Fontsize, styles.DefaultFontSize)
+		default:
+			colorValue = tok
+		}
+	}
+	d.color = shadowColor(colorValue, styles.color, df)
+	if d.width <= 0 || d.color == nil || d.style == frontend.BorderStyleNone || d.style == borderStyleHidden {
+		return nil
+	}
+	return d
+}
+
+// diagonalRule returns the hidden rule that draws the diagonals cd of a
+// cell box of wd × ht from its top left corner. Dashed and dotted lines
+// keep their style, the other styles are drawn solid (v1).
+func diagonalRule(cd *cellDiagonals, wd, ht bag.ScaledPoint) *node.Rule {
+	x0, y0 := cd.inset[sideLeft], -cd.inset[sideTop]
+	x1, y1 := wd-cd.inset[sideRight], -ht+cd.inset[sideBottom]
+	var pre string
+	line := func(d *cellDiagonal, fromX, fromY, toX, toY bag.ScaledPoint) {
+		if d == nil {
+			return
+		}
+		dash := ""
+		switch d.style {
+		case borderStyleDashed:
+			dash = "[" + pdfLength(2*d.width) + " " + pdfLength(4*d.width) + "] 0 d "
+		case borderStyleDotted:
+			dash = "[0 " + pdfLength(2*d.width) + "] 0 d 1 J "
+		}
+		pre += " q " + pdfLength(d.width) + " w " + dash + d.color.PDFStringStroking() + " " +
+			pdfdraw.New().Moveto(fromX, fromY).Lineto(toX, toY).Stroke().String() + " Q"
+	}
+	line(cd.down, x0, y0, x1, y1)
+	line(cd.up, x0, y1, x1, y0)
+	r := node.NewRule()
+	r.Hide = true
+	r.Pre = "q " + pdfdraw.New().Rect(x0, y1, x1-x0, y0-y1).Clip().Endpath().String() + pre + " Q"
+	r.Attributes = node.H{"origin": "table cell diagonal"}
+	return r
+}
+
+// rotatedCellContent formats the contents of a cell with a vertical
+// writing-mode (CSS Writing Modes 3): the lines are as long as the widest
+// one at max-content, and the block of lines is turned by 90°, clockwise
+// for vertical-rl and vertical-lr, counterclockwise for sideways-lr, so
+// the row gets as high as the longest line. Only single lines of text
+// (headers) are meant; the lines are not broken to fit the row (v1). The
+// box keeps its width whatever the cell offers, it tells the column width
+// algorithm by the _intrinsicWidth attribute.
+func (cb *CSSBuilder) rotatedCellContent(contents []any, counterclockwise bool) (*node.VList, error) {
+	var length bag.ScaledPoint
+	for _, c := range contents {
+		vl, err := cb.formatCellContent(c, maxContentProbe)
+		if err != nil {
+			return nil, err
+		}
+		if vl != nil {
+			length = max(length, maxContentWidth(vl, maxContentProbe))
+		}
+	}
+	var head node.Node
+	for _, c := range contents {
+		vl, err := cb.formatCellContent(c, length)
+		if err != nil {
+			return nil, err
+		}
+		if vl != nil {
+			head = node.InsertAfter(head, node.Tail(head), vl)
+		}
+	}
+	if head == nil {
+		return nil, nil
+	}
+	inner := node.Vpack(head)
+	inner.Width = length
+	wd, ht := inner.Height+inner.Depth, length
+	// In CSS coordinates: x' = wd - y, y' = x (clockwise) or x' = y,
+	// y' = ht - x (counterclockwise).
+	m := affine{0, 1, -1, 0, wd.ToPT(), 0}
+	if counterclockwise {
+		m = affine{0, -1, 1, 0, 0, ht.ToPT()}
+	}
+	save, r, restore := matrixNodes(m.flipY())
+	head = save
+	head = node.InsertAfter(head, save, r)
+	head = node.InsertAfter(head, r, inner)
+	head = node.InsertAfter(head, inner, restore)
+	box := node.Vpack(head)
+	box.Width, box.Height, box.Depth = wd, ht, 0
+	box.Attributes = node.H{"origin": "rotated cell content", "_intrinsicWidth": wd}
+	return box, nil
+}
+
+// isVerticalWritingMode reports whether the writing-mode v turns the lines
+// of a table cell.
+func isVerticalWritingMode(v string) bool {
+	switch v {
+	case "vertical-rl", "vertical-lr", "sideways-rl", "sideways-lr":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package htmlbag
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+func TestParseCellDiagonal(t *testing.T) {
+	var buf bytes.Buffer
+	df, err := frontend.NewForWriter(&buf)
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	styles := &FormattingStyles{Fontsize: bag.MustSP("10pt"), DefaultFontSize: bag.MustSP("10pt")}
+	for _, tc := range []struct {
+		in    string
+		width bag.ScaledPoint
+		style frontend.BorderStyle
+	}{
+		{"1pt solid black", bag.MustSP("1pt"), frontend.BorderStyleSolid},
+		{"dashed red", bag.MustSP("3pt"), borderStyleDashed},
+		{"thin", bag.MustSP("1pt"), frontend.BorderStyleSolid},
+		{"0.1em dotted", bag.MustSP("1pt"), borderStyleDotted},
+	} {
+		d := parseCellDiagonal(tc.in, styles, df)
+		if d == nil {
+			t.Errorf("parseCellDiagonal(%q) = nil", tc.in)
+			continue
+		}
+		if d.width != tc.width || d.style != tc.style {
+			t.Errorf("parseCellDiagonal(%q) = %s %v, want %s %v", tc.in, d.width, d.style, tc.width, tc.style)
+		}
+	}
+	for _, v := range []string{"", "none", "1pt none", "0pt solid"} {
+		if d := parseCellDiagonal(v, styles, df); d != nil {
+			t.Errorf("parseCellDiagonal(%q) = %+v, want nil", v, d)
+		}
+	}
+}
+
+// TestCellWritingMode: a header with a vertical writing-mode is turned, the
+// row gets as high as the text is long and the column stays narrow.
+func TestCellWritingMode(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+th { writing-mode: sideways-lr; }`
+	html := `<html><body><table><tr><th>Umsatz im Quartal</th><th>Kosten</th></tr><tr><td>1</td><td>2</td></tr></table></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	box := originVList(pages, "rotated cell content")
+	if box == nil {
+		t.Fatal("no rotated cell content")
+	}
+	if box.Height <= box.Width || box.Width > bag.MustSP("20pt") {
+		t.Errorf("rotated header is %s × %s", box.Width, box.Height)
+	}
+}
+
+// TestCellDiagonal: -bag-border-diagonal draws a line from the top left to
+// the bottom right corner of the cell, -bag-border-diagonal-up the other.
+func TestCellDiagonal(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+td.split { -bag-border-diagonal: 1pt solid red; }
+td.cross { -bag-border-diagonal-down: 1pt solid; -bag-border-diagonal-up: 1pt dashed; }`
+	html := `<html><body><table><tr><td class="split">Von / Nach</td><td>A</td><td class="cross">B</td></tr></table></body></html>`
+	pages := renderHTMLPages(t, css, html)
+	pres := borderRulePres(pages[0], "table cell diagonal")
+	if len(pres) != 2 {
+		t.Fatalf("got %d diagonal rules, want 2", len(pres))
+	}
+	if strings.Count(pres[0], " S") != 1 || strings.Count(pres[1], " S") != 2 {
+		t.Errorf("wrong number of lines: %q, %q", pres[0], pres[1])
+	}
+	if !strings.Contains(pres[1], " d ") {
+		t.Errorf("up diagonal is not dashed: %q", pres[1])
+	}
+}
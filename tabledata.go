@@ -0,0 +1,299 @@
+package htmlbag
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// TableColumn describes one column of a TableData.
+type TableColumn struct {
+	// Key selects the value of the column from the objects of a JSON
+	// array (ReadJSONTable).
+	Key string
+	// Header is the text of the header cell. A table gets a header row
+	// when one of its columns has a header.
+	Header string
+	// Class is the class attribute of the cells of the column, header
+	// cell included.
+	Class string
+}
+
+// TableData is a table as plain data: the columns and the cell texts of
+// the body rows, one string per column. Missing values are empty cells,
+// surplus values are ignored.
+type TableData struct {
+	Columns []TableColumn
+	Rows    [][]string
+	// Class and ID are the class and id attributes of the table.
+	Class string
+	ID    string
+}
+
+// ReadCSVTable reads CSV data (RFC 4180) with a header record into a
+// TableData. The header record names the columns.
+func ReadCSVTable(r io.Reader) (*TableData, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	td := &TableData{}
+	for _, h := range header {
+		td.Columns = append(td.Columns, TableColumn{Key: h, Header: h})
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		td.Rows = append(td.Rows, rec)
+	}
+	return td, nil
+}
+
+// ReadJSONTable reads a JSON array of objects into a TableData, one row per
+// object. columns picks the values by their Key; without columns every key
+// of the first object becomes a column, in the order of the object, with
+// the key as its header. Strings are taken as they are, null is an empty
+// cell, other values keep their JSON text.
+func ReadJSONTable(r io.Reader, columns []TableColumn) (*TableData, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("JSON array of objects expected")
+	}
+	td := &TableData{Columns: columns}
+	for dec.More() {
+		if tok, err := dec.Token(); err != nil {
+			return nil, err
+		} else if tok != json.Delim('{') {
+			return nil, fmt.Errorf("JSON object expected for row %d", len(td.Rows)+1)
+		}
+		var keys, values []string
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := tok.(string)
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			values = append(values, jsonCellText(raw))
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		if td.Columns == nil {
+			for _, k := range keys {
+				td.Columns = append(td.Columns, TableColumn{Key: k, Header: k})
+			}
+		}
+		row := make([]string, len(td.Columns))
+		for c, col := range td.Columns {
+			for i, k := range keys {
+				if k == col.Key {
+					row[c] = values[i]
+					break
+				}
+			}
+		}
+		td.Rows = append(td.Rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// jsonCellText returns the cell text of the JSON value raw.
+func jsonCellText(raw json.RawMessage) string {
+	switch {
+	case string(raw) == "null":
+		return ""
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
+
+// tableDataCellText is the text of the template cells. fillCellText puts
+// the cell values in its place, so the texts of the cell the cascade adds
+// (::before and ::after content) stay.
+const tableDataCellText = "\ue000cell\ue000"
+
+// TableDataToText turns td into a document Text like HTMLToText does for
+// the HTML of the table, styled by the CSS read so far, for
+// OutputPagesFromText or CreateVlist. Only a small table (the header row
+// and up to three body rows) goes through the HTML parser and the cascade;
+// the rows of the data take the settings of these rows with their own
+// texts. So the rules that count rows from the start (:first-child,
+// :nth-child(odd), :nth-child(even)) apply, rules that look at the end of
+// the table (:last-child, :nth-last-child) or at the contents of a cell do
+// not. Use -bag-row-banding for banded rows.
+func (cb *CSSBuilder) TableDataToText(td *TableData) (*frontend.Text, error) {
+	if len(td.Columns) == 0 {
+		return nil, fmt.Errorf("table data without columns")
+	}
+	ntemplates := min(len(td.Rows), 3)
+	var sb strings.Builder
+	sb.WriteString("<html><body><table")
+	if td.ID != "" {
+		fmt.Fprintf(&sb, ` id="%s"`, html.EscapeString(td.ID))
+	}
+	if td.Class != "" {
+		fmt.Fprintf(&sb, ` class="%s"`, html.EscapeString(td.Class))
+	}
+	sb.WriteString(">")
+	cell := func(elt, class, text string) {
+		sb.WriteString("<" + elt)
+		if class != "" {
+			fmt.Fprintf(&sb, ` class="%s"`, html.EscapeString(class))
+		}
+		sb.WriteString(">" + html.EscapeString(text) + "</" + elt + ">")
+	}
+	hasHeader := false
+	for _, col := range td.Columns {
+		hasHeader = hasHeader || col.Header != ""
+	}
+	if hasHeader {
+		sb.WriteString("<thead><tr>")
+		for _, col := range td.Columns {
+			cell("th", col.Class, col.Header)
+		}
+		sb.WriteString("</tr></thead>")
+	}
+	if ntemplates > 0 {
+		sb.WriteString("<tbody>")
+		for range ntemplates {
+			sb.WriteString("<tr>")
+			for _, col := range td.Columns {
+				cell("td", col.Class, tableDataCellText)
+			}
+			sb.WriteString("</tr>")
+		}
+		sb.WriteString("</tbody>")
+	}
+	sb.WriteString("</table></body></html>")
+
+	doc, err := cb.HTMLToText(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	tbl := findTableText(doc)
+	if tbl == nil {
+		return nil, fmt.Errorf("no table in the table data template")
+	}
+	for _, itm := range tbl.Items {
+		tbody, ok := itm.(*frontend.Text)
+		if !ok {
+			continue
+		}
+		if elt, _ := tbody.Settings[frontend.SettingDebug].(string); elt != "tbody" {
+			continue
+		}
+		var templates []*frontend.Text
+		for _, itm := range tbody.Items {
+			if tr, ok := itm.(*frontend.Text); ok {
+				if elt, _ := tr.Settings[frontend.SettingDebug].(string); elt == "tr" {
+					templates = append(templates, tr)
+				}
+			}
+		}
+		if len(templates) != ntemplates {
+			return nil, fmt.Errorf("template rows of the table data do not match")
+		}
+		tbody.Items = make([]any, 0, len(td.Rows))
+		for r, values := range td.Rows {
+			// The first row, then the even and the odd rows in turn.
+			tmpl := templates[0]
+			if r > 0 {
+				tmpl = templates[min(1+(r-1)%2, ntemplates-1)]
+			}
+			tbody.Items = append(tbody.Items, fillTableRow(tmpl, values))
+		}
+		break
+	}
+	return doc, nil
+}
+
+// findTableText returns the first table in the Text tree te.
+func findTableText(te *frontend.Text) *frontend.Text {
+	if elt, _ := te.Settings[frontend.SettingDebug].(string); elt == "table" {
+		return te
+	}
+	for _, itm := range te.Items {
+		if t, ok := itm.(*frontend.Text); ok {
+			if found := findTableText(t); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// fillTableRow returns a copy of the row tmpl with the cell texts values,
+// one per cell in turn.
+func fillTableRow(tmpl *frontend.Text, values []string) *frontend.Text {
+	tr := &frontend.Text{Settings: maps.Clone(tmpl.Settings)}
+	c := 0
+	for _, itm := range tmpl.Items {
+		t, ok := itm.(*frontend.Text)
+		if !ok {
+			tr.Items = append(tr.Items, itm)
+			continue
+		}
+		var v string
+		if c < len(values) {
+			v = values[c]
+		}
+		c++
+		done := false
+		cell := fillCellText(t, v, &done)
+		if !done && v != "" {
+			cell.Items = append(cell.Items, v)
+		}
+		tr.Items = append(tr.Items, cell)
+	}
+	return tr
+}
+
+// fillCellText returns a copy of the cell te with s in place of the
+// template text (tableDataCellText). done reports that s has been placed.
+func fillCellText(te *frontend.Text, s string, done *bool) *frontend.Text {
+	c := &frontend.Text{Settings: maps.Clone(te.Settings)}
+	for _, itm := range te.Items {
+		switch t := itm.(type) {
+		case string:
+			if !*done && strings.Contains(t, tableDataCellText) {
+				t = strings.Replace(t, tableDataCellText, s, 1)
+				*done = true
+			}
+			if t != "" {
+				c.Items = append(c.Items, t)
+			}
+		case *frontend.Text:
+			c.Items = append(c.Items, fillCellText(t, s, done))
+		default:
+			c.Items = append(c.Items, itm)
+		}
+	}
+	return c
+}
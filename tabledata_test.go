@@ -0,0 +1,198 @@
+package htmlbag
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/frontend"
+	"github.com/boxesandglue/csshtml"
+)
+
+func TestReadCSVTable(t *testing.T) {
+	td, err := ReadCSVTable(strings.NewReader("Artikel,Preis\nSchraube,\"0,10\"\nMutter,0.05\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(td.Columns) != 2 || td.Columns[1].Header != "Preis" {
+		t.Errorf("columns %+v", td.Columns)
+	}
+	if len(td.Rows) != 2 || td.Rows[0][1] != "0,10" {
+		t.Errorf("rows %q", td.Rows)
+	}
+}
+
+func TestReadJSONTable(t *testing.T) {
+	data := `[{"sku": "A-1", "price": 1.5, "note": null}, {"price": 2, "sku": "A-2", "note": "neu"}]`
+	td, err := ReadJSONTable(strings.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(td.Columns) != 3 || td.Columns[0].Key != "sku" || td.Columns[1].Key != "price" {
+		t.Errorf("columns %+v, want the keys in the order of the first object", td.Columns)
+	}
+	if want := [][]string{{"A-1", "1.5", ""}, {"A-2", "2", "neu"}}; fmt.Sprint(td.Rows) != fmt.Sprint(want) {
+		t.Errorf("rows %q, want %q", td.Rows, want)
+	}
+	td, err = ReadJSONTable(strings.NewReader(data), []TableColumn{{Key: "price", Header: "Preis"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(td.Rows) != "[[1.5] [2]]" {
+		t.Errorf("selected column: rows %q", td.Rows)
+	}
+	if _, err := ReadJSONTable(strings.NewReader(`{"sku": "A-1"}`), nil); err == nil {
+		t.Error("no error for a JSON object")
+	}
+}
+
+// dataRows returns n rows of a price list.
+func dataRows(n int) [][]string {
+	rows := make([][]string, n)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprintf("ART%03d", i), fmt.Sprintf("%d.00", i)}
+	}
+	return rows
+}
+
+// TestTableDataToText: the rows of the data take the settings the cascade
+// gives the template rows, odd and even rows alike.
+func TestTableDataToText(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	cb, err := New(fe, csshtml.NewCSSParserWithDefaults())
+	if err != nil {
+		t.Fatalf("htmlbag.New: %v", err)
+	}
+	if err := cb.ParseCSSString(`tr:nth-child(even) td { background-color: #eee; }`); err != nil {
+		t.Fatal(err)
+	}
+	te, err := cb.TableDataToText(&TableData{
+		Columns: []TableColumn{{Header: "Artikel"}, {Header: "Preis", Class: "num"}},
+		Rows:    dataRows(6),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := findTableText(te)
+	if tbl == nil {
+		t.Fatal("no table")
+	}
+	var rows []*frontend.Text
+	for _, itm := range tbl.Items {
+		if sec, ok := itm.(*frontend.Text); ok && sec.Settings[frontend.SettingDebug] == "tbody" {
+			for _, itm := range sec.Items {
+				rows = append(rows, itm.(*frontend.Text))
+			}
+		}
+	}
+	if len(rows) != 6 {
+		t.Fatalf("got %d body rows, want 6", len(rows))
+	}
+	for r, tr := range rows {
+		td := tr.Items[0].(*frontend.Text)
+		if _, banded := td.Settings[frontend.SettingBackgroundColor]; banded != (r%2 == 1) {
+			t.Errorf("row %d: background %t", r, banded)
+		}
+	}
+}
+
+// textItems returns the strings of the Text te and its nested Texts.
+func textItems(te *frontend.Text) string {
+	var sb strings.Builder
+	for _, itm := range te.Items {
+		switch t := itm.(type) {
+		case string:
+			sb.WriteString(t)
+		case *frontend.Text:
+			sb.WriteString(textItems(t))
+		}
+	}
+	return sb.String()
+}
+
+// TestTableDataGeneratedContent: the value of a cell goes between the
+// ::before and ::after content of its column.
+func TestTableDataGeneratedContent(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	cb, err := New(fe, csshtml.NewCSSParserWithDefaults())
+	if err != nil {
+		t.Fatalf("htmlbag.New: %v", err)
+	}
+	if err := cb.ParseCSSString(`td.num::before { content: "VOR"; } td.num::after { content: "EUR"; }`); err != nil {
+		t.Fatal(err)
+	}
+	te, err := cb.TableDataToText(&TableData{
+		Columns: []TableColumn{{Header: "Artikel"}, {Header: "Preis", Class: "num"}},
+		Rows:    dataRows(4),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []*frontend.Text
+	for _, itm := range findTableText(te).Items {
+		if sec, ok := itm.(*frontend.Text); ok && sec.Settings[frontend.SettingDebug] == "tbody" {
+			for _, itm := range sec.Items {
+				rows = append(rows, itm.(*frontend.Text))
+			}
+		}
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d body rows, want 4", len(rows))
+	}
+	for r, tr := range rows {
+		if got, want := textItems(tr.Items[0].(*frontend.Text)), fmt.Sprintf("ART%03d", r); got != want {
+			t.Errorf("row %d: first cell %q, want %q", r, got, want)
+		}
+		if got, want := textItems(tr.Items[1].(*frontend.Text)), fmt.Sprintf("VOR%d.00EUR", r); got != want {
+			t.Errorf("row %d: second cell %q, want %q", r, got, want)
+		}
+	}
+}
+
+// TestTableDataPages: a long table from data breaks across pages and
+// repeats its header row like the same table written in HTML.
+func TestTableDataPages(t *testing.T) {
+	fe, err := frontend.NewForWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("frontend.NewForWriter: %v", err)
+	}
+	if err := LoadIncludedFonts(fe); err != nil {
+		t.Fatalf("LoadIncludedFonts: %v", err)
+	}
+	cb, err := New(fe, csshtml.NewCSSParserWithDefaults())
+	if err != nil {
+		t.Fatalf("htmlbag.New: %v", err)
+	}
+	if err := cb.ParseCSSString(`@page { size: a4; margin: 20mm; }`); err != nil {
+		t.Fatal(err)
+	}
+	te, err := cb.TableDataToText(&TableData{
+		Columns: []TableColumn{{Header: "KOPF"}, {Header: "Preis"}},
+		Rows:    dataRows(150),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.OutputPagesFromText(te); err != nil {
+		t.Fatalf("OutputPagesFromText: %v", err)
+	}
+	pages := fe.Doc.Pages
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the table split", len(pages))
+	}
+	if pageWith(pages, "ART000") != 0 || pageWith(pages, "ART149") != len(pages)-1 {
+		t.Errorf("first row on page %d, last row on page %d of %d", pageWith(pages, "ART000"), pageWith(pages, "ART149"), len(pages))
+	}
+	for p := range pages {
+		if pageWith(pages[p:p+1], "KOPF") != 0 {
+			t.Errorf("no header row on page %d", p)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package htmlbag
+
+import (
+	"slices"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// markRowInserts notes on the row boxes of the table vl, built as tbl, which
+// of the table inserts come from their cells (collected per row by
+// buildTR). The table keeps all of them in its inserts attribute, for a
+// table placed whole; a table broken between its rows hands them to the
+// rows (rowInserts, spreadRowInserts), so a footnote goes to the page its
+// row lands on.
+func (cb *CSSBuilder) markRowInserts(vl *node.VList, tbl *frontend.Table) {
+	if len(cb.tableRowInserts) == 0 {
+		return
+	}
+	r := 0
+	for n := vl.List; n != nil && r < len(tbl.Rows); n = n.Next() {
+		hl, ok := n.(*node.HList)
+		if !ok {
+			continue
+		}
+		if ins := cb.tableRowInserts[tbl.Rows[r]]; len(ins) > 0 {
+			if hl.Attributes == nil {
+				hl.Attributes = node.H{}
+			}
+			hl.Attributes["_rowInserts"] = ins
+		}
+		r++
+	}
+}
+
+// rowInserts divides ins, the inserts of the table vl, among its row
+// nodes: perRow has one slice per row node, rest holds the inserts no row
+// claims (the caption's, or all of them when the rows were built
+// elsewhere).
+func rowInserts(vl *node.VList, ins []*Insert) (perRow [][]*Insert, rest []*Insert) {
+	claimed := map[*Insert]bool{}
+	for n := vl.List; n != nil; n = n.Next() {
+		var own []*Insert
+		if hl, ok := n.(*node.HList); ok && hl.Attributes != nil {
+			rowIns, _ := hl.Attributes["_rowInserts"].([]*Insert)
+			for _, i := range rowIns {
+				if slices.Contains(ins, i) {
+					own = append(own, i)
+					claimed[i] = true
+				}
+			}
+		}
+		perRow = append(perRow, own)
+	}
+	for _, i := range ins {
+		if !claimed[i] {
+			rest = append(rest, i)
+		}
+	}
+	return perRow, rest
+}
+
+// spreadRowInserts moves the inserts of the table vl onto its row nodes
+// before the page builder takes the table apart: every row carries the
+// inserts of its cells, the first row the rest.
+func spreadRowInserts(vl *node.VList) {
+	perRow, rest := rowInserts(vl, nodeInserts(vl))
+	r := 0
+	for n := vl.List; n != nil; n = n.Next() {
+		if hl, ok := n.(*node.HList); ok && len(perRow[r]) > 0 {
+			existing, _ := hl.Attributes["inserts"].([]*Insert)
+			hl.Attributes["inserts"] = append(append([]*Insert{}, perRow[r]...), existing...)
+		}
+		r++
+	}
+	if vl.Attributes != nil {
+		if len(rest) > 0 {
+			vl.Attributes["inserts"] = rest
+		} else {
+			delete(vl.Attributes, "inserts")
+		}
+	}
+	propagateInsertsAttr(vl, vl.List)
+}
+
+// queueInserts adds ins to the inserts of the current page and updates the
+// space they reserve.
+func (cb *CSSBuilder) queueInserts(ins []*Insert) {
+	if len(ins) == 0 {
+		return
+	}
+	for _, i := range ins {
+		cb.pageInserts[i.Class] = append(cb.pageInserts[i.Class], i)
+	}
+	cb.pageInsertHeight[InsertFloatTop] = cb.totalFloatTopHeight(cb.pageInserts[InsertFloatTop])
+	cb.pageInsertHeight[InsertFloatBottom] = cb.totalFloatBottomHeight(cb.pageInserts[InsertFloatBottom])
+	cb.pageInsertHeight[InsertFootnote] = cb.totalFootnoteHeight(cb.pageInserts[InsertFootnote])
+}
+
+// bottomInsertsHeight returns the space the footnotes and bottom floats of
+// the current page take up together with those of ins.
+func (cb *CSSBuilder) bottomInsertsHeight(ins []*Insert) bag.ScaledPoint {
+	fns := append(slices.Clone(cb.pageInserts[InsertFootnote]), filterInserts(ins, InsertFootnote)...)
+	fls := append(slices.Clone(cb.pageInserts[InsertFloatBottom]), filterInserts(ins, InsertFloatBottom)...)
+	return cb.totalFootnoteHeight(fns) + cb.totalFloatBottomHeight(fls)
+}
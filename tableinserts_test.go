@@ -0,0 +1,23 @@
+package htmlbag
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTableRowFootnote: the footnote of a cell far down a table that spans
+// several pages lands on the page of its row, with and without header rows.
+func TestTableRowFootnote(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }`
+	rows := strings.Replace(sumRows(90), "<td>POS70</td>", "<td>POS70<fn>FUSSNOTE</fn></td>", 1)
+	for _, head := range []string{"", `<thead><tr><th>KOPF</th><th>Betrag</th></tr></thead>`} {
+		pages := renderHTMLPages(t, css, `<html><body><table>`+head+`<tbody>`+rows+`</tbody></table></body></html>`)
+		if len(pages) < 2 {
+			t.Fatalf("got %d pages, want the table split", len(pages))
+		}
+		row, fn := pageWith(pages, "POS70"), pageWith(pages, "FUSSNOTE")
+		if row < 1 || fn != row {
+			t.Errorf("header %t: row on page %d, footnote on page %d", head != "", row, fn)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package htmlbag
+
+import (
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// layoutColumn is one column of a table in the column width algorithm: the
+// min-content and max-content widths of its cells and the width declared
+// for it by a col or a cell (0 for none). All widths are widths of the
+// cell boxes, padding, borders and border-spacing included.
+type layoutColumn struct {
+	min, max  bag.ScaledPoint
+	specified bag.ScaledPoint
+}
+
+// colgroupWidths returns the widths the col elements of the table te
+// declare, 0 for a col without width. Percentages resolve against avail.
+// ok is false when a col takes a share of the width ("*", "2*"): such a
+// colgroup keeps distributing the width itself through parseColumnWidth.
+func colgroupWidths(te *frontend.Text, avail bag.ScaledPoint) (widths []bag.ScaledPoint, ok bool) {
+	for _, itm := range te.Items {
+		cg, isText := itm.(*frontend.Text)
+		if !isText {
+			continue
+		}
+		if elt, _ := cg.Settings[frontend.SettingDebug].(string); elt != "colgroup" {
+			continue
+		}
+		for _, itm := range cg.Items {
+			col, isText := itm.(*frontend.Text)
+			if !isText {
+				continue
+			}
+			if elt, _ := col.Settings[frontend.SettingDebug].(string); elt != "col" {
+				continue
+			}
+			w, _ := col.Settings[frontend.SettingColumnWidth].(string)
+			w = strings.TrimSpace(w)
+			var wd bag.ScaledPoint
+			switch {
+			case strings.HasSuffix(w, "*"):
+				return nil, false
+			case strings.HasSuffix(w, "%"):
+				wd = ParseRelativeSize(w, avail, avail)
+			case w != "":
+				if sp, err := bag.SP(w); err == nil {
+					wd = sp
+				}
+			}
+			widths = append(widths, wd)
+		}
+	}
+	return widths, true
+}
+
+// layoutTableColumns sets the column widths of the table tbl built from te
+// before BuildTable runs. table-layout: fixed takes them from the colgroup
+// and the first row (CSS 2.1 §17.5.2.1), the auto layout from the contents
+// of all cells (§17.5.2.2). Every column gets a fixed width and the cells
+// lose their SpecifiedWidth, which the widths already account for. A
+// colgroup with "*" columns is left to the frontend.
+func (cb *CSSBuilder) layoutTableColumns(te *frontend.Text, tbl *frontend.Table) error {
+	declared, ok := colgroupWidths(te, tbl.MaxWidth)
+	if !ok {
+		return nil
+	}
+	g := newTableGrid(te)
+	if len(g.rows) != len(tbl.Rows) {
+		return nil
+	}
+	// The cells of tbl in the order of g.cells.
+	var tds []*frontend.TableCell
+	for r, row := range tbl.Rows {
+		cells, _ := g.rowCells(r)
+		if len(cells) != len(row.Cells) {
+			return nil
+		}
+		tds = append(tds, row.Cells...)
+	}
+	ncols := max(g.ncols, len(declared))
+	if ncols == 0 {
+		return nil
+	}
+	cols := make([]layoutColumn, ncols)
+	for i, wd := range declared {
+		cols[i].specified = wd
+	}
+
+	var widths []bag.ScaledPoint
+	if te.Settings[settingTableLayout] == "fixed" {
+		// Only the first row counts, a cell spanning several columns
+		// declares an even share for each.
+		for i, gc := range g.cells {
+			if gc.row != 0 {
+				break
+			}
+			if sw := tds[i].SpecifiedWidth; sw > 0 {
+				for c := gc.col; c < gc.col+gc.colSpan; c++ {
+					if cols[c].specified == 0 {
+						cols[c].specified = sw / bag.ScaledPoint(gc.colSpan)
+					}
+				}
+			}
+		}
+		widths = fixedColumnWidths(cols, tbl.MaxWidth)
+	} else {
+		measured := make([]intrinsicWidths, len(g.cells))
+		for i, gc := range g.cells {
+			iw, err := cb.cellIntrinsicWidths(tds[i])
+			if err != nil {
+				return err
+			}
+			measured[i] = iw
+			if gc.colSpan > 1 {
+				continue
+			}
+			col := &cols[gc.col]
+			col.min = max(col.min, iw.min)
+			col.max = max(col.max, iw.max)
+			col.specified = max(col.specified, tds[i].SpecifiedWidth)
+		}
+		// A cell spanning several columns widens them where they are
+		// too narrow for it, in proportion to their max-content widths
+		// (v1, its specified width is ignored).
+		for i, gc := range g.cells {
+			if gc.colSpan == 1 {
+				continue
+			}
+			span := cols[gc.col : gc.col+gc.colSpan]
+			weights := make([]bag.ScaledPoint, len(span))
+			var sumMin, sumMax bag.ScaledPoint
+			for c := range span {
+				weights[c] = span[c].max
+				sumMin += span[c].min
+				sumMax += span[c].max
+			}
+			if need := measured[i].min - sumMin; need > 0 {
+				for c, add := range distributeWidth(need, weights) {
+					span[c].min += add
+				}
+			}
+			if need := measured[i].max - sumMax; need > 0 {
+				for c, add := range distributeWidth(need, weights) {
+					span[c].max += add
+				}
+			}
+		}
+		for c := range cols {
+			cols[c].max = max(cols[c].max, cols[c].min)
+		}
+		widths = autoColumnWidths(cols, tbl.MaxWidth, tbl.Stretch)
+	}
+
+	tbl.ColSpec = tbl.ColSpec[:0]
+	for _, wd := range widths {
+		glue := node.NewGlue()
+		glue.Width = wd
+		tbl.ColSpec = append(tbl.ColSpec, frontend.ColSpec{ColumnWidth: glue})
+	}
+	for _, td := range tds {
+		td.SpecifiedWidth = 0
+	}
+	return nil
+}
+
+// cellIntrinsicWidths measures the contents buildTD collected for the cell
+// td like intrinsicWidths measures a box: the widest line at a content
+// width of 1pt and at maxContentProbe. Contents without lines (an image
+// sized to the cell) count as 0, turned contents (a vertical writing-mode)
+// as wide as they are. The measuring builds are detached (see measureItem).
+func (cb *CSSBuilder) cellIntrinsicWidths(td *frontend.TableCell) (intrinsicWidths, error) {
+	rebuild, tagging := cb.reflowRebuild, cb.enableTagging
+	cb.reflowRebuild, cb.enableTagging = true, false
+	defer func() {
+		cb.reflowRebuild, cb.enableTagging = rebuild, tagging
+	}()
+	narrow := bag.MustSP("1pt")
+	var iw intrinsicWidths
+	for _, c := range td.Contents {
+		vl, err := cb.formatCellContent(c, narrow)
+		if err != nil {
+			return intrinsicWidths{}, err
+		}
+		if vl != nil {
+			if wd, ok := vl.Attributes["_intrinsicWidth"].(bag.ScaledPoint); ok {
+				iw.min, iw.max = max(iw.min, wd), max(iw.max, wd)
+				continue
+			}
+			iw.min = max(iw.min, maxContentWidth(vl, narrow))
+		}
+		if vl, err = cb.formatCellContent(c, maxContentProbe); err != nil {
+			return intrinsicWidths{}, err
+		}
+		if vl != nil {
+			iw.max = max(iw.max, maxContentWidth(vl, maxContentProbe))
+		}
+	}
+	frame := td.PaddingLeft + td.PaddingRight + td.BorderLeftWidth + td.BorderRightWidth
+	iw.min += frame
+	iw.max = max(iw.max+frame, iw.min)
+	return iw, nil
+}
+
+// autoColumnWidths distributes the table width among the columns cols
+// (CSS 2.1 §17.5.2.2, with the distribution of CSS Tables 3 §3.9.3). The
+// table is as wide as avail when fill is set (a percentage width),
+// otherwise as wide as its columns want up to avail. Between the
+// min-content widths, the min-content widths raised to the specified
+// widths and the max-content widths (a column with a specified width does
+// not grow beyond it) the widths are interpolated linearly. Specified
+// widths that add up to more than avail are scaled down instead of
+// overflowing the table, only the min-content widths do that. Width left
+// over once every column has its max-content width goes to the columns
+// without specified width.
+func autoColumnWidths(cols []layoutColumn, avail bag.ScaledPoint, fill bool) []bag.ScaledPoint {
+	n := len(cols)
+	lo, mid, hi := make([]bag.ScaledPoint, n), make([]bag.ScaledPoint, n), make([]bag.ScaledPoint, n)
+	var sumLo, sumMid, sumHi bag.ScaledPoint
+	for i, c := range cols {
+		lo[i], mid[i], hi[i] = c.min, c.min, c.max
+		if c.specified > 0 {
+			mid[i] = max(c.min, c.specified)
+			hi[i] = mid[i]
+		}
+		sumLo += lo[i]
+		sumMid += mid[i]
+		sumHi += hi[i]
+	}
+	target := min(avail, sumHi)
+	if fill {
+		target = avail
+	}
+	interpolate := func(a, b []bag.ScaledPoint, sumA bag.ScaledPoint) []bag.ScaledPoint {
+		weights := make([]bag.ScaledPoint, n)
+		for i := range weights {
+			weights[i] = b[i] - a[i]
+		}
+		widths := distributeWidth(target-sumA, weights)
+		for i := range widths {
+			widths[i] += a[i]
+		}
+		return widths
+	}
+	switch {
+	case target <= sumLo:
+		return lo
+	case target <= sumMid:
+		return interpolate(lo, mid, sumLo)
+	case target <= sumHi:
+		return interpolate(mid, hi, sumMid)
+	}
+	weights := make([]bag.ScaledPoint, n)
+	for i, c := range cols {
+		if c.specified == 0 {
+			weights[i] = max(hi[i], 1)
+		}
+	}
+	if sumWidths(weights) == 0 {
+		for i := range weights {
+			weights[i] = max(hi[i], 1)
+		}
+	}
+	widths := distributeWidth(target-sumHi, weights)
+	for i := range widths {
+		widths[i] += hi[i]
+	}
+	return widths
+}
+
+// fixedColumnWidths is the fixed table layout (CSS 2.1 §17.5.2.1): the
+// table is as wide as avail, the columns with a specified width get it and
+// the others share what is left evenly. When every column has a width the
+// rest goes to all of them in proportion to their widths. Columns wider
+// than avail together overflow the table.
+func fixedColumnWidths(cols []layoutColumn, avail bag.ScaledPoint) []bag.ScaledPoint {
+	widths := make([]bag.ScaledPoint, len(cols))
+	weights := make([]bag.ScaledPoint, len(cols))
+	for i, c := range cols {
+		widths[i] = c.specified
+		if c.specified == 0 {
+			weights[i] = 1
+		}
+	}
+	if sumWidths(weights) == 0 {
+		copy(weights, widths)
+	}
+	if rest := avail - sumWidths(widths); rest > 0 {
+		for i, add := range distributeWidth(rest, weights) {
+			widths[i] += add
+		}
+	}
+	return widths
+}
+
+// distributeWidth splits amount in proportion to weights. The rounding
+// remainder goes to the last column with a weight; without any weight the
+// columns get even shares.
+func distributeWidth(amount bag.ScaledPoint, weights []bag.ScaledPoint) []bag.ScaledPoint {
+	shares := make([]bag.ScaledPoint, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+	total := sumWidths(weights)
+	last := len(weights) - 1
+	if total <= 0 {
+		for i := range shares {
+			shares[i] = amount / bag.ScaledPoint(len(shares))
+		}
+	} else {
+		for i, w := range weights {
+			if w > 0 {
+				shares[i] = bag.ScaledPoint(float64(amount) * float64(w) / float64(total))
+				last = i
+			}
+		}
+	}
+	shares[last] += amount - sumWidths(shares)
+	return shares
+}
+
+// sumWidths returns the sum of widths.
+func sumWidths(widths []bag.ScaledPoint) bag.ScaledPoint {
+	var sum bag.ScaledPoint
+	for _, w := range widths {
+		sum += w
+	}
+	return sum
+}
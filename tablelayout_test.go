@@ -0,0 +1,100 @@
+package htmlbag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func pts(v ...float64) []bag.ScaledPoint {
+	sp := make([]bag.ScaledPoint, len(v))
+	for i, f := range v {
+		sp[i] = bag.ScaledPoint(f * float64(bag.Factor))
+	}
+	return sp
+}
+
+func TestDistributeWidth(t *testing.T) {
+	got := distributeWidth(bag.MustSP("10pt"), pts(1, 3))
+	if got[0] != bag.MustSP("2.5pt") || got[1] != bag.MustSP("7.5pt") {
+		t.Errorf("got %v", got)
+	}
+	if got := distributeWidth(bag.MustSP("9pt"), pts(0, 0, 0)); got[0] != bag.MustSP("3pt") || sumWidths(got) != bag.MustSP("9pt") {
+		t.Errorf("even shares: got %v", got)
+	}
+	if got := distributeWidth(7, pts(1, 1, 1)); sumWidths(got) != 7 {
+		t.Errorf("remainder lost: got %v", got)
+	}
+}
+
+func TestAutoColumnWidths(t *testing.T) {
+	p := func(f float64) bag.ScaledPoint { return bag.ScaledPoint(f * float64(bag.Factor)) }
+	for _, tc := range []struct {
+		name  string
+		cols  []layoutColumn
+		avail float64
+		fill  bool
+		want  []bag.ScaledPoint
+	}{
+		{"max-content fits", []layoutColumn{{min: p(20), max: p(30)}, {min: p(40), max: p(100)}}, 300, false, pts(30, 100)},
+		{"interpolated", []layoutColumn{{min: p(20), max: p(30)}, {min: p(40), max: p(400)}}, 200, false, pts(23.784, 176.216)},
+		{"min-content overflows", []layoutColumn{{min: p(200), max: p(300)}, {min: p(200), max: p(300)}}, 300, false, pts(200, 200)},
+		{"specified wins", []layoutColumn{{min: p(10), max: p(400), specified: p(100)}, {min: p(10), max: p(400)}}, 300, false, pts(100, 200)},
+		{"specified scaled down", []layoutColumn{{min: p(10), max: p(10), specified: p(240)}, {min: p(10), max: p(10), specified: p(240)}}, 300, false, pts(150, 150)},
+		{"fill", []layoutColumn{{min: p(10), max: p(20), specified: p(50)}, {min: p(10), max: p(50)}}, 300, true, pts(50, 250)},
+	} {
+		got := autoColumnWidths(tc.cols, p(tc.avail), tc.fill)
+		for i := range got {
+			if d := got[i] - tc.want[i]; d > bag.MustSP("0.01pt") || d < -bag.MustSP("0.01pt") {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFixedColumnWidths(t *testing.T) {
+	got := fixedColumnWidths([]layoutColumn{{specified: bag.MustSP("100pt")}, {}, {}}, bag.MustSP("300pt"))
+	if want := pts(100, 100, 100); got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = fixedColumnWidths([]layoutColumn{{specified: bag.MustSP("50pt")}, {specified: bag.MustSP("100pt")}}, bag.MustSP("300pt"))
+	if want := pts(100, 200); got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("all specified: got %v, want %v", got, want)
+	}
+}
+
+// TestTableAutoLayout: a narrow ID column next to a long description keeps
+// the width of its content, the description takes the rest of the line.
+func TestTableAutoLayout(t *testing.T) {
+	desc := strings.Repeat("Beschreibung mit vielen Worten ", 20)
+	html := `<table><tr><td>A-1001</td><td>` + desc + `</td></tr><tr><td>A-1002</td><td>kurz</td></tr></table>`
+	widths := firstRowCellWidths(t, renderHTMLPages(t, cellWidthCSS, html))
+	if len(widths) != 2 {
+		t.Fatalf("got %d columns, want 2", len(widths))
+	}
+	cw := contentWidth(t)
+	if widths[0] > bag.MustSP("60pt") {
+		t.Errorf("ID column is %s, want it as narrow as its content", widths[0])
+	}
+	if !closeTo(widths[0]+widths[1], cw) {
+		t.Errorf("columns add up to %s, want the content width %s", widths[0]+widths[1], cw)
+	}
+}
+
+// TestTableFixedLayout: table-layout: fixed takes the widths from the first
+// row, later rows and the contents do not count.
+func TestTableFixedLayout(t *testing.T) {
+	css := cellWidthCSS + `
+table { table-layout: fixed; }`
+	html := `<table><tr><td style="width:3cm">A</td><td>B</td><td>C</td></tr><tr><td>x</td><td style="width:10cm">` + strings.Repeat("lang ", 50) + `</td><td>y</td></tr></table>`
+	widths := firstRowCellWidths(t, renderHTMLPages(t, css, html))
+	if len(widths) != 3 {
+		t.Fatalf("got %d columns, want 3", len(widths))
+	}
+	rest := (contentWidth(t) - bag.MustSP("3cm")) / 2
+	if !closeTo(widths[0], bag.MustSP("3cm")) || !closeTo(widths[1], rest) || !closeTo(widths[2], rest) {
+		t.Errorf("got %v, want 3cm and twice %s", widths, rest)
+	}
+}
@@ -0,0 +1,270 @@
+package htmlbag
+
+import (
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// tableOverflow is the -bag-table-overflow of a table, carried by
+// settingTableOverflow from Output() to buildTableParts: what becomes of a
+// table that is wider than its container even with every column at its
+// min-content width.
+type tableOverflow struct {
+	mode string // "shrink", "rotate" or "split-columns"
+	// keyColumns are the leading columns every part of a split table
+	// repeats.
+	keyColumns int
+}
+
+// parseTableOverflow parses a -bag-table-overflow value:
+//
+//	visible | shrink | rotate | split-columns [<integer>]
+//
+// split-columns repeats one key column unless the integer says otherwise.
+// It returns nil for visible and for invalid values.
+func parseTableOverflow(v string) *tableOverflow {
+	f := strings.Fields(strings.ToLower(v))
+	if len(f) == 0 {
+		return nil
+	}
+	switch f[0] {
+	case "shrink", "rotate":
+		if len(f) == 1 {
+			return &tableOverflow{mode: f[0]}
+		}
+	case "split-columns":
+		to := &tableOverflow{mode: f[0], keyColumns: 1}
+		switch len(f) {
+		case 1:
+			return to
+		case 2:
+			if n, err := strconv.Atoi(f[1]); err == nil && n >= 0 {
+				to.keyColumns = n
+				return to
+			}
+		}
+	}
+	return nil
+}
+
+// buildTableParts builds the table te at the width wd like buildTable and
+// applies its -bag-table-overflow when the table comes out wider than wd:
+//
+//   - shrink scales the table (with its caption) down to wd.
+//   - rotate builds the table as wide as the page is high and turns it
+//     counterclockwise by 90°, scaled down where it is still too big.
+//     Switching to a landscape page is not supported (v1).
+//   - split-columns builds the table in parts as wide as wd, each with the
+//     key columns and as many of the other columns as fit. The parts after
+//     the first go on pages of their own. split is false where the parts
+//     cannot follow one another (a flex or grid item); the table then
+//     overflows.
+//
+// A shrunk or rotated table is one box: the page builder no longer breaks
+// it between its rows (v1). To find out whether the table overflows it is
+// built detached first, the footnotes and floats of its cells taken out on
+// this build travel on the first box returned.
+func (cb *CSSBuilder) buildTableParts(te *frontend.Text, wd bag.ScaledPoint, split bool) ([]*node.VList, error) {
+	to, _ := te.Settings[settingTableOverflow].(*tableOverflow)
+	if to == nil || to.mode == "split-columns" && !split {
+		vl, err := cb.buildTable(te, wd)
+		if err != nil {
+			return nil, err
+		}
+		return []*node.VList{vl}, nil
+	}
+	rebuild, tagging := cb.reflowRebuild, cb.enableTagging
+	cb.reflowRebuild, cb.enableTagging = true, false
+	probe, err := cb.buildTable(te, wd)
+	cb.reflowRebuild, cb.enableTagging = rebuild, tagging
+	if err != nil {
+		return nil, err
+	}
+	inserts := nodeInserts(probe)
+
+	var parts []*node.VList
+	switch {
+	case probe.Width <= wd:
+	case to.mode == "shrink":
+		vl, err := cb.buildTable(te, wd)
+		if err != nil {
+			return nil, err
+		}
+		box := withCaption(vl, tableCaptionOf(vl))
+		s := float64(wd) / float64(box.Width)
+		parts = append(parts, transformedTable(vl, box, affine{s, 0, 0, s, 0, 0},
+			bag.ScaledPoint(s*float64(box.Width)), bag.ScaledPoint(s*float64(box.Height+box.Depth))))
+	case to.mode == "rotate":
+		ht := cb.currentPageDimensions.ContentHeight
+		if ht <= 0 {
+			ht = wd
+		}
+		vl, err := cb.buildTable(te, ht)
+		if err != nil {
+			return nil, err
+		}
+		box := withCaption(vl, tableCaptionOf(vl))
+		boxWd, boxHt := box.Width, box.Height+box.Depth
+		s := 1.0
+		if boxHt > wd {
+			s = float64(wd) / float64(boxHt)
+		}
+		if boxWd > ht {
+			s = min(s, float64(ht)/float64(boxWd))
+		}
+		// x' = s·y, y' = s·(width - x) in CSS coordinates: the top of
+		// the table runs up the left side of the box.
+		m := affine{0, -s, s, 0, 0, s * boxWd.ToPT()}
+		parts = append(parts, transformedTable(vl, box, m,
+			bag.ScaledPoint(s*float64(boxHt)), bag.ScaledPoint(s*float64(boxWd))))
+	case to.mode == "split-columns":
+		parts, err = cb.splitTableColumns(te, probe, wd, to.keyColumns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if parts == nil {
+		vl, err := cb.buildTable(te, wd)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, vl)
+	}
+	addInsertsAttr(parts[0], inserts)
+	return parts, nil
+}
+
+// transformedTable returns a box of wd × ht that paints the table vl, box
+// being vl with its caption, through the transformation m (in CSS
+// coordinates around the top left corner of the box). vl stops being a
+// table for the page builder.
+func transformedTable(vl, box *node.VList, m affine, wd, ht bag.ScaledPoint) *node.VList {
+	delete(vl.Attributes, "_caption")
+	vl.Attributes["origin"] = "transformed content"
+	save, r, restore := matrixNodes(m.flipY())
+	var head node.Node = save
+	head = node.InsertAfter(head, save, r)
+	head = node.InsertAfter(head, r, box)
+	head = node.InsertAfter(head, box, restore)
+	wrapper := node.Vpack(head)
+	wrapper.Width, wrapper.Height, wrapper.Depth = wd, ht, 0
+	wrapper.Attributes = node.H{"origin": "transformed table"}
+	if ins := nodeInserts(box); len(ins) > 0 {
+		wrapper.Attributes["inserts"] = ins
+		delete(box.Attributes, "inserts")
+	}
+	return wrapper
+}
+
+// splitTableColumns builds the table te, which probe shows to be wider
+// than wd, in parts: every part repeats the first key columns and takes
+// the next columns as long as they fit into wd, at least one. It returns
+// nil when the column widths cannot be taken from probe.
+func (cb *CSSBuilder) splitTableColumns(te *frontend.Text, probe *node.VList, wd bag.ScaledPoint, key int) ([]*node.VList, error) {
+	g := newTableGrid(te)
+	tc := measureTableColumns(probe, g)
+	if tc == nil || g.ncols < 2 {
+		return nil, nil
+	}
+	key = min(key, g.ncols-1)
+	keyWd := tc.span(0, key)
+	var parts []*node.VList
+	for c := key; c < g.ncols; {
+		keep := make([]bool, g.ncols)
+		for k := range key {
+			keep[k] = true
+		}
+		partWd := keyWd
+		for start := c; c < g.ncols && (c == start || partWd+tc.widths[c] <= wd); c++ {
+			partWd += tc.widths[c]
+			keep[c] = true
+		}
+		vl, err := cb.buildTable(sliceTableColumns(te, g, keep), wd)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, vl)
+	}
+	return parts, nil
+}
+
+// sliceTableColumns returns a copy of the table te (laid out in the grid g)
+// with the columns keep only. A cell spanning columns that are left out
+// spans the kept ones, a cell in none of them is dropped. The rows, cells
+// and other children are shared with te.
+func sliceTableColumns(te *frontend.Text, g *tableGrid, keep []bool) *frontend.Text {
+	part := frontend.NewText()
+	part.Settings = maps.Clone(te.Settings)
+	delete(part.Settings, settingTableOverflow)
+	rowOf := make(map[*frontend.Text]int, len(g.rows))
+	for r, tr := range g.rows {
+		rowOf[tr] = r
+	}
+	for _, itm := range te.Items {
+		section, ok := itm.(*frontend.Text)
+		if !ok {
+			part.Items = append(part.Items, itm)
+			continue
+		}
+		switch elt, _ := section.Settings[frontend.SettingDebug].(string); elt {
+		case "colgroup":
+			cg := &frontend.Text{Settings: section.Settings}
+			c := 0
+			for _, itm := range section.Items {
+				col, ok := itm.(*frontend.Text)
+				if !ok {
+					continue
+				}
+				if elt, _ := col.Settings[frontend.SettingDebug].(string); elt != "col" {
+					continue
+				}
+				if c < len(keep) && keep[c] {
+					cg.Items = append(cg.Items, col)
+				}
+				c++
+			}
+			part.Items = append(part.Items, cg)
+		case "thead", "tbody", "tfoot":
+			sec := &frontend.Text{Settings: section.Settings}
+			for _, itm := range section.Items {
+				tr, ok := itm.(*frontend.Text)
+				if !ok {
+					continue
+				}
+				r, ok := rowOf[tr]
+				if !ok {
+					continue
+				}
+				row := &frontend.Text{Settings: tr.Settings}
+				cells, _ := g.rowCells(r)
+				for _, gc := range cells {
+					n := 0
+					for c := gc.col; c < gc.col+gc.colSpan; c++ {
+						if keep[c] {
+							n++
+						}
+					}
+					if n == 0 {
+						continue
+					}
+					td := gc.te
+					if n != gc.colSpan {
+						td = &frontend.Text{Settings: maps.Clone(gc.te.Settings), Items: gc.te.Items}
+						td.Settings[frontend.SettingColspan] = n
+					}
+					row.Items = append(row.Items, td)
+				}
+				sec.Items = append(sec.Items, row)
+			}
+			part.Items = append(part.Items, sec)
+		default:
+			part.Items = append(part.Items, section)
+		}
+	}
+	return part
+}
@@ -0,0 +1,133 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+func TestParseTableOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want *tableOverflow
+	}{
+		{"shrink", &tableOverflow{mode: "shrink"}},
+		{"rotate", &tableOverflow{mode: "rotate"}},
+		{"split-columns", &tableOverflow{mode: "split-columns", keyColumns: 1}},
+		{"split-columns 2", &tableOverflow{mode: "split-columns", keyColumns: 2}},
+		{"visible", nil},
+		{"split-columns x", nil},
+		{"shrink 2", nil},
+	} {
+		got := parseTableOverflow(tc.in)
+		if (got == nil) != (tc.want == nil) || got != nil && *got != *tc.want {
+			t.Errorf("parseTableOverflow(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// wideTable returns a table with rows rows and 12 columns too wide for an A4
+// page: the cells hold unbreakable numbers, the first row names the
+// columns K, C01, C02, …
+func wideTable(rows int) string {
+	var sb strings.Builder
+	sb.WriteString("<table><tr><td>KEY</td>")
+	for c := 1; c < 12; c++ {
+		fmt.Fprintf(&sb, "<td>C%02d</td>", c)
+	}
+	sb.WriteString("</tr>")
+	for r := range rows {
+		fmt.Fprintf(&sb, "<tr><td>K%02d</td>", r)
+		for range 11 {
+			sb.WriteString("<td>12345678901234</td>")
+		}
+		sb.WriteString("</tr>")
+	}
+	sb.WriteString("</table>")
+	return sb.String()
+}
+
+// originVList returns the first VList with the given origin on the pages.
+func originVList(pages []*document.Page, origin string) *node.VList {
+	var walk func(n node.Node) *node.VList
+	walk = func(n node.Node) *node.VList {
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.HList:
+				if found := walk(v.List); found != nil {
+					return found
+				}
+			case *node.VList:
+				if o, _ := v.Attributes["origin"].(string); o == origin {
+					return v
+				}
+				if found := walk(v.List); found != nil {
+					return found
+				}
+			}
+		}
+		return nil
+	}
+	for _, pg := range pages {
+		for _, obj := range pg.Objects {
+			if obj.Vlist != nil {
+				if found := walk(obj.Vlist); found != nil {
+					return found
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TestTableOverflowShrink: the shrunk table is as wide as the page content.
+func TestTableOverflowShrink(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { -bag-table-overflow: shrink; }`
+	pages := renderHTMLPages(t, css, `<html><body>`+wideTable(5)+`</body></html>`)
+	box := originVList(pages, "transformed table")
+	if box == nil {
+		t.Fatal("no shrunk table")
+	}
+	if cw := bag.MustSP("170mm"); !closeTo(box.Width, cw) {
+		t.Errorf("shrunk table is %s wide, want %s", box.Width, cw)
+	}
+}
+
+// TestTableOverflowRotate: the rotated table is higher than wide and fits
+// the page content.
+func TestTableOverflowRotate(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { -bag-table-overflow: rotate; }`
+	pages := renderHTMLPages(t, css, `<html><body>`+wideTable(5)+`</body></html>`)
+	box := originVList(pages, "transformed table")
+	if box == nil {
+		t.Fatal("no rotated table")
+	}
+	if box.Width > bag.MustSP("170mm") || box.Height > bag.MustSP("257mm") || box.Height <= box.Width {
+		t.Errorf("rotated table is %s × %s", box.Width, box.Height)
+	}
+}
+
+// TestTableOverflowSplitColumns: the columns that do not fit move to the
+// next page, the key column is repeated there.
+func TestTableOverflowSplitColumns(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+table { -bag-table-overflow: split-columns; }`
+	pages := renderHTMLPages(t, css, `<html><body>`+wideTable(5)+`</body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the columns split", len(pages))
+	}
+	if pageWith(pages, "C01") != 0 || pageWith(pages, "C11") < 1 {
+		t.Errorf("first column on page %d, last column on page %d", pageWith(pages, "C01"), pageWith(pages, "C11"))
+	}
+	for p := range pages {
+		if pageWith(pages[p:p+1], "K04") != 0 {
+			t.Errorf("key column missing on page %d", p)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package htmlbag
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// tableColumns are the column widths of a built table, taken from its cell
+// boxes. Rows built apart from the table (the generated rows at a page
+// break, the parts of a split row) are built as tables of their own with
+// these column widths.
+type tableColumns struct {
+	widths []bag.ScaledPoint
+	width  bag.ScaledPoint
+}
+
+// measureTableColumns takes the column widths from the cell boxes of the
+// first rows of vl in which every column has a cell of its own. It returns
+// nil when the rows of vl do not match the grid g or a column is only ever
+// spanned.
+func measureTableColumns(vl *node.VList, g *tableGrid) *tableColumns {
+	tc := &tableColumns{widths: make([]bag.ScaledPoint, g.ncols), width: vl.Width}
+	found := 0
+	r := 0
+	for n := vl.List; n != nil; n = n.Next() {
+		hl, ok := n.(*node.HList)
+		if !ok {
+			continue
+		}
+		if r >= len(g.rows) {
+			return nil
+		}
+		boxes := cellBoxes(hl)
+		if cells, own := g.rowCells(r); own && len(cells) == len(boxes) {
+			for i, gc := range cells {
+				if gc.colSpan == 1 && tc.widths[gc.col] == 0 {
+					tc.widths[gc.col] = boxes[i].Width
+					found++
+				}
+			}
+		}
+		r++
+	}
+	if r != len(g.rows) || found != g.ncols {
+		return nil
+	}
+	return tc
+}
+
+// span returns the width of n columns from column col on.
+func (tc *tableColumns) span(col, n int) bag.ScaledPoint {
+	var wd bag.ScaledPoint
+	for c := col; c < col+n && c < len(tc.widths); c++ {
+		wd += tc.widths[c]
+	}
+	return wd
+}
+
+// cellBoxes returns the cell boxes of the row hl.
+func cellBoxes(hl *node.HList) []*node.VList {
+	var boxes []*node.VList
+	for c := hl.List; c != nil; c = c.Next() {
+		if cv, ok := c.(*node.VList); ok {
+			boxes = append(boxes, cv)
+		}
+	}
+	return boxes
+}
+
+// rowCells returns the cells of row r in column order. own is false when a
+// cell of an earlier row spans into r.
+func (g *tableGrid) rowCells(r int) (cells []*tableGridCell, own bool) {
+	own = true
+	for _, gc := range g.at[r] {
+		if gc == nil {
+			continue
+		}
+		if gc.row != r {
+			own = false
+			continue
+		}
+		if len(cells) == 0 || cells[len(cells)-1] != gc {
+			cells = append(cells, gc)
+		}
+	}
+	return cells, own
+}
+
+// buildRowsApart builds the rows trs of the table te as a table of their
+// own with the column widths tc and returns the row boxes. The rows are
+// built detached (see measureItem).
+func (cb *CSSBuilder) buildRowsApart(te *frontend.Text, tc *tableColumns, trs ...*frontend.Text) ([]*node.HList, error) {
+	tbl := frontend.NewText()
+	tbl.Settings = maps.Clone(te.Settings)
+	for _, k := range []frontend.SettingType{settingRunningSum, settingRowspanBreak, settingRowBanding, frontend.SettingWidth} {
+		delete(tbl.Settings, k)
+	}
+	// The columns keep the widths measured, whatever the contents of
+	// these rows would make of them.
+	tbl.Settings[settingTableLayout] = "fixed"
+	colgroup := frontend.NewText()
+	colgroup.Settings[frontend.SettingDebug] = "colgroup"
+	for _, wd := range tc.widths {
+		col := frontend.NewText()
+		col.Settings[frontend.SettingDebug] = "col"
+		col.Settings[frontend.SettingColumnWidth] = fmt.Sprintf("%gpt", wd.ToPT())
+		colgroup.Items = append(colgroup.Items, col)
+	}
+	tbody := frontend.NewText()
+	tbody.Settings[frontend.SettingDebug] = "tbody"
+	for _, tr := range trs {
+		tbody.Items = append(tbody.Items, tr)
+	}
+	tbl.Items = append(tbl.Items, colgroup, tbody)
+
+	vl, err := cb.measureItem(tbl, tc.width)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*node.HList
+	for n := vl.List; n != nil; {
+		next := n.Next()
+		if hl, ok := n.(*node.HList); ok {
+			hl.SetPrev(nil)
+			hl.SetNext(nil)
+			rows = append(rows, hl)
+		}
+		n = next
+	}
+	return rows, nil
+}
+
+// tableRowPager knows what outputTableRows needs beyond the row boxes to
+// break a table between and inside its rows (CSS Fragmentation 3 §4.4):
+//   - The rows joined by a cell with rowspan form a group that is kept on
+//     one page unless -bag-rowspan-break: auto allows breaks between its
+//     rows (v1: the spanning cell is not split, it runs on below the row
+//     it starts in). A group taller than a page is broken between its rows.
+//   - A body row that does not fit on an empty page is split between the
+//     lines of its cells, one with an explicit page-break-inside: auto
+//     whenever it does not fit in the rest of the page. Rows with
+//     page-break-inside: avoid and rows of a rowspan group are not split.
+type tableRowPager struct {
+	cb         *CSSBuilder
+	te         *frontend.Text
+	grid       *tableGrid
+	cols       *tableColumns // nil: the rows are not split
+	groupEnd   []int         // the last row of the rowspan group starting at a row
+	keepGroups bool
+}
+
+// newTableRowPager returns the row pager of the table vl with nrows row
+// boxes, nil when the rows do not match the source table.
+func (cb *CSSBuilder) newTableRowPager(vl *node.VList, nrows int) *tableRowPager {
+	te, ok := vl.Attributes["_tableTe"].(*frontend.Text)
+	if !ok {
+		return nil
+	}
+	g := newTableGrid(te)
+	if len(g.rows) != nrows {
+		return nil
+	}
+	p := &tableRowPager{cb: cb, te: te, grid: g, cols: measureTableColumns(vl, g)}
+	p.keepGroups = te.Settings[settingRowspanBreak] != "auto"
+	p.groupEnd = make([]int, nrows)
+	for r := 0; r < nrows; {
+		end := r
+		for changed := true; changed; {
+			changed = false
+			for _, c := range g.cells {
+				if c.row >= r && c.row <= end && c.row+c.rowSpan-1 > end {
+					end = c.row + c.rowSpan - 1
+					changed = true
+				}
+			}
+		}
+		for i := r; i <= end; i++ {
+			p.groupEnd[i] = end
+		}
+		r = end + 1
+	}
+	return p
+}
+
+// needsRowPager reports whether the table te, built as vl, needs the row
+// pager of outputTableRows: a cell spans rows, a row allows a break inside
+// explicitly or is taller than the page.
+func (cb *CSSBuilder) needsRowPager(te *frontend.Text, vl *node.VList) bool {
+	for _, c := range newTableGrid(te).cells {
+		if c.rowSpan > 1 {
+			return true
+		}
+	}
+	ht := cb.currentPageDimensions.ContentHeight
+	for n := vl.List; n != nil; n = n.Next() {
+		if hl, ok := n.(*node.HList); ok {
+			if v, _ := hl.Attributes["pageBreakInside"].(string); v == "auto" || ht > 0 && hl.Height+hl.Depth > ht {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remeasure takes the column widths from the rebuilt table vl.
+func (p *tableRowPager) remeasure(vl *node.VList) {
+	p.cols = measureTableColumns(vl, p.grid)
+}
+
+// keptGroup returns the last row of the rowspan group starting at row r
+// when the group is to be kept on one page, r otherwise.
+func (p *tableRowPager) keptGroup(r int) int {
+	if !p.keepGroups || r > 0 && p.groupEnd[r-1] >= r {
+		return r
+	}
+	return p.groupEnd[r]
+}
+
+// splittable reports whether row r may be split. eager is true for a row
+// with an explicit page-break-inside: auto, which is split to fill the
+// page; the others are split only when they do not fit on an empty page.
+func (p *tableRowPager) splittable(r int, row node.Node) (ok, eager bool) {
+	hl, isHL := row.(*node.HList)
+	if !isHL || p.cols == nil || p.groupEnd[r] != r || r > 0 && p.groupEnd[r-1] >= r {
+		return false, false
+	}
+	v, _ := hl.Attributes["pageBreakInside"].(string)
+	if v == "avoid" {
+		return false, false
+	}
+	return true, v == "auto"
+}
+
+// split splits row r so that the first part is at most avail high. The
+// cells are formatted again at the width of their content boxes and their
+// lines distributed over two rows built apart from the table; every cell
+// keeps its padding and borders in both parts. first is nil when not one
+// line fits into avail.
+func (p *tableRowPager) split(r int, row *node.HList, avail bag.ScaledPoint) (first, rest *node.HList, err error) {
+	cb := p.cb
+	cells, _ := p.grid.rowCells(r)
+	type cellContent struct {
+		gc     *tableGridCell
+		nodes  []node.Node
+		chrome bag.ScaledPoint // padding and borders above and below the content
+		width  bag.ScaledPoint
+	}
+	contents := make([]cellContent, 0, len(cells))
+
+	// The cells are taken apart by buildTD, in a scope of their own.
+	savedBorders, savedBackgrounds, savedTransforms := cb.tableCellBorders, cb.tableCellBackgrounds, cb.tableCellTransforms
+	savedDiagonals, savedTransparencies := cb.tableCellDiagonals, cb.tableCellTransparencies
+	savedInserts, savedModels := cb.tableInserts, cb.tableCellModels
+	cb.tableCellBorders, cb.tableCellBackgrounds, cb.tableCellTransforms = nil, nil, nil
+	cb.tableCellDiagonals, cb.tableCellTransparencies = nil, nil
+	tb, _ := p.te.Settings[settingTableBorders].(*tableBorders)
+	cb.tableCellModels = cellBorderModels(p.te, tb)
+	defer func() {
+		cb.tableCellBorders, cb.tableCellBackgrounds, cb.tableCellTransforms = savedBorders, savedBackgrounds, savedTransforms
+		cb.tableCellDiagonals, cb.tableCellTransparencies = savedDiagonals, savedTransparencies
+		cb.tableInserts, cb.tableCellModels = savedInserts, savedModels
+	}()
+	for _, gc := range cells {
+		tr := &frontend.TableRow{}
+		elt, _ := gc.te.Settings[frontend.SettingDebug].(string)
+		cb.buildTD(gc.te, tr, elt == "th", p.cols.width)
+		td := tr.Cells[0]
+		cc := cellContent{
+			gc:     gc,
+			chrome: td.PaddingTop + td.PaddingBottom + td.BorderTopWidth + td.BorderBottomWidth,
+			width:  p.cols.span(gc.col, gc.colSpan) - td.PaddingLeft - td.PaddingRight - td.BorderLeftWidth - td.BorderRightWidth,
+		}
+		for _, c := range td.Contents {
+			vl, err := cb.formatCellContent(c, cc.width)
+			if err != nil {
+				return nil, nil, err
+			}
+			if vl == nil {
+				continue
+			}
+			if isLineList(vl) {
+				for n := vl.List; n != nil; n = n.Next() {
+					cc.nodes = append(cc.nodes, n)
+				}
+			} else {
+				cc.nodes = append(cc.nodes, vl)
+			}
+		}
+		contents = append(contents, cc)
+	}
+
+	if cb.PendingVLists == nil {
+		cb.PendingVLists = map[string]*node.VList{}
+	}
+	var ids []string
+	defer func() {
+		for _, id := range ids {
+			delete(cb.PendingVLists, id)
+		}
+	}()
+	// cellText returns the part of a cell: its settings with the lines
+	// as pre-rendered content, hanging from the top of the cell.
+	cellText := func(cc cellContent, nodes []node.Node) *frontend.Text {
+		td := frontend.NewText()
+		td.Settings = maps.Clone(cc.gc.te.Settings)
+		td.Settings[frontend.SettingVAlign] = frontend.VAlignTop
+		if len(nodes) > 0 {
+			var head node.Node
+			for _, n := range nodes {
+				n.SetPrev(nil)
+				n.SetNext(nil)
+				head = node.InsertAfter(head, node.Tail(head), n)
+			}
+			vl := node.Vpack(head)
+			vl.Width = cc.width
+			id := fmt.Sprintf("_split-row-%p-%d", p, len(ids))
+			ids = append(ids, id)
+			cb.PendingVLists[id] = vl
+			td.Settings[frontend.SettingPrerenderedVListID] = id
+		}
+		return td
+	}
+
+	// The rows built apart may come out higher than the lines they hold
+	// (rounding, a cell taller than its lines); lower the target and try
+	// again.
+	var less bag.ScaledPoint
+	for range 4 {
+		trFirst, trRest := frontend.NewText(), frontend.NewText()
+		trFirst.Settings = maps.Clone(p.grid.rows[r].Settings)
+		trRest.Settings = maps.Clone(p.grid.rows[r].Settings)
+		some, left := false, false
+		for _, cc := range contents {
+			a, b := splitCellLines(cc.nodes, avail-cc.chrome-less)
+			some = some || len(a) > 0
+			left = left || len(b) > 0
+			trFirst.Items = append(trFirst.Items, cellText(cc, a))
+			trRest.Items = append(trRest.Items, cellText(cc, b))
+		}
+		if !some {
+			return nil, nil, nil
+		}
+		if !left {
+			return row, nil, nil
+		}
+		rows, err := cb.buildRowsApart(p.te, p.cols, trFirst, trRest)
+		if err != nil || len(rows) != 2 {
+			return nil, nil, err
+		}
+		first, rest = rows[0], rows[1]
+		if h := first.Height + first.Depth; h > avail {
+			less += h - avail
+			continue
+		}
+		break
+	}
+	if first == nil || first.Height+first.Depth > avail {
+		return nil, nil, nil
+	}
+	if row.Attributes != nil {
+		rest.Attributes = maps.Clone(row.Attributes)
+		// The parts get band rules of their own (paintRowBand).
+		delete(rest.Attributes, "_bandRules")
+		if bc, ok := row.Attributes["_bandCells"]; ok {
+			if first.Attributes == nil {
+				first.Attributes = node.H{}
+			}
+			first.Attributes["_bandCells"] = bc
+		}
+	}
+	return first, rest, nil
+}
+
+// isLineList reports whether vl is a plain paragraph: lines, glue and
+// kerns only. Its lines may go to different parts of a split row; any
+// other box is kept whole.
+func isLineList(vl *node.VList) bool {
+	for n := vl.List; n != nil; n = n.Next() {
+		switch n.(type) {
+		case *node.HList, *node.Glue, *node.Kern, *node.Penalty:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitCellLines splits the content nodes of a cell so that the first part
+// is at most ht high. The break is taken before a line or a box; the glue
+// and kerns at the break are dropped.
+func splitCellLines(nodes []node.Node, ht bag.ScaledPoint) (first, rest []node.Node) {
+	var sum bag.ScaledPoint
+	lastBox := -1
+	for i, n := range nodes {
+		switch n.(type) {
+		case *node.HList, *node.VList, *node.Rule:
+			if sum+vlistNodeHeight(n) > ht {
+				return nodes[:lastBox+1], trimLeadingSpace(nodes[lastBox+1:])
+			}
+			lastBox = i
+		}
+		sum += vlistNodeHeight(n)
+	}
+	return nodes, nil
+}
+
+// trimLeadingSpace drops the glue, kerns and penalties at the start of
+// nodes.
+func trimLeadingSpace(nodes []node.Node) []node.Node {
+	for len(nodes) > 0 {
+		switch nodes[0].(type) {
+		case *node.Glue, *node.Kern, *node.Penalty:
+			nodes = nodes[1:]
+		default:
+			return nodes
+		}
+	}
+	return nodes
+}
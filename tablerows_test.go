@@ -0,0 +1,95 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+// brLines returns n lines Z01, Z02, … separated by <br>.
+func brLines(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			sb.WriteString("<br>")
+		}
+		fmt.Fprintf(&sb, "Z%02d", i)
+	}
+	return sb.String()
+}
+
+// TestTallRowSplit: a row taller than a page is split between the lines of
+// its cells, and no line runs into the bottom margin.
+func TestTallRowSplit(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><tr><td>LINKS</td><td>`+brLines(90)+`</td></tr><tr><td>DANACH</td><td>x</td></tr></table></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the row to break", len(pages))
+	}
+	if pageWith(pages, "Z01") != 0 || pageWith(pages, "Z90") < 1 {
+		t.Errorf("first line on page %d, last line on page %d", pageWith(pages, "Z01"), pageWith(pages, "Z90"))
+	}
+	bottom := bag.MustSP("20mm")
+	for i := 1; i <= 90; i++ {
+		z := fmt.Sprintf("Z%02d", i)
+		p := pageWith(pages, z)
+		if p < 0 {
+			t.Errorf("%s missing", z)
+			continue
+		}
+		if _, y, ok := textPos(pages[p], z); !ok || y < bottom {
+			t.Errorf("%s on page %d at y=%s, below the page area", z, p, y)
+		}
+	}
+}
+
+// TestRowBreakInsideAuto: a row with page-break-inside: auto fills the rest
+// of the page, a row without moves whole to the next page.
+func TestRowBreakInsideAuto(t *testing.T) {
+	for _, tc := range []struct {
+		css       string
+		firstPage int
+	}{
+		{"", 1},
+		{"tr { page-break-inside: auto; }", 0},
+	} {
+		css := `@page { size: a4; margin: 20mm; }
+.platz { height: 200mm; }` + tc.css
+		pages := renderHTMLPages(t, css, `<html><body><div class="platz">OBEN</div><table><tr><td>`+brLines(30)+`</td></tr></table></body></html>`)
+		if got := pageWith(pages, "Z01"); got != tc.firstPage {
+			t.Errorf("%q: first line on page %d, want %d", tc.css, got, tc.firstPage)
+		}
+		if got := pageWith(pages, "Z30"); got != 1 {
+			t.Errorf("%q: last line on page %d, want 1", tc.css, got)
+		}
+	}
+}
+
+// TestRowspanGroup: the rows joined by a rowspan cell stay on one page
+// wherever the group falls; -bag-rowspan-break: auto lets the table break
+// between them.
+func TestRowspanGroup(t *testing.T) {
+	group := `<tr><td rowspan="3">GRUPPE</td><td>GA</td></tr><tr><td>GB</td></tr><tr><td>GC</td></tr>`
+	split := false
+	for n := 40; n < 65; n++ {
+		for _, auto := range []bool{false, true} {
+			css := `@page { size: a4; margin: 20mm; }`
+			if auto {
+				css += ` table { -bag-rowspan-break: auto; }`
+			}
+			pages := renderHTMLPages(t, css, `<html><body><table>`+captionRows(n)+group+captionRows(5)+`</table></body></html>`)
+			a, c := pageWith(pages, "GA"), pageWith(pages, "GC")
+			if !auto && a != c {
+				t.Errorf("%d rows before: group on pages %d to %d", n, a, c)
+			}
+			if auto && a != c {
+				split = true
+			}
+		}
+	}
+	if !split {
+		t.Error("-bag-rowspan-break: auto never broke inside the group")
+	}
+}
@@ -59,6 +59,7 @@ var htmlToPDFTag = map[string]pdfTagInfo{
 	"img":        {"Figure", "img"},
 	"figure":     {"Figure", "figure"},
 	"table":      {"Table", "table"},
+	"caption":    {"Caption", "caption"},
 	"thead":      {"THead", "thead"},
 	"tbody":      {"TBody", "tbody"},
 	"tfoot":      {"TFoot", "tfoot"},
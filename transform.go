@@ -0,0 +1,321 @@
+package htmlbag
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+)
+
+// boxTransform is carried by settingTransform from Output() to
+// buildVlistInternal (and to buildTD for table cells): the CSS transform
+// list and transform-origin of a box (CSS Transforms 1). Both are resolved
+// against the border box once the box is built. The font sizes resolve em
+// lengths.
+//
+// A transform only changes how the box is painted: the box keeps its place
+// and size in the layout, the following flow does not move, and content
+// that is rotated or scaled beyond the box may overlap its neighbours —
+// just like in a browser. 3D functions are not supported, and of the inline
+// boxes only images are transformed (v1).
+type boxTransform struct {
+	transform    string
+	origin       string
+	fontsize     bag.ScaledPoint
+	rootFontsize bag.ScaledPoint
+}
+
+// elementTransform returns the transform of an element's styles, if any.
+func elementTransform(styles *FormattingStyles) (*boxTransform, bool) {
+	if styles.transform == "" || styles.transform == "none" {
+		return nil, false
+	}
+	return &boxTransform{
+		transform:    styles.transform,
+		origin:       styles.transformOrigin,
+		fontsize:     styles.Fontsize,
+		rootFontsize: styles.DefaultFontSize,
+	}, true
+}
+
+// affine is a 2D affine transformation [a b c d e f] in the notation of
+// CSS matrix() and the PDF cm operator: x' = a·x + c·y + e,
+// y' = b·x + d·y + f. Lengths are in points.
+type affine [6]float64
+
+var identity = affine{1, 0, 0, 1, 0, 0}
+
+// then returns the transformation that applies m first and n afterwards.
+func (m affine) then(n affine) affine {
+	return affine{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// flipY converts between the CSS coordinates (y grows downward) and the
+// PDF coordinates (y grows upward) of the same box.
+func (m affine) flipY() affine {
+	return affine{m[0], -m[1], -m[2], m[3], m[4], -m[5]}
+}
+
+// cm returns the PDF instruction that concatenates m to the CTM.
+func (m affine) cm() string {
+	num := func(f float64) string {
+		return strconv.FormatFloat(math.Round(f*100000)/100000, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s %s %s %s %s %s cm", num(m[0]), num(m[1]), num(m[2]), num(m[3]), num(m[4]), num(m[5]))
+}
+
+// matrix returns the transformation of bt for a border box of wd × ht in
+// PDF coordinates relative to the top left corner of the box.
+func (bt *boxTransform) matrix(wd, ht bag.ScaledPoint) (affine, error) {
+	m, err := parseTransform(bt.transform, wd, ht, bt.fontsize, bt.rootFontsize)
+	if err != nil {
+		return identity, err
+	}
+	ox, oy := transformOrigin(bt.origin, wd, ht, bt.fontsize, bt.rootFontsize)
+	// CSS Transforms 1 §6: translate by the origin, apply the list,
+	// translate back.
+	m = affine{1, 0, 0, 1, -ox, -oy}.then(m).then(affine{1, 0, 0, 1, ox, oy})
+	return m.flipY(), nil
+}
+
+// parseTransform parses a CSS transform list in CSS coordinates.
+// Percentages in translations refer to the border box (wd × ht).
+func parseTransform(v string, wd, ht, fontsize, rootFontsize bag.ScaledPoint) (affine, error) {
+	length := func(s string, ref bag.ScaledPoint) (float64, error) {
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return 0, err
+			}
+			return ref.ToPT() * f / 100, nil
+		}
+		if s == "0" {
+			return 0, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return 0, fmt.Errorf("length without unit %q", s)
+		}
+		return ParseRelativeSize(s, fontsize, rootFontsize).ToPT(), nil
+	}
+	angle := func(s string) (float64, error) {
+		if a, ok := parseAngle(s); ok {
+			return a, nil
+		}
+		return 0, fmt.Errorf("invalid angle %q", s)
+	}
+	number := func(s string) (float64, error) {
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			f, err := strconv.ParseFloat(p, 64)
+			return f / 100, err
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+
+	// The list applies right to left: the function written last acts on
+	// the box first.
+	m := identity
+	rest := strings.TrimSpace(v)
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		end := strings.IndexByte(rest, ')')
+		if open <= 0 || end < open {
+			return identity, fmt.Errorf("invalid transform %q", v)
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:open]))
+		args := strings.Fields(strings.ReplaceAll(rest[open+1:end], ",", " "))
+		rest = strings.TrimSpace(rest[end+1:])
+
+		var f affine
+		var err error
+		arg := func(i int, conv func(string) (float64, error)) float64 {
+			if err != nil || i >= len(args) {
+				return 0
+			}
+			var x float64
+			x, err = conv(args[i])
+			return x
+		}
+		nargs := func(min, max int) bool {
+			if len(args) < min || len(args) > max {
+				err = fmt.Errorf("%s() takes %d to %d arguments", name, min, max)
+				return false
+			}
+			return true
+		}
+		switch name {
+		case "matrix":
+			if nargs(6, 6) {
+				f = affine{arg(0, number), arg(1, number), arg(2, number), arg(3, number),
+					arg(4, strconv.ParseFloat), arg(5, strconv.ParseFloat)}
+			}
+		case "translate":
+			if nargs(1, 2) {
+				f = affine{1, 0, 0, 1, arg(0, func(s string) (float64, error) { return length(s, wd) }),
+					arg(1, func(s string) (float64, error) { return length(s, ht) })}
+			}
+		case "translatex":
+			if nargs(1, 1) {
+				f = affine{1, 0, 0, 1, arg(0, func(s string) (float64, error) { return length(s, wd) }), 0}
+			}
+		case "translatey":
+			if nargs(1, 1) {
+				f = affine{1, 0, 0, 1, 0, arg(0, func(s string) (float64, error) { return length(s, ht) })}
+			}
+		case "scale":
+			if nargs(1, 2) {
+				sx := arg(0, number)
+				sy := sx
+				if len(args) == 2 {
+					sy = arg(1, number)
+				}
+				f = affine{sx, 0, 0, sy, 0, 0}
+			}
+		case "scalex":
+			if nargs(1, 1) {
+				f = affine{arg(0, number), 0, 0, 1, 0, 0}
+			}
+		case "scaley":
+			if nargs(1, 1) {
+				f = affine{1, 0, 0, arg(0, number), 0, 0}
+			}
+		case "rotate":
+			if nargs(1, 1) {
+				a := arg(0, angle)
+				sin, cos := math.Sincos(a)
+				f = affine{cos, sin, -sin, cos, 0, 0}
+			}
+		case "skew":
+			if nargs(1, 2) {
+				f = affine{1, math.Tan(arg(1, angle)), math.Tan(arg(0, angle)), 1, 0, 0}
+			}
+		case "skewx":
+			if nargs(1, 1) {
+				f = affine{1, 0, math.Tan(arg(0, angle)), 1, 0, 0}
+			}
+		case "skewy":
+			if nargs(1, 1) {
+				f = affine{1, math.Tan(arg(0, angle)), 0, 1, 0, 0}
+			}
+		default:
+			err = fmt.Errorf("unsupported transform function %s()", name)
+		}
+		if err != nil {
+			return identity, err
+		}
+		m = f.then(m)
+	}
+	return m, nil
+}
+
+// transformOrigin resolves a CSS transform-origin (default 50% 50%) to a
+// point in CSS coordinates of the border box. A third (z) value is ignored.
+func transformOrigin(v string, wd, ht, fontsize, rootFontsize bag.ScaledPoint) (float64, float64) {
+	f := strings.Fields(v)
+	if len(f) == 0 {
+		return wd.ToPT() / 2, ht.ToPT() / 2
+	}
+	vertical := func(s string) bool { return s == "top" || s == "bottom" }
+	horizontal := func(s string) bool { return s == "left" || s == "right" }
+	x, y := "center", "center"
+	switch {
+	case len(f) == 1 && vertical(f[0]):
+		y = f[0]
+	case len(f) == 1:
+		x = f[0]
+	case vertical(f[0]) || horizontal(f[1]):
+		x, y = f[1], f[0]
+	default:
+		x, y = f[0], f[1]
+	}
+	resolve := func(s string, ref bag.ScaledPoint) float64 {
+		switch s {
+		case "left", "top":
+			return 0
+		case "center":
+			return ref.ToPT() / 2
+		case "right", "bottom":
+			return ref.ToPT()
+		}
+		if p, ok := strings.CutSuffix(s, "%"); ok {
+			if pct, err := strconv.ParseFloat(p, 64); err == nil {
+				return ref.ToPT() * pct / 100
+			}
+			return ref.ToPT() / 2
+		}
+		return ParseRelativeSize(s, fontsize, rootFontsize).ToPT()
+	}
+	return resolve(x, wd), resolve(y, ht)
+}
+
+// transformNodes returns the nodes that paint everything between them
+// through the transformation of bt for a box of wd × ht:
+//
+//	StartStop "q" → Rule (cm) … StartStop "Q"
+//
+// The rule must sit at the top left corner of the box: like the clip rule of
+// clipVList it is drawn in box coordinates, so its cm acts around the box
+// and stays in effect until the "Q". An invalid transform is reported and
+// returns nil nodes.
+func (cb *CSSBuilder) transformNodes(bt *boxTransform, wd, ht bag.ScaledPoint) (node.Node, node.Node, node.Node) {
+	m, err := bt.matrix(wd, ht)
+	if err != nil {
+		bag.Logger.Warn("ignoring transform", "transform", bt.transform, "error", err)
+		return nil, nil, nil
+	}
+	return matrixNodes(m)
+}
+
+// matrixNodes returns the nodes of transformNodes for the transformation m
+// in PDF coordinates relative to the top left corner of the box.
+func matrixNodes(m affine) (node.Node, node.Node, node.Node) {
+	save := node.NewStartStop()
+	save.Position = node.PDFOutputPage
+	save.ShipoutCallback = func(n node.Node) string {
+		return "q "
+	}
+	restore := node.NewStartStop()
+	restore.Position = node.PDFOutputPage
+	restore.ShipoutCallback = func(n node.Node) string {
+		return "Q "
+	}
+	r := node.NewRule()
+	r.Hide = true
+	r.Pre = m.cm()
+	r.Attributes = node.H{"origin": "transform"}
+	return save, r, restore
+}
+
+// transformVList wraps vl so that it is painted through its CSS transform.
+// Like clipVList the wrapper takes over vl's attributes. A transformed box
+// is monolithic: a fragment on the next page would be transformed around
+// the wrong box.
+func (cb *CSSBuilder) transformVList(vl *node.VList, bt *boxTransform) *node.VList {
+	save, r, restore := cb.transformNodes(bt, vl.Width, vl.Height+vl.Depth)
+	if save == nil {
+		return vl
+	}
+	var head node.Node = save
+	head = node.InsertAfter(head, save, r)
+	head = node.InsertAfter(head, r, vl)
+	head = node.InsertAfter(head, vl, restore)
+	wrapper := node.Vpack(head)
+	wrapper.Width = vl.Width
+	wrapper.Height = vl.Height
+	wrapper.Depth = vl.Depth
+	wrapper.Attributes = vl.Attributes
+	if wrapper.Attributes == nil {
+		wrapper.Attributes = node.H{}
+	}
+	vl.Attributes = node.H{"origin": "transformed content"}
+	return wrapper
+}
@@ -0,0 +1,107 @@
+package htmlbag
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+)
+
+func TestParseTransform(t *testing.T) {
+	wd, ht := bag.MustSP("100pt"), bag.MustSP("50pt")
+	fs := bag.MustSP("10pt")
+	cases := []struct {
+		in   string
+		want affine
+	}{
+		{"rotate(90deg)", affine{0, 1, -1, 0, 0, 0}},
+		{"translate( 10pt , 50% )", affine{1, 0, 0, 1, 10, 25}},
+		{"translateX(2em)", affine{1, 0, 0, 1, 20, 0}},
+		{"scale(2) translate(10pt, 0)", affine{2, 0, 0, 2, 20, 0}},
+		{"translate(10pt, 0) scale(2)", affine{2, 0, 0, 2, 10, 0}},
+		{"scaleX(-1)", affine{-1, 0, 0, 1, 0, 0}},
+		{"skewX(45deg)", affine{1, 0, 1, 1, 0, 0}},
+		{"matrix(1, 2, 3, 4, 5, 6)", affine{1, 2, 3, 4, 5, 6}},
+	}
+	for _, tc := range cases {
+		got, err := parseTransform(tc.in, wd, ht, fs, fs)
+		if err != nil {
+			t.Errorf("parseTransform(%q): %v", tc.in, err)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("parseTransform(%q) = %v, want %v", tc.in, got, tc.want)
+				break
+			}
+		}
+	}
+	for _, in := range []string{"rotate(90)", "translate(10)", "perspective(10pt)", "rotate(90deg"} {
+		if _, err := parseTransform(in, wd, ht, fs, fs); err == nil {
+			t.Errorf("parseTransform(%q) did not fail", in)
+		}
+	}
+}
+
+// TestTransformMatrix: the transform acts around transform-origin and is
+// converted to PDF coordinates with the box's top left corner at 0,0.
+func TestTransformMatrix(t *testing.T) {
+	wd, ht := bag.MustSP("100pt"), bag.MustSP("50pt")
+	cases := []struct {
+		transform, origin string
+		want              affine
+	}{
+		// Half a turn around the center ends in the same box.
+		{"rotate(180deg)", "", affine{-1, 0, 0, -1, 100, -50}},
+		{"rotate(180deg)", "top left", affine{-1, 0, 0, -1, 0, 0}},
+		// A quarter turn clockwise around the bottom left corner puts the
+		// box below its original bottom edge.
+		{"rotate(90deg)", "left bottom", affine{0, -1, 1, 0, 50, -50}},
+		{"translateY(10pt)", "", affine{1, 0, 0, 1, 0, -10}},
+	}
+	for _, tc := range cases {
+		bt := &boxTransform{transform: tc.transform, origin: tc.origin}
+		got, err := bt.matrix(wd, ht)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s around %q = %v, want %v", tc.transform, tc.origin, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// TestTransformRender: a transformed block, table cell and image are
+// painted through a cm, the following flow stays where it was.
+func TestTransformRender(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.stamp { transform: rotate(-15deg); }
+th { transform: rotate(-90deg); }
+img { transform: scaleX(-1); }`
+	imgPath := writeTinyPNG(t, t.TempDir(), "tiny.png")
+	body := `<div class="stamp">ENTWURF</div><table><tr><th>Kopf</th></tr></table><p><img src="` + imgPath + `" width="20pt"></p><p>NACHHER</p>`
+	pages := renderHTMLPages(t, css, `<html><body>`+body+`</body></html>`)
+	if n := pageVListOrigins(pages[0], "transformed content"); n != 2 {
+		t.Errorf("got %d transformed boxes, want the div and the img", n)
+	}
+	cms := borderRulePres(pages[0], "transform")
+	if len(cms) != 3 {
+		t.Fatalf("got %d transform rules, want 3: %q", len(cms), cms)
+	}
+	for _, cm := range cms {
+		if !strings.HasSuffix(cm, " cm") {
+			t.Errorf("transform rule %q does not end in cm", cm)
+		}
+	}
+	transformed := lineTopY(pages[0], "NACHHER")
+	plain := strings.NewReplacer(`class="stamp"`, "").Replace(body)
+	pages = renderHTMLPages(t, `@page { size: a4; margin: 20mm; } p { margin: 0; }`, `<html><body>`+plain+`</body></html>`)
+	if y := lineTopY(pages[0], "NACHHER"); y != transformed {
+		t.Errorf("transform moved the following flow: %s, want %s", transformed, y)
+	}
+}
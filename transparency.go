@@ -0,0 +1,402 @@
+package htmlbag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pdf "github.com/boxesandglue/baseline-pdf"
+	"github.com/boxesandglue/boxesandglue/backend/bag"
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+// boxTransparency is carried by settingTransparency from Output() to
+// buildVlistInternal and HTMLBorder: the CSS opacity and mix-blend-mode of a
+// block (Compositing and Blending 1) and the alpha channels of its text,
+// background and border colors (rgba(), hsla(), #rrggbbaa).
+//
+// Everything is drawn with PDF constant alpha and blend modes (ExtGState,
+// ISO 32000 §11.6.4). An ExtGState replaces the alpha of the surrounding
+// graphics state instead of multiplying it, so every state set inside a
+// group already includes the opacity of the group and of the groups around
+// it (ambient).
+//
+// The parts of a table are drawn by the table code, which does not wrap
+// them in the state of a translucent ancestor: they select it themselves
+// (reopen).
+//
+// v1 limitation: opacity is not a real transparency group (no /Group XObject
+// is created), each painted object gets the alpha separately. Overlapping
+// parts of the same element — text over its own background — therefore show
+// through each other, where a browser composites the element first.
+type boxTransparency struct {
+	opacity    float64
+	blend      string // PDF blend mode without the slash, "" = Normal
+	text       float64
+	background float64
+	border     [4]float64 // top, right, bottom, left
+	// ambient is the opacity of the enclosing groups, ambientBlend the
+	// blend mode of the nearest one.
+	ambient      float64
+	ambientBlend string
+	reopen       bool
+}
+
+// group reports whether the box itself opens a group (opacity < 1 or a blend
+// mode), or reopens the group of its ancestors.
+func (bt *boxTransparency) group() bool {
+	if bt == nil {
+		return false
+	}
+	return bt.opacity < 1 || bt.blend != "" || bt.reopen && (bt.ambient < 1 || bt.ambientBlend != "")
+}
+
+// groupState is the graphics state of the whole box.
+func (bt *boxTransparency) groupState() extGState {
+	blend := bt.blend
+	if blend == "" {
+		blend = bt.ambientBlend
+	}
+	a := bt.ambient * bt.opacity
+	return extGState{fill: a, stroke: a, blend: blend}
+}
+
+// state returns the graphics state for something painted with the given
+// color alpha inside the box.
+func (bt *boxTransparency) state(alpha float64) extGState {
+	st := bt.groupState()
+	st.fill *= alpha
+	st.stroke *= alpha
+	return st
+}
+
+// transparencyExempt reports whether an element cannot carry a
+// boxTransparency: columns paint nothing, an img has no box of its own (see
+// imageTransparency).
+func transparencyExempt(element string) bool {
+	switch element {
+	case "col", "colgroup", "img":
+		return true
+	}
+	return false
+}
+
+// transparencyReopens reports whether the table code draws the element, so
+// that it has to select the state of its translucent ancestors itself.
+func transparencyReopens(element string) bool {
+	switch element {
+	case "table", "caption", "thead", "tbody", "tfoot", "tr", "td", "th":
+		return true
+	}
+	return false
+}
+
+// enterTransparency is called by Output() for each block element before its
+// settings are applied. It records the element as the block whose color
+// alpha the text lines get (blockColorTransparency), opens the group for the
+// descendants and returns the transparency of the element itself, nil if it
+// has none.
+func enterTransparency(styles *FormattingStyles, element string) *boxTransparency {
+	if transparencyExempt(element) {
+		return nil
+	}
+	bt := &boxTransparency{
+		opacity:      parseOpacity(styles.opacity),
+		text:         1 - styles.colorTransparency,
+		background:   1,
+		ambient:      1 - styles.groupTransparency,
+		ambientBlend: styles.groupBlend,
+		reopen:       transparencyReopens(element),
+	}
+	if blend, ok := cssBlendMode(styles.mixBlendMode); ok {
+		bt.blend = blend
+	} else {
+		bag.Logger.Warn("unsupported mix-blend-mode", "value", styles.mixBlendMode)
+	}
+	if styles.ownBackground {
+		bt.background = 1 - styles.backgroundTransparency
+	}
+	for i, t := range styles.borderTransparency {
+		bt.border[i] = 1 - t
+	}
+	styles.blockColorTransparency = styles.colorTransparency
+	if bt.group() {
+		styles.groupTransparency = 1 - bt.ambient*bt.opacity
+		if bt.blend != "" {
+			styles.groupBlend = bt.blend
+		}
+	}
+	if bt.group() || bt.text < 1 || bt.background < 1 {
+		return bt
+	}
+	for _, a := range bt.border {
+		if a < 1 {
+			return bt
+		}
+	}
+	return nil
+}
+
+// imageTransparency returns the opacity and blend mode of an img, nil when
+// it has neither. The image node is wrapped in groupVList where it is made.
+func imageTransparency(styles *FormattingStyles) *boxTransparency {
+	bt := &boxTransparency{
+		opacity:      parseOpacity(styles.opacity),
+		text:         1,
+		background:   1,
+		border:       [4]float64{1, 1, 1, 1},
+		ambient:      1 - styles.groupTransparency,
+		ambientBlend: styles.groupBlend,
+	}
+	if blend, ok := cssBlendMode(styles.mixBlendMode); ok {
+		bt.blend = blend
+	} else {
+		bag.Logger.Warn("unsupported mix-blend-mode", "value", styles.mixBlendMode)
+	}
+	if !bt.group() {
+		return nil
+	}
+	return bt
+}
+
+// parseOpacity parses a CSS opacity value: a number or a percentage,
+// clamped to 0…1. Empty and invalid values are 1.
+func parseOpacity(v string) float64 {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 1
+	}
+	scale := 1.0
+	if p, ok := strings.CutSuffix(v, "%"); ok {
+		v, scale = p, 100
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		bag.Logger.Warn("invalid opacity", "value", v)
+		return 1
+	}
+	return min(max(f/scale, 0), 1)
+}
+
+// cssBlendMode maps a CSS mix-blend-mode to the PDF blend mode name (ISO
+// 32000 §11.3.5, the same sixteen modes). normal and an empty value give "".
+// plus-lighter and plus-darker have no PDF counterpart.
+func cssBlendMode(v string) (string, bool) {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "", "normal":
+		return "", true
+	case "multiply", "screen", "overlay", "darken", "lighten", "color-dodge",
+		"color-burn", "hard-light", "soft-light", "difference", "exclusion",
+		"hue", "saturation", "color", "luminosity":
+		var b strings.Builder
+		for _, part := range strings.Split(v, "-") {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// mixTranslucentColors replaces the colors in settings that carry an alpha
+// by their mix with white, for the boxes that cannot set up an ExtGState
+// (inline boxes). A block or a table part with a boxTransparency gets its
+// own colors back in Output(). A background inherited from an ancestor is
+// dropped when it is translucent: the ancestor paints it already, a second
+// coat would make it darker.
+func mixTranslucentColors(settings frontend.TypesettingSettings, ih *FormattingStyles) {
+	if ih.color != nil && ih.colorTransparency != ih.blockColorTransparency {
+		// The text lines of the block get the block's alpha, the mix
+		// makes up the difference (it cannot make the text more opaque).
+		alpha := 1.0
+		if block := 1 - ih.blockColorTransparency; block > 0 {
+			alpha = (1 - ih.colorTransparency) / block
+		}
+		settings[frontend.SettingColor] = mixWithWhite(ih.color, alpha)
+	}
+	if ih.BackgroundColor != nil && ih.backgroundTransparency > 0 {
+		if ih.ownBackground {
+			settings[frontend.SettingBackgroundColor] = mixWithWhite(ih.BackgroundColor, 1-ih.backgroundTransparency)
+		} else {
+			settings[frontend.SettingBackgroundColor] = (*color.Color)(nil)
+		}
+	}
+	borders := []struct {
+		k frontend.SettingType
+		c *color.Color
+	}{
+		{frontend.SettingBorderTopColor, ih.BorderTopColor},
+		{frontend.SettingBorderRightColor, ih.BorderRightColor},
+		{frontend.SettingBorderBottomColor, ih.BorderBottomColor},
+		{frontend.SettingBorderLeftColor, ih.BorderLeftColor},
+	}
+	for i, b := range borders {
+		if b.c != nil && ih.borderTransparency[i] > 0 {
+			settings[b.k] = mixWithWhite(b.c, 1-ih.borderTransparency[i])
+		}
+	}
+}
+
+// unmixTranslucentColors undoes mixTranslucentColors for a block that draws
+// its colors with a boxTransparency.
+func unmixTranslucentColors(settings frontend.TypesettingSettings, ih *FormattingStyles) {
+	settings[frontend.SettingColor] = ih.color
+	if ih.ownBackground {
+		settings[frontend.SettingBackgroundColor] = ih.BackgroundColor
+	}
+	settings[frontend.SettingBorderTopColor] = ih.BorderTopColor
+	settings[frontend.SettingBorderRightColor] = ih.BorderRightColor
+	settings[frontend.SettingBorderBottomColor] = ih.BorderBottomColor
+	settings[frontend.SettingBorderLeftColor] = ih.BorderLeftColor
+}
+
+// extGState is a PDF graphics state parameter dictionary with constant
+// alpha (ca for filling, CA for stroking) and a blend mode.
+type extGState struct {
+	fill, stroke float64
+	blend        string
+}
+
+// opaque reports whether st leaves the graphics state as it is.
+func (st extGState) opaque() bool {
+	return st.fill >= 1 && st.stroke >= 1 && st.blend == ""
+}
+
+// extGStateResource is an ExtGState written to the PDF and the resource
+// name the content streams refer to it by.
+type extGStateResource struct {
+	name   string
+	objnum pdf.Objectnumber
+}
+
+// gs returns the PDF instruction that selects st ("/GSh1 gs"). The
+// ExtGState object is written on first use and added to the resources of
+// every page shipped out from then on (see addPageExtGStates).
+func (cb *CSSBuilder) gs(st extGState) string {
+	if res, ok := cb.extGStates[st]; ok {
+		return "/" + res.name + " gs"
+	}
+	if cb.extGStates == nil {
+		cb.extGStates = make(map[extGState]extGStateResource)
+	}
+	d := pdf.Dict{
+		"Type": "/ExtGState",
+		"ca":   strconv.FormatFloat(st.fill, 'f', -1, 64),
+		"CA":   strconv.FormatFloat(st.stroke, 'f', -1, 64),
+	}
+	if st.blend != "" {
+		d["BM"] = "/" + st.blend
+	}
+	obj := cb.frontend.Doc.PDFWriter.NewObject()
+	obj.Dictionary = d
+	if err := obj.Save(); err != nil {
+		bag.Logger.Error("cannot write ExtGState", "error", err)
+	}
+	res := extGStateResource{name: fmt.Sprintf("GSh%d", len(cb.extGStates)+1), objnum: obj.ObjectNumber}
+	cb.extGStates[st] = res
+	return "/" + res.name + " gs"
+}
+
+// addPageExtGStates adds all ExtGStates created so far to the resources of
+// pg. A state is created when the box using it is built, which is always
+// before the page that shows the box is shipped out; the unused entries of
+// the other pages do no harm.
+func (cb *CSSBuilder) addPageExtGStates(pg *document.Page) {
+	if pg == nil || len(cb.extGStates) == 0 {
+		return
+	}
+	if pg.ExtGState == nil {
+		pg.ExtGState = make(map[pdf.Name]pdf.Objectnumber, len(cb.extGStates))
+	}
+	for _, res := range cb.extGStates {
+		pg.ExtGState[pdf.Name(res.name)] = res.objnum
+	}
+}
+
+// withState wraps the PDF instructions pre in a save/restore pair that
+// selects st. Opaque states leave pre alone.
+func (cb *CSSBuilder) withState(pre string, st extGState) string {
+	if st.opaque() {
+		return pre
+	}
+	return "q " + cb.gs(st) + " " + pre + " Q"
+}
+
+// stateNodes returns the start/stop pair that selects st for the nodes
+// between them.
+func (cb *CSSBuilder) stateNodes(st extGState) (node.Node, node.Node) {
+	code := "q " + cb.gs(st) + " "
+	start := node.NewStartStop()
+	start.Position = node.PDFOutputPage
+	start.ShipoutCallback = func(n node.Node) string {
+		return code
+	}
+	stop := node.NewStartStop()
+	stop.Position = node.PDFOutputPage
+	stop.ShipoutCallback = func(n node.Node) string {
+		return "Q "
+	}
+	return start, stop
+}
+
+// translucentLines gives every line of the paragraph vl the alpha of the
+// text color. Each line gets its own save/restore pair, so the paragraph
+// can still be split across pages.
+func (cb *CSSBuilder) translucentLines(vl *node.VList, bt *boxTransparency) {
+	if bt == nil || bt.text >= 1 {
+		return
+	}
+	st := bt.state(bt.text)
+	for _, line := range paragraphLines(vl, nil) {
+		start, stop := cb.stateNodes(st)
+		line.List = node.InsertBefore(line.List, line.List, start)
+		line.List = node.InsertAfter(line.List, node.Tail(line.List), stop)
+	}
+}
+
+// fadedFormatter returns ftv with its result wrapped in the state of bt, for
+// an image whose size is resolved at layout time. A nil bt leaves ftv alone.
+func (cb *CSSBuilder) fadedFormatter(ftv frontend.FormatToVList, bt *boxTransparency) frontend.FormatToVList {
+	if bt == nil {
+		return ftv
+	}
+	return func(wd bag.ScaledPoint) (*node.VList, error) {
+		vl, err := ftv(wd)
+		if err != nil {
+			return nil, err
+		}
+		return cb.groupVList(vl, bt), nil
+	}
+}
+
+// groupVList wraps vl in the graphics state of its opacity and blend mode:
+//
+//	StartStop "q /GSh… gs" → vl → StartStop "Q"
+//
+// Like clipVList, the wrapper takes over vl's attributes. A splittable box
+// stays splittable: outputBlockSplit wraps every fragment in the state
+// again (_splittableGroup), so each page opens and closes its own.
+func (cb *CSSBuilder) groupVList(vl *node.VList, bt *boxTransparency) *node.VList {
+	if !bt.group() {
+		return vl
+	}
+	start, stop := cb.stateNodes(bt.groupState())
+	var head node.Node = start
+	head = node.InsertAfter(head, start, vl)
+	head = node.InsertAfter(head, vl, stop)
+	wrapper := node.Vpack(head)
+	wrapper.Width = vl.Width
+	wrapper.Height = vl.Height
+	wrapper.Depth = vl.Depth
+	wrapper.Attributes = vl.Attributes
+	if wrapper.Attributes == nil {
+		wrapper.Attributes = node.H{}
+	}
+	if _, ok := wrapper.Attributes["_splittable"]; ok {
+		wrapper.Attributes["_splittableGroup"] = bt
+	}
+	vl.Attributes = node.H{"origin": "transparency group"}
+	return wrapper
+}
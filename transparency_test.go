@@ -0,0 +1,208 @@
+package htmlbag
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/boxesandglue/boxesandglue/backend/color"
+	"github.com/boxesandglue/boxesandglue/backend/document"
+	"github.com/boxesandglue/boxesandglue/backend/node"
+	"github.com/boxesandglue/boxesandglue/frontend"
+)
+
+func TestParseOpacity(t *testing.T) {
+	cases := map[string]float64{"": 1, "0.3": 0.3, "50%": 0.5, "2": 1, "-1": 0, "x": 1}
+	for in, want := range cases {
+		if got := parseOpacity(in); math.Abs(got-want) > 1e-9 {
+			t.Errorf("parseOpacity(%q) = %g, want %g", in, got, want)
+		}
+	}
+}
+
+func TestCSSBlendMode(t *testing.T) {
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"normal", "", true},
+		{"multiply", "Multiply", true},
+		{"color-dodge", "ColorDodge", true},
+		{"Hard-Light", "HardLight", true},
+		{"plus-lighter", "", false},
+	}
+	for _, tc := range cases {
+		if got, ok := cssBlendMode(tc.in); got != tc.want || ok != tc.ok {
+			t.Errorf("cssBlendMode(%q) = %q, %v, want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// TestEnterTransparency: the opacity of an ancestor is part of every state
+// set inside it, and a block without anything of its own needs no
+// settingTransparency.
+func TestEnterTransparency(t *testing.T) {
+	parent := &FormattingStyles{opacity: "0.5", mixBlendMode: "multiply"}
+	bt := enterTransparency(parent, "div")
+	if !bt.group() || bt.groupState() != (extGState{fill: 0.5, stroke: 0.5, blend: "Multiply"}) {
+		t.Fatalf("parent = %+v", bt)
+	}
+
+	child := parent.Clone()
+	if enterTransparency(child, "p") != nil {
+		t.Error("a plain child of a group got a transparency of its own")
+	}
+	child.colorTransparency = 0.5
+	bt = enterTransparency(child, "p")
+	if bt == nil || bt.group() {
+		t.Fatalf("child with a translucent color = %+v", bt)
+	}
+	if st := bt.state(bt.text); st != (extGState{fill: 0.25, stroke: 0.25, blend: "Multiply"}) {
+		t.Errorf("text state = %+v, want the alpha times the parent's opacity", st)
+	}
+
+	if !enterTransparency(&FormattingStyles{opacity: "0.5"}, "td").group() {
+		t.Error("a translucent table cell got no graphics state")
+	}
+	cell := enterTransparency(parent.Clone(), "td")
+	if !cell.group() || cell.groupState() != (extGState{fill: 0.5, stroke: 0.5, blend: "Multiply"}) {
+		t.Errorf("cell in a group = %+v, want the group's state reopened", cell)
+	}
+	if enterTransparency(&FormattingStyles{opacity: "0.5"}, "col") != nil {
+		t.Error("a column got a transparency")
+	}
+}
+
+// TestImageTransparency: an img gets a graphics state for its own opacity
+// or blend mode only, not for the group it is in.
+func TestImageTransparency(t *testing.T) {
+	if bt := imageTransparency(&FormattingStyles{opacity: "50%"}); !bt.group() || bt.groupState().fill != 0.5 {
+		t.Errorf("translucent image = %+v", bt)
+	}
+	if bt := imageTransparency(&FormattingStyles{mixBlendMode: "screen"}); !bt.group() || bt.groupState().blend != "Screen" {
+		t.Errorf("blended image = %+v", bt)
+	}
+	if bt := imageTransparency(&FormattingStyles{groupTransparency: 0.5}); bt != nil {
+		t.Errorf("image in a group = %+v, want none of its own", bt)
+	}
+}
+
+// TestMixTranslucentColors: boxes without an ExtGState get their colors
+// mixed with white, an inherited translucent background is not painted
+// twice.
+func TestMixTranslucentColors(t *testing.T) {
+	blue := &color.Color{Space: color.ColorRGB, B: 1}
+	ih := &FormattingStyles{color: blue, colorTransparency: 0.5, BackgroundColor: blue, backgroundTransparency: 0.5, ownBackground: true}
+	settings := frontend.TypesettingSettings{}
+	mixTranslucentColors(settings, ih)
+	if c := settings[frontend.SettingColor].(*color.Color); math.Abs(c.R-0.5) > 1e-9 || c.B != 1 {
+		t.Errorf("text color = %+v, want blue mixed half with white", c)
+	}
+	if c := settings[frontend.SettingBackgroundColor].(*color.Color); math.Abs(c.G-0.5) > 1e-9 {
+		t.Errorf("background color = %+v, want blue mixed half with white", c)
+	}
+
+	inherited := ih.Clone()
+	settings = frontend.TypesettingSettings{}
+	mixTranslucentColors(settings, inherited)
+	if c := settings[frontend.SettingBackgroundColor].(*color.Color); c != nil {
+		t.Errorf("inherited translucent background = %+v, want none", c)
+	}
+}
+
+// TestOpacityRender: a block with opacity is wrapped in its graphics state,
+// a translucent background color selects an ExtGState in its rule.
+func TestOpacityRender(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.draft { opacity: 0.2; color: gray; }
+.tint { background-color: rgba(255, 0, 0, 0.25); }`
+	pages := renderHTMLPages(t, css, `<html><body><div class="draft">DRAFT</div><p class="tint">Hinweis</p><p>NACHHER</p></body></html>`)
+	if n := pageVListOrigins(pages[0], "transparency group"); n != 1 {
+		t.Errorf("got %d transparency groups, want 1", n)
+	}
+	bg := borderRulePres(pages[0], "html background color")
+	if len(bg) != 1 || !strings.Contains(bg[0], " gs ") {
+		t.Errorf("translucent background is drawn without an ExtGState: %q", bg)
+	}
+	shaded := lineTopY(pages[0], "NACHHER")
+	pages = renderHTMLPages(t, css, `<html><body><div>DRAFT</div><p class="tint">Hinweis</p><p>NACHHER</p></body></html>`)
+	if plain := lineTopY(pages[0], "NACHHER"); plain != shaded {
+		t.Errorf("opacity moved the following flow: %s, want %s", shaded, plain)
+	}
+}
+
+// TestOpacityPages: a translucent paragraph longer than a page is split,
+// and every page draws its part in the graphics state of the paragraph.
+func TestOpacityPages(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+p { margin: 0; }
+.draft { opacity: 0.5; }`
+	pages := renderHTMLPages(t, css, `<html><body><p class="draft">ANFANG `+strings.Repeat("Wort ", 1500)+`ENDE</p></body></html>`)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want the paragraph split", len(pages))
+	}
+	if !strings.Contains(pageText(pages[0]), "ANFANG") {
+		t.Error("the paragraph does not start on the first page")
+	}
+	for p, pg := range pages {
+		if n := pageVListOrigins(pg, "transparency group"); n != 1 {
+			t.Errorf("page %d: %d transparency groups, want 1", p+1, n)
+		}
+	}
+}
+
+// stateStarts counts the start nodes on pg that select an ExtGState.
+func stateStarts(pg *document.Page) int {
+	var walk func(n node.Node) int
+	walk = func(n node.Node) int {
+		count := 0
+		for ; n != nil; n = n.Next() {
+			switch v := n.(type) {
+			case *node.StartStop:
+				if v.ShipoutCallback != nil && strings.Contains(v.ShipoutCallback(v), " gs ") {
+					count++
+				}
+			case *node.HList:
+				count += walk(v.List)
+			case *node.VList:
+				count += walk(v.List)
+			}
+		}
+		return count
+	}
+	count := 0
+	for _, obj := range pg.Objects {
+		if obj.Vlist != nil {
+			count += walk(obj.Vlist.List)
+		}
+	}
+	return count
+}
+
+// TestOpacityTableCells: a translucent cell and a translucent cell
+// background are drawn in an ExtGState, not mixed with white.
+func TestOpacityTableCells(t *testing.T) {
+	css := `@page { size: a4; margin: 20mm; }
+.tint { background-color: rgba(255, 0, 0, 0.25); }
+.draft { opacity: 0.5; }`
+	pages := renderHTMLPages(t, css, `<html><body><table><tr><td class="tint">A</td><td class="draft">B</td><td>C</td></tr></table></body></html>`)
+	bg := borderRulePres(pages[0], "html background color")
+	if len(bg) != 1 || !strings.Contains(bg[0], " gs ") {
+		t.Errorf("translucent cell background is drawn without an ExtGState: %q", bg)
+	}
+	if n := stateStarts(pages[0]); n != 1 {
+		t.Errorf("got %d cells in a graphics state, want 1", n)
+	}
+}
+
+// TestOpacityImage: an img with opacity is wrapped in its graphics state.
+func TestOpacityImage(t *testing.T) {
+	imgPath := writeTinyPNG(t, t.TempDir(), "tiny.png")
+	css := `@page { size: a4; margin: 20mm; }
+img { opacity: 0.5; width: 20mm; }`
+	pages := renderHTMLPages(t, css, `<html><body><p><img src="`+imgPath+`"></p></body></html>`)
+	if n := pageVListOrigins(pages[0], "transparency group"); n != 1 {
+		t.Errorf("got %d transparency groups, want 1", n)
+	}
+}
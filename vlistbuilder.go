@@ -43,9 +43,19 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 	settings := te.Settings
 
 	// If a CSS width is specified, use it instead of the inherited width.
+	// The intrinsic sizing keywords measure the box first (see
+	// intrinsicWidths).
 	if sWd, ok := settings[frontend.SettingWidth]; ok {
 		if wdStr, ok := sWd.(string); ok {
-			wd = ParseRelativeSize(wdStr, wd, wd)
+			if isIntrinsicWidth(wdStr) {
+				iw, err := cb.intrinsicWidth(te, wdStr, wd)
+				if err != nil {
+					return nil, err
+				}
+				wd = iw
+			} else {
+				wd = ParseRelativeSize(wdStr, wd, wd)
+			}
 		}
 	}
 
@@ -166,7 +176,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 
 		// Extract border/padding values for this container
 		hv := settingsToHTMLValues(settings)
-		hasBorderOrBg := hv.hasBorder() || hv.BackgroundColor != nil
+		hasBorderOrBg := hv.hasDecoration()
 
 		// Calculate effective width for children
 		childBaseWidth := wd
@@ -181,8 +191,42 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 		// Track previous element's margin-bottom for margin collapsing
 		var prevMarginBottom bag.ScaledPoint
 
+		// Absolutely positioned elements wait for the next child: its
+		// VList carries them to the page (see positionedMarker).
+		var pendingPositioned []*Insert
+		var lastChild *node.VList
+
+		// display: flex / grid (settingFlex, settingGrid, stamped by
+		// Output()): resolve the item widths and places first. The loop
+		// below builds every item at its resolved width, arrange places
+		// them after the loop.
+		var layout childLayout
+		layoutWidth := childBaseWidth
+		if !hasBorderOrBg {
+			layoutWidth -= paddingLeft + paddingRight
+		}
+		if fc, ok := settings[settingFlex].(*flexContainer); ok {
+			fl, err := cb.layoutFlex(te, fc, layoutWidth)
+			if err != nil {
+				return nil, err
+			}
+			layout = fl
+		}
+		if gc, ok := settings[settingGrid].(*gridContainer); ok {
+			gl, err := cb.layoutGrid(te, gc, layoutWidth)
+			if err != nil {
+				return nil, err
+			}
+			layout = gl
+		}
+
 		for i, itm := range te.Items {
 			switch t := itm.(type) {
+			case positionedMarker:
+				// Consumed like a float marker, so a reflow rebuild
+				// does not see it again.
+				te.Items[i] = frontend.NewText()
+				pendingPositioned = append(pendingPositioned, &Insert{Class: InsertPositioned, Positioned: t.pi})
 			case *frontend.Text:
 				// Skip whitespace-only text elements (e.g. whitespace
 				// between </ul> and </li> in the HTML tree).
@@ -206,8 +250,13 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 					marginGlue = bag.Max(prevMarginBottom, curMarginTop)
 				}
 
-				// Insert margin kern if needed
-				if marginGlue > 0 {
+				// Insert margin kern if needed. The margins of flex and
+				// grid items do not collapse, the layout places them.
+				var fe *layoutItem
+				if layout != nil {
+					fe = layout.item(t)
+				}
+				if marginGlue > 0 && fe == nil {
 					k := node.NewKern()
 					k.Kern = marginGlue
 					k.Attributes = node.H{"origin": "margin"}
@@ -224,7 +273,12 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 				bmRaw, _ := t.Settings[settingBookmark].(string)
 				delete(t.Settings, settingBookmark)
 
+				var restoreFlex func()
+				if fe != nil {
+					restoreFlex = fe.prepare()
+				}
 				var vl *node.VList
+				var tableParts []*node.VList
 				if dbg, ok := t.Settings[frontend.SettingDebug].(string); ok && dbg == "table" {
 					// CSS border/padding/background declared on the <table>
 					// element itself are not handled inside buildTable
@@ -239,7 +293,14 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 					// that the page builder cannot split — causing tail
 					// rows to be silently dropped.
 					tableHv := settingsToHTMLValues(t.Settings)
-					hasTableBorderOrBg := tableHv.hasBorder() || tableHv.BackgroundColor != nil
+					// In the collapsing border model the outer cells draw
+					// the table border (collapseBorders), and a table has
+					// no padding (CSS 2.1 §17.6.2).
+					if tb, _ := t.Settings[settingTableBorders].(*tableBorders); tb != nil && tb.collapse {
+						tableHv.BorderTopWidth, tableHv.BorderRightWidth, tableHv.BorderBottomWidth, tableHv.BorderLeftWidth = 0, 0, 0, 0
+						tableHv.PaddingTop, tableHv.PaddingRight, tableHv.PaddingBottom, tableHv.PaddingLeft = 0, 0, 0, 0
+					}
+					hasTableBorderOrBg := tableHv.hasDecoration()
 					hasTheadOrTfoot := false
 					if hasTableBorderOrBg {
 						for _, itm := range t.Items {
@@ -255,17 +316,28 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 					}
 					wrapTable := hasTableBorderOrBg && !hasTheadOrTfoot
 					tableWidth := wd
+					if fe != nil {
+						tableWidth = fe.width
+					}
 					if wrapTable {
 						tableWidth = wd - tableHv.BorderLeftWidth - tableHv.BorderRightWidth - tableHv.PaddingLeft - tableHv.PaddingRight
 					}
-					var err error
-					vl, err = cb.buildTable(t, tableWidth)
+					// A table split by -bag-table-overflow: split-columns
+					// comes in parts, a flex or grid item cannot be
+					// split.
+					parts, err := cb.buildTableParts(t, tableWidth, fe == nil)
 					if err != nil {
 						return nil, err
 					}
-					if wrapTable {
-						vl = cb.HTMLBorder(vl, tableHv)
+					for i, part := range parts {
+						// The caption sits outside the table's border.
+						caption := tableCaptionOf(part)
+						if wrapTable {
+							part = cb.HTMLBorder(part, tableHv)
+						}
+						parts[i] = withCaption(part, caption)
 					}
+					vl, tableParts = parts[0], parts[1:]
 				} else {
 					// Two CSS shifts apply to every child of a block
 					// container: the parent's padding-left (an offset
@@ -290,6 +362,9 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 						childWidth = childBaseWidth - paddingLeft - paddingRight
 					}
 					childWidth -= childMarginLeft + childMarginRight
+					if fe != nil {
+						childWidth = fe.width
+					}
 					var err error
 					vl, err = cb.buildVlistInternal(t, childWidth)
 					if err != nil {
@@ -302,7 +377,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 					if !hasBorderOrBg {
 						shift += paddingLeft
 					}
-					if shift > 0 {
+					if shift > 0 && fe == nil {
 						vl.ShiftX += shift
 					}
 					// CSS position: relative offsets — htmlbag's
@@ -315,6 +390,10 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 						vl.ShiftX += sx.(bag.ScaledPoint)
 					}
 				}
+				if fe != nil {
+					restoreFlex()
+					fe.vl = vl
+				}
 				// Propagate page-break-after to node attributes
 				if pba, ok := t.Settings[frontend.SettingPageBreakAfter]; ok {
 					if vl.Attributes == nil {
@@ -341,12 +420,31 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 					vl.Attributes["pageBreakInside"] = pbi
 				}
 
+				addInsertsAttr(vl, pendingPositioned)
+				pendingPositioned = nil
+				lastChild = vl
+
 				vls.List = node.InsertAfter(vls.List, node.Tail(vls.List), vl)
 				if vl.Width > vls.Width {
 					vls.Width = vl.Width
 				}
 				vls.Height += vl.Height
 				vls.Depth = vl.Depth
+				// The further column parts of a split table follow, each
+				// starting a new page.
+				for _, part := range tableParts {
+					if part.Attributes == nil {
+						part.Attributes = node.H{}
+					}
+					part.Attributes["pageBreakBefore"] = "always"
+					part.Attributes["_tablePart"] = true
+					vls.List = node.InsertAfter(vls.List, node.Tail(vls.List), part)
+					if part.Width > vls.Width {
+						vls.Width = part.Width
+					}
+					vls.Height += part.Height
+					vls.Depth = part.Depth
+				}
 
 				// A reflow rebuild (page width change during pagination)
 				// re-runs this builder on already-registered content: skip
@@ -444,6 +542,24 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 			}
 		}
 
+		// Positioned elements at the end of the container belong to its
+		// last child, or to the container itself when it has none.
+		if lastChild != nil {
+			addInsertsAttr(lastChild, pendingPositioned)
+		} else {
+			inserts = append(inserts, pendingPositioned...)
+		}
+
+		if layout != nil {
+			lineShift := bag.ScaledPoint(0)
+			if !hasBorderOrBg {
+				lineShift = paddingLeft
+			}
+			layout.arrange(vls, lineShift)
+			// No margin of an item collapses through the container.
+			prevMarginBottom = 0
+		}
+
 		// Handle final margin-bottom after last element.
 		if prevMarginBottom > 0 {
 			if hasBorderOrBg || hv.PaddingBottom > 0 {
@@ -468,6 +584,42 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 			}
 		}
 
+		// overflow: hidden / clip-path (settingClip, stamped by Output()):
+		// the box gets wrapped in a clip path at the end; a split box gets
+		// the clip on every fragment (see clipVList). A clip-path shape
+		// belongs to the whole box, so such a box is monolithic.
+		bc, hasClip := settings[settingClip].(boxClip)
+		monolithic := hasClip && bc.clipPath != ""
+
+		// opacity / mix-blend-mode (settingTransparency): the box gets
+		// wrapped in its graphics state at the end; a split box gets the
+		// state on every fragment (see groupVList).
+		bt, _ := settings[settingTransparency].(*boxTransparency)
+
+		// transform (settingTransform): painted around the finished box,
+		// monolithic like a clip-path.
+		tf, hasTransform := settings[settingTransform].(*boxTransform)
+		monolithic = monolithic || hasTransform
+
+		// position: relative (settingPositionedBox): the vertical offset
+		// and the absolutely positioned descendants are attached to the
+		// finished box. It stays splittable, outputBlockSplit shifts and
+		// hangs every fragment on its own.
+		pb, hasPositioned := settings[settingPositionedBox].(*positionedBox)
+
+		// Line clamping (settingLineClamp, stamped by Output()): cut the
+		// children after max-lines lines or at max-height. Runs before the
+		// CSS height so a clamped box is still padded to its declared
+		// height. A clamped box is monolithic too: its split snapshot below
+		// would bring the cut content back on the next page.
+		if lc, ok := settings[settingLineClamp].(lineClamp); ok {
+			cut, err := cb.applyLineClamp(vls, lc, settings)
+			if err != nil {
+				return nil, err
+			}
+			monolithic = monolithic || cut
+		}
+
 		// CSS height on a block (settingCSSHeight, stamped by Output()):
 		// extend the box to the declared height so an empty block paints
 		// its background / reserves flow space and a partially filled
@@ -481,6 +633,12 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 			// settings never reach FormatParagraph, and a reflow rebuild at
 			// another page width must see the declared height again.
 			if h, ok := hRaw.(bag.ScaledPoint); ok {
+				// With overflow: hidden the height is exact: what does
+				// not fit is cut off (and clipped) instead of growing
+				// the box. A cut box is monolithic like a clamped one.
+				if hasClip && bc.overflowY && cropVList(vls, h) {
+					monolithic = true
+				}
 				applyCSSHeight(vls, h)
 			}
 			if !hasBorderOrBg {
@@ -520,7 +678,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 			// disable), and an explicit break-inside: avoid keeps the
 			// container monolithic.
 			pbiRaw, _ := settings[settingPageBreakInside].(string)
-			if pbiRaw != "avoid" && !hasTableChild(vls.List) {
+			if pbiRaw != "avoid" && !monolithic && !hasTableChild(vls.List) {
 				var splittableInner []node.Node
 				for n := vls.List; n != nil; n = n.Next() {
 					splittableInner = append(splittableInner, n)
@@ -554,7 +712,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 
 			vls = cb.HTMLBorder(vls, hv)
 
-			if len(splittableInner) > 0 {
+			if len(splittableInner) > 0 && !monolithic {
 				if vls.Attributes == nil {
 					vls.Attributes = node.H{}
 				}
@@ -565,6 +723,41 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 			}
 		}
 
+		if hasPositioned && len(pb.children) > 0 {
+			if !hasBorderOrBg {
+				// The containing block is the full box width.
+				vls.Width = wd
+			}
+			var err error
+			if vls, err = cb.attachPositioned(vls, pb, hv); err != nil {
+				return nil, err
+			}
+		}
+		if hasClip {
+			if !hasBorderOrBg {
+				// The clip covers the full box width, not just the
+				// widest child.
+				vls.Width = wd
+			}
+			vls = cb.clipVList(vls, hv, bc)
+		}
+		if bt.group() {
+			if !hasBorderOrBg && !hasClip {
+				vls.Width = wd
+			}
+			vls = cb.groupVList(vls, bt)
+		}
+		if hasTransform {
+			if !hasBorderOrBg && !hasClip && !bt.group() {
+				// The origin refers to the full border box.
+				vls.Width = wd
+			}
+			vls = cb.transformVList(vls, tf)
+		}
+		if hasPositioned {
+			vls = cb.shiftVList(vls, pb.shiftY)
+		}
+
 		// PDF/UA: pop structure element back to parent
 		if containerSE != nil {
 			cb.structureCurrent = savedStructureCurrent
@@ -598,6 +791,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 	inserts = append(inserts, deepFootnotes...)
 	inserts = append(inserts, deepTopFloats...)
 	inserts = append(inserts, deepBottomFloats...)
+	inserts = append(inserts, extractPositionedMarkers(te)...)
 
 	// Pull inline-anchor markers out of the Text tree before the
 	// paragraph builds so they don't confuse Mknodes. The indices
@@ -627,49 +821,79 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 	// rebuild at another page width sees the same input again.
 	paddingLeftSaved, hasPaddingLeftSaved := te.Settings[frontend.SettingPaddingLeft]
 	paddingRightSaved, hasPaddingRightSaved := te.Settings[frontend.SettingPaddingRight]
-	hasBorderOrBg := hv.hasBorder() || hv.BackgroundColor != nil
+	hasBorderOrBg := hv.hasDecoration()
 	if hasBorderOrBg {
 		delete(te.Settings, frontend.SettingPaddingLeft)
 		delete(te.Settings, frontend.SettingPaddingRight)
 	}
 
-	// Capture-and-strip settingPageBreakInside before FormatParagraph.
-	// Block-level Text that only contains inline children reaches the leaf
-	// branch (HTMLNodeToText leaves SettingBox off because cur flips to
+	// Take the htmlbag-private sentinels off before FormatParagraph. Block
+	// Text that only contains inline children reaches this leaf branch
+	// (HTMLNodeToText leaves SettingBox off because cur flips to
 	// ModeHorizontal after inline content), so the box branch above never
-	// sees the sentinel for those blocks. The negative sentinel would
-	// otherwise hit the strict "unknown setting" default inside
-	// FormatParagraph → Mknodes → BuildNodelistFromString.
-	pbi, hasPBI := te.Settings[settingPageBreakInside]
-	if hasPBI {
-		delete(te.Settings, settingPageBreakInside)
-	}
-
-	// Capture-and-strip settingCSSHeight for the same reason: a block with
-	// only inline content (e.g. <div style="height: 85mm">text</div>) has
-	// SettingBox off and reaches this leaf branch. The declared height is
-	// applied to the finished VList below.
-	var cssHeight bag.ScaledPoint
-	cssHeightRaw, hasCSSHeight := te.Settings[settingCSSHeight]
-	if hasCSSHeight {
-		delete(te.Settings, settingCSSHeight)
-		cssHeight, _ = cssHeightRaw.(bag.ScaledPoint)
-	}
+	// sees them for those blocks. A negative sentinel would otherwise hit
+	// the strict "unknown setting" default inside FormatParagraph →
+	// Mknodes → BuildNodelistFromString. Their values are applied to the
+	// finished VList below: the declared height, the clamp, the clip, the
+	// shadows, the background and transparency (already in hv), the
+	// transform and the positioned box; copy-fitting re-runs
+	// FormatParagraph itself.
+	private := stripPrivateSettings(te.Settings)
+	pbi, hasPBI := private[settingPageBreakInside]
+	cssHeight, _ := private[settingCSSHeight].(bag.ScaledPoint)
+	clampRaw := private[settingLineClamp]
+	clipRaw, hasClip := private[settingClip]
+	bc, _ := clipRaw.(boxClip)
+	fitRaw, hasFit := private[settingFitText]
+	textShadowRaw := private[settingTextShadow]
+	transparencyRaw := private[settingTransparency]
+	bt, _ := transparencyRaw.(*boxTransparency)
+	transformRaw, hasTransform := private[settingTransform]
+	positionedRaw := private[settingPositionedBox]
+	textShadows, _ := textShadowRaw.([]shadow)
 
 	// FormatParagraph -> Mknodes handles SettingPrepend (e.g., bullet points).
-	vl, _, err := cb.frontend.FormatParagraph(te, contentWidth)
+	typeset := func() (*node.VList, error) {
+		pl, hasPL := te.Settings[frontend.SettingPaddingLeft]
+		if ft, ok := fitRaw.(fitText); ok {
+			// Each attempt must see the same input: FormatParagraph
+			// consumes SettingPaddingLeft, so put it back after every run.
+			return cb.fitParagraph(te, ft, func() (*node.VList, error) {
+				v, _, err := cb.frontend.FormatParagraph(te, contentWidth)
+				if hasPL {
+					te.Settings[frontend.SettingPaddingLeft] = pl
+				}
+				return v, err
+			})
+		}
+		v, _, err := cb.frontend.FormatParagraph(te, contentWidth)
+		if hasPL && len(textShadows) > 0 {
+			// The shadow copies below need the same input again.
+			te.Settings[frontend.SettingPaddingLeft] = pl
+		}
+		return v, err
+	}
+	vl, err := typeset()
 	if err != nil {
 		return nil, err
 	}
+	// text-shadow: typeset the paragraph once more per shadow in the
+	// shadow color. Same text, fonts and width give the same line breaks,
+	// so textShadowLines can pair the lines up.
+	var shadowVLs []*node.VList
+	for _, ts := range textShadows {
+		restoreColor := recolorText(te, ts.color)
+		svl, err := typeset()
+		restoreColor()
+		if err != nil {
+			return nil, err
+		}
+		shadowVLs = append(shadowVLs, svl)
+	}
 	// Restore the settings stripped before FormatParagraph (and the
 	// SettingPaddingLeft it consumed itself), so a reflow rebuild or a
 	// FormatParagraphTail pass at another page width sees the same input.
-	if hasPBI {
-		te.Settings[settingPageBreakInside] = pbi
-	}
-	if hasCSSHeight {
-		te.Settings[settingCSSHeight] = cssHeightRaw
-	}
+	private.restore(te.Settings)
 	if hasPaddingLeftSaved {
 		te.Settings[frontend.SettingPaddingLeft] = paddingLeftSaved
 	}
@@ -713,16 +937,50 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 
 	attachInserts(vl)
 
+	// Line clamping: keep max-lines lines / what fits into max-height and
+	// end the last one with an ellipsis if requested. A clamped paragraph
+	// is not exposed to the page splitter (see the box branch), and neither
+	// is a copy-fitted, a transformed or a clip-path one.
+	monolithic := hasFit || bc.clipPath != "" || hasTransform
+	if lc, ok := clampRaw.(lineClamp); ok {
+		cut, err := cb.applyLineClamp(vl, lc, te.Settings)
+		if err != nil {
+			return nil, err
+		}
+		monolithic = monolithic || cut
+		// The shadow copies are cut the same way, their ellipsis in the
+		// shadow color.
+		for i, svl := range shadowVLs {
+			shadowSettings := frontend.TypesettingSettings{}
+			for k, v := range te.Settings {
+				shadowSettings[k] = v
+			}
+			shadowSettings[frontend.SettingColor] = textShadows[i].color
+			if _, err := cb.applyLineClamp(svl, lc, shadowSettings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(shadowVLs) > 0 {
+		textShadowLines(vl, shadowVLs, textShadows)
+	}
+	// A translucent text color: every line (with its shadows) is drawn
+	// with the alpha of the color.
+	cb.translucentLines(vl, bt)
+
 	// CSS height on a leaf block: extend to the declared height before the
 	// border wrap so padding/border stay outside the height (content box).
 	// Content taller than the declared height keeps its natural size
-	// (min-height semantics, no clipping).
+	// (min-height semantics), unless overflow: hidden cuts it off.
 	if cssHeight > 0 {
+		if hasClip && bc.overflowY && cropVList(vl, cssHeight) {
+			monolithic = true
+		}
 		applyCSSHeight(vl, cssHeight)
 	}
 
 	// Apply borders if any are defined
-	if hv.hasBorder() || hv.BackgroundColor != nil {
+	if hv.hasDecoration() {
 		// Snapshot the inner children (typically HList lines for <pre>)
 		// before HTMLBorder mutates the list. outputBlockSplit reuses this
 		// snapshot to fragment the block across pages when its wrapped
@@ -736,7 +994,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 
 		vl = cb.HTMLBorder(vl, hv)
 
-		if len(splittableInner) > 0 {
+		if len(splittableInner) > 0 && !monolithic {
 			if vl.Attributes == nil {
 				vl.Attributes = node.H{}
 			}
@@ -777,7 +1035,7 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 		for n := vl.List; n != nil; n = n.Next() {
 			splittableInner = append(splittableInner, n)
 		}
-		if len(splittableInner) > 1 {
+		if len(splittableInner) > 1 && !monolithic {
 			if vl.Attributes == nil {
 				vl.Attributes = node.H{}
 			}
@@ -792,6 +1050,25 @@ func (cb *CSSBuilder) buildVlistInternal(te *frontend.Text, wd bag.ScaledPoint)
 		}
 	}
 
+	pb, _ := positionedRaw.(*positionedBox)
+	if pb != nil {
+		if vl, err = cb.attachPositioned(vl, pb, hv); err != nil {
+			return nil, err
+		}
+	}
+	if hasClip {
+		vl = cb.clipVList(vl, hv, bc)
+	}
+	if bt.group() {
+		vl = cb.groupVList(vl, bt)
+	}
+	if tf, ok := transformRaw.(*boxTransform); ok {
+		vl = cb.transformVList(vl, tf)
+	}
+	if pb != nil {
+		vl = cb.shiftVList(vl, pb.shiftY)
+	}
+
 	// PDF/UA: tag leaf block elements (p, h1-h6, pre, code)
 	if cb.enableTagging {
 		if tag, ok := settings[frontend.SettingDebug].(string); ok {
@@ -1110,6 +1387,15 @@ func settingsToHTMLValues(settings frontend.TypesettingSettings) HTMLValues {
 	if v, ok := settings[frontend.SettingBorderBottomRightRadius]; ok && v != nil {
 		hv.BorderBottomRightRadius = v.(bag.ScaledPoint)
 	}
+	if v, ok := settings[settingBoxShadow].([]shadow); ok {
+		hv.boxShadows = v
+	}
+	if v, ok := settings[settingBackground].(*boxBackground); ok {
+		hv.background = v
+	}
+	if v, ok := settings[settingTransparency].(*boxTransparency); ok {
+		hv.transparency = v
+	}
 	if v, ok := settings[frontend.SettingPaddingTop]; ok && v != nil {
 		hv.PaddingTop = v.(bag.ScaledPoint)
 	}